@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+
+	"github.com/arianitu/go-challenge-2/snacl/agent"
+)
+
+// runAgent holds the key pair at key, see loadKey, and answers requests for it on the unix socket at
+// path until it fails. Clients find it with -agent or SNACL_AGENT_SOCK:
+//
+//	go-challenge-2 agent ~/.snacl/agent.sock ~/.snacl/id.key &
+//	SNACL_AGENT_SOCK=~/.snacl/agent.sock go-challenge-2 9000 hello
+func runAgent(path, key string) error {
+	keys, err := loadKey(key)
+	if err != nil {
+		return err
+	}
+	a, err := agent.New(keys)
+	clear(keys.Private[:])
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if !a.Locked() {
+		log.Printf("couldn't lock the key in memory, it may be swapped out")
+	}
+	log.Printf("agent listening on %s", path)
+	return a.Listen(path)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Chat rooms. A server started with -chat keeps rooms of connected clients, and the chat subcommand
+// joins one, sends every line typed on stdin to the other members and prints what they send:
+//
+//	go-challenge-2 -l 9000 -chat
+//	go-challenge-2 chat localhost:9000 lobby
+//
+// A client's first message is the room it joins and every message after that is a line of chat. The
+// server sends each line on to the rest of the room as [sender's public key][line], so members are
+// shown by the fingerprint of the key they authenticated with and can't pass themselves off as someone
+// else. A line must be text without control characters, so it can't start a line of its own, and leave
+// room for the key, the server drops any other line.
+
+// chatKeepalive is the keepalive interval on chat connections, so the server's idle timeout doesn't
+// close the connection of a member who's only reading
+const chatKeepalive = 30 * time.Second
+
+// chatSenderLength is the size of the sender's public key in front of every line the server sends
+const chatSenderLength = 32
+
+// chatOptions returns opts set up for chat
+func chatOptions(opts *snacl.Options) *snacl.Options {
+	o := *opts
+	o.KeepaliveInterval = chatKeepalive
+	return &o
+}
+
+// chatServer keeps the chat rooms, the zero value has none
+type chatServer struct {
+	mu sync.Mutex
+	// rooms has the members of every room with someone in it
+	rooms map[string]map[*snacl.Conn]struct{}
+}
+
+// handle is the handler for serve, it adds conn to the room it asks for and sends its lines to the
+// other members until it leaves
+func (s *chatServer) handle(conn *snacl.Conn) {
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	room := string(msg.Data)
+	sender := conn.ConnectionState().PeerPublicKey
+
+	s.mu.Lock()
+	if s.rooms == nil {
+		s.rooms = make(map[string]map[*snacl.Conn]struct{})
+	}
+	if s.rooms[room] == nil {
+		s.rooms[room] = make(map[*snacl.Conn]struct{})
+	}
+	s.rooms[room][conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.rooms[room], conn)
+		if len(s.rooms[room]) == 0 {
+			delete(s.rooms, room)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		msg, err := conn.ReadMsg()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println(err)
+			}
+			return
+		}
+		if err := checkChatLine(msg.Data, conn.ConnectionState().MaxMessageLength); err != nil {
+			log.Printf("chat: dropping a line from %v: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		s.send(room, conn, append(sender[:], msg.Data...))
+	}
+}
+
+// checkChatLine returns an error unless line is text without control characters that still fits in a
+// message of max bytes with the sender in front of it
+func checkChatLine(line []byte, max int) error {
+	if len(line) > max-chatSenderLength {
+		return fmt.Errorf("line is too long (len:%d max:%d)", len(line), max-chatSenderLength)
+	}
+	if !utf8.Valid(line) {
+		return errors.New("line isn't UTF-8")
+	}
+	if i := strings.IndexFunc(string(line), unicode.IsControl); i >= 0 {
+		r, _ := utf8.DecodeRune(line[i:])
+		return fmt.Errorf("line has control character %q", r)
+	}
+	return nil
+}
+
+// escapeChatLine returns line with its control characters and invalid UTF-8 escaped, so whatever the
+// server sends is shown as one line
+func escapeChatLine(line []byte) string {
+	var b strings.Builder
+	for len(line) > 0 {
+		r, size := utf8.DecodeRune(line)
+		if r == utf8.RuneError && size <= 1 || unicode.IsControl(r) {
+			q := strconv.QuoteToASCII(string(line[:size]))
+			b.WriteString(q[1 : len(q)-1])
+		} else {
+			b.Write(line[:size])
+		}
+		line = line[size:]
+	}
+	return b.String()
+}
+
+// send queues line for every member of room except from. A member whose queue is full misses it rather
+// than holding up the room.
+func (s *chatServer) send(room string, from *snacl.Conn, line []byte) {
+	s.mu.Lock()
+	members := make([]*snacl.Conn, 0, len(s.rooms[room]))
+	for conn := range s.rooms[room] {
+		if conn != from {
+			members = append(members, conn)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, conn := range members {
+		err := conn.WriteMsgAsync(line, nil)
+		if errors.Is(err, snacl.ErrQueueFull) {
+			log.Printf("chat: %v can't keep up, dropping a line", conn.RemoteAddr())
+		} else if err != nil {
+			log.Printf("chat: dropping a line for %v: %v", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// chat joins room on the server at addr, host:port or unix:///path, then sends the lines read from in
+// and writes the room's lines to out, each after its sender's fingerprint. It returns when in ends.
+func chat(addr, room string, opts *snacl.Options, in io.Reader, out io.Writer) error {
+	network, addr := dialAddr(addr)
+	conn, err := snacl.Dial(network, addr, chatOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.WriteMsg([]byte(room)); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			msg, err := conn.ReadMsg()
+			if err != nil {
+				return
+			}
+			if len(msg.Data) < chatSenderLength {
+				log.Println("chat: the server sent a line without a sender")
+				continue
+			}
+			fmt.Fprintf(out, "%s: %s\n", snacl.Fingerprint([32]byte(msg.Data[:chatSenderLength])), escapeChatLine(msg.Data[chatSenderLength:]))
+		}
+	}()
+
+	// A longer line wouldn't leave room for the sender, the scan fails with bufio.ErrTooLong. The buffer
+	// holds the newline too.
+	lines := bufio.NewScanner(in)
+	lines.Buffer(nil, conn.ConnectionState().MaxMessageLength-chatSenderLength+1)
+	for lines.Scan() {
+		if len(lines.Bytes()) == 0 {
+			continue
+		}
+		if err := conn.WriteMsg(lines.Bytes()); err != nil {
+			return err
+		}
+	}
+	return lines.Err()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// startChat starts a chat server and returns its address
+func startChat(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serve(l, chatOptions(&snacl.Options{}), 0, (&chatServer{}).handle)
+	return l.Addr().String()
+}
+
+// joinChat starts a member of room with its own key pair and returns its stdin and stdout
+func joinChat(t *testing.T, addr, room string) (*snacl.Keys, io.WriteCloser, *bufio.Scanner) {
+	keys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go chat(addr, room, &snacl.Options{Keys: keys}, inR, outW)
+	t.Cleanup(func() { inW.Close() })
+	return keys, inW, bufio.NewScanner(outR)
+}
+
+// readChatLine reads a line a member was shown
+func readChatLine(t *testing.T, out *bufio.Scanner) string {
+	line := make(chan string, 1)
+	go func() {
+		out.Scan()
+		line <- out.Text()
+	}()
+	select {
+	case s := <-line:
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. Nothing was shown.")
+		return ""
+	}
+}
+
+// sayUntilHeard calls write every few milliseconds until out shows a line and returns it, members
+// join in the background
+func sayUntilHeard(t *testing.T, write func(), out *bufio.Scanner) string {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				write()
+			}
+		}
+	}()
+	// Nothing is still being said once it returns
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	return readChatLine(t, out)
+}
+
+func TestChat(t *testing.T) {
+	addr := startChat(t)
+	aliceKeys, alice, aliceOut := joinChat(t, addr, "lobby")
+	_, bob, bobOut := joinChat(t, addr, "lobby")
+	_, _, carolOut := joinChat(t, addr, "elsewhere")
+
+	// Alice says hello until bob is there to hear it
+	hello := sayUntilHeard(t, func() { io.WriteString(alice, "hello\n") }, bobOut)
+	if expected := snacl.Fingerprint(aliceKeys.Public) + ": hello"; hello != expected {
+		t.Fatalf("Unexpected result: %q != %q", hello, expected)
+	}
+
+	io.WriteString(bob, "hi alice\n")
+	if l := readChatLine(t, aliceOut); !strings.HasSuffix(l, ": hi alice") || strings.HasPrefix(l, snacl.Fingerprint(aliceKeys.Public)) {
+		t.Fatalf("Unexpected result: %q", l)
+	}
+
+	// Other rooms don't see the lobby
+	line := make(chan string, 1)
+	go func() {
+		carolOut.Scan()
+		line <- carolOut.Text()
+	}()
+	select {
+	case s := <-line:
+		t.Fatalf("Unexpected result: %q", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestChatSpoofing(t *testing.T) {
+	addr := startChat(t)
+	_, _, bobOut := joinChat(t, addr, "lobby")
+
+	// mallory talks to the server directly rather than through chat
+	malloryKeys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := snacl.Dial("tcp", addr, chatOptions(&snacl.Options{Keys: malloryKeys}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mallory.Close()
+	if err := mallory.WriteMsg([]byte("lobby")); err != nil {
+		t.Fatal(err)
+	}
+	prefix := snacl.Fingerprint(malloryKeys.Public) + ": "
+	if l := sayUntilHeard(t, func() { mallory.WriteMsg([]byte("hi")) }, bobOut); l != prefix+"hi" {
+		t.Fatalf("Unexpected result: %q", l)
+	}
+	// Lines said before bob heard the first one may still be on their way
+	mallory.WriteMsg([]byte("done waiting"))
+	for l := readChatLine(t, bobOut); l != prefix+"done waiting"; l = readChatLine(t, bobOut) {
+		if l != prefix+"hi" {
+			t.Fatalf("Unexpected result: %q", l)
+		}
+	}
+
+	// Lines that would show up under someone else's fingerprint are dropped
+	someone, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := snacl.Fingerprint(someone.Public) + ": give me your password"
+	for _, line := range []string{
+		"hi\n" + forged,
+		"hi\r" + forged,
+		"\x1b[2K\r" + forged,
+		strings.Repeat("x", mallory.ConnectionState().MaxMessageLength-chatSenderLength+1),
+		"\xff" + forged,
+	} {
+		if err := mallory.WriteMsg([]byte(line)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	mallory.WriteMsg([]byte("bye"))
+	if l := readChatLine(t, bobOut); l != prefix+"bye" {
+		t.Fatalf("Unexpected result: %q", l)
+	}
+
+	// Whatever the server sends is shown as one line
+	if s := escapeChatLine([]byte("hi\n" + forged + "\xffé")); s != `hi\n`+forged+`\xffé` {
+		t.Fatalf("Unexpected result: %q", s)
+	}
+}
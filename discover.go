@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/mdns"
+)
+
+// discoverTimeout is how long -discover looks for servers on the local network
+const discoverTimeout = 2 * time.Second
+
+// advertise advertises the server listening on l on the local network, see the mdns package. A
+// fingerprint is only worth advertising for a key that doesn't change, so the server gets one key
+// pair for all its connections if it doesn't have one already.
+func advertise(l net.Listener, opts *snacl.Options) (*mdns.Advertiser, error) {
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("can't advertise %v, only TCP listeners can be", l.Addr())
+	}
+	keys := opts.Keys
+	if keys == nil {
+		var err error
+		keys, err = snacl.GenerateKeys(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		opts.Keys = keys
+	}
+
+	instance, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	instance, _, _ = strings.Cut(instance, ".")
+	return mdns.Advertise(&mdns.Service{Instance: instance, Port: addr.Port, PublicKey: keys.Public})
+}
+
+// listPeers writes the servers advertising on the local network to w
+func listPeers(w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	peers, err := mdns.Browse(ctx)
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		return mdns.ErrNoPeers
+	}
+	for _, peer := range peers {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", peer.Instance, peer.Addr, peer.Fingerprint)
+	}
+	return nil
+}
+
+// dialDiscovered dials the server called instance on the local network, or the only one if instance
+// is empty, and checks it has the key it advertised
+func dialDiscovered(instance string, opts *snacl.Options) (*snacl.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	peer, err := mdns.Find(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := snacl.Dial("tcp", peer.Addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if fp := snacl.Fingerprint(conn.PeerPublicKey()); fp != peer.Fingerprint {
+		conn.Close()
+		return nil, fmt.Errorf("%s (%s) advertised the key %s but has %s", peer.Instance, peer.Addr, peer.Fingerprint, fp)
+	}
+	return conn, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/mux"
+)
+
+// Forwarding works like ssh -L. The forward subcommand listens on a local port and carries every
+// connection to it over its own stream of one multiplexed secure connection, and a server started with
+// -forward dials its backend for every stream and copies between the two:
+//
+//	go-challenge-2 -l 9000 -forward localhost:5432
+//	go-challenge-2 forward 5432 db.example.com:9000
+//
+// The backend is the server's choice, the client can't ask for anything else, so the server isn't an
+// open proxy.
+
+// forwardKeepalive is the keepalive interval on forwarding connections, so the server's idle timeout
+// doesn't close a tunnel whose connections are quiet for a while
+const forwardKeepalive = 30 * time.Second
+
+// forwardOptions returns opts set up for forwarding
+func forwardOptions(opts *snacl.Options) *snacl.Options {
+	o := *opts
+	o.KeepaliveInterval = forwardKeepalive
+	return &o
+}
+
+// forwarder carries the connections from a local listener to a forwarding server
+type forwarder struct {
+	addr string
+	opts *snacl.Options
+
+	// mu guards session, which is dialed when the first connection arrives and again after it fails
+	mu      sync.Mutex
+	session *mux.Session
+}
+
+// forward accepts plaintext connections from l and forwards each one to the server at addr, host:port
+// or unix:///path, until l fails
+func forward(l net.Listener, addr string, opts *snacl.Options) error {
+	f := &forwarder{addr: addr, opts: forwardOptions(opts)}
+	defer f.close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if temporaryAcceptError(err) {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go f.forwardConn(conn)
+	}
+}
+
+// forwardConn opens a stream for conn and copies between them
+func (f *forwarder) forwardConn(conn net.Conn) {
+	stream, err := f.open()
+	if err != nil {
+		log.Printf("forwarding %v: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	join(conn, stream)
+}
+
+// open opens a stream, dialing the server if there's no session or the last one has failed
+func (f *forwarder) open() (*mux.Stream, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.session != nil {
+		stream, err := f.session.Open()
+		if err == nil {
+			return stream, nil
+		}
+		f.session.Close()
+		f.session = nil
+	}
+
+	network, addr := dialAddr(f.addr)
+	conn, err := snacl.Dial(network, addr, f.opts)
+	if err != nil {
+		return nil, err
+	}
+	session, err := mux.Client(conn, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	f.session = session
+	return session.Open()
+}
+
+func (f *forwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.session != nil {
+		f.session.Close()
+	}
+}
+
+// forwardTo returns a handler for serve that dials backend for every stream the client opens
+func forwardTo(backend string) func(*snacl.Conn) {
+	return func(conn *snacl.Conn) {
+		session, err := mux.Server(conn, nil)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer session.Close()
+		for {
+			stream, err := session.Accept()
+			if err != nil {
+				if !errors.Is(err, mux.ErrSessionClosed) && !errors.Is(err, io.EOF) {
+					log.Println(err)
+				}
+				return
+			}
+			go func() {
+				backendConn, err := net.Dial("tcp", backend)
+				if err != nil {
+					log.Printf("forwarding to %s: %v", backend, err)
+					stream.Reset()
+					return
+				}
+				join(backendConn, stream)
+			}()
+		}
+	}
+}
+
+// join copies between conn and stream until both directions are done. A direction that reaches EOF
+// is half-closed on the other side, like TCP, so protocols that finish their requests with a FIN work.
+// A failure in either direction tears down both.
+func join(conn net.Conn, stream *mux.Stream) {
+	defer conn.Close()
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stream, conn)
+		if err == nil {
+			err = stream.Close()
+		}
+		done <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, stream)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok && err == nil {
+			err = cw.CloseWrite()
+		}
+		done <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			stream.Reset()
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func TestForward(t *testing.T) {
+	// The backend reads a request until the client half-closes, then answers and closes
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, _ := io.ReadAll(conn)
+				conn.Write(append([]byte("got "), req...))
+			}()
+		}
+	}()
+
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	opts := &snacl.Options{}
+	go serve(server, forwardOptions(opts), 0, forwardTo(backend.Addr().String()))
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	go forward(local, server.Addr().String(), opts)
+
+	// Several connections at once share the one secure connection
+	results := make(chan string, 3)
+	for _, req := range []string{"a", "b", "c"} {
+		go func() {
+			conn, err := net.Dial("tcp", local.Addr().String())
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte(req))
+			conn.(*net.TCPConn).CloseWrite()
+			resp, err := io.ReadAll(conn)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- req + ":" + string(resp)
+		}()
+	}
+	got := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		got[<-results] = true
+	}
+	for _, want := range []string{"a:got a", "b:got b", "c:got c"} {
+		if !got[want] {
+			t.Fatalf("Unexpected result: %v", got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package frame implements length-prefixed framing on top of a stream.
+package frame
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+// LengthPrefixer implements length-prefixing framing.
+// TCP and other streaming communication channels do not work on frames, but work on a stream of data.
+// A common technique to read in frames is to add a length before each message on a write, and then
+// consume the length on a read. LengthPrefixer does that for you on an underlying ReadWriteCloser
+//
+// By default the length is a uint32 in network byte order (big-endian), the same as snacl's frames.
+// Use NewLengthPrefixerOptions for other prefixes.
+type LengthPrefixer struct {
+	rw        io.ReadWriteCloser
+	maxLength uint32
+	prefix    Prefix
+	order     binary.ByteOrder
+
+	// buf is reused for every frame, it grows up to maxLength
+	buf []byte
+	// pending is a frame that didn't fit in p on the last Read
+	pending []byte
+
+	// wbuf is reused to build each frame before it's written, mu guards it when concurrent is set
+	wbuf       []byte
+	concurrent bool
+	mu         sync.Mutex
+}
+
+// Init initializes the prefixer
+// maxLength is the maximum message size that LengthPrefier will try to read (not including the uint32 header)
+// rw is the underlying stream, such as tcp.Conn
+func (l *LengthPrefixer) Init(rw io.ReadWriteCloser, maxLength uint32) {
+	l.rw = rw
+	l.maxLength = maxLength
+	l.prefix = Uint32
+	l.order = binary.BigEndian
+}
+
+// NewLengthPrefixer is a helper method that allocates a LengthPrefixer and initializes it for you
+// maxLength is the maximum message size that LengthPrefier will try to read (not including the uin32 header)
+// rw is the underlying stream, such as tcp.Conn
+func NewLengthPrefixer(rw io.ReadWriteCloser, maxLength uint32) *LengthPrefixer {
+	l := &LengthPrefixer{}
+	l.Init(rw, maxLength)
+	return l
+}
+
+// NewLegacyLengthPrefixer is NewLengthPrefixer for the old format, which had a little-endian length.
+// It's only meant for talking to peers that haven't been updated yet.
+func NewLegacyLengthPrefixer(rw io.ReadWriteCloser, maxLength uint32) *LengthPrefixer {
+	return NewLengthPrefixerOptions(rw, maxLength, &Options{Order: binary.LittleEndian})
+}
+
+// NewLengthPrefixerOptions is NewLengthPrefixer with a different length prefix, a nil opts is the same
+// as NewLengthPrefixer
+func NewLengthPrefixerOptions(rw io.ReadWriteCloser, maxLength uint32, opts *Options) *LengthPrefixer {
+	l := NewLengthPrefixer(rw, maxLength)
+	if opts != nil {
+		l.prefix = opts.Prefix
+		l.concurrent = opts.Concurrent
+		if opts.Order != nil {
+			l.order = opts.Order
+		}
+	}
+	return l
+}
+
+// Write data to the underlying stream. The data is prefixed with a length, and the prefix and data
+// are sent with a single write so a failed write never leaves half a frame on the stream.
+func (l *LengthPrefixer) Write(p []byte) (n int, err error) {
+	if l.concurrent {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+
+	frame, err := l.appendLength(l.wbuf[:0], len(p))
+	if err != nil {
+		return 0, err
+	}
+	frame = append(frame, p...)
+	// Only keep buffers for frames we'd accept ourselves, so one huge write doesn't pin its memory
+	if len(p) <= int(l.maxLength) {
+		l.wbuf = frame
+	}
+
+	err = wire.WriteFull(l.rw, frame)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read a frame from the underlying stream into p. If the frame is longer than p, Read returns
+// io.ErrShortBuffer and keeps the frame, so the next Read or ReadMsg gets it instead of a new one.
+func (l *LengthPrefixer) Read(p []byte) (n int, err error) {
+	frame, err := l.readFrame()
+	if err != nil {
+		return 0, err
+	}
+	if len(frame) > len(p) {
+		l.pending = frame
+		return 0, io.ErrShortBuffer
+	}
+	return copy(p, frame), nil
+}
+
+// ReadMsg reads a frame from the underlying stream and returns it. The frame is only valid until
+// the next call to Read or ReadMsg, the buffer is reused.
+func (l *LengthPrefixer) ReadMsg() ([]byte, error) {
+	return l.readFrame()
+}
+
+// readFrame returns the pending frame if there is one, otherwise it reads the next frame into buf
+func (l *LengthPrefixer) readFrame() ([]byte, error) {
+	if l.pending != nil {
+		frame := l.pending
+		l.pending = nil
+		return frame, nil
+	}
+
+	length, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(cap(l.buf)) < length {
+		l.buf = make([]byte, length)
+	}
+	frame := l.buf[:length]
+	_, err = io.ReadFull(l.rw, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Close closes the underlying stream
+func (l *LengthPrefixer) Close() error {
+	return l.rw.Close()
+}
@@ -0,0 +1,206 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+// buffer is a bytes.Buffer that can be closed
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Close() error { return nil }
+
+func TestLengthPrefixerByteOrder(t *testing.T) {
+	tests := []struct {
+		l        func(io.ReadWriteCloser) *LengthPrefixer
+		expected []byte
+	}{
+		{func(rw io.ReadWriteCloser) *LengthPrefixer { return NewLengthPrefixer(rw, 1024) }, []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}},
+		{func(rw io.ReadWriteCloser) *LengthPrefixer { return NewLegacyLengthPrefixer(rw, 1024) }, []byte{5, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'}},
+	}
+	for _, test := range tests {
+		var buf buffer
+		l := test.l(&buf)
+		if _, err := l.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.expected) {
+			t.Fatalf("Unexpected frame: %x != %x", buf.Bytes(), test.expected)
+		}
+
+		p := make([]byte, 16)
+		n, err := l.Read(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p[:n]); got != "hello" {
+			t.Fatalf("Unexpected result: %s != %s", got, "hello")
+		}
+	}
+}
+
+func TestLengthPrefixerOptions(t *testing.T) {
+	long := bytes.Repeat([]byte{'a'}, 300)
+	tests := []struct {
+		opts     *Options
+		msg      []byte
+		expected []byte
+	}{
+		{&Options{Prefix: Uint16}, []byte("hello"), []byte{0, 5, 'h', 'e', 'l', 'l', 'o'}},
+		{&Options{Prefix: Uint16, Order: binary.LittleEndian}, []byte("hello"), []byte{5, 0, 'h', 'e', 'l', 'l', 'o'}},
+		{&Options{Prefix: Uint32, Order: binary.LittleEndian}, []byte("hello"), []byte{5, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'}},
+		{&Options{Prefix: Uvarint}, []byte("hello"), []byte{5, 'h', 'e', 'l', 'l', 'o'}},
+		{&Options{Prefix: Uvarint}, long, append([]byte{0xac, 0x02}, long...)},
+	}
+	for _, test := range tests {
+		var buf buffer
+		l := NewLengthPrefixerOptions(&buf, 1024, test.opts)
+		if _, err := l.Write(test.msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.expected) {
+			t.Fatalf("Unexpected frame: %x != %x", buf.Bytes(), test.expected)
+		}
+
+		p := make([]byte, 1024)
+		n, err := l.Read(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p[:n], test.msg) {
+			t.Fatalf("Unexpected result: %q != %q", p[:n], test.msg)
+		}
+	}
+
+	// A frame that doesn't fit in the prefix isn't written
+	var buf buffer
+	l := NewLengthPrefixerOptions(&buf, 1<<20, &Options{Prefix: Uint16})
+	if _, err := l.Write(make([]byte, 1<<16)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Unexpected write of %d bytes", buf.Len())
+	}
+}
+
+func TestLengthPrefixerShortBuffer(t *testing.T) {
+	var buf buffer
+	l := NewLengthPrefixer(&buf, 1024)
+	for _, msg := range []string{"hello world", "bye"} {
+		if _, err := l.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The frame doesn't fit, and isn't lost
+	p := make([]byte, 5)
+	if n, err := l.Read(p); err != io.ErrShortBuffer || n != 0 {
+		t.Fatalf("Unexpected result: %d, %v", n, err)
+	}
+	msg, err := l.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello world" {
+		t.Fatalf("Unexpected result: %s != %s", msg, "hello world")
+	}
+
+	n, err := l.Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(p[:n]); got != "bye" {
+		t.Fatalf("Unexpected result: %s != %s", got, "bye")
+	}
+
+	if _, err := l.ReadMsg(); err != io.EOF {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// writeCounter counts the writes to it, and fails them all once fail is set
+type writeCounter struct {
+	buffer
+	writes int
+	fail   bool
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.fail {
+		return 0, io.ErrClosedPipe
+	}
+	return w.buffer.Write(p)
+}
+
+func TestLengthPrefixerSingleWrite(t *testing.T) {
+	w := &writeCounter{}
+	l := NewLengthPrefixer(w, 1024)
+	if _, err := l.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Fatalf("Unexpected number of writes: %d != 1", w.writes)
+	}
+
+	// A failed write doesn't leave a length prefix behind
+	w.fail = true
+	if _, err := l.Write([]byte("world")); err == nil {
+		t.Fatal("Unexpected result. Write succeeded on a failed stream.")
+	}
+	if w.Len() != wire.HeaderLength+len("hello") {
+		t.Fatalf("Unexpected stream length: %d", w.Len())
+	}
+}
+
+func TestLengthPrefixerConcurrentWrites(t *testing.T) {
+	var buf lockedBuffer
+	l := NewLengthPrefixerOptions(&buf, 1024, &Options{Concurrent: true})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := bytes.Repeat([]byte{byte('a' + i)}, 100)
+			for j := 0; j < 50; j++ {
+				if _, err := l.Write(msg); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every frame comes back whole
+	for i := 0; i < 8*50; i++ {
+		msg, err := l.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg) != 100 || !bytes.Equal(msg, bytes.Repeat(msg[:1], 100)) {
+			t.Fatalf("Unexpected frame: %q", msg)
+		}
+	}
+}
+
+// lockedBuffer is a buffer that's safe for concurrent writes, so the test only checks the framing
+type lockedBuffer struct {
+	mu sync.Mutex
+	buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
@@ -0,0 +1,106 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+var (
+	// ErrZeroLength is returned when reading a frame with a length of zero
+	ErrZeroLength = wire.ErrZeroLength
+	// ErrTooLong is returned for a frame over the maximum length, or one that doesn't fit in the prefix
+	ErrTooLong = wire.ErrTooLong
+)
+
+// Prefix is the encoding of the length in front of each frame
+type Prefix int
+
+const (
+	// Uint32 is a 4 byte length, the default
+	Uint32 Prefix = iota
+	// Uint16 is a 2 byte length, frames can't be longer than 65535 bytes
+	Uint16
+	// Uvarint is encoding/binary's unsigned varint, 1 to 5 bytes. The byte order doesn't apply to it.
+	Uvarint
+)
+
+// Options configure the length prefix, so LengthPrefixer can talk to protocols other than our own.
+// The zero value is a big-endian uint32 prefix.
+type Options struct {
+	Prefix Prefix
+	// Order is the byte order of Uint16 and Uint32 prefixes, nil means big-endian
+	Order binary.ByteOrder
+	// Concurrent makes Write safe to call from several goroutines at once
+	Concurrent bool
+}
+
+// maxPrefixLength returns the biggest length the prefix can hold
+func (p Prefix) maxPrefixLength() uint64 {
+	if p == Uint16 {
+		return math.MaxUint16
+	}
+	return math.MaxUint32
+}
+
+// readLength reads a length prefix and checks it against maxLength
+func (l *LengthPrefixer) readLength() (uint32, error) {
+	var length uint32
+	switch l.prefix {
+	case Uint16:
+		var n uint16
+		if err := binary.Read(l.rw, l.order, &n); err != nil {
+			return 0, err
+		}
+		length = uint32(n)
+	case Uvarint:
+		n, err := binary.ReadUvarint(byteReader{l.rw})
+		if err != nil {
+			return 0, err
+		}
+		if n > math.MaxUint32 {
+			return 0, fmt.Errorf("%w (len:%d max:%d)", wire.ErrTooLong, n, l.maxLength)
+		}
+		length = uint32(n)
+	default:
+		return wire.ReadLength(l.rw, l.order, l.maxLength)
+	}
+
+	if err := wire.CheckLength(length, l.maxLength); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// appendLength appends a length prefix to b, length has to fit in the prefix
+func (l *LengthPrefixer) appendLength(b []byte, length int) ([]byte, error) {
+	if max := l.prefix.maxPrefixLength(); uint64(length) > max {
+		return b, fmt.Errorf("%w (len:%d max:%d)", wire.ErrTooLong, length, max)
+	}
+
+	var buf [binary.MaxVarintLen32]byte
+	switch l.prefix {
+	case Uint16:
+		l.order.PutUint16(buf[:], uint16(length))
+		return append(b, buf[:2]...), nil
+	case Uvarint:
+		return append(b, buf[:binary.PutUvarint(buf[:], uint64(length))]...), nil
+	default:
+		l.order.PutUint32(buf[:], uint32(length))
+		return append(b, buf[:wire.HeaderLength]...), nil
+	}
+}
+
+// byteReader reads one byte at a time so a varint prefix never reads into the frame after it
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var p [1]byte
+	_, err := io.ReadFull(b.r, p[:])
+	return p[0], err
+}
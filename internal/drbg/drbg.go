@@ -0,0 +1,82 @@
+// Package drbg implements a ChaCha20-based deterministic random bit generator.
+//
+// It's used for nonces, which only have to be unique and are sent in the clear anyway, so a
+// connection doesn't have to go to crypto/rand for every message. It must not be used for keys.
+package drbg
+
+import (
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// DefaultReseedInterval is the number of bytes a DRBG hands out before it reseeds.
+// It's well under the 256GB a single ChaCha20 key and nonce can produce.
+const DefaultReseedInterval = 1 << 30
+
+// DRBG reads a ChaCha20 keystream, keyed from a seed source and rekeyed every reseed interval.
+// A DRBG is not safe for concurrent use, the idea is to have one per connection.
+type DRBG struct {
+	seed           io.Reader
+	reseedInterval uint64
+	remaining      uint64
+	stream         *chacha20.Cipher
+}
+
+// New returns a DRBG seeded from seed, which is expected to be crypto/rand.Reader or similar.
+// If reseedInterval is 0, DefaultReseedInterval is used.
+func New(seed io.Reader, reseedInterval uint64) (*DRBG, error) {
+	if reseedInterval == 0 {
+		reseedInterval = DefaultReseedInterval
+	}
+
+	d := &DRBG{seed: seed, reseedInterval: reseedInterval}
+	err := d.reseed()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// reseed replaces the keystream with one keyed by fresh data from the seed source
+func (d *DRBG) reseed() error {
+	var key [chacha20.KeySize]byte
+	_, err := io.ReadFull(d.seed, key[:])
+	if err != nil {
+		return err
+	}
+
+	// Every key is only used once, so a zero nonce is fine
+	var nonce [chacha20.NonceSize]byte
+	d.stream, err = chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		return err
+	}
+	d.remaining = d.reseedInterval
+	return nil
+}
+
+// Read fills p with random data. It only fails if it has to reseed and the seed source fails.
+func (d *DRBG) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if d.remaining == 0 {
+			err = d.reseed()
+			if err != nil {
+				return n, err
+			}
+		}
+
+		chunk := p[n:]
+		if uint64(len(chunk)) > d.remaining {
+			chunk = chunk[:d.remaining]
+		}
+		for i := range chunk {
+			chunk[i] = 0
+		}
+		d.stream.XORKeyStream(chunk, chunk)
+
+		d.remaining -= uint64(len(chunk))
+		n += len(chunk)
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package drbg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// countingReader counts how many times it was read from
+type countingReader struct {
+	reads int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return rand.Read(p)
+}
+
+func TestReseed(t *testing.T) {
+	seed := &countingReader{}
+	d, err := New(seed, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 100)
+	if _, err := d.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if seed.reads != 2 {
+		t.Fatalf("Unexpected seed reads: %d != %d", seed.reads, 2)
+	}
+	if bytes.Equal(buf, make([]byte, len(buf))) {
+		t.Fatal("Unexpected result. The DRBG returned zeroes.")
+	}
+
+	buf2 := make([]byte, 100)
+	if _, err := d.Read(buf2); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(buf, buf2) {
+		t.Fatal("Unexpected result. The DRBG repeated itself.")
+	}
+}
+
+// The two benchmarks below read a nonce per message from many goroutines at once, like a busy
+// server does. Every goroutine gets its own DRBG, while crypto/rand is shared by all of them.
+
+func BenchmarkNonceCryptoRand(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		var nonce [24]byte
+		for pb.Next() {
+			rand.Read(nonce[:])
+		}
+	})
+}
+
+func BenchmarkNonceDRBG(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		d, err := New(rand.Reader, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var nonce [24]byte
+		for pb.Next() {
+			d.Read(nonce[:])
+		}
+	})
+}
@@ -0,0 +1,8 @@
+package memlock
+
+import "golang.org/x/sys/unix"
+
+// excludeFromCoreDumps keeps mem out of core dumps
+func excludeFromCoreDumps(mem []byte) {
+	unix.Madvise(mem, unix.MADV_DONTDUMP)
+}
@@ -0,0 +1,6 @@
+//go:build darwin || freebsd
+
+package memlock
+
+// excludeFromCoreDumps does nothing, there's no MADV_DONTDUMP here
+func excludeFromCoreDumps(mem []byte) {}
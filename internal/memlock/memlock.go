@@ -0,0 +1,51 @@
+// Package memlock keeps secrets in memory that's locked so it isn't swapped out, and left out of core
+// dumps, where the system allows it. Go's heap can't be locked, the garbage collector moves and copies
+// what's in it, so the memory comes straight from the system and has to be given back explicitly.
+package memlock
+
+import "sync"
+
+// pageSize is how much memory keys are allocated in, a page holds 128 of them
+const pageSize = 4096
+
+// keys hands out 32 byte keys from pages of locked memory, so a connection with two keys doesn't take
+// two pages out of RLIMIT_MEMLOCK. Pages are never freed, freed keys are reused.
+var keys struct {
+	mu   sync.Mutex
+	free []*[32]byte
+	// locked holds the keys whose page was locked
+	locked map[*[32]byte]bool
+}
+
+// NewKey returns a zeroed 32 byte key from locked memory. locked is false if the memory couldn't be
+// locked, usually because of RLIMIT_MEMLOCK, and it's then only kept out of core dumps. The key must be
+// given back with FreeKey.
+func NewKey() (key *[32]byte, locked bool, err error) {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	if len(keys.free) == 0 {
+		page, locked, err := Alloc(pageSize)
+		if err != nil {
+			return nil, false, err
+		}
+		if keys.locked == nil {
+			keys.locked = make(map[*[32]byte]bool)
+		}
+		for i := 0; i+32 <= len(page); i += 32 {
+			key := (*[32]byte)(page[i:])
+			keys.free = append(keys.free, key)
+			keys.locked[key] = locked
+		}
+	}
+	key = keys.free[len(keys.free)-1]
+	keys.free = keys.free[:len(keys.free)-1]
+	return key, keys.locked[key], nil
+}
+
+// FreeKey zeroes a key from NewKey and gives it back, it must not be used afterwards
+func FreeKey(key *[32]byte) {
+	clear(key[:])
+	keys.mu.Lock()
+	keys.free = append(keys.free, key)
+	keys.mu.Unlock()
+}
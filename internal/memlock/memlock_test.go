@@ -0,0 +1,32 @@
+package memlock
+
+import "testing"
+
+func TestKeys(t *testing.T) {
+	// More than a page's worth, so a second page is allocated
+	var keys []*[32]byte
+	for i := 0; i < pageSize/32+1; i++ {
+		key, _, err := NewKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *key != [32]byte{} {
+			t.Fatal("Unexpected result. A new key isn't zero.")
+		}
+		key[0] = byte(i) + 1
+		keys = append(keys, key)
+	}
+	for i, key := range keys {
+		if key[0] != byte(i)+1 {
+			t.Fatal("Unexpected result. Keys overlap.")
+		}
+	}
+
+	FreeKey(keys[0])
+	if *keys[0] != [32]byte{} {
+		t.Fatal("Unexpected result. A freed key isn't zero.")
+	}
+	if reused, _, _ := NewKey(); reused != keys[0] {
+		t.Fatal("Unexpected result. A freed key wasn't reused.")
+	}
+}
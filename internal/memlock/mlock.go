@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+package memlock
+
+import "golang.org/x/sys/unix"
+
+// Alloc returns size bytes of memory of their own, locked so they're never swapped out if the
+// system allows it, and excluded from core dumps on Linux. locked is false if it couldn't be locked,
+// usually because of RLIMIT_MEMLOCK.
+func Alloc(size int) (mem []byte, locked bool, err error) {
+	mem, err = unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, false, err
+	}
+	excludeFromCoreDumps(mem)
+	return mem, unix.Mlock(mem) == nil, nil
+}
+
+// Free zeroes and frees memory from Alloc
+func Free(mem []byte) {
+	clear(mem)
+	unix.Munlock(mem)
+	unix.Munmap(mem)
+}
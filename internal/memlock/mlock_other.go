@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd)
+
+package memlock
+
+// Alloc can't lock memory here, it returns ordinary memory
+func Alloc(size int) (mem []byte, locked bool, err error) {
+	return make([]byte, size), false, nil
+}
+
+// Free zeroes memory from Alloc
+func Free(mem []byte) {
+	clear(mem)
+}
@@ -0,0 +1,74 @@
+// Package wire holds the pieces of the wire format shared by the framing code:
+// the length prefix in front of every frame and the nonce in front of every box.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// HeaderLength is the size of the length prefix in front of every frame
+	HeaderLength = 4
+	// NonceLength is the size of the nonce in front of every box
+	NonceLength = 24
+)
+
+var (
+	// ErrZeroLength is returned for a length prefix of zero
+	ErrZeroLength = errors.New("length prefix is zero")
+	// ErrTooLong is returned for a length prefix over the maximum
+	ErrTooLong = errors.New("length prefix is too big")
+)
+
+// ReadLength reads a length prefix from r and checks it with CheckLength
+func ReadLength(r io.Reader, order binary.ByteOrder, max uint32) (uint32, error) {
+	var length uint32
+	err := binary.Read(r, order, &length)
+	if err != nil {
+		return 0, err
+	}
+
+	err = CheckLength(length, max)
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// CheckLength checks that a length prefix is non-zero and no bigger than max, this stops a peer
+// from making us allocate an arbitrary amount of memory.
+func CheckLength(length, max uint32) error {
+	if length == 0 {
+		return ErrZeroLength
+	}
+	if length > max {
+		return fmt.Errorf("%w (len:%d max:%d)", ErrTooLong, length, max)
+	}
+	return nil
+}
+
+// WriteFull writes all of p to w. It keeps writing after a short write, and only gives up
+// when w returns an error or stops making progress.
+func WriteFull(w io.Writer, p []byte) error {
+	for len(p) > 0 {
+		n, err := w.Write(p)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// ReadNonce fills nonce with data from rand, rand is expected to be a cryptographically secure source.
+// nonce is at most NonceLength bytes, some ciphers use shorter nonces.
+func ReadNonce(rand io.Reader, nonce []byte) error {
+	_, err := io.ReadFull(rand, nonce)
+	return err
+}
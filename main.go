@@ -1,108 +1,398 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"golang.org/x/crypto/nacl/box"
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/agent"
 )
 
 // If you're looking for NewSecureReader and NewSecureWriter, they're in secure.go (it's easier to read from top to bottom)
+// The implementation lives in the snacl package, this file is the command line tool on top of it.
 
-// PerformHandshake performs a key exchange with the underlying stream and returns a secure version of it
-// rwc is the underlying ReadWriteCloser we want to do the handshake on
-func PerformHandshake(rwc io.ReadWriteCloser) (*SecureReadWriteCloser, error) {
-	ourPublicKey, ourPrivateKey, err := box.GenerateKey(new(CryptoRandomReader))
-	var theirPublicKey [32]byte
+// legacyOptions makes Dial and Serve speak the original challenge protocol, see snacl.Options.LegacyV0
+var legacyOptions = &snacl.Options{LegacyV0: true}
 
-	_, err = rwc.Write(ourPublicKey[:])
-	if err != nil {
-		return nil, err
-	}
+// serverIdleTimeout is how long the server waits on a connection with nothing to read or write before
+// it hangs up, see snacl.Options.IdleTimeout
+const serverIdleTimeout = 2 * time.Minute
 
-	_, err = io.ReadFull(rwc, theirPublicKey[:])
-	if err != nil {
-		return nil, err
-	}
-	return NewSecureReadWriteCloser(rwc, ourPrivateKey, &theirPublicKey), nil
-}
+// serverMaxConnections is how many connections the server handles at once, see serve
+const serverMaxConnections = 1024
 
 // Dial generates a private/public key pair,
 // connects to the server, perform the handshake
 // and return a reader/writer.
+// addr is host:port, or unix:///path for a unix socket.
+// It speaks the original challenge protocol, the command line tool only does that with -legacy.
 func Dial(addr string) (io.ReadWriteCloser, error) {
-	conn, err := net.Dial("tcp", addr)
+	return dial(addr, legacyOptions)
+}
+
+func dial(addr string, opts *snacl.Options) (io.ReadWriteCloser, error) {
+	network, addr := dialAddr(addr)
+	conn, err := snacl.Dial(network, addr, opts)
 	if err != nil {
 		return nil, err
 	}
+	return conn, nil
+}
+
+// unixScheme is how an address names a unix socket, unix:///tmp/echo.sock is the socket /tmp/echo.sock
+const unixScheme = "unix://"
+
+// dialAddr returns the network and address to dial for addr, which is host:port or unix:///path
+func dialAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// listenAddr returns the network and address to listen on for the -l flag, which is a port or
+// unix:///path for a unix socket
+func listenAddr(l string) (network, address string, err error) {
+	if path, ok := strings.CutPrefix(l, unixScheme); ok && path != "" {
+		return "unix", path, nil
+	}
+	if _, err := strconv.ParseUint(l, 10, 16); err != nil {
+		return "", "", fmt.Errorf("%q isn't a port or %s/path", l, unixScheme)
+	}
+	return "tcp", ":" + l, nil
+}
 
-	return PerformHandshake(conn)
+// listen listens for the -l flag. A unix socket left behind by a server that didn't shut down cleanly is
+// removed first, one that a server is still listening on is left alone and so is anything that isn't a
+// socket.
+func listen(l string) (net.Listener, error) {
+	network, addr, err := listenAddr(l)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		// Dialing a regular file is refused too, only a socket may be removed
+		if info, err := os.Lstat(addr); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			if conn, err := net.Dial(network, addr); err == nil {
+				conn.Close()
+			} else if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(addr)
+			}
+		}
+	}
+	return net.Listen(network, addr)
 }
 
 // Serve starts a secure echo server on the given listener.
+// It speaks the original challenge protocol, the command line tool only does that with -legacy.
+// Connections that are idle for serverIdleTimeout are closed, and at most serverMaxConnections are
+// handled at once.
 func Serve(l net.Listener) error {
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			return err
+	opts := *legacyOptions
+	opts.IdleTimeout = serverIdleTimeout
+	return serve(l, &opts, serverMaxConnections, echo)
+}
+
+// serve runs handler on each connection from l, handling at most maxConns at once, 0 means no limit.
+// Past the limit nothing is accepted until a connection finishes, so a flood waits in the listen
+// backlog and is refused by the kernel rather than piling up goroutines.
+func serve(l net.Listener, opts *snacl.Options, maxConns int, handler func(*snacl.Conn)) error {
+	s, err := newServer(opts, maxConns, "")
+	if err != nil {
+		return err
+	}
+	return s.serve(l, handler)
+}
+
+// serveConn runs handler on conn and closes it. A panic in handler is logged and only takes down the
+// one connection, not the whole server.
+func serveConn(conn *snacl.Conn, handler func(*snacl.Conn)) {
+	defer conn.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic serving %v: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
 		}
-		go func(conn net.Conn) {
-			defer conn.Close()
+	}()
+	handler(conn)
+}
 
-			sconn, err := PerformHandshake(conn)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
+// echo reads a message and sends it back
+func echo(conn *snacl.Conn) {
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-			msg, err := sconn.ReadMsg()
-			if err != nil {
-				log.Println(err)
-				return
-			}
+	_, err = conn.Write(msg.Data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
 
-			_, err = sconn.Write(msg.Data)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-		}(conn)
+// temporaryAcceptError returns true for Accept errors that go away by themselves, like running out of
+// file descriptors or a client resetting a connection before it was accepted
+func temporaryAcceptError(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
 	}
+	return false
 }
 
 func main() {
-	port := flag.Int("l", 0, "Listen mode. Specify port")
+	listenFlag := flag.String("l", "", "Listen mode. Specify a port, or unix:///path for a unix socket")
+	legacy := flag.Bool("legacy", false, "Speak the original challenge protocol, for peers that haven't been updated")
+	idle := flag.Duration("idle", serverIdleTimeout, "Listen mode. Close connections idle for this long, 0 never closes them")
+	maxConns := flag.Int("max-conns", serverMaxConnections, "Listen mode. How many connections are handled at once, 0 is no limit")
+	backend := flag.String("forward", "", "Listen mode. Forward the streams of forward clients to this host:port instead of echoing")
+	chatFlag := flag.Bool("chat", false, "Listen mode. Keep chat rooms for chat clients instead of echoing")
+	advertiseFlag := flag.Bool("advertise", false, "Listen mode. Advertise the server on the local network with mDNS")
+	config := flag.String("config", "", "Listen mode. Read the key pair, allowed client keys and limits from this JSON file, and again on SIGHUP")
+	agentSocket := flag.String("agent", os.Getenv(agent.EnvSocket), "Use the key pair held by the key agent on this unix socket, see the agent subcommand")
+	keyFlag := flag.String("key", "", "Use this key pair instead of a new one: a key file, env:VAR, vault:<mount>/<path> or vault-transit:<key>:<file>")
+	pipeFlag := flag.Bool("pipe", false, "Client mode. Send stdin instead of a message and copy everything the server sends to stdout, like netcat")
+	discover := flag.Bool("discover", false, "Find the server on the local network with mDNS instead of giving a port, list the servers if there's no message")
 	flag.Parse()
+	opts := &snacl.Options{LegacyV0: *legacy}
+
+	// Hold a key pair for other processes
+	if flag.NArg() == 3 && flag.Arg(0) == "agent" {
+		log.Fatal(runAgent(flag.Arg(1), flag.Arg(2)))
+	}
+	if *keyFlag != "" && *agentSocket != "" {
+		log.Fatal("-key and -agent both give a key pair, use one of them")
+	}
+	if *keyFlag != "" {
+		keys, err := loadKey(*keyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Keys = keys
+	}
+	if *agentSocket != "" {
+		c, err := agent.Dial(*agentSocket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+		opts = c.Options(opts)
+	}
 
 	// Server mode
-	if *port != 0 {
-		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if *listenFlag != "" {
+		opts.IdleTimeout = *idle
+		l, err := listen(*listenFlag)
 		if err != nil {
 			log.Fatal(err)
 			return
 		}
 		defer l.Close()
-		log.Fatal(Serve(l))
+		if *advertiseFlag {
+			a, err := advertise(l, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer a.Close()
+		}
+		handler := echo
+		if *backend != "" {
+			opts, handler = forwardOptions(opts), forwardTo(*backend)
+		}
+		if *chatFlag {
+			opts, handler = chatOptions(opts), (&chatServer{}).handle
+		}
+		s, err := newServer(opts, *maxConns, *config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *config != "" {
+			s.reloadOnHangup()
+		}
+		log.Fatal(s.serve(l, handler))
+	}
+
+	// Forward a local port to a server started with -forward, like ssh -L
+	if flag.NArg() == 3 && flag.Arg(0) == "forward" {
+		l, err := net.Listen("tcp", "localhost:"+flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer l.Close()
+		log.Fatal(forward(l, flag.Arg(2), opts))
+	}
+
+	// Join a chat room on a server started with -chat
+	if flag.NArg() == 3 && flag.Arg(0) == "chat" {
+		if err := chat(flag.Arg(1), flag.Arg(2), opts, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Receive files sent with the send subcommand into a directory, the current one by default
+	if (flag.NArg() == 2 || flag.NArg() == 3) && flag.Arg(0) == "receive" {
+		dir := "."
+		if flag.NArg() == 3 {
+			dir = flag.Arg(2)
+		}
+		l, err := listen(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer l.Close()
+		opts.IdleTimeout = *idle
+		s, err := newServer(opts, *maxConns, *config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal(s.serve(l, receiveInto(dir)))
+	}
+
+	// Send a file to a receiver, carrying on from where an earlier try was cut off
+	if flag.NArg() == 3 && flag.Arg(0) == "send" {
+		network, addr := dialAddr(flag.Arg(2))
+		conn, err := snacl.Dial(network, addr, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		if err := sendFile(conn, flag.Arg(1), printProgress(os.Stderr, filepath.Base(flag.Arg(1)))); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Push a directory tree to a receiver, only sending the files it doesn't have
+	if flag.NArg() == 3 && flag.Arg(0) == "sync" {
+		network, addr := dialAddr(flag.Arg(2))
+		conn, err := snacl.Dial(network, addr, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		progress := func(name string) func(sent, size int64) {
+			return printProgress(os.Stderr, name)
+		}
+		if err := sendTree(conn, flag.Arg(1), progress); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Client mode
-	if len(os.Args) != 3 {
-		log.Fatalf("Usage: %s <port> <message>", os.Args[0])
+	// Print the wire format test vectors for other implementations
+	if flag.NArg() == 1 && flag.Arg(0) == "vectors" {
+		vectors, err := snacl.GenerateVectors()
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(vectors); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Benchmark settings on this machine and recommend options
+	if flag.NArg() == 1 && flag.Arg(0) == "tune" {
+		if err := tune(os.Stdout, 300*time.Millisecond); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Split a private key into shares for backup, and put it back together
+	if flag.NArg() == 4 && flag.Arg(0) == "split" {
+		n, err := strconv.Atoi(flag.Arg(2))
+		if err != nil {
+			log.Fatal(err)
+		}
+		k, err := strconv.Atoi(flag.Arg(3))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := splitKey(os.Stdout, flag.Arg(1), n, k); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() == 1 && flag.Arg(0) == "combine" {
+		if err := combineKey(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// List the servers on the local network
+	if *discover && flag.NArg() == 0 && !*pipeFlag {
+		if err := listPeers(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Client mode, the last argument is the message unless it's -pipe
+	args := flag.Args()
+	message := ""
+	if !*pipeFlag && len(args) > 0 {
+		message, args = args[len(args)-1], args[:len(args)-1]
+	}
+	var conn io.ReadWriteCloser
+	var err error
+	switch {
+	case *discover && len(args) <= 1:
+		instance := ""
+		if len(args) == 1 {
+			instance = args[0]
+		}
+		conn, err = dialDiscovered(instance, opts)
+	case len(args) == 1:
+		addr := args[0]
+		if !strings.HasPrefix(addr, unixScheme) {
+			addr = "localhost:" + addr
+		}
+		conn, err = dial(addr, opts)
+	default:
+		log.Fatalf("Usage: %s [-legacy] <port|unix:///path> <message>\n       %s [-legacy] -pipe <port|unix:///path>\n       %s [-legacy] -discover [-pipe] [server] [message]\n       %s [-legacy] forward <local port> <host:port|unix:///path>\n       %s [-legacy] chat <host:port|unix:///path> <room>\n       %s [-legacy] receive <port|unix:///path> [dir]\n       %s [-legacy] send <file> <host:port|unix:///path>\n       %s sync <dir> <host:port|unix:///path>\n       %s agent <socket path> <key>\n       %s split <key> <shares> <needed>\n       %s combine < shares", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	}
-	conn, err := Dial("localhost:" + os.Args[1])
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	if _, err := conn.Write([]byte(os.Args[2])); err != nil {
+	if *pipeFlag {
+		if err := netcat(conn, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if _, err := conn.Write([]byte(message)); err != nil {
 		log.Fatal(err)
 	}
-	buf := make([]byte, len(os.Args[2]))
+	buf := make([]byte, len(message))
 	n, err := conn.Read(buf)
 	if err != nil {
 		log.Fatal(err)
@@ -181,3 +181,41 @@ func TestSecureDial(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// failingWriter accepts the first n writes and fails every write after that
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, io.ErrClosedPipe
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestSecureWriterCount(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	// Each message is sent with a single write
+	secureW := NewSecureWriter(&failingWriter{n: 1}, priv, pub)
+	msg := make([]byte, 2*MaxMessageLength+1)
+
+	n, err := secureW.Write(msg)
+	if err == nil {
+		t.Fatal("Unexpected result. Write succeeded on a failed stream.")
+	}
+	if n != MaxMessageLength {
+		t.Fatalf("Unexpected count: %d != %d", n, MaxMessageLength)
+	}
+
+	secureW = NewSecureWriter(&failingWriter{n: 3}, priv, pub)
+	n, err = secureW.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(msg) {
+		t.Fatalf("Unexpected count: %d != %d", n, len(msg))
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+)
+
+// netcat copies in to conn and conn to out at the same time, like netcat, so the client can sit in a
+// shell pipeline. When in ends the writing side of conn is closed, so the server sees the end of the
+// input, and netcat returns once the server has closed its side too.
+func netcat(conn io.ReadWriteCloser, in io.Reader, out io.Writer) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, conn)
+		done <- err
+	}()
+
+	_, err := io.Copy(conn, in)
+	if err == nil {
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			err = cw.CloseWrite()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return <-done
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func TestNetcat(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// The server answers once it has read all the input
+	go serve(l, nil, 0, func(conn *snacl.Conn) {
+		var got bytes.Buffer
+		if _, err := conn.WriteTo(&got); err != nil {
+			return
+		}
+		conn.Write(bytes.ToUpper(got.Bytes()))
+	})
+
+	conn, err := dial(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	input := strings.Repeat("hello pipe\n", 1000)
+	var out bytes.Buffer
+	if err := netcat(conn, strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out.String() != strings.ToUpper(input) {
+		t.Fatalf("Unexpected result: %d bytes", out.Len())
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/keystore"
+)
+
+// A server started with -config takes its key pair, the client keys it allows and its limits from a
+// JSON file, and reads it again on SIGHUP:
+//
+//	{
+//	  "key_file": "/etc/snacl/server.key",
+//	  "allow": ["<base64 client public key>", ...],
+//	  "max_conns": 1024,
+//	  "idle_timeout": "2m"
+//	}
+//
+// Every field is optional, a missing one leaves the flag's setting, and an empty allow list lets every
+// client in. The key file holds the base64 private key, any 32 random bytes will do:
+//
+//	head -c 32 /dev/urandom | base64 > server.key
+//
+// Instead of key_file, "key" takes the key from anywhere -key can, see the keystore package, so
+// "key": "vault:secret/snacl/server" fetches it from Vault again on every reload.
+//
+// A reload only applies to new connections, the ones already open carry on with what they started
+// with. A config that doesn't load is logged and the server keeps the one it had.
+
+// serverConfig is the -config file
+type serverConfig struct {
+	KeyFile     string   `json:"key_file"`
+	Key         string   `json:"key"`
+	Allow       []string `json:"allow"`
+	MaxConns    *int     `json:"max_conns"`
+	IdleTimeout string   `json:"idle_timeout"`
+}
+
+// server accepts connections and runs a handler on each, with settings that can be reloaded while it
+// runs, see Reload
+type server struct {
+	// base is what the flags set, configPath is the -config file or empty
+	base       *snacl.Options
+	baseConns  int
+	configPath string
+
+	// mu guards the settings and active, the number of connections being handled. cond is signalled
+	// when a connection finishes or the limit changes.
+	mu       sync.Mutex
+	cond     *sync.Cond
+	opts     *snacl.Options
+	maxConns int
+	allow    map[[32]byte]bool
+	active   int
+}
+
+// newServer returns a server with opts and maxConns from the flags, overridden by the config at
+// configPath if it isn't empty
+func newServer(opts *snacl.Options, maxConns int, configPath string) (*server, error) {
+	if opts == nil {
+		opts = &snacl.Options{}
+	}
+	s := &server{base: opts, baseConns: maxConns, configPath: configPath}
+	s.cond = sync.NewCond(&s.mu)
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the config again and applies it to new connections. On error the settings are left as
+// they were.
+func (s *server) Reload() error {
+	opts := *s.base
+	maxConns := s.baseConns
+	var allow map[[32]byte]bool
+
+	if s.configPath != "" {
+		data, err := os.ReadFile(s.configPath)
+		if err != nil {
+			return err
+		}
+		var cfg serverConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s: %w", s.configPath, err)
+		}
+		if cfg.KeyFile != "" && cfg.Key != "" {
+			return fmt.Errorf("%s: key_file and key can't both be set", s.configPath)
+		}
+		if spec := cfg.Key + cfg.KeyFile; spec != "" {
+			if s.base.Keys != nil || s.base.KeyExchanger != nil {
+				return errors.New("key_file and key can't be used with -advertise, -agent or -key, which bring their own key")
+			}
+			if cfg.KeyFile != "" {
+				spec = "file:" + cfg.KeyFile
+			}
+			if opts.Keys, err = loadKey(spec); err != nil {
+				return err
+			}
+		}
+		for _, key := range cfg.Allow {
+			pub, err := decodeKey(key)
+			if err != nil {
+				return fmt.Errorf("%s: allow: %w", s.configPath, err)
+			}
+			if allow == nil {
+				allow = make(map[[32]byte]bool)
+			}
+			allow[pub] = true
+		}
+		if cfg.MaxConns != nil {
+			maxConns = *cfg.MaxConns
+		}
+		if cfg.IdleTimeout != "" {
+			if opts.IdleTimeout, err = time.ParseDuration(cfg.IdleTimeout); err != nil {
+				return fmt.Errorf("%s: idle_timeout: %w", s.configPath, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.opts, s.maxConns, s.allow = &opts, maxConns, allow
+	s.mu.Unlock()
+	// A higher limit may let a waiting Accept through
+	s.cond.Broadcast()
+	return nil
+}
+
+// reloadOnHangup calls Reload whenever the process gets SIGHUP
+func (s *server) reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := s.Reload(); err != nil {
+				log.Printf("reload failed, keeping the old config: %v", err)
+				continue
+			}
+			log.Printf("reloaded %s", s.configPath)
+		}
+	}()
+}
+
+// settings returns the options and allowed client keys for a new connection
+func (s *server) settings() (*snacl.Options, map[[32]byte]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts, s.allow
+}
+
+// acquire waits until there are fewer than maxConns connections and counts a new one
+func (s *server) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.maxConns > 0 && s.active >= s.maxConns {
+		s.cond.Wait()
+	}
+	s.active++
+}
+
+// release counts a connection that's finished
+func (s *server) release() {
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+// allowOnly wraps handler so it only runs for clients with a key in allow, nil allows every client
+func allowOnly(allow map[[32]byte]bool, handler func(*snacl.Conn)) func(*snacl.Conn) {
+	if allow == nil {
+		return handler
+	}
+	return func(conn *snacl.Conn) {
+		if err := conn.Handshake(); err != nil {
+			log.Println(err)
+			return
+		}
+		if pub := conn.PeerPublicKey(); !allow[pub] {
+			log.Printf("%v: client key %s isn't allowed", conn.RemoteAddr(), snacl.Fingerprint(pub))
+			return
+		}
+		handler(conn)
+	}
+}
+
+// loadKey loads the key pair spec points at, see the keystore package
+func loadKey(spec string) (*snacl.Keys, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return keystore.Load(ctx, spec)
+}
+
+// decodeKey decodes a base64 key
+func decodeKey(s string) ([32]byte, error) {
+	var key [32]byte
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return key, err
+	}
+	if len(decoded) != len(key) {
+		return key, fmt.Errorf("a key is 32 bytes, got %d", len(decoded))
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// serve runs handler on each connection from l with the server's settings at the time it's accepted
+func (s *server) serve(l net.Listener, handler func(*snacl.Conn)) error {
+	var delay time.Duration
+	for {
+		s.acquire()
+		rawConn, err := l.Accept()
+		if err != nil {
+			s.release()
+			if !temporaryAcceptError(err) {
+				return err
+			}
+			// Back off like net/http does, so running out of file descriptors doesn't spin
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			log.Printf("accept error: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		opts, allow := s.settings()
+		go func(conn *snacl.Conn) {
+			defer s.release()
+			serveConn(conn, allowOnly(allow, handler))
+		}(snacl.Server(rawConn, opts))
+	}
+}
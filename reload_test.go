@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// writeConfig writes a -config file with a new server key allowing clients, and returns the server key
+func writeConfig(t *testing.T, path string, clients ...*snacl.Keys) *snacl.Keys {
+	keys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := path + ".key"
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(keys.Private[:])+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{KeyFile: keyFile, IdleTimeout: "1m"}
+	for _, client := range clients {
+		cfg.Allow = append(cfg.Allow, base64.StdEncoding.EncodeToString(client.Public[:]))
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// echoOnce dials addr with keys, sends a message and returns the server's key, or an error if the
+// message didn't come back
+func echoOnce(addr string, keys *snacl.Keys) ([32]byte, error) {
+	conn, err := snacl.Dial("tcp", addr, &snacl.Options{Keys: keys})
+	if err != nil {
+		return [32]byte{}, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		return [32]byte{}, err
+	}
+	if _, err := conn.ReadMsg(); err != nil {
+		return [32]byte{}, err
+	}
+	return conn.PeerPublicKey(), nil
+}
+
+func TestServerReload(t *testing.T) {
+	alice, _ := snacl.GenerateKeys(rand.Reader)
+	bob, _ := snacl.GenerateKeys(rand.Reader)
+	path := filepath.Join(t.TempDir(), "server.json")
+	first := writeConfig(t, path, alice)
+
+	s, err := newServer(nil, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.serve(l, echo)
+	addr := l.Addr().String()
+
+	if key, err := echoOnce(addr, alice); err != nil || key != first.Public {
+		t.Fatalf("Unexpected result: %x %v", key, err)
+	}
+	if _, err := echoOnce(addr, bob); err == nil {
+		t.Fatal("Unexpected result. A client that isn't allowed was served.")
+	}
+
+	// A connection from before the reload carries on with the old key
+	open, err := snacl.Dial("tcp", addr, &snacl.Options{Keys: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	if err := open.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := writeConfig(t, path, bob)
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := echoOnce(addr, bob); err != nil || key != second.Public {
+		t.Fatalf("Unexpected result: %x %v", key, err)
+	}
+	if _, err := echoOnce(addr, alice); err == nil {
+		t.Fatal("Unexpected result. A client that's no longer allowed was served.")
+	}
+	if _, err := open.Write([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := open.ReadMsg(); err != nil || string(msg.Data) != "still here" || open.PeerPublicKey() != first.Public {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// A config that doesn't load leaves the old one in place
+	if err := os.WriteFile(path, []byte(`{"max_conns": "lots"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Fatal("Unexpected result. A bad config was loaded.")
+	}
+	if key, err := echoOnce(addr, bob); err != nil || key != second.Public {
+		t.Fatalf("Unexpected result: %x %v", key, err)
+	}
+}
+
+func TestServerReloadOnHangup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	writeConfig(t, path)
+	s, err := newServer(nil, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.reloadOnHangup()
+
+	second := writeConfig(t, path)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		opts, _ := s.settings()
+		if opts.Keys.Public == second.Public {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Unexpected result. SIGHUP didn't reload the config.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerReloadLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(path, []byte(`{"max_conns": 1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(nil, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.acquire()
+
+	// The second connection waits for a slot, until the limit goes up
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Unexpected result. A connection was handled past the limit.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := os.WriteFile(path, []byte(`{"max_conns": 2}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	<-acquired
+}
+
+func TestServerConfigKey(t *testing.T) {
+	keys, _ := snacl.GenerateKeys(rand.Reader)
+	t.Setenv("SNACL_TEST_SERVER_KEY", base64.StdEncoding.EncodeToString(keys.Private[:]))
+	path := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(path, []byte(`{"key": "env:SNACL_TEST_SERVER_KEY"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(nil, 0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts, _ := s.settings(); opts.Keys.Public != keys.Public {
+		t.Fatal("Unexpected result. The key wasn't loaded.")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"key": "env:SNACL_TEST_SERVER_KEY", "key_file": "server.key"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Fatal("Unexpected result. Both key and key_file were accepted.")
+	}
+}
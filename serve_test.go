@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/mdns"
+)
+
+func TestServeConnectionLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serve(l, nil, 1, echo)
+
+	// The first connection takes the only slot until it's done
+	first, err := snacl.Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second one is only accepted once the first has finished, until then its handshake waits
+	done := make(chan error, 1)
+	go func() {
+		conn, err := snacl.Dial("tcp", l.Addr().String(), nil)
+		if err == nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Unexpected result. The second connection was handled past the limit: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flakyListener fails its first Accepts with err
+type flakyListener struct {
+	net.Listener
+	err   error
+	fails int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeTemporaryAcceptErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	go serve(&flakyListener{Listener: l, err: emfile, fails: 3}, nil, 0, echo)
+
+	conn, err := snacl.Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "hello" {
+		t.Fatalf("Unexpected result: %q", msg.Data)
+	}
+}
+
+func TestServePermanentAcceptError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if err := serve(l, nil, 0, echo); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestServeConnPanic(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	conn := snacl.Server(c2, nil)
+
+	// The panic is recovered and the connection closed
+	serveConn(conn, func(*snacl.Conn) {
+		panic("malformed interaction")
+	})
+	if _, err := c2.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestServeUnix(t *testing.T) {
+	path := t.TempDir() + "/echo.sock"
+	// A socket left behind by a server that's gone doesn't get in the way
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen(unixScheme + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go Serve(l)
+
+	conn, err := Dial(unixScheme + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Unexpected result: %q %v", buf[:n], err)
+	}
+
+	// One a server is listening on is left alone
+	if _, err := listen(unixScheme + path); err == nil {
+		t.Fatal("Unexpected result. Listened on a socket that's in use.")
+	}
+
+	// So is a file that isn't a socket, though dialing it is refused like a stale socket
+	file := t.TempDir() + "/notes.txt"
+	if err := os.WriteFile(file, []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(unixScheme + file); err == nil {
+		t.Fatal("Unexpected result. Listened in place of a regular file.")
+	}
+	if got, err := os.ReadFile(file); err != nil || string(got) != "notes" {
+		t.Fatalf("Unexpected result: %q %v", got, err)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for _, tt := range []struct{ l, network, addr string }{
+		{"8080", "tcp", ":8080"},
+		{"unix:///tmp/echo.sock", "unix", "/tmp/echo.sock"},
+		{"unix://echo.sock", "unix", "echo.sock"},
+	} {
+		if network, addr, err := listenAddr(tt.l); err != nil || network != tt.network || addr != tt.addr {
+			t.Fatalf("Unexpected result for %q: %s %s %v", tt.l, network, addr, err)
+		}
+	}
+	// Anything else is a mistake, not a socket path
+	for _, l := range []string{"70000", "-1", "notes.txt", "/tmp/echo.sock", "unix://", ""} {
+		if _, _, err := listenAddr(l); err == nil {
+			t.Fatalf("Unexpected result. %q was accepted.", l)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	opts := &snacl.Options{}
+	a, err := advertise(l, opts)
+	if err != nil {
+		t.Skipf("Multicast isn't available: %v", err)
+	}
+	defer a.Close()
+	go serve(l, opts, 0, echo)
+
+	instance, _ := os.Hostname()
+	instance, _, _ = strings.Cut(instance, ".")
+	conn, err := dialDiscovered(instance, nil)
+	if errors.Is(err, mdns.ErrNoPeers) {
+		t.Skip("Multicast doesn't reach this host's own sockets")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.PeerPublicKey() != opts.Keys.Public {
+		t.Fatal("Unexpected result. The server didn't use the advertised key.")
+	}
+}
@@ -0,0 +1,81 @@
+package snacl
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+// When both sides set Options.AssociatedData, every frame carries associated data in the clear between
+// the length prefix and the nonce:
+//
+//	[length uint32be][associated data length uint16be][associated data][nonce][box]
+//
+// The associated data is authenticated as the cipher's additional data, so it can't be changed without
+// the box failing to open. Frames without any have a length of zero.
+
+// aadHeaderLength is the size of the associated data length
+const aadHeaderLength = 2
+
+// MaxAssociatedDataLength is the most associated data a message can carry
+const MaxAssociatedDataLength = 1024
+
+// ErrNoAssociatedData is returned for associated data on a connection that didn't negotiate it
+var ErrNoAssociatedData = errors.New("associated data wasn't negotiated, see Options.AssociatedData")
+
+// sealFrameAAD is sealFrame with associated data, see above
+func sealFrameAAD(out, msg, aad, nonce []byte, aead cipher.AEAD) []byte {
+	start := len(out)
+	out = append(out, make([]byte, wire.HeaderLength)...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(aad)))
+	out = append(out, aad...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, msg, aad)
+
+	binary.BigEndian.PutUint32(out[start:], uint32(len(out)-start-wire.HeaderLength))
+	return out
+}
+
+// splitAAD splits a frame from readFrame into its associated data and the [nonce][box] part
+func splitAAD(frame []byte) (aad, rest []byte, err error) {
+	length := int(binary.BigEndian.Uint16(frame))
+	if length > MaxAssociatedDataLength || aadHeaderLength+length > len(frame) {
+		return nil, nil, fmt.Errorf("%w (associated data len:%d max:%d)", ErrFrameTooLarge, length, MaxAssociatedDataLength)
+	}
+	return frame[aadHeaderLength : aadHeaderLength+length], frame[aadHeaderLength+length:], nil
+}
+
+// WriteMsgAAD sends msg as a single message with aad as its associated data, which is sent in the
+// clear but can't be tampered with. It returns ErrNoAssociatedData unless both sides set
+// Options.AssociatedData. msg can be at most ConnectionState.MaxMessageLength bytes.
+func (c *Conn) WriteMsgAAD(msg, aad []byte) error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	if len(msg) > c.state.MaxMessageLength {
+		return fmt.Errorf("message is too large (len:%d max:%d)", len(msg), c.state.MaxMessageLength)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	// Anything buffered was written first
+	err = c.sw.Flush()
+	if err != nil {
+		return err
+	}
+	return c.closedErr(c.sw.enc.Encode(&Message{Data: msg, AssociatedData: aad}))
+}
+
+// ReadMsgAAD reads the next message and returns it with its associated data, see WriteMsgAAD.
+// Messages sent by Write have no associated data.
+func (c *Conn) ReadMsgAAD() (msg, aad []byte, err error) {
+	m, err := c.ReadMsg()
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.Data, m.AssociatedData, nil
+}
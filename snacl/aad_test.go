@@ -0,0 +1,74 @@
+package snacl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConnAssociatedData(t *testing.T) {
+	for _, suite := range defaultCipherSuites {
+		t.Run(suite.Name(), func(t *testing.T) {
+			client, server := pipe(t, &Options{AssociatedData: true, CipherSuites: []CipherSuite{suite}})
+			if !client.ConnectionState().AssociatedData {
+				t.Fatal("Unexpected result. Associated data wasn't negotiated.")
+			}
+
+			go func() {
+				client.WriteMsgAAD([]byte("body"), []byte("route: a"))
+				client.Write([]byte("plain"))
+			}()
+			msg, aad, err := server.ReadMsgAAD()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != "body" || string(aad) != "route: a" {
+				t.Fatalf("Unexpected result: %q %q", msg, aad)
+			}
+			msg, aad, err = server.ReadMsgAAD()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != "plain" || len(aad) != 0 {
+				t.Fatalf("Unexpected result: %q %q", msg, aad)
+			}
+		})
+	}
+
+	// Both sides have to offer it
+	client, _ := pipeOptions(t, &Options{AssociatedData: true}, nil)
+	if err := client.WriteMsgAAD([]byte("body"), []byte("route: a")); err != ErrNoAssociatedData {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestAssociatedDataTampered(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+	for _, suite := range defaultCipherSuites {
+		var buf bytes.Buffer
+		enc := newEncoder(&buf, key)
+		enc.withAAD = true
+		if err := enc.setSuite(suite); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(&Message{Data: []byte("body"), AssociatedData: []byte("route: a")}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The associated data is in the clear
+		frame := buf.Bytes()
+		i := bytes.Index(frame, []byte("route: a"))
+		if i < 0 {
+			t.Fatalf("%s: the associated data isn't in the frame", suite.Name())
+		}
+		frame[i+len("route: ")] = 'b'
+
+		dec := newDecoder(&buf, key)
+		dec.withAAD = true
+		if err := dec.setSuite(suite); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Decode(&Message{}); err != ErrDecrypt {
+			t.Fatalf("%s: unexpected error: %v", suite.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Package agent keeps a private key in a process of its own, like ssh-agent, so the applications that
+// use it never hold it. The agent answers requests on a unix socket, and only anyone who can open the
+// socket can use the key, so it belongs in a directory only its owner can get into.
+//
+// Requests and responses are frames, see the frame package:
+//
+//	[op uint8][arguments]
+//	[status uint8][result, or an error message]
+//
+// opPublicKey returns the public key. opX25519 takes a peer's public key and returns the X25519 shared
+// secret with it, which is all a handshake needs, see Client.Options, and opPrecompute returns the box
+// shared key with it instead. opSeal and opOpen take
+// [peer public key 32][nonce 24][message] and seal or open the message with box, for applications that
+// don't want even the shared keys.
+//
+// The private key is kept in memory of its own, locked so it isn't swapped out where the system allows
+// it (check Agent.Locked), kept out of core dumps on Linux, and zeroed on Close.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/arianitu/go-challenge-2/frame"
+	"github.com/arianitu/go-challenge-2/internal/memlock"
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// EnvSocket is the environment variable with the agent's socket, like SSH_AUTH_SOCK
+const EnvSocket = "SNACL_AGENT_SOCK"
+
+// maxRequestLength bounds requests and responses, seal and open are meant for small messages
+const maxRequestLength = 1 << 20
+
+const (
+	opPublicKey byte = iota + 1
+	opPrecompute
+	opSeal
+	opOpen
+	opX25519
+)
+
+const (
+	statusOK byte = iota
+	statusError
+)
+
+// ErrAgent wraps errors reported by the agent, use errors.Is
+var ErrAgent = errors.New("agent")
+
+// Agent holds a key pair and answers requests for it
+type Agent struct {
+	public [32]byte
+	// mem holds the private key, see memlock.Alloc
+	mem     []byte
+	private *[32]byte
+	locked  bool
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	closed    bool
+}
+
+// New returns an agent holding a copy of keys. The caller should zero its own copy once it's done with
+// it.
+func New(keys *snacl.Keys) (*Agent, error) {
+	mem, locked, err := memlock.Alloc(len(keys.Private))
+	if err != nil {
+		return nil, err
+	}
+	a := &Agent{public: keys.Public, mem: mem, private: (*[32]byte)(mem), locked: locked}
+	copy(a.private[:], keys.Private[:])
+	return a, nil
+}
+
+// Locked returns true if the private key's memory is locked, so it can't be swapped out
+func (a *Agent) Locked() bool {
+	return a.locked
+}
+
+// Listen listens on the unix socket at path, readable and writable only by this user, and answers
+// requests on it until the agent is closed
+func (a *Agent) Listen(path string) error {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return err
+	}
+	return a.Serve(l)
+}
+
+// Serve answers requests on connections from l until l fails or the agent is closed
+func (a *Agent) Serve(l net.Listener) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	a.listeners = append(a.listeners, l)
+	a.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// serveConn answers requests on conn until it's closed
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	f := frame.NewLengthPrefixer(conn, maxRequestLength)
+	for {
+		req, err := f.ReadMsg()
+		if err != nil {
+			return
+		}
+		result, err := a.handle(req)
+		var resp []byte
+		if err != nil {
+			resp = append([]byte{statusError}, err.Error()...)
+		} else {
+			resp = append([]byte{statusOK}, result...)
+		}
+		if _, err := f.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request
+func (a *Agent) handle(req []byte) ([]byte, error) {
+	if len(req) == 0 {
+		return nil, errors.New("empty request")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil, errors.New("agent closed")
+	}
+
+	op, args := req[0], req[1:]
+	switch op {
+	case opPublicKey:
+		return a.public[:], nil
+	case opPrecompute:
+		if len(args) != 32 {
+			return nil, errors.New("bad precompute request")
+		}
+		var shared [32]byte
+		box.Precompute(&shared, (*[32]byte)(args), a.private)
+		return shared[:], nil
+	case opX25519:
+		if len(args) != 32 {
+			return nil, errors.New("bad X25519 request")
+		}
+		return curve25519.X25519(a.private[:], args)
+	case opSeal, opOpen:
+		if len(args) < 32+24 {
+			return nil, errors.New("bad seal or open request")
+		}
+		peer, nonce, msg := (*[32]byte)(args), (*[24]byte)(args[32:]), args[32+24:]
+		if op == opSeal {
+			return box.Seal(nil, msg, nonce, peer, a.private), nil
+		}
+		opened, ok := box.Open(nil, msg, nonce, peer, a.private)
+		if !ok {
+			return nil, errors.New("message failed authentication")
+		}
+		return opened, nil
+	default:
+		return nil, fmt.Errorf("unknown op %d", op)
+	}
+}
+
+// Close stops serving, and zeroes and frees the private key
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	for _, l := range a.listeners {
+		l.Close()
+	}
+	memlock.Free(a.mem)
+	a.private = nil
+	return nil
+}
+
+// Client talks to an agent
+type Client struct {
+	public [32]byte
+
+	// mu makes requests one at a time, the protocol has no request IDs
+	mu   sync.Mutex
+	conn net.Conn
+	f    *frame.LengthPrefixer
+}
+
+// Dial connects to the agent listening on the unix socket at path, or on the socket in EnvSocket if
+// path is empty
+func Dial(path string) (*Client, error) {
+	if path == "" {
+		path = os.Getenv(EnvSocket)
+		if path == "" {
+			return nil, fmt.Errorf("agent: no socket given and %s isn't set", EnvSocket)
+		}
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, f: frame.NewLengthPrefixer(conn, maxRequestLength)}
+	public, err := c.call(opPublicKey, nil)
+	if err == nil && len(public) != 32 {
+		err = errors.New("agent: bad public key")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	copy(c.public[:], public)
+	return c, nil
+}
+
+// call sends a request and returns the result
+func (c *Client) call(op byte, args []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.f.Write(append([]byte{op}, args...)); err != nil {
+		return nil, err
+	}
+	resp, err := c.f.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 {
+		return nil, errors.New("agent: empty response")
+	}
+	if resp[0] != statusOK {
+		return nil, fmt.Errorf("%w: %s", ErrAgent, resp[1:])
+	}
+	// The frame's buffer is reused by the next request
+	return append([]byte(nil), resp[1:]...), nil
+}
+
+// PublicKey returns the agent's public key
+func (c *Client) PublicKey() [32]byte {
+	return c.public
+}
+
+// X25519 returns the X25519 shared secret between the agent's key and peer, it makes Client a
+// snacl.KeyExchanger
+func (c *Client) X25519(peer *[32]byte) ([32]byte, error) {
+	var shared [32]byte
+	result, err := c.call(opX25519, peer[:])
+	if err == nil && len(result) != len(shared) {
+		err = errors.New("agent: bad shared secret")
+	}
+	copy(shared[:], result)
+	return shared, err
+}
+
+// Precompute returns the box shared key between the agent's key and peer, like box.Precompute
+func (c *Client) Precompute(peer *[32]byte) ([32]byte, error) {
+	var shared [32]byte
+	result, err := c.call(opPrecompute, peer[:])
+	if err == nil && len(result) != len(shared) {
+		err = errors.New("agent: bad shared key")
+	}
+	copy(shared[:], result)
+	return shared, err
+}
+
+// Seal appends msg sealed for peer with box to out, like box.Seal with the agent's private key
+func (c *Client) Seal(out, msg []byte, nonce *[24]byte, peer *[32]byte) ([]byte, error) {
+	result, err := c.call(opSeal, append(append(append([]byte(nil), peer[:]...), nonce[:]...), msg...))
+	if err != nil {
+		return nil, err
+	}
+	return append(out, result...), nil
+}
+
+// Open appends the message sealed in boxed by peer to out, like box.Open with the agent's private key
+func (c *Client) Open(out, boxed []byte, nonce *[24]byte, peer *[32]byte) ([]byte, error) {
+	result, err := c.call(opOpen, append(append(append([]byte(nil), peer[:]...), nonce[:]...), boxed...))
+	if err != nil {
+		return nil, err
+	}
+	return append(out, result...), nil
+}
+
+// Options returns a copy of opts, which may be nil, that uses the agent's key pair
+func (c *Client) Options(opts *snacl.Options) *snacl.Options {
+	var o snacl.Options
+	if opts != nil {
+		o = *opts
+	}
+	o.Keys = nil
+	o.KeyExchanger = c
+	return &o
+}
+
+// Close closes the connection to the agent
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
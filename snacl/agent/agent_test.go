@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// startAgent starts an agent for keys and returns a client connected to it
+func startAgent(t *testing.T, keys *snacl.Keys) (*Agent, *Client) {
+	a, err := New(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve(l)
+
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return a, c
+}
+
+func mustGenerateKeys(t *testing.T) *snacl.Keys {
+	keys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestAgentOperations(t *testing.T) {
+	keys, peer := mustGenerateKeys(t), mustGenerateKeys(t)
+	_, c := startAgent(t, keys)
+	if c.PublicKey() != keys.Public {
+		t.Fatal("Unexpected result. The agent has another public key.")
+	}
+
+	var expected [32]byte
+	box.Precompute(&expected, &peer.Public, &keys.Private)
+	if shared, err := c.Precompute(&peer.Public); err != nil || shared != expected {
+		t.Fatalf("Unexpected result: %x %v", shared, err)
+	}
+	expected, _ = keys.X25519(&peer.Public)
+	if shared, err := c.X25519(&peer.Public); err != nil || shared != expected {
+		t.Fatalf("Unexpected result: %x %v", shared, err)
+	}
+
+	var nonce [24]byte
+	rand.Read(nonce[:])
+	sealed, err := c.Seal(nil, []byte("hello"), &nonce, &peer.Public)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opened, ok := box.Open(nil, sealed, &nonce, &keys.Public, &peer.Private); !ok || string(opened) != "hello" {
+		t.Fatalf("Unexpected result: %q %v", opened, ok)
+	}
+	reply := box.Seal(nil, []byte("hi"), &nonce, &keys.Public, &peer.Private)
+	if opened, err := c.Open(nil, reply, &nonce, &peer.Public); err != nil || string(opened) != "hi" {
+		t.Fatalf("Unexpected result: %q %v", opened, err)
+	}
+	reply[0] ^= 1
+	if _, err := c.Open(nil, reply, &nonce, &peer.Public); !errors.Is(err, ErrAgent) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
+
+func TestAgentHandshake(t *testing.T) {
+	keys := mustGenerateKeys(t)
+	_, c := startAgent(t, keys)
+
+	// The client's side of the handshake is done by the agent, in every version
+	for _, opts := range []*snacl.Options{nil, {LegacyV0: true}, {Noise: true}} {
+		c1, c2 := net.Pipe()
+		client := snacl.Client(c1, c.Options(opts))
+		server := snacl.Server(c2, opts)
+		go func() {
+			server.Write([]byte("hello"))
+		}()
+		msg, err := client.ReadMsg()
+		if err != nil || string(msg.Data) != "hello" {
+			t.Fatalf("Unexpected result: %v", err)
+		}
+		if server.PeerPublicKey() != keys.Public {
+			t.Fatal("Unexpected result. The server saw another key.")
+		}
+		client.Close()
+		server.Close()
+	}
+
+}
+
+func TestAgentClose(t *testing.T) {
+	keys := mustGenerateKeys(t)
+	a, c := startAgent(t, keys)
+	a.Close()
+	if _, err := c.Precompute(&keys.Public); err == nil {
+		t.Fatal("Unexpected result. A closed agent answered.")
+	}
+
+	t.Setenv(EnvSocket, "")
+	if _, err := Dial(""); err == nil {
+		t.Fatal("Unexpected result. Dialed without a socket.")
+	}
+}
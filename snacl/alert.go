@@ -0,0 +1,124 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A Conn that hangs up because of something the other side did tells it why first, so the other side
+// gets an error it can act on instead of a reset connection. An alert is a recordAlert, sealed and
+// authenticated like any other record:
+//
+//	[alert uint8]
+//
+// Alerts are sent when a read fails because of what the other side sent, see alertFor, and when the
+// other side's key is rejected once the handshake has proved it holds it. The other side's reads then
+// fail with an *AlertError. Version0 has no records to send them in, so it just hangs up.
+
+// Alert is the reason in an alert, see AlertError
+type Alert uint8
+
+// Alerts. Codes we don't know are still reported, they're only for diagnosis.
+const (
+	// AlertBadRecord is sent for a frame or record that couldn't be opened or made no sense
+	AlertBadRecord Alert = 1
+	// AlertMaxSizeExceeded is sent for a frame bigger than the negotiated ConnectionState.MaxMessageLength
+	AlertMaxSizeExceeded Alert = 2
+	// AlertAuthFailed is sent when the handshake rejects the other side's key, see Options.PeerKeys,
+	// Options.RevocationChecker and Options.TrustedCAs
+	AlertAuthFailed Alert = 3
+	// AlertInternalError is for the application, see Conn.CloseWithAlert
+	AlertInternalError Alert = 4
+)
+
+// alertTimeout is how long sending an alert can take before we hang up without it, the other side may
+// not be reading
+const alertTimeout = time.Second
+
+func (a Alert) String() string {
+	switch a {
+	case AlertBadRecord:
+		return "bad_record"
+	case AlertMaxSizeExceeded:
+		return "max_size_exceeded"
+	case AlertAuthFailed:
+		return "auth_failed"
+	case AlertInternalError:
+		return "internal_error"
+	}
+	return fmt.Sprintf("alert %d", uint8(a))
+}
+
+// AlertError is returned by reads once the other side has sent an alert, it's about to hang up. Use
+// errors.As to get the alert.
+type AlertError struct {
+	Alert Alert
+}
+
+func (e *AlertError) Error() string {
+	return "peer sent alert: " + e.Alert.String()
+}
+
+// alertFor returns the alert for a read that failed with err, or 0 if it isn't the other side's fault
+func alertFor(err error) Alert {
+	switch {
+	case errors.Is(err, ErrFrameTooLarge):
+		return AlertMaxSizeExceeded
+	case errors.Is(err, ErrBadRecord), errors.Is(err, ErrDecrypt), errors.Is(err, ErrFrameTooShort),
+		errors.Is(err, ErrFrameEmpty), errors.Is(err, ErrFlowControl):
+		return AlertBadRecord
+	}
+	return 0
+}
+
+// readAlert returns the error for an alert record from the other side
+func readAlert(data []byte) error {
+	if len(data) != 1 {
+		return fmt.Errorf("%w: alert of %d bytes", ErrBadRecord, len(data))
+	}
+	return &AlertError{Alert: Alert(data[0])}
+}
+
+// readErr returns the error for a failed read. If the other side is to blame, it's sent an alert and
+// the Conn is closed.
+func (c *Conn) readErr(err error) error {
+	err = c.closedErr(err)
+	if alert := alertFor(err); alert != 0 {
+		c.sendAlert(alert)
+		c.Close()
+	}
+	return err
+}
+
+// CloseWithAlert sends the other side alert and closes the Conn, its reads then fail with an
+// *AlertError. It's for an application hanging up because something went wrong, AlertInternalError
+// usually. Version0 can't send alerts, it only closes.
+func (c *Conn) CloseWithAlert(alert Alert) error {
+	if c.ready.Load() {
+		c.sendAlert(alert)
+	}
+	return c.Close()
+}
+
+// sendAlert sends an alert, we're about to hang up. If the other side doesn't take it within
+// alertTimeout the stream is closed, which ends the write.
+func (c *Conn) sendAlert(alert Alert) {
+	if c.sw == nil || !c.sw.enc.typed {
+		return
+	}
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		enc := c.sw.enc
+		enc.mu.Lock()
+		defer enc.mu.Unlock()
+		enc.writeRecord(recordAlert, []byte{byte(alert)}, nil)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(alertTimeout):
+		c.rwc.Close()
+		<-sent
+	}
+}
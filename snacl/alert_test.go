@@ -0,0 +1,80 @@
+package snacl
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAlertMaxSizeExceeded(t *testing.T) {
+	client, server := pipe(t, &Options{MaxMessageLength: 100})
+	defer client.Close()
+	defer server.Close()
+
+	// The client ignores the negotiated length
+	client.sw.SetMaxMessageLength(1000)
+	// net.Pipe doesn't buffer, so the rest of the frame is stuck until the server hangs up
+	go client.Write(make([]byte, 500))
+	clientErr := make(chan error, 1)
+	go func() {
+		_, err := client.ReadMsg()
+		clientErr <- err
+	}()
+	if _, err := server.ReadMsg(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var alert *AlertError
+	if err := <-clientErr; !errors.As(err, &alert) || alert.Alert != AlertMaxSizeExceeded {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The server hung up
+	if err := server.WriteMsg([]byte("hello")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCloseWithAlert(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer server.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := server.ReadMsg()
+		serverErr <- err
+	}()
+	if err := client.CloseWithAlert(AlertInternalError); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var alert *AlertError
+	if err := <-serverErr; !errors.As(err, &alert) || alert.Alert != AlertInternalError {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if alert.Error() != "peer sent alert: internal_error" || Alert(99).String() != "alert 99" {
+		t.Fatalf("Unexpected result: %s, %s", alert, Alert(99))
+	}
+
+	// Version0 has nowhere to put an alert, it only hangs up
+	legacy, legacyServer := pipe(t, &Options{LegacyV0: true})
+	defer legacyServer.Close()
+	go legacyServer.ReadMsg()
+	if err := legacy.CloseWithAlert(AlertInternalError); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestBadAlert(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		enc := client.sw.enc
+		enc.mu.Lock()
+		enc.writeRecord(recordAlert, []byte{1, 2}, nil)
+		enc.mu.Unlock()
+		client.ReadMsg()
+	}()
+	if _, err := server.ReadMsg(); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
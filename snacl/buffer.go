@@ -0,0 +1,117 @@
+package snacl
+
+import (
+	"sync"
+	"time"
+)
+
+// writeBuffer collects small writes so they can be sealed as one message.
+// Every message costs the length prefix, the nonce and box.Overhead, 44 bytes in total, which adds
+// up quickly for chatty protocols that write a few bytes at a time.
+type writeBuffer struct {
+	sw    *Writer
+	size  int
+	delay time.Duration
+
+	// mu guards everything below, the timer flushes from its own goroutine
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	// err is the first error from a flush started by the timer, it's returned by the next call
+	err error
+}
+
+// Buffer turns on buffered mode. Writes are collected and sealed as a single message once size bytes
+// are waiting, once delay has passed since the first write that's waiting, or when Flush is called.
+// A size of 0 or more than the Writer's maximum message length means the maximum, a delay of 0 means there's no timer
+// and data waits for the buffer to fill up or for Flush.
+// Buffer must be called before the Writer is used.
+func (sw *Writer) Buffer(size int, delay time.Duration) {
+	if size <= 0 || size > sw.enc.maxLength {
+		size = sw.enc.maxLength
+	}
+	sw.buffered = &writeBuffer{
+		sw:    sw,
+		size:  size,
+		delay: delay,
+		buf:   make([]byte, 0, size),
+	}
+}
+
+// Flush seals everything that's waiting in the buffer and sends it. It does nothing if the
+// Writer isn't in buffered mode.
+func (sw *Writer) Flush() error {
+	if sw.buffered == nil {
+		return nil
+	}
+
+	b := sw.buffered
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+// Write copies p into the buffer and sends full buffers. The returned count includes the part
+// of p that's still waiting in the buffer, like bufio.Writer.
+func (b *writeBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	// pending is how much of the buffer came from earlier calls, a failed flush loses those too but
+	// they aren't this call's to count
+	pending := len(b.buf)
+	for len(p) > 0 {
+		m := copy(b.buf[len(b.buf):b.size], p)
+		b.buf = b.buf[:len(b.buf)+m]
+		p = p[m:]
+		n += m
+
+		if len(b.buf) == b.size {
+			err = b.flush()
+			if err != nil {
+				return max(0, n-(len(b.buf)-pending)), err
+			}
+			pending = 0
+		}
+	}
+
+	if len(b.buf) > 0 && b.timer == nil && b.delay > 0 {
+		b.timer = time.AfterFunc(b.delay, b.flushTimer)
+	}
+	return n, nil
+}
+
+// flush sends the buffer as one message, b.mu must be held
+func (b *writeBuffer) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+
+	_, err := b.sw.write(b.buf)
+	if err != nil {
+		b.err = err
+		return err
+	}
+	b.buf = b.buf[:0]
+	return nil
+}
+
+// flushTimer is called by the timer once the oldest waiting write has waited for the delay
+func (b *writeBuffer) flushTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.timer = nil
+	b.flush()
+}
@@ -0,0 +1,341 @@
+// Package call does request/response over one snacl.Conn. A Client's Call sends a request and waits
+// for its response, and any number of calls can be in flight at once: every request has an ID that its
+// response carries back, so responses can arrive in any order. Serve answers requests with a Handler,
+// each in its own goroutine.
+//
+// Every frame is a single snacl message:
+//
+//	[type uint8][request ID uint64be][data]
+//
+// A caller that gives up on a request sends frameCancel, which cancels the handler's context.
+package call
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Frame types
+const (
+	// frameRequest is a request, the data is the request
+	frameRequest byte = 0
+	// frameResponse answers a request, the data is the response
+	frameResponse byte = 1
+	// frameError answers a request whose handler failed, the data is the error's message
+	frameError byte = 2
+	// frameCancel says the caller gave up on a request, it has no data
+	frameCancel byte = 3
+)
+
+// headerLength is the size of a frame's type and request ID
+const headerLength = 9
+
+var (
+	// ErrClientClosed is returned for calls on a closed Client, and calls in flight when it closed
+	ErrClientClosed = errors.New("call: client closed")
+	// ErrProtocol is returned when the other side breaks the protocol, the connection is closed
+	ErrProtocol = errors.New("call: protocol error")
+)
+
+// RemoteError is returned by Call when the handler failed, it holds the handler's error message
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "call: remote error: " + e.Message
+}
+
+// Handler answers requests, see Serve. ctx is cancelled when the caller gives up or the connection
+// fails. An error is sent back to the caller as a RemoteError.
+type Handler interface {
+	ServeCall(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// HandlerFunc is a function that's a Handler
+type HandlerFunc func(ctx context.Context, req []byte) ([]byte, error)
+
+// ServeCall calls f
+func (f HandlerFunc) ServeCall(ctx context.Context, req []byte) ([]byte, error) {
+	return f(ctx, req)
+}
+
+// result is a call's response or error
+type result struct {
+	resp []byte
+	err  error
+}
+
+// Client makes calls over a snacl.Conn
+type Client struct {
+	conn *snacl.Conn
+	// maxData is the most data that fits in one frame
+	maxData int
+
+	mu      sync.Mutex
+	pending map[uint64]chan result
+	nextID  uint64
+	err     error
+
+	closeOnce sync.Once
+}
+
+// NewClient starts a client on conn, which it reads from until it's closed
+func NewClient(conn *snacl.Conn) (*Client, error) {
+	maxData, err := maxDataLength(conn)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		maxData: maxData,
+		pending: make(map[uint64]chan result),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// maxDataLength does the handshake and returns the most data that fits in one frame
+func maxDataLength(conn *snacl.Conn) (int, error) {
+	err := conn.Handshake()
+	if err != nil {
+		return 0, err
+	}
+	maxData := conn.ConnectionState().MaxMessageLength - headerLength
+	if maxData <= 0 {
+		return 0, fmt.Errorf("call: MaxMessageLength %d is too small", conn.ConnectionState().MaxMessageLength)
+	}
+	return maxData, nil
+}
+
+// Call sends req and waits for the response. If ctx is done first the handler is told to give up, and
+// Call returns ctx.Err().
+func (c *Client) Call(ctx context.Context, req []byte) ([]byte, error) {
+	if len(req) > c.maxData {
+		return nil, fmt.Errorf("call: request is too large (len:%d max:%d)", len(req), c.maxData)
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	id := c.nextID
+	c.nextID++
+	done := make(chan result, 1)
+	c.pending[id] = done
+	c.mu.Unlock()
+
+	err := c.writeFrame(frameRequest, id, req)
+	if err != nil {
+		c.forget(id)
+		return nil, err
+	}
+
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		if c.forget(id) {
+			// The handler is still running, there's no point in it finishing
+			c.writeFrame(frameCancel, id, nil)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// forget stops waiting for a response, it returns false if the response already came
+func (c *Client) forget(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// Close fails the calls in flight with ErrClientClosed and closes the underlying connection
+func (c *Client) Close() error {
+	return c.closeWithErr(ErrClientClosed)
+}
+
+// closeWithErr closes the client because of err, the first error is the one that's kept. It returns
+// the error from closing the connection, or nil if the client was already closed.
+func (c *Client) closeWithErr(err error) error {
+	var closeErr error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		pending := c.pending
+		c.pending = make(map[uint64]chan result)
+		c.mu.Unlock()
+
+		closeErr = c.conn.Close()
+		for _, done := range pending {
+			done <- result{err: err}
+		}
+	})
+	return closeErr
+}
+
+// writeFrame sends a frame, a failure closes the client
+func (c *Client) writeFrame(typ byte, id uint64, data []byte) error {
+	err := writeFrame(c.conn, typ, id, data)
+	if err != nil {
+		c.closeWithErr(err)
+	}
+	return err
+}
+
+// readLoop hands responses to their calls until the connection fails
+func (c *Client) readLoop() {
+	for {
+		msg, err := c.conn.ReadMsg()
+		if err == io.EOF {
+			err = ErrClientClosed
+		}
+		if err == nil {
+			err = c.handleFrame(msg.Data)
+		}
+		if err != nil {
+			c.closeWithErr(err)
+			return
+		}
+	}
+}
+
+func (c *Client) handleFrame(frame []byte) error {
+	typ, id, data, err := parseFrame(frame)
+	if err != nil {
+		return err
+	}
+	var r result
+	switch typ {
+	case frameResponse:
+		r.resp = data
+	case frameError:
+		r.err = &RemoteError{Message: string(data)}
+	default:
+		return fmt.Errorf("%w: frame type %d sent to a client", ErrProtocol, typ)
+	}
+
+	c.mu.Lock()
+	done := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if done != nil {
+		// Responses can still arrive for calls that gave up
+		done <- r
+	}
+	return nil
+}
+
+// Serve answers the requests on conn with h until conn fails or the other side closes it, which
+// returns nil. Every request is handled in its own goroutine, Serve waits for them before it returns.
+func Serve(conn *snacl.Conn, h Handler) error {
+	maxData, err := maxDataLength(conn)
+	if err != nil {
+		return err
+	}
+
+	// The handlers are cancelled before they're waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	running := make(map[uint64]context.CancelFunc)
+	for {
+		msg, err := conn.ReadMsg()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		typ, id, req, err := parseFrame(msg.Data)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+
+		switch typ {
+		case frameRequest:
+			callCtx, callCancel := context.WithCancel(ctx)
+			mu.Lock()
+			if _, ok := running[id]; ok {
+				mu.Unlock()
+				callCancel()
+				conn.Close()
+				return fmt.Errorf("%w: request %d is already running", ErrProtocol, id)
+			}
+			running[id] = callCancel
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(running, id)
+					mu.Unlock()
+					callCancel()
+				}()
+				serveCall(callCtx, conn, h, id, req, maxData)
+			}()
+		case frameCancel:
+			mu.Lock()
+			if callCancel := running[id]; callCancel != nil {
+				callCancel()
+			}
+			mu.Unlock()
+		default:
+			conn.Close()
+			return fmt.Errorf("%w: frame type %d sent to a server", ErrProtocol, typ)
+		}
+	}
+}
+
+// serveCall runs h for one request and sends back what it returns. A request the caller gave up on
+// gets no answer.
+func serveCall(ctx context.Context, conn *snacl.Conn, h Handler, id uint64, req []byte, maxData int) {
+	resp, err := h.ServeCall(ctx, req)
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil && len(resp) > maxData {
+		err = fmt.Errorf("response is too large (len:%d max:%d)", len(resp), maxData)
+	}
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > maxData {
+			msg = msg[:maxData]
+		}
+		writeFrame(conn, frameError, id, []byte(msg))
+		return
+	}
+	writeFrame(conn, frameResponse, id, resp)
+}
+
+// writeFrame sends a frame as a single message
+func writeFrame(conn *snacl.Conn, typ byte, id uint64, data []byte) error {
+	frame := make([]byte, headerLength, headerLength+len(data))
+	frame[0] = typ
+	binary.BigEndian.PutUint64(frame[1:], id)
+	frame = append(frame, data...)
+	return conn.WriteMsg(frame)
+}
+
+// parseFrame splits a frame into its type, request ID and data
+func parseFrame(frame []byte) (typ byte, id uint64, data []byte, err error) {
+	if len(frame) < headerLength {
+		return 0, 0, nil, fmt.Errorf("%w: short frame", ErrProtocol)
+	}
+	return frame[0], binary.BigEndian.Uint64(frame[1:]), frame[headerLength:], nil
+}
@@ -0,0 +1,206 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/securetest"
+)
+
+// pipe returns a Client whose calls are answered by h
+func pipe(t *testing.T, h Handler) (client *Client, served chan error) {
+	c, s := securetest.Pipe()
+	served = make(chan error, 1)
+	go func() {
+		served <- Serve(s, h)
+	}()
+	client, err := NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		s.Close()
+	})
+	return client, served
+}
+
+// echo answers every request with itself
+var echo = HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+	return req, nil
+})
+
+func TestCall(t *testing.T) {
+	// Every handler waits for the one after it, so the responses come back in reverse
+	const calls = 8
+	var gates [calls + 1]chan struct{}
+	for i := range gates {
+		gates[i] = make(chan struct{})
+	}
+	close(gates[calls])
+	client, _ := pipe(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		var i int
+		fmt.Sscan(string(req), &i)
+		<-gates[i+1]
+		defer close(gates[i])
+		return append([]byte("re: "), req...), nil
+	}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := fmt.Sprint(i)
+			resp, err := client.Call(context.Background(), []byte(req))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(resp) != "re: "+req {
+				errs <- fmt.Errorf("response %q to %q", resp, req)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCallRemoteError(t *testing.T) {
+	client, _ := pipe(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		if len(req) == 0 {
+			return nil, errors.New("empty request")
+		}
+		return make([]byte, snacl.MaxMessageLength), nil
+	}))
+
+	_, err := client.Call(context.Background(), nil)
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Message != "empty request" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A response that doesn't fit in a frame fails the call, not the connection
+	if _, err := client.Call(context.Background(), []byte("big")); !errors.As(err, &remote) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp, err := client.Call(context.Background(), nil); !errors.As(err, &remote) || resp != nil {
+		t.Fatalf("Unexpected result: %q %v", resp, err)
+	}
+
+	if _, err := client.Call(context.Background(), make([]byte, snacl.MaxMessageLength)); err == nil {
+		t.Fatal("Unexpected result. A request too large for a frame was sent.")
+	}
+}
+
+func TestCallCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	client, _ := pipe(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		if string(req) == "echo" {
+			return req, nil
+		}
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(ctx, []byte("wait")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. The handler wasn't cancelled.")
+	}
+
+	// The connection is still usable
+	if resp, err := client.Call(context.Background(), []byte("echo")); err != nil || string(resp) != "echo" {
+		t.Fatalf("Unexpected result: %q %v", resp, err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	started := make(chan struct{})
+	client, served := pipe(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+
+	called := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), []byte("wait"))
+		called <- err
+	}()
+	<-started
+	client.Close()
+
+	if err := <-called; !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Call(context.Background(), nil); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Serve cancels the running handler and returns
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. Serve didn't return.")
+	}
+}
+
+func TestServeProtocolError(t *testing.T) {
+	c, s := securetest.Pipe()
+	defer c.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(s, echo)
+	}()
+
+	c.Write([]byte{frameResponse, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err := <-served; !errors.Is(err, ErrProtocol) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := c.ReadMsg(); err == nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCallBufferedConn(t *testing.T) {
+	// Buffered writes would collect small frames into one message, every frame must still be its own
+	opts := &snacl.Options{WriteBufferSize: 1024, WriteBufferDelay: time.Hour}
+	c, s := securetest.PipeOptions(opts, opts)
+	defer c.Close()
+	defer s.Close()
+	go Serve(s, echo)
+	client, err := NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, req := range []string{"a", "bb", "ccc"} {
+		resp, err := client.Call(ctx, []byte(req))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(resp) != req {
+			t.Fatalf("Unexpected result: %q", resp)
+		}
+	}
+}
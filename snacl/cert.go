@@ -0,0 +1,129 @@
+package snacl
+
+import (
+	"cmp"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Certificates let a fleet of services trust each other through a CA rather than pinning every key
+// pair. A CA is an Ed25519 key pair kept offline, and a certificate is the CA's signature over a
+// subject name, a public key and an expiry:
+//
+//	[magic "SNCT"][version uint8][subject length uint8][subject][public key 32][not after int64be][signature 64]
+//
+// The signature covers everything before it. Options.Certificate is sent in the hello, and a side with
+// Options.TrustedCAs only finishes the handshake with a peer whose certificate one of them signed for
+// the key the peer is using. The finished messages prove the peer has the key's private half, so the
+// certificate can't be replayed by someone else.
+
+const (
+	certMagic   = "SNCT"
+	certVersion = 1
+)
+
+// ErrBadCertificate is returned for certificates that can't be parsed or don't verify. It's wrapped
+// with the reason, use errors.Is.
+var ErrBadCertificate = errors.New("bad certificate")
+
+// Certificate binds a subject name to a public key until NotAfter, see cert.go
+type Certificate struct {
+	Subject   string
+	PublicKey [32]byte
+	// NotAfter is when the certificate expires, it's kept to the second
+	NotAfter  time.Time
+	Signature []byte
+}
+
+// SignCertificate returns a certificate for subject and pub that expires at notAfter, signed by ca
+func SignCertificate(ca ed25519.PrivateKey, subject string, pub [32]byte, notAfter time.Time) (*Certificate, error) {
+	if len(subject) == 0 || len(subject) > 255 {
+		return nil, fmt.Errorf("certificate subject must be 1 to 255 bytes, got %d", len(subject))
+	}
+	cert := &Certificate{Subject: subject, PublicKey: pub, NotAfter: time.Unix(notAfter.Unix(), 0)}
+	cert.Signature = ed25519.Sign(ca, cert.signed())
+	return cert, nil
+}
+
+// signed returns the part of the certificate the signature covers
+func (cert *Certificate) signed() []byte {
+	b := make([]byte, 0, len(certMagic)+2+len(cert.Subject)+32+8)
+	b = append(b, certMagic...)
+	b = append(b, certVersion, byte(len(cert.Subject)))
+	b = append(b, cert.Subject...)
+	b = append(b, cert.PublicKey[:]...)
+	return binary.BigEndian.AppendUint64(b, uint64(cert.NotAfter.Unix()))
+}
+
+// Marshal returns the certificate in its wire format
+func (cert *Certificate) Marshal() []byte {
+	return append(cert.signed(), cert.Signature...)
+}
+
+// ParseCertificate parses a certificate in its wire format, it doesn't verify it
+func ParseCertificate(b []byte) (*Certificate, error) {
+	header := len(certMagic) + 2
+	if len(b) < header || string(b[:len(certMagic)]) != certMagic {
+		return nil, fmt.Errorf("%w: not a certificate", ErrBadCertificate)
+	}
+	if b[len(certMagic)] != certVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadCertificate, b[len(certMagic)])
+	}
+	subjectLength := int(b[len(certMagic)+1])
+	if len(b) != header+subjectLength+32+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: wrong length", ErrBadCertificate)
+	}
+
+	b = b[header:]
+	cert := &Certificate{Subject: string(b[:subjectLength])}
+	b = b[subjectLength:]
+	copy(cert.PublicKey[:], b)
+	cert.NotAfter = time.Unix(int64(binary.BigEndian.Uint64(b[32:])), 0)
+	cert.Signature = append([]byte(nil), b[40:]...)
+	return cert, nil
+}
+
+// Verify checks the certificate was signed by one of cas and hasn't expired at now
+func (cert *Certificate) Verify(cas []ed25519.PublicKey, now time.Time) error {
+	if !now.Before(cert.NotAfter) {
+		return fmt.Errorf("%w: expired at %v", ErrBadCertificate, cert.NotAfter)
+	}
+	signed := cert.signed()
+	for _, ca := range cas {
+		if ed25519.Verify(ca, signed, cert.Signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: not signed by a trusted CA", ErrBadCertificate)
+}
+
+// verifyPeerCertificate checks the certificate in the other side's hello against opts, it returns the
+// certificate once it's been verified
+func verifyPeerCertificate(opts *Options, raw []byte, peerKey [32]byte) (*Certificate, error) {
+	if len(opts.TrustedCAs) == 0 {
+		// There's nothing to check it against, and a certificate that wasn't checked says nothing
+		return nil, nil
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%w: the other side has no certificate", ErrHandshakeFailed)
+	}
+	cert, err := ParseCertificate(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+	if err := cert.Verify(opts.TrustedCAs, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+	if cert.PublicKey != peerKey {
+		return nil, fmt.Errorf("%w: %w: it's for another key", ErrHandshakeFailed, ErrBadCertificate)
+	}
+	// A client that named the server it wants expects a certificate for that name
+	name := cmp.Or(opts.PeerName, opts.ServerName)
+	if name != "" && cert.Subject != name {
+		return nil, fmt.Errorf("%w: %w: it's for %q, not %q", ErrHandshakeFailed, ErrBadCertificate, cert.Subject, name)
+	}
+	return cert, nil
+}
@@ -0,0 +1,153 @@
+package snacl
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// newCA returns a CA key pair
+func newCA(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+// certifiedOptions returns options with a new key pair and a certificate for it signed by ca
+func certifiedOptions(t *testing.T, ca ed25519.PrivateKey, subject string, notAfter time.Time) *Options {
+	keys := mustGenerateKeys(t)
+	cert, err := SignCertificate(ca, subject, keys.Public, notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Options{Keys: keys, Certificate: cert}
+}
+
+func TestCertificateRoundTrip(t *testing.T) {
+	caPub, caPriv := newCA(t)
+	keys := mustGenerateKeys(t)
+	cert, err := SignCertificate(caPriv, "db.example.com", keys.Public, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseCertificate(cert.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.Subject != cert.Subject || parsed.PublicKey != cert.PublicKey || !parsed.NotAfter.Equal(cert.NotAfter) {
+		t.Fatalf("Unexpected result: %+v", parsed)
+	}
+	if err := parsed.Verify([]ed25519.PublicKey{caPub}, time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	otherPub, _ := newCA(t)
+	if err := parsed.Verify([]ed25519.PublicKey{otherPub}, time.Now()); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	if err := parsed.Verify([]ed25519.PublicKey{caPub}, time.Now().Add(2*time.Hour)); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// Changing anything breaks the signature
+	parsed.Subject = "www.example.com"
+	if err := parsed.Verify([]ed25519.PublicKey{caPub}, time.Now()); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	raw := cert.Marshal()
+	for _, bad := range [][]byte{nil, raw[:10], raw[:len(raw)-1], append(raw, 0)} {
+		if _, err := ParseCertificate(bad); !errors.Is(err, ErrBadCertificate) {
+			t.Fatalf("Unexpected result for %d bytes: %v", len(bad), err)
+		}
+	}
+}
+
+func TestCertificateHandshake(t *testing.T) {
+	caPub, caPriv := newCA(t)
+	serverOpts := certifiedOptions(t, caPriv, "db.example.com", time.Now().Add(time.Hour))
+	serverOpts.TrustedCAs = []ed25519.PublicKey{caPub}
+	clientOpts := certifiedOptions(t, caPriv, "app.example.com", time.Now().Add(time.Hour))
+	clientOpts.TrustedCAs = []ed25519.PublicKey{caPub}
+	clientOpts.PeerName = "db.example.com"
+
+	client, server := pipeOptions(t, clientOpts, serverOpts)
+	if cert := client.ConnectionState().PeerCertificate; cert == nil || cert.Subject != "db.example.com" {
+		t.Fatalf("Unexpected result: %+v", cert)
+	}
+	if cert := server.ConnectionState().PeerCertificate; cert == nil || cert.Subject != "app.example.com" {
+		t.Fatalf("Unexpected result: %+v", cert)
+	}
+
+	// A certificate nobody asked to check isn't reported
+	client, _ = pipeOptions(t, nil, &Options{Keys: serverOpts.Keys, Certificate: serverOpts.Certificate})
+	if cert := client.ConnectionState().PeerCertificate; cert != nil {
+		t.Fatalf("Unexpected result: %+v", cert)
+	}
+}
+
+func TestCertificateRejected(t *testing.T) {
+	caPub, caPriv := newCA(t)
+	_, otherCA := newCA(t)
+	trusting := &Options{TrustedCAs: []ed25519.PublicKey{caPub}, PeerName: "db.example.com"}
+
+	tests := map[string]*Options{
+		"no certificate": nil,
+		"untrusted CA":   certifiedOptions(t, otherCA, "db.example.com", time.Now().Add(time.Hour)),
+		"expired":        certifiedOptions(t, caPriv, "db.example.com", time.Now().Add(-time.Minute)),
+		"wrong name":     certifiedOptions(t, caPriv, "www.example.com", time.Now().Add(time.Hour)),
+	}
+	for name, serverOpts := range tests {
+		t.Run(name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+			client, server := Client(c1, trusting), Server(c2, serverOpts)
+
+			serverErr := make(chan error, 1)
+			go func() {
+				_, err := server.ReadMsg()
+				serverErr <- err
+			}()
+			if err := client.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+				t.Fatalf("Expected ErrHandshakeFailed, got %v", err)
+			}
+			var alert *AlertError
+			if err := <-serverErr; !errors.As(err, &alert) || alert.Alert != AlertAuthFailed {
+				t.Fatalf("Unexpected server error: %v", err)
+			}
+		})
+	}
+
+	// Someone else's certificate, a Conn can't send it since it's checked against Options.Keys
+	valid := certifiedOptions(t, caPriv, "db.example.com", time.Now().Add(time.Hour))
+	if _, err := verifyPeerCertificate(trusting, valid.Certificate.Marshal(), mustGenerateKeys(t).Public); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
+
+func TestCertificateOptions(t *testing.T) {
+	_, caPriv := newCA(t)
+	opts := certifiedOptions(t, caPriv, "db.example.com", time.Now().Add(time.Hour))
+
+	// The certificate has to be for our key, and Version0 and Noise have nowhere to send it
+	for _, bad := range []*Options{
+		{Keys: mustGenerateKeys(t), Certificate: opts.Certificate},
+		{Certificate: opts.Certificate},
+		{Keys: opts.Keys, Certificate: opts.Certificate, LegacyV0: true},
+		{Keys: opts.Keys, Certificate: opts.Certificate, Noise: true},
+	} {
+		c1, c2 := net.Pipe()
+		if err := Client(c1, bad).Handshake(); err == nil {
+			t.Fatalf("Unexpected result for %+v: no error", bad)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package snacl
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec turns values into messages and back, see NewEncoder and NewDecoder
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values with encoding/json
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec encodes values with encoding/gob. Every message is a gob stream of its own, so it carries
+// the descriptions of its types, which makes small values quite a bit bigger than with JSONCodec.
+var GobCodec Codec = gobCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Encoder sends every value as a message of its own, so values don't need framing of their own
+type Encoder struct {
+	c     *Conn
+	codec Codec
+}
+
+// NewEncoder returns an Encoder that sends values encoded with codec over c
+func NewEncoder(c *Conn, codec Codec) *Encoder {
+	return &Encoder{c: c, codec: codec}
+}
+
+// Encode sends v. Its encoding can be at most ConnectionState.MaxMessageLength bytes.
+func (e *Encoder) Encode(v any) error {
+	data, err := e.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %T: %w", v, err)
+	}
+	return e.c.WriteMsg(data)
+}
+
+// Decoder reads values sent by an Encoder
+type Decoder struct {
+	c     *Conn
+	codec Codec
+}
+
+// NewDecoder returns a Decoder that reads values encoded with codec from c
+func NewDecoder(c *Conn, codec Codec) *Decoder {
+	return &Decoder{c: c, codec: codec}
+}
+
+// Decode reads the next value into v. If v is nil the value is skipped.
+func (d *Decoder) Decode(v any) error {
+	msg, err := d.c.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	err = d.codec.Unmarshal(msg.Data, v)
+	if err != nil {
+		return fmt.Errorf("decoding %T: %w", v, err)
+	}
+	return nil
+}
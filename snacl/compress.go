@@ -0,0 +1,71 @@
+package snacl
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// When both sides set Options.Compression, messages are compressed with DEFLATE (RFC 1951) before
+// they're sealed and sent as recordCompressed records. A message that doesn't get smaller is sent as an
+// ordinary data record, so incompressible data costs nothing. Each message is compressed on its own,
+// there's no dictionary shared between messages.
+//
+// Compressing before encrypting leaks how compressible the plaintext is through the frame length. If
+// an attacker can get their own data into messages that also hold a secret, they can learn the secret
+// a byte at a time by watching the lengths, like the CRIME and BREACH attacks on TLS and HTTP. Only
+// turn it on for data that doesn't mix secrets with anything an attacker controls, logs or bulk JSON
+// from a trusted source say. Padding blunts the attack but doesn't stop it.
+
+// compressors reuses flate.Writers, they're expensive to allocate
+var compressors sync.Pool
+
+// decompressors reuses flate readers
+var decompressors sync.Pool
+
+// compressRecord compresses data and returns it, or returns false when it wouldn't get smaller
+func compressRecord(data []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+
+	fw, _ := compressors.Get().(*flate.Writer)
+	if fw == nil {
+		// BestSpeed is the only level where compressing is usually faster than sending the bytes
+		fw, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		fw.Reset(&buf)
+	}
+	defer compressors.Put(fw)
+
+	_, err := fw.Write(data)
+	if err == nil {
+		err = fw.Close()
+	}
+	if err != nil || buf.Len() >= len(data) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressRecord decompresses the data of a recordCompressed record. It fails rather than inflate
+// more than maxLength bytes, so a small record can't be used to make us allocate without limit.
+func decompressRecord(data []byte, maxLength int) ([]byte, error) {
+	fr, _ := decompressors.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(bytes.NewReader(data))
+	} else {
+		fr.(flate.Resetter).Reset(bytes.NewReader(data), nil)
+	}
+	defer decompressors.Put(fr)
+
+	out, err := io.ReadAll(io.LimitReader(fr, int64(maxLength)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad compressed data: %v", ErrBadRecord, err)
+	}
+	if len(out) > maxLength {
+		return nil, fmt.Errorf("%w: compressed message is too large (max:%d)", ErrBadRecord, maxLength)
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package snacl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestConnCompression(t *testing.T) {
+	client, server := pipe(t, &Options{Compression: true})
+	if !client.ConnectionState().Compressed || !server.ConnectionState().Compressed {
+		t.Fatal("Unexpected result. Compression wasn't negotiated.")
+	}
+
+	logs := bytes.Repeat([]byte(`{"level":"info","msg":"request served"}`+"\n"), 100)
+	go func() {
+		client.Write(logs)
+		client.Write([]byte("x"))
+	}()
+	for _, expected := range [][]byte{logs, []byte("x")} {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg.Data, expected) {
+			t.Fatalf("Unexpected result: %q", msg.Data)
+		}
+	}
+
+	// Both sides have to offer it
+	client, _ = pipeOptions(t, &Options{Compression: true}, nil)
+	if client.ConnectionState().Compressed {
+		t.Fatal("Unexpected result. Compression was negotiated with one side.")
+	}
+}
+
+func TestEncoderCompression(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newEncoder(&buf, &[32]byte{'k', 'e', 'y'})
+	enc.typed = true
+	enc.compress = true
+	data := bytes.Repeat([]byte("a"), 10000)
+	if err := enc.Encode(&Message{Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= len(data) {
+		t.Fatalf("Unexpected frame length: %d", buf.Len())
+	}
+
+	// A decoder that didn't negotiate compression refuses compressed records
+	frame := bytes.Clone(buf.Bytes())
+	dec := newDecoder(bytes.NewReader(frame), &[32]byte{'k', 'e', 'y'})
+	dec.typed = true
+	if err := dec.Decode(&Message{}); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dec = newDecoder(&buf, &[32]byte{'k', 'e', 'y'})
+	dec.typed = true
+	dec.compressed = true
+	msg := &Message{}
+	if err := dec.Decode(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, data) {
+		t.Fatal("Unexpected result. The message doesn't match.")
+	}
+}
+
+func TestDecompressRecordLimit(t *testing.T) {
+	bomb, ok := compressRecord(make([]byte, 1<<20))
+	if !ok {
+		t.Fatal("Unexpected result. Zeros didn't compress.")
+	}
+	if _, err := decompressRecord(bomb, 1<<20-1); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out, err := decompressRecord(bomb, 1<<20); err != nil || len(out) != 1<<20 {
+		t.Fatalf("Unexpected result: %d %v", len(out), err)
+	}
+
+	if _, ok := compressRecord([]byte("abc")); ok {
+		t.Fatal("Unexpected result. A tiny message was compressed.")
+	}
+}
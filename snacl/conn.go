@@ -0,0 +1,409 @@
+package snacl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn is a secure connection over an underlying stream. It's a net.Conn, the addresses and deadlines
+// are the underlying stream's when it has them.
+// The keys are exchanged by Handshake, which is called for you on the first Read or Write.
+type Conn struct {
+	rwc  io.ReadWriteCloser
+	opts Options
+	// isClient is set for the side that dialed, the two sides derive their keys in a different order
+	isClient bool
+
+	handshakeMu  sync.Mutex
+	handshakeErr error
+	handshaked   bool
+	// ready is set once a handshake has succeeded, it can be checked without waiting for handshakeMu
+	ready atomic.Bool
+	// state is set by the handshake before ready, see ConnectionState
+	state ConnectionState
+
+	sr         *Reader
+	sw         *Writer
+	readStats  counters
+	writeStats counters
+
+	// writeMu stops writes from Write, ReadFrom and the write queue interleaving on sw
+	writeMu sync.Mutex
+
+	queueOnce sync.Once
+	queue     *writeQueue
+
+	// pumpOnce starts the read goroutine behind Messages and Errors, see messages.go
+	pumpOnce sync.Once
+	pump     *readPump
+
+	// flow is set when flow control was negotiated, messages are then read from its queue, see flow.go
+	flow *flowControl
+	// keepalive is set when keepalives were negotiated, see keepalive.go
+	keepalive *keepalive
+	// idle closes the Conn when it's idle for Options.IdleTimeout, see idle.go
+	idle *idleTimer
+	// ratchet is set when the double ratchet was negotiated, see ratchet.go
+	ratchet *ratchet
+	// peerCertificate is the certificate in the other side's hello, see authenticate
+	peerCertificate []byte
+}
+
+// Client returns a new Conn using rwc as the underlying stream for the side that dialed.
+// opts may be nil.
+func Client(rwc io.ReadWriteCloser, opts *Options) *Conn {
+	c := newConn(rwc, opts)
+	c.isClient = true
+	return c
+}
+
+// Server returns a new Conn using rwc as the underlying stream for the side that accepted.
+// opts may be nil.
+func Server(rwc io.ReadWriteCloser, opts *Options) *Conn {
+	return newConn(rwc, opts)
+}
+
+func newConn(rwc io.ReadWriteCloser, opts *Options) *Conn {
+	c := &Conn{rwc: rwc}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.IdleTimeout > 0 {
+		c.idle = newIdleTimer(c.opts.IdleTimeout, rwc.Close)
+	}
+	return c
+}
+
+// Handshake exchanges public keys with the other side and agrees on the connection's settings, see
+// handshake.go. It only runs once, later calls return the result of the first one.
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if !c.handshaked {
+		c.handshakeErr = c.closedErr(c.handshake())
+		if c.handshakeErr != nil {
+			c.wipe()
+		}
+		c.handshaked = true
+		c.ready.Store(c.handshakeErr == nil)
+	}
+	return c.handshakeErr
+}
+
+// Read decrypts from the underlying stream and writes it to p []byte
+// p is expected to be big enough to hold the entire decrypted message, if it's not,
+// Read writes as much as it can and discards the rest of the message.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	err = c.Handshake()
+	if err != nil {
+		return 0, err
+	}
+	if c.flow != nil {
+		msg, err := c.flow.next()
+		if err != nil {
+			return 0, c.readErr(err)
+		}
+		return copy(p, msg.Data), nil
+	}
+	n, err = c.sr.Read(p)
+	return n, c.readErr(err)
+}
+
+// ReadMsg decrypts an entire message from the underlying stream and returns it
+func (c *Conn) ReadMsg() (msg *Message, err error) {
+	err = c.Handshake()
+	if err != nil {
+		return nil, err
+	}
+	if c.flow != nil {
+		msg, err = c.flow.next()
+	} else {
+		msg, err = c.sr.ReadMsg()
+	}
+	return msg, c.readErr(err)
+}
+
+// Write encrypts p []byte and sends it to the underlying stream
+func (c *Conn) Write(p []byte) (n int, err error) {
+	err = c.Handshake()
+	if err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	n, err = c.sw.Write(p)
+	return n, c.closedErr(err)
+}
+
+// WriteMsg sends msg as a single message. Unlike Write it's never split or merged with buffered
+// writes, so the other side's ReadMsg gets exactly msg. msg can be at most
+// ConnectionState.MaxMessageLength bytes.
+func (c *Conn) WriteMsg(msg []byte) error {
+	return c.WriteMsgAAD(msg, nil)
+}
+
+// ReadFrom sends everything read from r until io.EOF, see Writer.ReadFrom
+func (c *Conn) ReadFrom(r io.Reader) (n int64, err error) {
+	err = c.Handshake()
+	if err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	n, err = c.sw.ReadFrom(r)
+	return n, c.closedErr(err)
+}
+
+// WriteTo writes every message to w until the other side closes the stream, see Reader.WriteTo
+func (c *Conn) WriteTo(w io.Writer) (n int64, err error) {
+	err = c.Handshake()
+	if err != nil {
+		return 0, err
+	}
+	if c.flow != nil {
+		n, err = c.flow.writeTo(w)
+	} else {
+		n, err = c.sr.WriteTo(w)
+	}
+	return n, c.readErr(err)
+}
+
+// Flush sends any buffered writes, see Options.WriteBufferSize
+func (c *Conn) Flush() error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.sw.Flush()
+}
+
+// CloseWrite sends any queued and buffered writes and shuts down the writing side of the underlying
+// stream, so the other side reads io.EOF while we can still read what it sends, like
+// net.TCPConn.CloseWrite. It fails if the underlying stream can't be half-closed.
+func (c *Conn) CloseWrite() error {
+	cw, ok := c.rwc.(interface{ CloseWrite() error })
+	if !ok {
+		return errNoCloseWrite
+	}
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+
+	// Nothing can be sent after this, pings included
+	if c.keepalive != nil {
+		c.keepalive.stop()
+	}
+	c.closeQueue()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	err = c.sw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.CloseWrite()
+}
+
+// Close sends any queued and buffered writes, closes the underlying stream and zeroes the keys, see
+// wipe.go
+func (c *Conn) Close() error {
+	var err error
+	if c.idle != nil {
+		c.idle.stop()
+	}
+	if c.ready.Load() {
+		if c.keepalive != nil {
+			c.keepalive.stop()
+		}
+		c.closeQueue()
+		c.writeMu.Lock()
+		err = c.sw.Flush()
+		c.writeMu.Unlock()
+	}
+
+	c.stopPump()
+	closeErr := c.rwc.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if c.ready.Load() {
+		c.wipe()
+	}
+	return err
+}
+
+// wipe zeroes the traffic keys, see wipe.go. A failed handshake may have only set up some of them.
+func (c *Conn) wipe() {
+	if c.sw != nil {
+		c.sw.enc.wipe()
+	}
+	if c.sr != nil {
+		c.sr.dec.wipe()
+	}
+	if c.ratchet != nil && (c.sr == nil || c.sr.dec.ratchet == nil) {
+		// The handshake failed after the ratchet was negotiated, the decoder doesn't have it to zero
+		c.ratchet.zero()
+	}
+}
+
+// RemoteAddr returns the other side's address if the underlying stream has one, like a net.Conn,
+// and nil otherwise
+func (c *Conn) RemoteAddr() net.Addr {
+	if a, ok := c.rwc.(interface{ RemoteAddr() net.Addr }); ok {
+		return a.RemoteAddr()
+	}
+	return nil
+}
+
+// LocalAddr returns our address if the underlying stream has one, like a net.Conn, and nil otherwise
+func (c *Conn) LocalAddr() net.Addr {
+	if a, ok := c.rwc.(interface{ LocalAddr() net.Addr }); ok {
+		return a.LocalAddr()
+	}
+	return nil
+}
+
+// errNoDeadlines is returned when the underlying stream can't set deadlines
+var errNoDeadlines = errors.New("the underlying stream doesn't support deadlines")
+
+// errNoCloseWrite is returned when the underlying stream can't be half-closed
+var errNoCloseWrite = errors.New("the underlying stream can't close just its writing side")
+
+// SetDeadline sets the read and write deadlines of the underlying stream, see net.Conn
+func (c *Conn) SetDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return errNoDeadlines
+}
+
+// SetReadDeadline sets the read deadline of the underlying stream, see net.Conn
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errNoDeadlines
+}
+
+// SetWriteDeadline sets the write deadline of the underlying stream, see net.Conn
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return errNoDeadlines
+}
+
+// closedErr returns the reason in place of err when the Conn closed itself, because the other side
+// was unresponsive or the Conn was idle
+func (c *Conn) closedErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if c.keepalive != nil && c.keepalive.unresponsive.Load() {
+		return ErrPeerUnresponsive
+	}
+	if c.idle != nil && c.idle.expired.Load() {
+		return ErrIdleTimeout
+	}
+	return err
+}
+
+// Dialer connects to a server and performs the handshake
+type Dialer struct {
+	// NetDialer opens the underlying connection. If nil, the zero value of net.Dialer is used.
+	NetDialer *net.Dialer
+	// Options configures the connection, it may be nil.
+	Options *Options
+	// Proxy returns the proxy to connect to addr through, or nil to connect directly, see proxy.go. If
+	// nil, connections are direct. ProxyFromEnvironment uses ALL_PROXY.
+	Proxy func(addr string) (*url.URL, error)
+}
+
+// Dial connects to addr on the named network and performs the handshake
+func (d *Dialer) Dial(network, addr string) (*Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext is Dial with a context, which bounds both connecting and the handshake
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (*Conn, error) {
+	netDialer := d.NetDialer
+	if netDialer == nil {
+		netDialer = new(net.Dialer)
+	}
+
+	rawConn, err := d.dialProxy(ctx, netDialer, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// ctx being done, cancelled or past its deadline, interrupts the handshake by expiring the deadlines
+	stop := context.AfterFunc(ctx, func() {
+		rawConn.SetDeadline(time.Unix(1, 0))
+	})
+
+	conn := Client(rawConn, d.Options)
+	err = conn.Handshake()
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = rawConn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		rawConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Dial connects to addr on the named network and performs the handshake, opts may be nil
+func Dial(network, addr string, opts *Options) (*Conn, error) {
+	d := &Dialer{Options: opts}
+	return d.Dial(network, addr)
+}
+
+// Listener accepts secure connections from an underlying net.Listener
+type Listener struct {
+	l    net.Listener
+	opts *Options
+}
+
+// NewListener returns a Listener accepting connections from l, opts may be nil
+func NewListener(l net.Listener, opts *Options) *Listener {
+	return &Listener{l: l, opts: opts}
+}
+
+// Accept waits for the next connection. The handshake is not done by Accept so a slow client
+// can't hold up the others, it's done on the first Read or Write or by calling Handshake.
+func (l *Listener) Accept() (*Conn, error) {
+	rawConn, err := l.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(rawConn, l.opts), nil
+}
+
+// Close closes the underlying listener
+func (l *Listener) Close() error {
+	return l.l.Close()
+}
+
+// Addr returns the address of the underlying listener
+func (l *Listener) Addr() net.Addr {
+	return l.l.Addr()
+}
@@ -0,0 +1,163 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+)
+
+func TestConnEcho(t *testing.T) {
+	serverKeys, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl := NewListener(l, &Options{Keys: serverKeys})
+	defer sl.Close()
+
+	go func() {
+		conn, err := sl.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		msg, err := conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		conn.Write(msg.Data)
+	}()
+
+	conn, err := Dial("tcp", sl.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expected := []byte("hello world\n")
+	if _, err := conn.Write(expected); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+	}
+}
+
+func TestConnCloseWrite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl := NewListener(l, nil)
+	defer sl.Close()
+
+	// The server reads until the client is done sending, then answers
+	go func() {
+		conn, err := sl.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var got bytes.Buffer
+		if _, err := conn.WriteTo(&got); err != nil {
+			return
+		}
+		conn.Write(append([]byte("got "), got.Bytes()...))
+	}()
+
+	conn, err := Dial("tcp", sl.Addr().String(), &Options{WriteBufferSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Buffered writes are sent first
+	conn.Write([]byte("hello "))
+	conn.Write([]byte("world"))
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(msg.Data) != "got hello world" {
+		t.Fatalf("Unexpected result: %s", msg.Data)
+	}
+
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+	if err := client.CloseWrite(); err == nil {
+		t.Fatal("Unexpected result. net.Pipe can't be half-closed.")
+	}
+}
+
+// pipe returns a client and server Conn over net.Pipe with the handshake done
+func pipe(t *testing.T, opts *Options) (client, server *Conn) {
+	return pipeOptions(t, opts, opts)
+}
+
+// pipeOptions is pipe with different options for each side
+func pipeOptions(t *testing.T, clientOpts, serverOpts *Options) (client, server *Conn) {
+	c1, c2 := net.Pipe()
+	client, server = Client(c1, clientOpts), Server(c2, serverOpts)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Handshake()
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// benchmarkConns sets up a connection pair over net.Pipe per iteration and sends a few messages,
+// from many goroutines at once like a server with a lot of connection churn.
+func benchmarkConns(b *testing.B, opts *Options) {
+	msg := []byte("hello world\n")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c1, c2 := net.Pipe()
+			client, server := Client(c1, opts), Server(c2, opts)
+
+			go func() {
+				for i := 0; i < 10; i++ {
+					msg, err := server.ReadMsg()
+					if err != nil {
+						return
+					}
+					server.Write(msg.Data)
+				}
+			}()
+			for i := 0; i < 10; i++ {
+				client.Write(msg)
+				client.ReadMsg()
+			}
+			client.Close()
+			server.Close()
+		}
+	})
+}
+
+func BenchmarkConnCryptoRandNonces(b *testing.B) {
+	benchmarkConns(b, nil)
+}
+
+func BenchmarkConnDRBGNonces(b *testing.B) {
+	benchmarkConns(b, &Options{NonceDRBG: true})
+}
@@ -0,0 +1,154 @@
+// Package directory resolves people's and services' names to public keys through a pluggable
+// KeyDirectory, so applications can dial "alice" rather than handle keys. HTTPDirectory is a client
+// for a simple JSON keyserver, and Cache keeps answers in a store.Store so the keyserver isn't asked
+// on every connection.
+package directory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/store"
+)
+
+// KeyDirectory resolves names to public keys. Implementations must be safe for concurrent use.
+type KeyDirectory interface {
+	// Lookup returns the public key of name, or ErrNotFound if the directory doesn't know it
+	Lookup(name string) ([32]byte, error)
+}
+
+// Errors returned by directories and Dial
+var (
+	ErrNotFound    = errors.New("directory: name not found")
+	ErrKeyMismatch = errors.New("directory: the server's key isn't the one in the directory")
+)
+
+// DefaultHTTPTimeout is how long HTTPDirectory waits for the keyserver when it has no Client
+const DefaultHTTPTimeout = 10 * time.Second
+
+// HTTPDirectory looks names up on a keyserver. A lookup is GET <URL>/<name>, with the name escaped as
+// a path segment, and the keyserver answers 404 for names it doesn't know and otherwise
+//
+//	{"name": "alice", "public_key": "<base64 public key>"}
+//
+// The keyserver is trusted with every key, so URL should be https://.
+type HTTPDirectory struct {
+	URL string
+	// Client makes the requests, if nil a client with DefaultHTTPTimeout is used
+	Client *http.Client
+}
+
+// httpEntry is the keyserver's answer
+type httpEntry struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+}
+
+// Lookup asks the keyserver for name's key
+func (d *HTTPDirectory) Lookup(name string) ([32]byte, error) {
+	var key [32]byte
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultHTTPTimeout}
+	}
+
+	resp, err := client.Get(strings.TrimSuffix(d.URL, "/") + "/" + url.PathEscape(name))
+	if err != nil {
+		return key, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return key, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return key, fmt.Errorf("directory: keyserver answered %s", resp.Status)
+	}
+
+	var entry httpEntry
+	// A key and a name are small, anything much bigger isn't an answer
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&entry); err != nil {
+		return key, fmt.Errorf("directory: keyserver answer: %w", err)
+	}
+	if entry.Name != name {
+		return key, fmt.Errorf("directory: asked for %q, keyserver answered for %q", name, entry.Name)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.PublicKey)
+	if err != nil || len(decoded) != len(key) {
+		return key, fmt.Errorf("directory: keyserver answered with an invalid key for %q", name)
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// Cache remembers another KeyDirectory's answers in a store.Store, a store.FileStore keeps them across
+// restarts. Names that weren't found are remembered too, for NegativeTTL.
+type Cache struct {
+	d     KeyDirectory
+	store store.Store
+	ttl   time.Duration
+	// NegativeTTL is how long ErrNotFound is remembered, 0 doesn't remember it
+	NegativeTTL time.Duration
+}
+
+// NewCache returns a Cache keeping d's keys in s for ttl. Entries are stored under keys starting with
+// "directory:", so s can be shared.
+func NewCache(d KeyDirectory, s store.Store, ttl time.Duration) *Cache {
+	return &Cache{d: d, store: s, ttl: ttl}
+}
+
+// Lookup returns name's key from the store, or asks the directory and stores the answer. Errors other
+// than ErrNotFound aren't remembered.
+func (c *Cache) Lookup(name string) ([32]byte, error) {
+	var key [32]byte
+	storeKey := "directory:" + name
+	value, ok, err := c.store.Get(storeKey)
+	if err != nil {
+		return key, err
+	}
+	if ok {
+		// An empty value remembers that name wasn't found
+		if len(value) == 0 {
+			return key, ErrNotFound
+		}
+		if len(value) == len(key) {
+			copy(key[:], value)
+			return key, nil
+		}
+	}
+
+	key, err = c.d.Lookup(name)
+	switch {
+	case err == nil:
+		return key, c.store.Set(storeKey, key[:], c.ttl)
+	case errors.Is(err, ErrNotFound) && c.NegativeTTL > 0:
+		if err := c.store.Set(storeKey, nil, c.NegativeTTL); err != nil {
+			return key, err
+		}
+	}
+	return key, err
+}
+
+// Dial dials addr and checks the server has the key d has for name. opts may be nil.
+func Dial(d KeyDirectory, name, network, addr string, opts *snacl.Options) (*snacl.Conn, error) {
+	key, err := d.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := snacl.Dial(network, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if conn.PeerPublicKey() != key {
+		conn.Close()
+		return nil, ErrKeyMismatch
+	}
+	return conn, nil
+}
@@ -0,0 +1,65 @@
+package directory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/store"
+)
+
+// keyserver serves keys and counts the lookups it answers
+func keyserver(t *testing.T, keys map[string][32]byte) (*httptest.Server, *atomic.Int32) {
+	var lookups atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		name := strings.TrimPrefix(r.URL.Path, "/keys/")
+		key, ok := keys[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(httpEntry{Name: name, PublicKey: base64.StdEncoding.EncodeToString(key[:])})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &lookups
+}
+
+func TestHTTPDirectory(t *testing.T) {
+	alice := [32]byte{1, 2, 3}
+	srv, _ := keyserver(t, map[string][32]byte{"alice smith": alice})
+	d := &HTTPDirectory{URL: srv.URL + "/keys/"}
+
+	key, err := d.Lookup("alice smith")
+	if err != nil || key != alice {
+		t.Fatalf("Unexpected result: %v %v", key, err)
+	}
+	if _, err := d.Lookup("bob"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	alice := [32]byte{1, 2, 3}
+	srv, lookups := keyserver(t, map[string][32]byte{"alice": alice})
+	c := NewCache(&HTTPDirectory{URL: srv.URL + "/keys"}, store.NewMemoryStore(), time.Hour)
+	c.NegativeTTL = time.Hour
+
+	for i := 0; i < 3; i++ {
+		if key, err := c.Lookup("alice"); err != nil || key != alice {
+			t.Fatalf("Unexpected result: %v %v", key, err)
+		}
+		if _, err := c.Lookup("bob"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if n := lookups.Load(); n != 2 {
+		t.Fatalf("Unexpected result. The keyserver was asked %d times.", n)
+	}
+}
@@ -0,0 +1,272 @@
+// Package dnskey looks up servers' public keys in DNS, so clients can check who they're talking to
+// without keys handed out some other way. A server's keys are TXT records at _snacl.<host>, one per
+// key so a new key can be published before the old one goes:
+//
+//	_snacl.example.com. TXT "v=snacl1; k=<base64 public key>"
+//
+// DNS answers can be forged unless the zone is signed. Lookups ask for DNSSEC and report whether the
+// resolver validated the answer, the AD bit, which is only worth trusting from a resolver on this
+// machine or reached over a trusted network. Resolver.RequireDNSSEC refuses anything else.
+package dnskey
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// prefix is the label in front of the host name
+const prefix = "_snacl."
+
+// recordVersion starts every record
+const recordVersion = "v=snacl1"
+
+// DefaultTimeout is the default Resolver.Timeout
+const DefaultTimeout = 5 * time.Second
+
+// ednsBufferSize is the UDP answer size we offer in the EDNS0 record
+const ednsBufferSize = 1232
+
+// dnssecOK is the DO bit of the EDNS0 record, it asks for DNSSEC
+const dnssecOK = 1 << 15
+
+// Errors returned by lookups
+var (
+	ErrNoKeys           = errors.New("dnskey: no keys published")
+	ErrNotAuthenticated = errors.New("dnskey: answer not authenticated by DNSSEC")
+	ErrKeyMismatch      = errors.New("dnskey: the server's key isn't published")
+)
+
+// Resolver looks up keys, the zero value uses the first nameserver in /etc/resolv.conf
+type Resolver struct {
+	// Server is the host:port of the resolver to ask
+	Server string
+	// RequireDNSSEC fails lookups with ErrNotAuthenticated unless the resolver validated the answer
+	RequireDNSSEC bool
+	// Timeout bounds a lookup, DefaultTimeout if 0
+	Timeout time.Duration
+}
+
+// LookupKeys returns the keys published for host, and whether the resolver validated them with DNSSEC
+func (r *Resolver) LookupKeys(ctx context.Context, host string) (keys [][32]byte, authenticated bool, err error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	server := r.Server
+	if server == "" {
+		if server, err = systemResolver(); err != nil {
+			return nil, false, err
+		}
+	}
+	name, err := dnsmessage.NewName(prefix + strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, false, err
+	}
+
+	msg, err := exchange(ctx, server, name)
+	if err != nil {
+		return nil, false, err
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError {
+		return nil, false, fmt.Errorf("dnskey: looking up %s: %v", name, msg.RCode)
+	}
+	authenticated = msg.AuthenticData
+	if r.RequireDNSSEC && !authenticated {
+		return nil, false, ErrNotAuthenticated
+	}
+
+	for _, answer := range msg.Answers {
+		txt, ok := answer.Body.(*dnsmessage.TXTResource)
+		if !ok || !strings.EqualFold(answer.Header.Name.String(), name.String()) {
+			continue
+		}
+		// A long record is split into several strings, which are one value
+		if key, ok := parseRecord(strings.Join(txt.TXT, "")); ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, authenticated, ErrNoKeys
+	}
+	return keys, authenticated, nil
+}
+
+// Record returns the TXT record to publish for key
+func Record(key [32]byte) string {
+	return recordVersion + "; k=" + base64.StdEncoding.EncodeToString(key[:])
+}
+
+// parseRecord returns the key in a TXT record, records that aren't ours are ignored
+func parseRecord(record string) ([32]byte, bool) {
+	var key [32]byte
+	fields := strings.Split(record, ";")
+	if strings.TrimSpace(fields[0]) != recordVersion {
+		return key, false
+	}
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(strings.TrimSpace(field), "k=")
+		if !ok {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(decoded) != len(key) {
+			return key, false
+		}
+		copy(key[:], decoded)
+		return key, true
+	}
+	return key, false
+}
+
+// Dial dials addr and checks the server's key is one published for its host, see LookupKeys. opts may
+// be nil.
+func (r *Resolver) Dial(ctx context.Context, network, addr string, opts *snacl.Options) (*snacl.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	keys, _, err := r.LookupKeys(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &snacl.Dialer{Options: opts}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	peer := conn.PeerPublicKey()
+	for _, key := range keys {
+		if key == peer {
+			return conn, nil
+		}
+	}
+	conn.Close()
+	return nil, ErrKeyMismatch
+}
+
+// exchange asks server for name's TXT records, over UDP and then TCP if the answer didn't fit
+func exchange(ctx context.Context, server string, name dnsmessage.Name) (*dnsmessage.Message, error) {
+	query, id, err := txtQuery(name)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := exchangeOver(ctx, "udp", server, query, id)
+	if err != nil || !msg.Truncated {
+		return msg, err
+	}
+	return exchangeOver(ctx, "tcp", server, query, id)
+}
+
+// txtQuery builds a recursive TXT query for name that asks for DNSSEC
+func txtQuery(name dnsmessage.Name) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := io.ReadFull(rand.Reader, idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(ednsBufferSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, 0, err
+	}
+	opt.TTL |= dnssecOK
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{
+			{Header: opt, Body: &dnsmessage.OPTResource{}},
+		},
+	}
+	query, err := msg.Pack()
+	return query, id, err
+}
+
+// exchangeOver sends query to server over network and returns the answer with id
+func exchangeOver(ctx context.Context, network, server string, query []byte, id uint16) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	stream := network == "tcp"
+	if stream {
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		var answer []byte
+		if stream {
+			var length [2]byte
+			if _, err := io.ReadFull(r, length[:]); err != nil {
+				return nil, err
+			}
+			answer = make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(r, answer); err != nil {
+				return nil, err
+			}
+		} else {
+			answer = make([]byte, 65535)
+			n, err := conn.Read(answer)
+			if err != nil {
+				return nil, err
+			}
+			answer = answer[:n]
+		}
+		var msg dnsmessage.Message
+		// Anything that isn't the answer, a stray or spoofed datagram, is skipped
+		if msg.Unpack(answer) != nil || !msg.Response || msg.ID != id {
+			continue
+		}
+		return &msg, nil
+	}
+}
+
+// resolvConf is where the system resolver is configured
+var resolvConf = "/etc/resolv.conf"
+
+// systemResolver returns the first nameserver in resolvConf
+func systemResolver() (string, error) {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("dnskey: no nameserver in %s", resolvConf)
+}
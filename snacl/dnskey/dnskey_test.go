@@ -0,0 +1,133 @@
+package dnskey
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer answers TXT queries from records over UDP and TCP on the same port. Answers with more
+// than one record don't fit in its UDP answers, so they're truncated and have to be asked over TCP.
+func dnsServer(t *testing.T, records map[string][]string, authenticated bool) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	answer := func(query []byte, stream bool) []byte {
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil {
+			return nil
+		}
+		q := msg.Questions[0]
+		msg.Response, msg.AuthenticData, msg.Additionals = true, authenticated, nil
+		txts := records[strings.ToLower(q.Name.String())]
+		if !stream && len(txts) > 1 {
+			msg.Truncated = true
+		} else {
+			for _, txt := range txts {
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.TXTResource{TXT: []string{txt[:10], txt[10:]}},
+				})
+			}
+		}
+		if len(txts) == 0 {
+			msg.RCode = dnsmessage.RCodeNameError
+		}
+		packed, _ := msg.Pack()
+		return packed
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(answer(buf[:n], false), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			io.ReadFull(conn, length[:])
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			io.ReadFull(conn, query)
+			resp := answer(query, true)
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			conn.Close()
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestLookupKeys(t *testing.T) {
+	first, second := [32]byte{1}, [32]byte{2}
+	server := dnsServer(t, map[string][]string{
+		"_snacl.one.example.": {Record(first)},
+		"_snacl.two.example.": {Record(first), "v=spf1 -all", Record(second)},
+	}, true)
+	r := &Resolver{Server: server, RequireDNSSEC: true}
+
+	keys, authenticated, err := r.LookupKeys(context.Background(), "one.example")
+	if err != nil || !authenticated || len(keys) != 1 || keys[0] != first {
+		t.Fatalf("Unexpected result: %v %v %v", keys, authenticated, err)
+	}
+	// Published with a new key alongside the old one, over TCP since it doesn't fit in UDP
+	keys, _, err = r.LookupKeys(context.Background(), "two.example")
+	if err != nil || len(keys) != 2 || keys[0] != first || keys[1] != second {
+		t.Fatalf("Unexpected result: %v %v", keys, err)
+	}
+	if _, _, err := r.LookupKeys(context.Background(), "none.example"); !errors.Is(err, ErrNoKeys) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestLookupKeysRequireDNSSEC(t *testing.T) {
+	server := dnsServer(t, map[string][]string{"_snacl.one.example.": {Record([32]byte{1})}}, false)
+
+	r := &Resolver{Server: server}
+	if _, authenticated, err := r.LookupKeys(context.Background(), "one.example"); err != nil || authenticated {
+		t.Fatalf("Unexpected result: %v %v", authenticated, err)
+	}
+	r.RequireDNSSEC = true
+	if _, _, err := r.LookupKeys(context.Background(), "one.example"); !errors.Is(err, ErrNotAuthenticated) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	key := [32]byte{9, 8, 7}
+	for _, tt := range []struct {
+		record string
+		ok     bool
+	}{
+		{Record(key), true},
+		{"v=snacl1;k=" + Record(key)[len("v=snacl1; k="):], true},
+		{"v=snacl2; k=" + Record(key)[len("v=snacl1; k="):], false},
+		{"v=snacl1; k=AAAA", false},
+		{"v=spf1 -all", false},
+	} {
+		got, ok := parseRecord(tt.record)
+		if ok != tt.ok || ok && got != key {
+			t.Fatalf("Unexpected result for %q: %v %v", tt.record, got, ok)
+		}
+	}
+}
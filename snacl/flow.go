@@ -0,0 +1,216 @@
+package snacl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+// When both sides set Options.FlowControlWindow, each side grants the other a window of credit in its
+// hello and a message can't be sent until there's credit for it. Reading a message earns the sender its
+// credit back with a recordWindowUpdate record:
+//
+//	[type uint8 = 3][credit uint32be]
+//
+// A message costs its length, or 1 if it's empty so empty messages aren't free. Window updates have
+// to get through to a side that only writes, so a flow controlled Conn reads in its own goroutine and
+// queues messages until they're read. The queue never holds more than the window, a peer that sends
+// past its credit is a protocol error.
+
+// ErrFlowControl is returned when the other side sends more than its window allows
+var ErrFlowControl = errors.New("peer sent past its flow control window")
+
+// ErrNoFlowControl is returned by Conn.Window and Conn.UpdateWindow when flow control wasn't negotiated
+var ErrNoFlowControl = errors.New("flow control wasn't negotiated")
+
+// flowControl is a Conn's flow control state
+type flowControl struct {
+	enc *encoder
+	dec *decoder
+	// window is the credit we grant the other side, manual is Options.ManualWindowUpdates
+	window int
+	manual bool
+
+	mu   sync.Mutex
+	cond sync.Cond
+	// sendCredit is how much more we may send, recvCredit is how much more the other side may send
+	sendCredit int
+	recvCredit int
+	// unacked is how much has been read since the last window update when manual isn't set
+	unacked int
+	// queue is the messages read and not handed to the application yet
+	queue []*Message
+	// err is the error that stopped the read goroutine, it's returned once the queue is empty
+	err error
+}
+
+func newFlowControl(enc *encoder, dec *decoder, window, peerWindow int, manual bool) *flowControl {
+	f := &flowControl{enc: enc, dec: dec, window: window, manual: manual, sendCredit: peerWindow, recvCredit: window}
+	f.cond.L = &f.mu
+	enc.flow = f
+	dec.flow = f
+	return f
+}
+
+// messageCost is how much credit a message of n bytes takes
+func messageCost(n int) int {
+	return max(n, 1)
+}
+
+// readLoop reads messages into the queue until the connection fails
+func (f *flowControl) readLoop() {
+	for {
+		msg := &Message{}
+		err := f.dec.Decode(msg)
+
+		f.mu.Lock()
+		if err == nil {
+			cost := messageCost(len(msg.Data))
+			if cost > f.recvCredit {
+				err = ErrFlowControl
+			} else {
+				f.recvCredit -= cost
+				f.queue = append(f.queue, msg)
+			}
+		}
+		if err != nil {
+			f.err = err
+		}
+		f.mu.Unlock()
+		f.cond.Broadcast()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next waits for the next message, and gives its credit back unless window updates are manual
+func (f *flowControl) next() (*Message, error) {
+	f.mu.Lock()
+	for len(f.queue) == 0 && f.err == nil {
+		f.cond.Wait()
+	}
+	if len(f.queue) == 0 {
+		f.mu.Unlock()
+		return nil, f.err
+	}
+	msg := f.queue[0]
+	f.queue[0] = nil
+	f.queue = f.queue[1:]
+
+	var credit int
+	if !f.manual {
+		// Credit goes back in batches so there isn't an update for every message
+		f.unacked += messageCost(len(msg.Data))
+		if f.unacked >= f.window/2 {
+			credit, f.unacked = f.unacked, 0
+		}
+	}
+	f.mu.Unlock()
+
+	if credit > 0 {
+		err := f.grant(credit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// writeTo writes messages to w as they're read, see Reader.WriteTo
+func (f *flowControl) writeTo(w io.Writer) (n int64, err error) {
+	for {
+		msg, err := f.next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		err = wire.WriteFull(w, msg.Data)
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(msg.Data))
+	}
+}
+
+// reserve waits until there's credit to send a message of n bytes and takes it
+func (f *flowControl) reserve(n int) error {
+	cost := messageCost(n)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.sendCredit < cost && f.err == nil {
+		f.cond.Wait()
+	}
+	if f.sendCredit < cost {
+		if f.err == io.EOF {
+			return io.ErrClosedPipe
+		}
+		return f.err
+	}
+	f.sendCredit -= cost
+	return nil
+}
+
+// credited handles a window update from the other side
+func (f *flowControl) credited(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("%w: bad window update", ErrBadRecord)
+	}
+	f.mu.Lock()
+	f.sendCredit += int(binary.BigEndian.Uint32(data))
+	f.mu.Unlock()
+	f.cond.Broadcast()
+	return nil
+}
+
+// grant gives the other side n more credit. It doesn't need Conn.writeMu, so it can't get stuck behind
+// a write that's waiting for credit.
+func (f *flowControl) grant(n int) error {
+	f.mu.Lock()
+	f.recvCredit += n
+	f.mu.Unlock()
+
+	f.enc.mu.Lock()
+	defer f.enc.mu.Unlock()
+	return f.enc.writeRecord(recordWindowUpdate, binary.BigEndian.AppendUint32(nil, uint32(n)), nil)
+}
+
+// Window returns how many more bytes we can send before the other side gives back credit, see
+// Options.FlowControlWindow. It returns ErrNoFlowControl unless flow control was negotiated.
+func (c *Conn) Window() (int, error) {
+	err := c.Handshake()
+	if err != nil {
+		return 0, err
+	}
+	if c.flow == nil {
+		return 0, ErrNoFlowControl
+	}
+	c.flow.mu.Lock()
+	defer c.flow.mu.Unlock()
+	return c.flow.sendCredit, nil
+}
+
+// UpdateWindow lets the other side send n more bytes. It's only needed with Options.ManualWindowUpdates,
+// otherwise credit is given back as messages are read. It returns ErrNoFlowControl unless flow control
+// was negotiated.
+func (c *Conn) UpdateWindow(n int) error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	if c.flow == nil {
+		return ErrNoFlowControl
+	}
+	if n <= 0 || n > math.MaxUint32 {
+		return fmt.Errorf("window update must be between 1 and %d, got %d", uint32(math.MaxUint32), n)
+	}
+	return c.flow.grant(n)
+}
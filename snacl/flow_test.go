@@ -0,0 +1,113 @@
+package snacl
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFlowControl(t *testing.T) {
+	client, server := pipe(t, &Options{FlowControlWindow: 1024})
+	if state := client.ConnectionState(); state.FlowControlWindow != 1024 || state.MaxMessageLength != 1024 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := client.Write(make([]byte, 4000))
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("Unexpected result. Write finished without the other side reading.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if window, err := client.Window(); err != nil || window != 0 {
+		t.Fatalf("Unexpected window: %d %v", window, err)
+	}
+
+	var got int
+	for got < 4000 {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += len(msg.Data)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlowControlOneWay(t *testing.T) {
+	// The writer never reads, it still has to see the window updates
+	client, server := pipe(t, &Options{FlowControlWindow: 4096})
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, server); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("Unexpected result. The data doesn't match.")
+	}
+}
+
+func TestManualWindowUpdates(t *testing.T) {
+	client, server := pipeOptions(t, &Options{FlowControlWindow: 100}, &Options{FlowControlWindow: 100, ManualWindowUpdates: true})
+
+	go client.Write(make([]byte, 100))
+	if _, err := server.ReadMsg(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if window, _ := client.Window(); window != 0 {
+		t.Fatalf("Unexpected window: %d", window)
+	}
+
+	if err := server.UpdateWindow(60); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for window, _ := client.Window(); window != 60; window, _ = client.Window() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected window: %d", window)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlowControlNotNegotiated(t *testing.T) {
+	client, _ := pipeOptions(t, &Options{FlowControlWindow: 1024}, nil)
+	if _, err := client.Window(); err != ErrNoFlowControl {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := client.UpdateWindow(1); err != ErrNoFlowControl {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestFlowControlViolation(t *testing.T) {
+	var buf bytes.Buffer
+	key := &[32]byte{'k', 'e', 'y'}
+	enc := newEncoder(&buf, key)
+	enc.typed = true
+	for i := 0; i < 3; i++ {
+		if err := enc.Encode(&Message{Data: make([]byte, 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := newDecoder(&buf, key)
+	dec.typed = true
+	f := newFlowControl(newEncoder(io.Discard, key), dec, 25, 25, true)
+	f.readLoop()
+	if f.err != ErrFlowControl || len(f.queue) != 2 {
+		t.Fatalf("Unexpected result: %v %d", f.err, len(f.queue))
+	}
+}
@@ -0,0 +1,129 @@
+package snacl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fuzzSeeds are hostile and valid inputs to start fuzzing from
+func fuzzSeeds(f *testing.F) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	frame, err := SealMessage(priv, pub, []byte("hello world\n"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(frame)
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 1, 0})
+	f.Add([]byte{0, 0, 0, 23})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add(frame[:len(frame)-1])
+}
+
+func FuzzParseFrame(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fr, n, err := ParseFrame(data)
+		if err != nil {
+			return
+		}
+		if n > len(data) || len(fr.Box) != n-4-24 {
+			t.Fatalf("Unexpected frame: n:%d len(data):%d len(box):%d", n, len(data), len(fr.Box))
+		}
+	})
+}
+
+func FuzzReadMsg(f *testing.F) {
+	fuzzSeeds(f)
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewReader(bytes.NewReader(data), priv, pub)
+		for {
+			_, err := r.ReadMsg()
+			if err == nil {
+				continue
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, ErrFrameEmpty) || errors.Is(err, ErrFrameTooLarge) ||
+				errors.Is(err, ErrFrameTooShort) || errors.Is(err, ErrDecrypt) {
+				return
+			}
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+}
+
+// fuzzStream feeds the fuzzer's data to a Conn and discards what the Conn writes
+type fuzzStream struct {
+	io.Reader
+}
+
+func (fuzzStream) Write(p []byte) (int, error) { return len(p), nil }
+func (fuzzStream) Close() error                { return nil }
+
+func FuzzHandshake(f *testing.F) {
+	fuzzSeeds(f)
+	f.Add(make([]byte, 32))
+	f.Add((&hello{version: Version1, maxMessageLength: 100, cipherSuites: []uint16{3, 1}}).marshal())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := Server(fuzzStream{bytes.NewReader(data)}, nil)
+		if err := c.Handshake(); err != nil {
+			return
+		}
+		for {
+			if _, err := c.ReadMsg(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// FuzzParser checks that Parser finds the same frames as ParseFrame, however the data is split up
+func FuzzParser(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var expected []Event
+		var expectedErr error
+		for rest := data; len(rest) > 0; {
+			fr, n, err := ParseFrame(rest)
+			if err != nil {
+				if err != io.ErrUnexpectedEOF {
+					expectedErr = err
+				}
+				break
+			}
+			expected = append(expected, Event{Type: EventFrame, Frame: fr})
+			rest = rest[n:]
+		}
+
+		for _, chunk := range []int{1, 3, 7, len(data) + 1} {
+			p := NewParser(false)
+			var events []Event
+			var err error
+			for rest := data; len(rest) > 0 && err == nil; {
+				n := min(chunk, len(rest))
+				var evs []Event
+				evs, err = p.Feed(rest[:n])
+				events = append(events, evs...)
+				rest = rest[n:]
+			}
+
+			if (err == nil) != (expectedErr == nil) {
+				t.Fatalf("Unexpected error with chunks of %d: %v != %v", chunk, err, expectedErr)
+			}
+			if len(events) != len(expected) {
+				t.Fatalf("Unexpected number of events with chunks of %d: %d != %d", chunk, len(events), len(expected))
+			}
+			for i := range events {
+				if events[i].Frame.Nonce != expected[i].Frame.Nonce || !bytes.Equal(events[i].Frame.Box, expected[i].Frame.Box) {
+					t.Fatalf("Unexpected frame %d with chunks of %d", i, chunk)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package snacl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenFrames seals a fixed set of messages with fixed keys and nonces, one frame per line in hex
+func goldenFrames(t *testing.T) []byte {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	var nonce [24]byte
+	for i := range nonce {
+		nonce[i] = byte(i)
+	}
+
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = byte(i)
+	}
+
+	var out bytes.Buffer
+	for _, msg := range [][]byte{{}, []byte("hello world\n"), long} {
+		frame, err := SealMessageWithNonce(priv, pub, &nonce, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&out, "%x\n", frame)
+	}
+	return out.Bytes()
+}
+
+// TestGoldenFrames pins the wire format, see WireFormat. Run with -update to regenerate testdata/frames.golden,
+// which should only ever happen together with a change to WireFormat.
+func TestGoldenFrames(t *testing.T) {
+	path := filepath.Join("testdata", "frames.golden")
+	got := goldenFrames(t)
+
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("Frames don't match %s:\nGot:\n%s\nExpected:\n%s", path, got, expected)
+	}
+
+	// The golden frames have a big-endian length and open with the same keys
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	for _, line := range bytes.Split(bytes.TrimSpace(expected), []byte("\n")) {
+		frame, err := hex.DecodeString(string(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenMessage(priv, pub, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// goldenStream is one side of a connection for golden tests. Reads come from in, and every write is
+// recorded as a line of hex.
+type goldenStream struct {
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (s *goldenStream) Read(p []byte) (int, error) { return s.in.Read(p) }
+func (s *goldenStream) Close() error               { return nil }
+
+func (s *goldenStream) Write(p []byte) (int, error) {
+	fmt.Fprintf(&s.out, "%x\n", p)
+	return len(p), nil
+}
+
+// goldenLegacySession records everything a Version0 client sends: its public key and then a frame per
+// message. The keys are fixed and the nonces are all zero, so it's the same every time.
+func goldenLegacySession(t *testing.T) []byte {
+	clientKeys, err := vectorKeys("sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKeys, err := vectorKeys("recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &goldenStream{in: bytes.NewReader(serverKeys.Public[:])}
+	client := Client(stream, &Options{LegacyV0: true, Keys: clientKeys, Rand: zeroReader{}})
+	for _, msg := range []string{"hello world\n", "bye\n"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stream.out.Bytes()
+}
+
+// TestGoldenLegacyV0 pins the original challenge protocol, so builds with Options.LegacyV0 keep working
+// with existing deployments. Run with -update to regenerate testdata/legacy_v0.golden, which should never
+// be needed.
+func TestGoldenLegacyV0(t *testing.T) {
+	path := filepath.Join("testdata", "legacy_v0.golden")
+	got := goldenLegacySession(t)
+
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("Session doesn't match %s:\nGot:\n%s\nExpected:\n%s", path, got, expected)
+	}
+
+	// A Version0 server understands the golden session
+	var session []byte
+	for _, line := range bytes.Split(bytes.TrimSpace(expected), []byte("\n")) {
+		b, err := hex.DecodeString(string(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		session = append(session, b...)
+	}
+	serverKeys, err := vectorKeys("recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := Server(&goldenStream{in: bytes.NewReader(session)}, &Options{LegacyV0: true, Keys: serverKeys})
+	for _, expected := range []string{"hello world\n", "bye\n"} {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data) != expected {
+			t.Fatalf("Unexpected result: %q != %q", msg.Data, expected)
+		}
+	}
+}
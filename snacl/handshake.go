@@ -0,0 +1,821 @@
+package snacl
+
+import (
+	"crypto/hmac"
+	"crypto/mlkem"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/arianitu/go-challenge-2/internal/drbg"
+)
+
+// Protocol versions
+const (
+	// Version0 is the original challenge protocol, both sides send their raw 32 byte public key and
+	// nothing is negotiated. See Options.LegacyV0.
+	Version0 = 0
+	// Version1 starts with a hello carrying the public key and extensions, see hello
+	Version1 = 1
+)
+
+// maxNegotiableLength is the biggest Options.MaxMessageLength, it keeps frame lengths well inside a uint32
+const maxNegotiableLength = 1 << 30
+
+// ErrHandshakeFailed is returned when the other side's handshake can't be understood or accepted.
+// It's wrapped with the reason, use errors.Is.
+var ErrHandshakeFailed = errors.New("handshake failed")
+
+// ConnectionState describes a Conn once the handshake is done
+type ConnectionState struct {
+	// HandshakeComplete is set once the handshake has succeeded, nothing else is set before that
+	HandshakeComplete bool
+	// Version is the protocol version in use, Version0 or Version1
+	Version int
+	// MaxMessageLength is the biggest message either side sends or accepts. It's the smaller of the two
+	// sides' Options.MaxMessageLength, or our own for Version0 which can't negotiate it.
+	MaxMessageLength int
+	// LocalPublicKey is our public key and PeerPublicKey is the other side's, see Conn.PeerPublicKey
+	LocalPublicKey [32]byte
+	PeerPublicKey  [32]byte
+	// CipherSuite seals the records, it's NaClBox for Version0
+	CipherSuite CipherSuite
+	// PostQuantum is set when the keys came from the hybrid key exchange, see Options.PostQuantum
+	PostQuantum bool
+	// Noise is set when the keys came from the Noise handshake, see Options.Noise
+	Noise bool
+	// AssociatedData is set when messages can carry associated data, see Options.AssociatedData
+	AssociatedData bool
+	// Padded is set when records are padded, see Options.Padding
+	Padded bool
+	// EncryptedLengths is set when the length prefixes are encrypted, see Options.EncryptLengths
+	EncryptedLengths bool
+	// Compressed is set when messages are compressed, see Options.Compression
+	Compressed bool
+	// FlowControlWindow is the window the other side granted when messages are flow controlled, see
+	// Options.FlowControlWindow. It's 0 without flow control.
+	FlowControlWindow int
+	// Keepalive is set when idle connections are pinged, see Options.KeepaliveInterval
+	Keepalive bool
+	// Urgent is set when the other side takes urgent messages, see Conn.WriteUrgent
+	Urgent bool
+	// ServerName is the client's Options.ServerName, "" if it didn't send one
+	ServerName string
+	// MemoryLocked is set when the traffic keys are in locked memory, see Options.LockMemory
+	MemoryLocked bool
+	// Ratchet is set when the keys are ratcheted, see Options.Ratchet
+	Ratchet bool
+	// PeerCertificate is the other side's certificate once it's been verified against
+	// Options.TrustedCAs, nil without them
+	PeerCertificate *Certificate
+}
+
+// ConnectionState returns the state of the connection, it's the zero value until Handshake has succeeded
+func (c *Conn) ConnectionState() ConnectionState {
+	if !c.ready.Load() {
+		return ConnectionState{}
+	}
+	return c.state
+}
+
+// PeerPublicKey returns the other side's public key once the handshake is done, or a zero key before that.
+// The key isn't checked against anything by the handshake, so it's up to the application to decide
+// whether it's talking to someone it trusts.
+func (c *Conn) PeerPublicKey() [32]byte {
+	return c.ConnectionState().PeerPublicKey
+}
+
+// LocalPublicKey returns our public key once the handshake is done, or a zero key before that.
+// It's useful when Options.Keys is nil and a key pair was generated for the connection.
+func (c *Conn) LocalPublicKey() [32]byte {
+	return c.ConnectionState().LocalPublicKey
+}
+
+func (c *Conn) handshake() error {
+	var clientHello *hello
+	if c.opts.GetConfigForClient != nil {
+		if c.isClient || c.opts.LegacyV0 || c.opts.Noise {
+			return errors.New("Options.GetConfigForClient is for Version1 servers")
+		}
+		var err error
+		clientHello, err = c.configForClient()
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.opts.ServerName) > maxServerNameLength {
+		return fmt.Errorf("Options.ServerName must be at most %d bytes, got %d", maxServerNameLength, len(c.opts.ServerName))
+	}
+
+	kx := c.opts.KeyExchanger
+	if kx == nil && c.opts.Keys != nil {
+		kx = c.opts.Keys
+	}
+	if kx == nil && c.opts.GetKeys == nil {
+		keys, err := GenerateKeys(c.opts.rand())
+		if err != nil {
+			return err
+		}
+		// Nobody else has the key pair, and it isn't needed after the handshake
+		defer keys.Zero()
+		kx = keys
+	}
+
+	maxLength := c.opts.MaxMessageLength
+	if maxLength == 0 {
+		maxLength = MaxMessageLength
+	}
+	if maxLength < 0 || maxLength > maxNegotiableLength {
+		return fmt.Errorf("Options.MaxMessageLength must be between 0 and %d, got %d", maxNegotiableLength, maxLength)
+	}
+
+	if c.opts.FlowControlWindow < 0 || c.opts.FlowControlWindow > math.MaxUint32 {
+		return fmt.Errorf("Options.FlowControlWindow must be between 0 and %d, got %d", uint32(math.MaxUint32), c.opts.FlowControlWindow)
+	}
+
+	if len(c.opts.CipherSuites) == 0 && c.opts.CipherSuites != nil {
+		return errors.New("Options.CipherSuites is empty")
+	}
+
+	if c.opts.Certificate != nil || len(c.opts.TrustedCAs) > 0 {
+		if c.opts.LegacyV0 || c.opts.Noise {
+			return errors.New("Options.Certificate and Options.TrustedCAs need Version1")
+		}
+		if c.opts.Certificate != nil && (kx == nil || c.opts.Certificate.PublicKey != kx.PublicKey()) {
+			return errors.New("Options.Certificate isn't for Options.Keys")
+		}
+	}
+
+	if c.isClient && c.opts.NoiseIK && (!c.opts.Noise || c.opts.LegacyV0 || len(c.opts.PeerKeys) != 1) {
+		return errors.New("Options.NoiseIK needs Options.Noise and the server's key as the only Options.PeerKeys")
+	}
+
+	if c.opts.GetKeys != nil && (c.isClient || c.opts.LegacyV0 || c.opts.Noise || c.opts.Certificate != nil) {
+		return errors.New("Options.GetKeys is for Version1 servers without Options.Certificate")
+	}
+
+	state := ConnectionState{HandshakeComplete: true, MaxMessageLength: maxLength, CipherSuite: NaClBox}
+	if kx != nil {
+		state.LocalPublicKey = kx.PublicKey()
+	}
+	var sendKey, recvKey [32]byte
+	defer clear(sendKey[:])
+	defer clear(recvKey[:])
+	var err error
+	switch {
+	case c.opts.LegacyV0:
+		sendKey, recvKey, err = c.handshakeV0(kx, &state)
+	case c.opts.Noise:
+		sendKey, recvKey, err = c.handshakeNoise(kx, &state)
+	default:
+		sendKey, recvKey, err = c.handshakeV1(kx, &state, clientHello)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The traffic keys are moved to where they're kept until Close zeroes them, see wipe.go
+	send, sendLocked, err := trafficKey(&sendKey, c.opts.LockMemory)
+	if err != nil {
+		clear(recvKey[:])
+		return err
+	}
+	c.sw = &Writer{enc: newEncoder(c.rwc, send)}
+	c.sw.enc.lockedKey = c.opts.LockMemory
+	recv, recvLocked, err := trafficKey(&recvKey, c.opts.LockMemory)
+	if err != nil {
+		return err
+	}
+	c.sr = &Reader{dec: newDecoder(c.rwc, recv)}
+	c.sr.dec.lockedKey = c.opts.LockMemory
+	state.MemoryLocked = sendLocked && recvLocked
+	err = c.sr.dec.setSuite(state.CipherSuite)
+	if err != nil {
+		return err
+	}
+	err = c.sw.enc.setSuite(state.CipherSuite)
+	if err != nil {
+		return err
+	}
+	c.sr.SetMaxMessageLength(state.MaxMessageLength)
+	c.sw.SetMaxMessageLength(state.MaxMessageLength)
+	if c.opts.StatsSampleRate > 0 {
+		c.readStats.sampleRate = uint64(c.opts.StatsSampleRate)
+		c.writeStats.sampleRate = uint64(c.opts.StatsSampleRate)
+	}
+	if state.Padded {
+		c.sr.dec.padded = true
+		c.sw.enc.padding = c.opts.Padding
+	}
+	if state.EncryptedLengths {
+		c.sr.dec.lengths, err = newLengthCipher(recv)
+		if err != nil {
+			return err
+		}
+		c.sw.enc.lengths, err = newLengthCipher(send)
+		if err != nil {
+			return err
+		}
+	}
+	c.sr.dec.compressed = state.Compressed
+	c.sw.enc.compress = state.Compressed
+	if state.FlowControlWindow > 0 {
+		c.flow = newFlowControl(c.sw.enc, c.sr.dec, c.opts.FlowControlWindow, state.FlowControlWindow, c.opts.ManualWindowUpdates)
+	}
+	if state.Keepalive {
+		c.keepalive = newKeepalive(c.sw.enc, c.opts.KeepaliveInterval, c.opts.KeepaliveTimeout, c.rwc.Close)
+		c.sr.dec.keepalive = c.keepalive
+	}
+	c.sr.dec.idle = c.idle
+	c.sw.enc.idle = c.idle
+	c.sr.dec.withAAD = state.AssociatedData
+	c.sw.enc.withAAD = state.AssociatedData
+	c.sr.dec.stats = &c.readStats
+	c.sw.enc.stats = &c.writeStats
+	if state.Version >= Version1 {
+		c.sr.dec.typed = true
+		c.sw.enc.typed = true
+		c.sw.enc.rekey = newRekeyPolicy(c.opts.RekeyMessages, c.opts.RekeyBytes)
+		c.sr.dec.urgent = c.opts.OnUrgent
+	}
+	if state.Ratchet {
+		err = c.ratchet.init(send, recv)
+		if err != nil {
+			return err
+		}
+		c.sr.dec.ratchet = c.ratchet
+		c.sw.enc.ratchet = c.ratchet
+	}
+
+	if c.opts.WriteBufferSize > 0 {
+		c.sw.Buffer(c.opts.WriteBufferSize, c.opts.WriteBufferDelay)
+	}
+	if c.opts.NonceDRBG {
+		nonces, err := drbg.New(c.opts.rand(), 0)
+		if err != nil {
+			return err
+		}
+		c.sw.SetRand(nonces)
+	} else {
+		c.sw.SetRand(c.opts.rand())
+	}
+
+	// The other side has proved it holds its key, so if we don't accept it we can say so, see alert.go
+	err = c.authenticate(&state)
+	if err != nil {
+		c.sendAlert(AlertAuthFailed)
+		return err
+	}
+
+	c.state = state
+	if c.flow != nil {
+		go c.flow.readLoop()
+	}
+	if c.keepalive != nil {
+		go c.keepalive.run()
+	}
+	return nil
+}
+
+// handshakeV0 swaps raw public keys, and both directions use the box's shared key
+func (c *Conn) handshakeV0(kx KeyExchanger, state *ConnectionState) (sendKey, recvKey [32]byte, err error) {
+	ourKey := kx.PublicKey()
+	err = c.exchange(ourKey[:], func(r io.Reader) error {
+		_, err := io.ReadFull(r, state.PeerPublicKey[:])
+		return err
+	})
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+
+	state.Version = Version0
+	sendKey, err = boxSharedKey(kx, &state.PeerPublicKey)
+	return sendKey, sendKey, err
+}
+
+// configForClient reads the client's hello and switches to the Options GetConfigForClient picks for
+// its server name. It returns the hello for handshakeV1.
+func (c *Conn) configForClient() (*hello, error) {
+	theirs, err := readHello(c.rwc)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := c.opts.GetConfigForClient(theirs.serverName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+	if opts != nil {
+		if opts.LegacyV0 || opts.Noise {
+			return nil, errors.New("Options.GetConfigForClient returned Options that aren't for Version1")
+		}
+		c.opts = *opts
+		c.opts.GetConfigForClient = nil
+	}
+	return theirs, nil
+}
+
+// authenticate fails the handshake if we don't accept the other side's key
+func (c *Conn) authenticate(state *ConnectionState) error {
+	err := checkPeerKey(&c.opts, state.PeerPublicKey)
+	if err != nil {
+		return err
+	}
+	err = checkRevocation(&c.opts, state.PeerPublicKey)
+	if err != nil {
+		return err
+	}
+	state.PeerCertificate, err = verifyPeerCertificate(&c.opts, c.peerCertificate, state.PeerPublicKey)
+	return err
+}
+
+// checkPeerKey fails the handshake if the other side's key isn't one of opts.PeerKeys
+func checkPeerKey(opts *Options, peerKey [32]byte) error {
+	if len(opts.PeerKeys) == 0 {
+		return nil
+	}
+	for _, key := range opts.PeerKeys {
+		if key == peerKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the other side's key %s isn't one of Options.PeerKeys", ErrHandshakeFailed, Fingerprint(peerKey))
+}
+
+// handshakeV1 swaps hellos, derives a key for each direction from the transcript, and then swaps
+// finished messages to prove both sides got the same keys before any data is sent. theirs is the
+// client's hello if a server has already read it, see configForClient.
+func (c *Conn) handshakeV1(kx KeyExchanger, state *ConnectionState, theirs *hello) (sendKey, recvKey [32]byte, err error) {
+	// A server picking its key has to see which ones the client accepts first
+	if c.opts.GetKeys != nil {
+		if theirs == nil {
+			theirs, err = readHello(c.rwc)
+			if err != nil {
+				return sendKey, recvKey, err
+			}
+		}
+		keys, err := c.opts.GetKeys(theirs.peerKeys)
+		if err != nil {
+			return sendKey, recvKey, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+		}
+		kx = keys
+		state.LocalPublicKey = keys.Public
+	}
+
+	ours := &hello{version: Version1, publicKey: kx.PublicKey(), maxMessageLength: uint32(state.MaxMessageLength), associatedData: c.opts.AssociatedData, padding: c.opts.Padding != nil, encryptLengths: c.opts.EncryptLengths, compression: c.opts.Compression, flowWindow: uint32(c.opts.FlowControlWindow), keepalive: c.opts.KeepaliveInterval > 0, urgent: c.opts.OnUrgent != nil}
+	if c.opts.Certificate != nil {
+		ours.certificate = c.opts.Certificate.Marshal()
+	}
+	ours.peerKeys = c.opts.PeerKeys
+	if c.isClient {
+		ours.serverName = c.opts.ServerName
+	}
+	for _, suite := range c.opts.cipherSuites() {
+		ours.cipherSuites = append(ours.cipherSuites, suite.ID())
+	}
+	var kemKey *mlkem.DecapsulationKey768
+	if c.opts.PostQuantum {
+		seed := make([]byte, mlkem.SeedSize)
+		_, err = io.ReadFull(c.opts.rand(), seed)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		kemKey, err = mlkem.NewDecapsulationKey768(seed)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		ours.kemKey = kemKey.EncapsulationKey().Bytes()
+	}
+	var ratchetKeys *Keys
+	if c.opts.Ratchet {
+		ratchetKeys, err = GenerateKeys(c.opts.rand())
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		ours.ratchetKey = &ratchetKeys.Public
+		// The ratchet takes them over if the other side offered it too
+		defer func() {
+			if !state.Ratchet || err != nil {
+				ratchetKeys.Zero()
+			}
+		}()
+	}
+	ours.raw = ours.marshal()
+	if theirs != nil {
+		_, err = c.rwc.Write(ours.raw)
+	} else {
+		err = c.exchange(ours.raw, func(r io.Reader) error {
+			var err error
+			theirs, err = readHello(r)
+			return err
+		})
+	}
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+
+	state.PeerPublicKey = theirs.publicKey
+	state.ServerName = theirs.serverName
+	if c.isClient {
+		state.ServerName = ours.serverName
+	}
+	// It's checked by authenticate once the finished messages have proved the other side holds the key
+	c.peerCertificate = theirs.certificate
+	// Both sides speak the lower version, and readHello has already checked it's one we know
+	state.Version = min(int(theirs.version), Version1)
+	if theirs.maxMessageLength != 0 {
+		state.MaxMessageLength = min(state.MaxMessageLength, int(theirs.maxMessageLength))
+	}
+	state.AssociatedData = ours.associatedData && theirs.associatedData
+	state.Padded = ours.padding && theirs.padding
+	state.EncryptedLengths = ours.encryptLengths && theirs.encryptLengths
+	state.Compressed = ours.compression && theirs.compression
+	state.Keepalive = ours.keepalive && theirs.keepalive
+	// Each side takes urgent messages if it offered to, whether or not the other side did
+	state.Urgent = theirs.urgent
+	if ours.flowWindow > 0 && theirs.flowWindow > 0 {
+		// Every message has to fit in both windows
+		state.FlowControlWindow = int(theirs.flowWindow)
+		state.MaxMessageLength = min(state.MaxMessageLength, int(ours.flowWindow), int(theirs.flowWindow))
+	}
+	// A ratchet step is a record like any other, both sides leave the ratchet off if it wouldn't fit
+	if ratchetKeys != nil && theirs.ratchetKey != nil && state.MaxMessageLength >= ratchetStepLength {
+		state.Ratchet = true
+		c.ratchet = newRatchet(c.opts.rand(), ratchetKeys, *theirs.ratchetKey)
+	}
+	clientSuites, serverSuites := theirs.cipherSuites, ours.cipherSuites
+	if c.isClient {
+		clientSuites, serverSuites = serverSuites, clientSuites
+	}
+	state.CipherSuite, err = chooseCipherSuite(clientSuites, serverSuites, c.opts.cipherSuites())
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+
+	// transcript is the client's hello followed by the server's
+	var transcript []byte
+	if c.isClient {
+		transcript = append(append(transcript, ours.raw...), theirs.raw...)
+	} else {
+		transcript = append(append(transcript, theirs.raw...), ours.raw...)
+	}
+
+	sharedKey, err := boxSharedKey(kx, &theirs.publicKey)
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	defer clear(sharedKey[:])
+	secret := sharedKey[:]
+	if kemKey != nil && theirs.kemKey != nil {
+		kemSecret, kemTranscript, err := c.kemExchange(kemKey, theirs.kemKey)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		secret = append(secret, kemSecret...)
+		defer clear(secret)
+		clear(kemSecret)
+		transcript = append(transcript, kemTranscript...)
+		state.PostQuantum = true
+	}
+
+	ks, err := deriveKeys(secret, transcript)
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	defer ks.zero()
+
+	sendKey, recvKey = ks.serverKey, ks.clientKey
+	params := negotiated(state)
+	ourFinished, theirFinished := finishedMAC(&ks.serverFinished, transcript, params), finishedMAC(&ks.clientFinished, transcript, params)
+	if c.isClient {
+		sendKey, recvKey = ks.clientKey, ks.serverKey
+		ourFinished, theirFinished = theirFinished, ourFinished
+	}
+
+	var got [finishedLength]byte
+	err = c.exchange(ourFinished, func(r io.Reader) error {
+		_, err := io.ReadFull(r, got[:])
+		return err
+	})
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	if !hmac.Equal(got[:], theirFinished) {
+		return sendKey, recvKey, fmt.Errorf("%w: finished message doesn't match, the handshake was corrupted or tampered with", ErrHandshakeFailed)
+	}
+	return sendKey, recvKey, nil
+}
+
+// kemExchange does the ML-KEM half of the hybrid key exchange. Hellos are sent at the same time, so
+// neither side can answer the other's encapsulation key in its hello. Instead each side encapsulates
+// to the other's key and they swap ciphertexts. It returns both shared keys and both ciphertexts,
+// the client's first.
+func (c *Conn) kemExchange(ours *mlkem.DecapsulationKey768, theirKey []byte) (secret, transcript []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(theirKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: bad ML-KEM encapsulation key", ErrHandshakeFailed)
+	}
+	ourSecret, ourCiphertext := ek.Encapsulate()
+
+	theirCiphertext := make([]byte, mlkem.CiphertextSize768)
+	err = c.exchange(ourCiphertext, func(r io.Reader) error {
+		_, err := io.ReadFull(r, theirCiphertext)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	theirSecret, err := ours.Decapsulate(theirCiphertext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: bad ML-KEM ciphertext", ErrHandshakeFailed)
+	}
+
+	defer clear(ourSecret)
+	defer clear(theirSecret)
+	// A new slice, so clearing ours and theirs doesn't clear it
+	secret = make([]byte, 0, len(ourSecret)+len(theirSecret))
+	if c.isClient {
+		return append(append(secret, ourSecret...), theirSecret...), append(ourCiphertext, theirCiphertext...), nil
+	}
+	return append(append(secret, theirSecret...), ourSecret...), append(theirCiphertext, ourCiphertext...), nil
+}
+
+// exchange sends out and calls read to read what the other side sent. Both sides send straight away,
+// so the write happens while we read and the handshake doesn't deadlock on unbuffered streams like net.Pipe.
+func (c *Conn) exchange(out []byte, read func(io.Reader) error) error {
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := c.rwc.Write(out)
+		writeErr <- err
+	}()
+
+	err := read(c.rwc)
+	if err != nil {
+		return err
+	}
+	return <-writeErr
+}
+
+// hello is the first thing each side sends in Version1:
+//
+//	[magic "SNCL"][version uint8][public key 32][extensions length uint16be][extensions]
+//
+// and every extension is [type uint16be][length uint16be][data]. Unknown extensions are skipped, so new
+// ones can be added without breaking older peers. After the hellos each side sends its finished message,
+// finishedLength bytes, see handshakeV1 and keyschedule.go. When both hellos carry an ML-KEM key, the
+// ML-KEM ciphertexts are swapped before the finished messages, see kemExchange.
+type hello struct {
+	// version is the highest version the sender speaks
+	version   uint8
+	publicKey [32]byte
+	// maxMessageLength is the sender's Options.MaxMessageLength, 0 if it wasn't sent
+	maxMessageLength uint32
+	// cipherSuites are the IDs of the sender's Options.CipherSuites in order of preference.
+	// A hello without them only speaks NaClBox.
+	cipherSuites []uint16
+	// kemKey is the sender's ML-KEM-768 encapsulation key if it offered the hybrid key exchange
+	kemKey []byte
+	// associatedData is set if the sender offered associated data
+	associatedData bool
+	// padding is set if the sender offered padding
+	padding bool
+	// encryptLengths is set if the sender offered encrypted lengths
+	encryptLengths bool
+	// compression is set if the sender offered compression
+	compression bool
+	// flowWindow is the credit the sender grants for flow control, 0 if it didn't offer it
+	flowWindow uint32
+	// keepalive is set if the sender offered keepalives
+	keepalive bool
+	// urgent is set if the sender takes urgent messages
+	urgent bool
+	// certificate is the sender's certificate, see Options.Certificate
+	certificate []byte
+	// peerKeys are the keys the sender accepts from us, see Options.PeerKeys
+	peerKeys [][32]byte
+	// ratchetKey is the sender's first ratchet public key if it offered the double ratchet
+	ratchetKey *[32]byte
+	// serverName is the client's Options.ServerName, only clients send it
+	serverName string
+
+	// raw is the hello as it was sent or received, for the transcript
+	raw []byte
+}
+
+const (
+	helloMagic        = "SNCL"
+	helloHeaderLength = len(helloMagic) + 1 + 32 + 2
+	// maxServerNameLength is the longest Options.ServerName
+	maxServerNameLength = 255
+)
+
+// Extension types
+const (
+	// extMaxMessageLength is a uint32be, the biggest message the sender wants to send or accept
+	extMaxMessageLength uint16 = 1
+	// extCipherSuites is a list of uint16be cipher suite IDs, see CipherSuite.ID
+	extCipherSuites uint16 = 2
+	// extKEM is an ML-KEM-768 encapsulation key, see Options.PostQuantum
+	extKEM uint16 = 3
+	// extAssociatedData offers associated data in every frame, see Options.AssociatedData. It's empty.
+	extAssociatedData uint16 = 4
+	// extPadding offers padded records, see Options.Padding. It's empty.
+	extPadding uint16 = 5
+	// extEncryptLengths offers encrypted length prefixes, see Options.EncryptLengths. It's empty.
+	extEncryptLengths uint16 = 6
+	// extCompression offers compressed messages, see Options.Compression. It's empty.
+	extCompression uint16 = 7
+	// extFlowControl offers flow control, the data is the uint32be window the sender grants, see
+	// Options.FlowControlWindow
+	extFlowControl uint16 = 8
+	// extKeepalive offers pings, see Options.KeepaliveInterval. It's empty.
+	extKeepalive uint16 = 9
+	// extCertificate is the sender's certificate, see Options.Certificate
+	extCertificate uint16 = 10
+	// extPeerKeys lists the public keys the sender accepts from the other side, 32 bytes each, see
+	// Options.PeerKeys
+	extPeerKeys uint16 = 11
+	// extRatchet offers the double ratchet, the data is the sender's first 32 byte ratchet public key,
+	// see Options.Ratchet
+	extRatchet uint16 = 12
+	// extServerName is the client's Options.ServerName, 1 to maxServerNameLength bytes
+	extServerName uint16 = 13
+	// extUrgent says the sender takes urgent messages, see Options.OnUrgent. It's empty.
+	extUrgent uint16 = 14
+)
+
+// marshal returns the hello as it's sent on the wire
+func (h *hello) marshal() []byte {
+	var ext []byte
+	ext = appendExtension(ext, extMaxMessageLength, binary.BigEndian.AppendUint32(nil, h.maxMessageLength))
+	if h.cipherSuites != nil {
+		var ids []byte
+		for _, id := range h.cipherSuites {
+			ids = binary.BigEndian.AppendUint16(ids, id)
+		}
+		ext = appendExtension(ext, extCipherSuites, ids)
+	}
+	if h.kemKey != nil {
+		ext = appendExtension(ext, extKEM, h.kemKey)
+	}
+	if h.associatedData {
+		ext = appendExtension(ext, extAssociatedData, nil)
+	}
+	if h.padding {
+		ext = appendExtension(ext, extPadding, nil)
+	}
+	if h.encryptLengths {
+		ext = appendExtension(ext, extEncryptLengths, nil)
+	}
+	if h.compression {
+		ext = appendExtension(ext, extCompression, nil)
+	}
+	if h.flowWindow > 0 {
+		ext = appendExtension(ext, extFlowControl, binary.BigEndian.AppendUint32(nil, h.flowWindow))
+	}
+	if h.keepalive {
+		ext = appendExtension(ext, extKeepalive, nil)
+	}
+	if h.certificate != nil {
+		ext = appendExtension(ext, extCertificate, h.certificate)
+	}
+	if len(h.peerKeys) > 0 {
+		var data []byte
+		for _, key := range h.peerKeys {
+			data = append(data, key[:]...)
+		}
+		ext = appendExtension(ext, extPeerKeys, data)
+	}
+	if h.ratchetKey != nil {
+		ext = appendExtension(ext, extRatchet, h.ratchetKey[:])
+	}
+	if h.serverName != "" {
+		ext = appendExtension(ext, extServerName, []byte(h.serverName))
+	}
+	if h.urgent {
+		ext = appendExtension(ext, extUrgent, nil)
+	}
+
+	out := make([]byte, 0, helloHeaderLength+len(ext))
+	out = append(out, helloMagic...)
+	out = append(out, h.version)
+	out = append(out, h.publicKey[:]...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(ext)))
+	return append(out, ext...)
+}
+
+// appendExtension appends an extension with data to b
+func appendExtension(b []byte, typ uint16, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// readHello reads and checks the other side's hello
+func readHello(r io.Reader) (*hello, error) {
+	// The magic is read on its own so a Version0 peer, which only sends 32 bytes, fails straight away
+	// instead of leaving us waiting for the rest of a hello
+	var header [helloHeaderLength]byte
+	_, err := io.ReadFull(r, header[:len(helloMagic)])
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:len(helloMagic)]) != helloMagic {
+		return nil, fmt.Errorf("%w: not a hello, the other side may be using Version0", ErrHandshakeFailed)
+	}
+	_, err = io.ReadFull(r, header[len(helloMagic):])
+	if err != nil {
+		return nil, err
+	}
+
+	h := &hello{version: header[len(helloMagic)]}
+	if h.version < Version1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrHandshakeFailed, h.version)
+	}
+	copy(h.publicKey[:], header[len(helloMagic)+1:])
+
+	extLength := int(binary.BigEndian.Uint16(header[helloHeaderLength-2:]))
+	h.raw = make([]byte, helloHeaderLength+extLength)
+	copy(h.raw, header[:])
+	ext := h.raw[helloHeaderLength:]
+	_, err = io.ReadFull(r, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(ext) > 0 {
+		if len(ext) < 4 {
+			return nil, fmt.Errorf("%w: truncated extension", ErrHandshakeFailed)
+		}
+		typ := binary.BigEndian.Uint16(ext)
+		length := int(binary.BigEndian.Uint16(ext[2:]))
+		if len(ext) < 4+length {
+			return nil, fmt.Errorf("%w: truncated extension", ErrHandshakeFailed)
+		}
+		data := ext[4 : 4+length]
+		ext = ext[4+length:]
+
+		switch typ {
+		case extMaxMessageLength:
+			if len(data) != 4 {
+				return nil, fmt.Errorf("%w: bad max message length extension", ErrHandshakeFailed)
+			}
+			h.maxMessageLength = binary.BigEndian.Uint32(data)
+		case extCipherSuites:
+			if len(data) == 0 || len(data)%2 != 0 {
+				return nil, fmt.Errorf("%w: bad cipher suites extension", ErrHandshakeFailed)
+			}
+			h.cipherSuites = make([]uint16, 0, len(data)/2)
+			for ; len(data) > 0; data = data[2:] {
+				h.cipherSuites = append(h.cipherSuites, binary.BigEndian.Uint16(data))
+			}
+		case extKEM:
+			if len(data) != mlkem.EncapsulationKeySize768 {
+				return nil, fmt.Errorf("%w: bad ML-KEM key extension", ErrHandshakeFailed)
+			}
+			h.kemKey = data
+		case extAssociatedData:
+			h.associatedData = true
+		case extPadding:
+			h.padding = true
+		case extEncryptLengths:
+			h.encryptLengths = true
+		case extCompression:
+			h.compression = true
+		case extFlowControl:
+			if len(data) != 4 {
+				return nil, fmt.Errorf("%w: bad flow control extension", ErrHandshakeFailed)
+			}
+			h.flowWindow = binary.BigEndian.Uint32(data)
+		case extKeepalive:
+			h.keepalive = true
+		case extCertificate:
+			h.certificate = data
+		case extPeerKeys:
+			if len(data) == 0 || len(data)%32 != 0 {
+				return nil, fmt.Errorf("%w: bad peer keys extension", ErrHandshakeFailed)
+			}
+			h.peerKeys = make([][32]byte, len(data)/32)
+			for i := range h.peerKeys {
+				copy(h.peerKeys[i][:], data[32*i:])
+			}
+		case extRatchet:
+			if len(data) != 32 {
+				return nil, fmt.Errorf("%w: bad ratchet extension", ErrHandshakeFailed)
+			}
+			h.ratchetKey = (*[32]byte)(data)
+		case extServerName:
+			if len(data) == 0 || len(data) > maxServerNameLength {
+				return nil, fmt.Errorf("%w: bad server name extension", ErrHandshakeFailed)
+			}
+			h.serverName = string(data)
+		case extUrgent:
+			h.urgent = true
+		}
+	}
+	if h.cipherSuites == nil {
+		h.cipherSuites = []uint16{NaClBox.ID()}
+	}
+	return h, nil
+}
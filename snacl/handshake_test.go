@@ -0,0 +1,320 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeMaxMessageLength(t *testing.T) {
+	tests := []struct {
+		client, server int
+		expected       int
+	}{
+		{0, 0, MaxMessageLength},
+		{1000, 0, 1000},
+		{0, 1000, 1000},
+		{100000, 200000, 100000},
+	}
+	for _, test := range tests {
+		client, server := pipeOptions(t, &Options{MaxMessageLength: test.client}, &Options{MaxMessageLength: test.server})
+
+		for _, c := range []*Conn{client, server} {
+			state := c.ConnectionState()
+			if !state.HandshakeComplete || state.Version != Version1 {
+				t.Fatalf("Unexpected state: %+v", state)
+			}
+			if state.MaxMessageLength != test.expected {
+				t.Fatalf("Unexpected max message length: %d != %d", state.MaxMessageLength, test.expected)
+			}
+		}
+
+		// A write as long as the negotiated maximum arrives as a single message
+		msg := bytes.Repeat([]byte{'a'}, test.expected)
+		go client.Write(msg)
+		got, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data, msg) {
+			t.Fatalf("Unexpected message of %d bytes", len(got.Data))
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := Client(c1, &Options{LegacyV0: true}), Server(c2, nil)
+	defer client.Close()
+	defer server.Close()
+
+	if state := server.ConnectionState(); state.HandshakeComplete {
+		t.Fatalf("Unexpected state before the handshake: %+v", state)
+	}
+
+	go client.Handshake()
+	if err := server.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestHandshakeLegacyV0(t *testing.T) {
+	client, server := pipe(t, &Options{LegacyV0: true})
+	defer client.Close()
+	defer server.Close()
+
+	if state := client.ConnectionState(); state.Version != Version0 || state.MaxMessageLength != MaxMessageLength {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+}
+
+func TestReadHello(t *testing.T) {
+	h := &hello{version: Version1, publicKey: [32]byte{1, 2, 3}, maxMessageLength: 1234}
+	data := h.marshal()
+
+	got, err := readHello(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.version != h.version || got.publicKey != h.publicKey || got.maxMessageLength != h.maxMessageLength || !bytes.Equal(got.raw, data) {
+		t.Fatalf("Unexpected hello: %+v != %+v", got, h)
+	}
+
+	// Unknown extensions are skipped
+	data = append(data, 0, 0)
+	data = appendExtension(data[:len(data)-2], 0xffff, []byte("future"))
+	extLength := len(data) - helloHeaderLength
+	data[helloHeaderLength-2], data[helloHeaderLength-1] = byte(extLength>>8), byte(extLength)
+	if _, err := readHello(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated extension isn't
+	data[helloHeaderLength-1]++
+	data = append(data, 0)
+	if _, err := readHello(bytes.NewReader(data)); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnPublicKeys(t *testing.T) {
+	clientKeys, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := pipeOptions(t, &Options{Keys: clientKeys}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	if client.LocalPublicKey() != clientKeys.Public || server.PeerPublicKey() != clientKeys.Public {
+		t.Fatal("Unexpected result. The client's key doesn't match.")
+	}
+	// The server generated its own keys
+	if server.LocalPublicKey() == ([32]byte{}) || client.PeerPublicKey() != server.LocalPublicKey() {
+		t.Fatal("Unexpected result. The server's key doesn't match.")
+	}
+}
+
+func TestConnDirectionKeys(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	if *client.sw.enc.sharedKey == *client.sr.dec.sharedKey {
+		t.Fatal("Unexpected result. Both directions use the same key.")
+	}
+	if *client.sw.enc.sharedKey != *server.sr.dec.sharedKey || *server.sw.enc.sharedKey != *client.sr.dec.sharedKey {
+		t.Fatal("Unexpected result. The two sides derived different keys.")
+	}
+
+	// A frame reflected back to its sender doesn't open
+	var frame bytes.Buffer
+	enc := newEncoder(&frame, client.sw.enc.sharedKey)
+	enc.typed = true
+	if err := enc.Encode(&Message{Data: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	dec := newDecoder(&frame, client.sr.dec.sharedKey)
+	dec.typed = true
+	if err := dec.Decode(&Message{}); err != ErrDecrypt {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// tamperConn flips a bit in the byte at offset of everything read from it, like an attacker on the path
+type tamperConn struct {
+	net.Conn
+	offset int
+	read   int
+}
+
+func (c *tamperConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if i := c.offset - c.read; i >= 0 && i < n {
+		p[i] ^= 1
+	}
+	c.read += n
+	return n, err
+}
+
+func TestHandshakeFinishedMismatch(t *testing.T) {
+	// The server sees a different max message length than the client sent, so their transcripts differ
+	c1, c2 := net.Pipe()
+	client := Client(c1, nil)
+	server := Server(&tamperConn{Conn: c2, offset: helloHeaderLength + 4 + 3}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- client.Handshake()
+	}()
+	if err := server.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if err := <-clientErr; !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+}
+
+func TestHandshakeDowngrade(t *testing.T) {
+	// The server sees the client's first cipher suite as another one, so the two sides settle on
+	// different suites
+	c1, c2 := net.Pipe()
+	suites := []CipherSuite{XChaCha20Poly1305, AES256GCM, NaClBox}
+	client := Client(c1, &Options{CipherSuites: suites})
+	server := Server(&tamperConn{Conn: c2, offset: helloHeaderLength + 8 + 4 + 1}, &Options{CipherSuites: suites})
+	defer client.Close()
+	defer server.Close()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- client.Handshake()
+	}()
+	if err := server.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	if err := <-clientErr; !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected client error: %v", err)
+	}
+
+	// Even with the same transcript, sides that settle on different versions or suites don't agree
+	var key [32]byte
+	transcript := []byte("transcript")
+	expected := finishedMAC(&key, transcript, negotiated(&ConnectionState{Version: Version1, CipherSuite: XChaCha20Poly1305}))
+	for _, state := range []*ConnectionState{{Version: Version1, CipherSuite: NaClBox}, {Version: Version0, CipherSuite: XChaCha20Poly1305}} {
+		if bytes.Equal(finishedMAC(&key, transcript, negotiated(state)), expected) {
+			t.Fatalf("Unexpected result. The finished message doesn't cover %+v.", state)
+		}
+	}
+}
+
+func TestHandshakePostQuantum(t *testing.T) {
+	client, server := pipe(t, &Options{PostQuantum: true})
+	defer client.Close()
+	defer server.Close()
+	if !client.ConnectionState().PostQuantum || !server.ConnectionState().PostQuantum {
+		t.Fatal("Unexpected result. The hybrid key exchange wasn't used.")
+	}
+
+	expected := []byte("hello")
+	go client.Write(expected)
+	msg, err := server.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+	}
+
+	// Both sides have to offer it
+	client, server = pipeOptions(t, &Options{PostQuantum: true}, nil)
+	defer client.Close()
+	defer server.Close()
+	if client.ConnectionState().PostQuantum || server.ConnectionState().PostQuantum {
+		t.Fatal("Unexpected result. The hybrid key exchange was used with only one side offering it.")
+	}
+}
+
+func TestHandshakeServerName(t *testing.T) {
+	caPub, caPriv := newCA(t)
+	services := map[string]*Options{
+		"db.example.com":    certifiedOptions(t, caPriv, "db.example.com", time.Now().Add(time.Hour)),
+		"cache.example.com": certifiedOptions(t, caPriv, "cache.example.com", time.Now().Add(time.Hour)),
+	}
+	fallback := mustGenerateKeys(t)
+	serverOpts := &Options{Keys: fallback, GetConfigForClient: func(serverName string) (*Options, error) {
+		if serverName == "unknown.example.com" {
+			return nil, errors.New("no such service")
+		}
+		return services[serverName], nil
+	}}
+
+	for name, opts := range services {
+		client, server := pipeOptions(t, &Options{ServerName: name, TrustedCAs: []ed25519.PublicKey{caPub}}, serverOpts)
+		if client.PeerPublicKey() != opts.Keys.Public || server.ConnectionState().ServerName != name || client.ConnectionState().ServerName != name {
+			t.Fatalf("Unexpected result for %s: %+v", name, server.ConnectionState())
+		}
+		client.Close()
+		server.Close()
+	}
+
+	// Without a name the server keeps its own Options
+	client, server := pipeOptions(t, nil, serverOpts)
+	if client.PeerPublicKey() != fallback.Public || server.ConnectionState().ServerName != "" {
+		t.Fatalf("Unexpected result: %+v", server.ConnectionState())
+	}
+	client.Close()
+	server.Close()
+
+	// A name the server doesn't serve, the server hangs up like a Listener would
+	c1, c2 := net.Pipe()
+	serverErr := make(chan error, 1)
+	go func() {
+		err := Server(c2, serverOpts).Handshake()
+		c2.Close()
+		serverErr <- err
+	}()
+	if err := Client(c1, &Options{ServerName: "unknown.example.com"}).Handshake(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := <-serverErr; !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	c1.Close()
+
+	// The server's certificate has to be for the name the client asked for
+	c1, c2 = net.Pipe()
+	go func() {
+		_, err := Server(c2, services["db.example.com"]).ReadMsg()
+		serverErr <- err
+	}()
+	err := Client(c1, &Options{ServerName: "cache.example.com", TrustedCAs: []ed25519.PublicKey{caPub}}).Handshake()
+	if !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var alert *AlertError
+	if err := <-serverErr; !errors.As(err, &alert) || alert.Alert != AlertAuthFailed {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+	c1.Close()
+	c2.Close()
+
+	c1, c2 = net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if err := Client(c1, &Options{GetConfigForClient: serverOpts.GetConfigForClient}).Handshake(); err == nil {
+		t.Fatal("Unexpected result. A client used GetConfigForClient.")
+	}
+	if err := Client(c1, &Options{ServerName: string(make([]byte, 256))}).Handshake(); err == nil {
+		t.Fatal("Unexpected result. A 256 byte server name was sent.")
+	}
+}
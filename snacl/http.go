@@ -0,0 +1,81 @@
+package snacl
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// HTTP is plain HTTP/1.1 over secure connections, so a server and its clients get encrypted requests
+// without TLS certificates:
+//
+//	l, err := net.Listen("tcp", ":8080")
+//	http.Serve(snacl.NewHTTPListener(l, opts), handler)
+//
+//	client := &http.Client{Transport: &http.Transport{DialContext: snacl.HTTPDialContext(opts)}}
+//	client.Get("http://example.com:8080/")
+//
+// URLs stay http://, the encryption is underneath HTTP rather than in it.
+
+// NewHTTPListener returns a listener for http.Serve and http.Server that accepts secure connections
+// from l, see NewNetListener. opts may be nil. net/http reads with small buffers, so its connections
+// are byte streams, see streamConn.
+func NewHTTPListener(l net.Listener, opts *Options) net.Listener {
+	return &httpListener{Listener: NewNetListener(l, opts)}
+}
+
+type httpListener struct {
+	net.Listener
+}
+
+func (hl *httpListener) Accept() (net.Conn, error) {
+	conn, err := hl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{Conn: conn.(*Conn)}, nil
+}
+
+// HTTPDialContext returns a function for http.Transport.DialContext that dials secure connections
+// with opts, which may be nil
+func HTTPDialContext(opts *Options) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &Dialer{Options: opts}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &streamConn{Conn: conn}, nil
+	}
+}
+
+// streamConn is a Conn that's a byte stream: a message that doesn't fit in Read's p is kept for the
+// next Read instead of being dropped
+type streamConn struct {
+	*Conn
+	pending []byte
+}
+
+func (sc *streamConn) Read(p []byte) (int, error) {
+	if len(sc.pending) == 0 {
+		msg, err := sc.Conn.ReadMsg()
+		if err != nil {
+			return 0, err
+		}
+		sc.pending = msg.Data
+	}
+	n := copy(p, sc.pending)
+	sc.pending = sc.pending[n:]
+	return n, nil
+}
+
+// WriteTo writes what's pending before handing over to Conn.WriteTo
+func (sc *streamConn) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(sc.pending)
+	sc.pending = sc.pending[n:]
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := sc.Conn.WriteTo(w)
+	return int64(n) + m, err
+}
@@ -0,0 +1,65 @@
+package snacl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bigger than a message, so bodies are split across messages and read in small pieces
+	body := bytes.Repeat([]byte("secure http "), 20000)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		w.Write(got)
+	})}
+	go srv.Serve(NewHTTPListener(l, nil))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: HTTPDialContext(nil)}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post("http://"+l.Addr().String()+"/echo", "text/plain", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Fatalf("Unexpected result. Got %d bytes, expected %d.", len(got), len(body))
+		}
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	// The server accepts but never does its handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d := &Dialer{}
+	if _, err := d.DialContext(ctx, "tcp", l.Addr().String()); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
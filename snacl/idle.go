@@ -0,0 +1,68 @@
+package snacl
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is returned once a Conn has been closed for being idle, see Options.IdleTimeout
+var ErrIdleTimeout = errors.New("connection idle timeout")
+
+// idleTimer closes a Conn that hasn't sent or received a frame for its timeout. Frames only store the
+// time, the timer checks it when it fires and goes back to sleep for whatever is left.
+type idleTimer struct {
+	timeout time.Duration
+	// closeConn closes the underlying stream once the timeout has passed
+	closeConn func() error
+
+	// lastActive is when the last frame was sent or received, in Unix nanoseconds
+	lastActive atomic.Int64
+	// expired is set once the Conn has been closed for being idle
+	expired atomic.Bool
+
+	// mu guards timer, which can fire before time.AfterFunc has returned it
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func newIdleTimer(timeout time.Duration, closeConn func() error) *idleTimer {
+	t := &idleTimer{timeout: timeout, closeConn: closeConn}
+	t.active()
+	t.mu.Lock()
+	t.timer = time.AfterFunc(timeout, t.check)
+	t.mu.Unlock()
+	return t
+}
+
+// active notes that a frame was sent or received
+func (t *idleTimer) active() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// check closes the Conn if it's been idle for the timeout, and otherwise sleeps until it could be
+func (t *idleTimer) check() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+
+	idle := time.Since(time.Unix(0, t.lastActive.Load()))
+	if idle < t.timeout {
+		t.timer.Reset(t.timeout - idle)
+		return
+	}
+	t.expired.Store(true)
+	t.closeConn()
+}
+
+// stop stops the timer, it's called when the Conn is closed
+func (t *idleTimer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	t.timer.Stop()
+}
@@ -0,0 +1,42 @@
+package snacl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	client, server := pipeOptions(t, nil, &Options{IdleTimeout: 50 * time.Millisecond})
+
+	// Traffic keeps the connection open past the timeout
+	go func() {
+		for {
+			if _, err := server.ReadMsg(); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Then the server hangs up
+	time.Sleep(100 * time.Millisecond)
+	if _, err := server.Write([]byte("x")); err != ErrIdleTimeout {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestIdleTimeoutHandshake(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	server := Server(c2, &Options{IdleTimeout: 20 * time.Millisecond})
+	// The client never says anything
+	if err := server.Handshake(); err != ErrIdleTimeout {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package snacl
+
+import "io"
+
+// Interceptor sees the plaintext of every message going through an InterceptedConn, for metrics,
+// content filters or DLP scanning. An interceptor can replace a message by returning a different
+// slice, or fail the Read or Write by returning an error.
+type Interceptor interface {
+	// Outgoing is called with every message before it's sealed
+	Outgoing(msg []byte) ([]byte, error)
+	// Incoming is called with every message after it's opened
+	Incoming(msg []byte) ([]byte, error)
+}
+
+// InterceptorFuncs is an Interceptor made of two functions, either may be nil to pass messages through
+type InterceptorFuncs struct {
+	OutgoingFunc func(msg []byte) ([]byte, error)
+	IncomingFunc func(msg []byte) ([]byte, error)
+}
+
+// Outgoing calls OutgoingFunc
+func (f InterceptorFuncs) Outgoing(msg []byte) ([]byte, error) {
+	if f.OutgoingFunc == nil {
+		return msg, nil
+	}
+	return f.OutgoingFunc(msg)
+}
+
+// Incoming calls IncomingFunc
+func (f InterceptorFuncs) Incoming(msg []byte) ([]byte, error) {
+	if f.IncomingFunc == nil {
+		return msg, nil
+	}
+	return f.IncomingFunc(msg)
+}
+
+// InterceptedConn is a Conn with interceptors in front of it, see WrapConn
+type InterceptedConn struct {
+	conn         *Conn
+	interceptors []Interceptor
+}
+
+// WrapConn returns conn with interceptors applied to every message. Outgoing messages go through the
+// interceptors in order, incoming messages in reverse order, so wrapping both ends of a connection with
+// the same interceptors is symmetric.
+func WrapConn(conn *Conn, interceptors ...Interceptor) *InterceptedConn {
+	return &InterceptedConn{conn: conn, interceptors: interceptors}
+}
+
+// Conn returns the wrapped Conn
+func (ic *InterceptedConn) Conn() *Conn {
+	return ic.conn
+}
+
+// ReadMsg reads a message and passes it through the interceptors
+func (ic *InterceptedConn) ReadMsg() (*Message, error) {
+	msg, err := ic.conn.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(ic.interceptors) - 1; i >= 0; i-- {
+		msg.Data, err = ic.interceptors[i].Incoming(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Read reads a message, passes it through the interceptors and writes it to p. Like Conn.Read,
+// Read writes as much as it can to p and discards the rest of the message.
+func (ic *InterceptedConn) Read(p []byte) (n int, err error) {
+	msg, err := ic.ReadMsg()
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, msg.Data), nil
+}
+
+// Write passes p through the interceptors and sends the result. On success it returns len(p), even if
+// an interceptor changed the length of the message.
+func (ic *InterceptedConn) Write(p []byte) (n int, err error) {
+	msg := p
+	for _, i := range ic.interceptors {
+		msg, err = i.Outgoing(msg)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = ic.conn.Write(msg)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the wrapped Conn
+func (ic *InterceptedConn) Close() error {
+	return ic.conn.Close()
+}
+
+var _ io.ReadWriteCloser = (*InterceptedConn)(nil)
@@ -0,0 +1,70 @@
+package snacl
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestWrapConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	var sent, received int
+	counter := InterceptorFuncs{
+		OutgoingFunc: func(msg []byte) ([]byte, error) {
+			sent += len(msg)
+			return msg, nil
+		},
+		IncomingFunc: func(msg []byte) ([]byte, error) {
+			received += len(msg)
+			return msg, nil
+		},
+	}
+	errBlocked := errors.New("blocked")
+	filter := InterceptorFuncs{
+		OutgoingFunc: func(msg []byte) ([]byte, error) {
+			if bytes.Contains(msg, []byte("secret")) {
+				return nil, errBlocked
+			}
+			return bytes.ToUpper(msg), nil
+		},
+		IncomingFunc: func(msg []byte) ([]byte, error) {
+			return bytes.ToLower(msg), nil
+		},
+	}
+
+	client := WrapConn(Client(c1, nil), counter, filter)
+	server := WrapConn(Server(c2, nil), counter, filter)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			return
+		}
+		server.Write(msg.Data)
+	}()
+
+	if _, err := client.Write([]byte("secret")); err != errBlocked {
+		t.Fatalf("Unexpected error: %v != %v", err, errBlocked)
+	}
+
+	expected := "hello world\n"
+	if _, err := client.Write([]byte(expected)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(msg.Data); got != expected {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", got, expected)
+	}
+
+	// The counter runs before the filter, so it saw the blocked message go out as well.
+	// Apart from that both ends counted the plaintext once on the way out and once on the way in.
+	if sent != len("secret")+2*len(expected) || received != 2*len(expected) {
+		t.Fatalf("Unexpected counts: sent:%d received:%d", sent, received)
+	}
+}
@@ -0,0 +1,121 @@
+package snacl
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// When both sides set Options.KeepaliveInterval, a side that hasn't heard anything for an interval
+// sends a recordPing, and the other side answers with a recordPong. Neither has any data. If nothing at
+// all arrives for Options.KeepaliveTimeout the other side is taken to be gone, a half-open TCP
+// connection or a NAT mapping that timed out, and the Conn is closed with ErrPeerUnresponsive.
+//
+// Pongs are only seen when something is reading the Conn, like every other record, so a Conn that's
+// kept alive needs a Read waiting most of the time, or flow control, which always reads.
+
+// ErrPeerUnresponsive is returned once the other side hasn't sent anything for Options.KeepaliveTimeout
+var ErrPeerUnresponsive = errors.New("peer unresponsive")
+
+// keepalive sends a Conn's pings and pongs and watches for the other side going quiet
+type keepalive struct {
+	enc      *encoder
+	interval time.Duration
+	timeout  time.Duration
+	// closeConn closes the underlying stream once the other side is unresponsive
+	closeConn func() error
+
+	// lastRead is when the last frame arrived, in Unix nanoseconds
+	lastRead atomic.Int64
+	// pongDue is signalled when a ping arrives, the pong is sent from the keepalive goroutine so the
+	// read never waits for a write
+	pongDue chan struct{}
+	// unresponsive is set once the timeout has passed
+	unresponsive atomic.Bool
+	// sending is set while a ping or pong is being written
+	sending atomic.Bool
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newKeepalive(enc *encoder, interval, timeout time.Duration, closeConn func() error) *keepalive {
+	if timeout <= 0 {
+		timeout = 3 * interval
+	}
+	k := &keepalive{
+		enc:       enc,
+		interval:  interval,
+		timeout:   timeout,
+		closeConn: closeConn,
+		pongDue:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	k.read()
+	return k
+}
+
+// read notes that a frame arrived
+func (k *keepalive) read() {
+	k.lastRead.Store(time.Now().UnixNano())
+}
+
+// pinged handles a ping from the other side
+func (k *keepalive) pinged() {
+	select {
+	case k.pongDue <- struct{}{}:
+	default:
+		// A pong is already on its way
+	}
+}
+
+// run sends pings and pongs until stop is called or the other side is unresponsive
+func (k *keepalive) run() {
+	// Checking a few times an interval keeps the timeout reasonably accurate
+	ticker := time.NewTicker(max(min(k.interval, k.timeout)/4, time.Millisecond))
+	defer ticker.Stop()
+
+	var lastPing time.Time
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-k.pongDue:
+			k.send(recordPong)
+		case now := <-ticker.C:
+			quiet := now.Sub(time.Unix(0, k.lastRead.Load()))
+			if quiet >= k.timeout {
+				k.unresponsive.Store(true)
+				k.closeConn()
+				return
+			}
+			if quiet >= k.interval && now.Sub(lastPing) >= k.interval {
+				k.send(recordPing)
+				lastPing = now
+			}
+		}
+	}
+}
+
+// send sends a ping or pong in its own goroutine, so a write stuck on a dead connection can't stop the
+// timeout. Nothing is sent while a write is still stuck, and a failed write is left for the timeout to
+// notice. Either record tells the other side we're alive, so a pong skipped for a ping does no harm.
+func (k *keepalive) send(typ byte) {
+	if !k.sending.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer k.sending.Store(false)
+		k.enc.mu.Lock()
+		defer k.enc.mu.Unlock()
+		k.enc.writeRecord(typ, nil, nil)
+	}()
+}
+
+// stop stops the keepalive goroutine
+func (k *keepalive) stop() {
+	k.stopOnce.Do(func() {
+		close(k.done)
+	})
+}
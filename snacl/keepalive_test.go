@@ -0,0 +1,74 @@
+package snacl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	opts := &Options{KeepaliveInterval: 10 * time.Millisecond, KeepaliveTimeout: 100 * time.Millisecond}
+	client, server := pipe(t, opts)
+	if !client.ConnectionState().Keepalive {
+		t.Fatal("Unexpected result. Keepalives weren't negotiated.")
+	}
+
+	// Both sides read, so the pings get answered while nothing else is sent
+	clientErr := make(chan error, 1)
+	go func() {
+		_, err := client.ReadMsg()
+		clientErr <- err
+	}()
+	serverMsg := make(chan string, 1)
+	go func() {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			serverMsg <- err.Error()
+			return
+		}
+		serverMsg <- string(msg.Data)
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case err := <-clientErr:
+		t.Fatalf("Unexpected error: %v", err)
+	default:
+	}
+	if _, err := client.Write([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-serverMsg; msg != "still here" {
+		t.Fatalf("Unexpected result: %s", msg)
+	}
+}
+
+func TestKeepaliveUnresponsive(t *testing.T) {
+	opts := &Options{KeepaliveInterval: 10 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond}
+	client, server := pipe(t, opts)
+	// The server goes silent: it stops pinging and never reads, so the client's pings go unanswered
+	server.keepalive.stop()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.ReadMsg()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrPeerUnresponsive {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unexpected result. The silent peer wasn't noticed.")
+	}
+	if _, err := client.Write([]byte("x")); err != ErrPeerUnresponsive {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestKeepaliveNotNegotiated(t *testing.T) {
+	client, _ := pipeOptions(t, &Options{KeepaliveInterval: time.Second}, nil)
+	if client.ConnectionState().Keepalive || client.keepalive != nil {
+		t.Fatal("Unexpected result. Keepalives were negotiated with one side.")
+	}
+}
@@ -0,0 +1,33 @@
+package snacl
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Keys is a Curve25519 key pair as used by box
+type Keys struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// GenerateKeys generates a new key pair using rand, rand is expected to be a cryptographically
+// secure source such as crypto/rand.Reader
+func GenerateKeys(rand io.Reader) (*Keys, error) {
+	pub, priv, err := box.GenerateKey(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keys{Public: *pub, Private: *priv}, nil
+}
+
+// Fingerprint returns a short form of a public key for people to compare, in the same format as SSH
+// uses: SHA256: and the unpadded base64 of the key's SHA-256 hash
+func Fingerprint(pub [32]byte) string {
+	sum := sha256.Sum256(pub[:])
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,86 @@
+package snacl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The Version1 key schedule. Everything is derived with HKDF-SHA256 from the box's shared key, salted
+// with a hash of the handshake transcript, so the keys are tied to everything both sides sent.
+// In hybrid mode the ML-KEM shared keys are appended to the box's, see Options.PostQuantum.
+//
+// The finished messages cover the transcript and the version and cipher suite each side settled on,
+// see negotiated. An attacker who strips versions or suites from a hello to push both sides down to the
+// weakest mode they share changes what they settle on, and the handshake fails instead.
+
+// finishedLength is the size of a finished message, an HMAC-SHA256
+const finishedLength = sha256.Size
+
+// keySchedule holds the keys derived from a Version1 handshake
+type keySchedule struct {
+	// clientKey seals client to server records and serverKey seals server to client records.
+	// Separate keys mean a frame reflected back to its sender doesn't open.
+	clientKey, serverKey [32]byte
+	// clientFinished and serverFinished key each side's finished message
+	clientFinished, serverFinished [32]byte
+}
+
+// deriveKeys derives the key schedule from the shared secret and the handshake transcript
+func deriveKeys(secret, transcript []byte) (*keySchedule, error) {
+	salt := sha256.Sum256(transcript)
+	prk := hkdf.Extract(sha256.New, secret, salt[:])
+	defer clear(prk)
+
+	ks := &keySchedule{}
+	for _, k := range []struct {
+		key   *[32]byte
+		label string
+	}{
+		{&ks.clientKey, "snacl client to server"},
+		{&ks.serverKey, "snacl server to client"},
+		{&ks.clientFinished, "snacl client finished"},
+		{&ks.serverFinished, "snacl server finished"},
+	} {
+		_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(k.label)), k.key[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// zero zeroes the keys once the handshake is done with them, see wipe.go
+func (ks *keySchedule) zero() {
+	clear(ks.clientKey[:])
+	clear(ks.serverKey[:])
+	clear(ks.clientFinished[:])
+	clear(ks.serverFinished[:])
+}
+
+// finishedMAC returns the finished message for a side, an HMAC of the transcript and what was
+// negotiated with its finished key
+func finishedMAC(key *[32]byte, transcript, negotiated []byte) []byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write(transcript)
+	mac.Write(negotiated)
+	return mac.Sum(nil)
+}
+
+// negotiated returns what a side settled on in the handshake for its finished message:
+//
+//	[version uint8][cipher suite ID uint16be]
+func negotiated(state *ConnectionState) []byte {
+	return binary.BigEndian.AppendUint16([]byte{byte(state.Version)}, state.CipherSuite.ID())
+}
+
+// nextKey replaces key with the next key in the chain. Each direction has its own chain, so updating
+// the sending key doesn't touch the receiving key.
+func nextKey(key *[32]byte) error {
+	next := hkdf.Expand(sha256.New, key[:], []byte("snacl key update"))
+	_, err := io.ReadFull(next, key[:])
+	return err
+}
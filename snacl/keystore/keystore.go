@@ -0,0 +1,137 @@
+// Package keystore loads private keys from where operators keep them: environment variables, files,
+// or HashiCorp Vault, either as a KV secret or wrapped by a transit key. A private key is always stored
+// as its 32 bytes in base64, any 32 random bytes will do:
+//
+//	head -c 32 /dev/urandom | base64
+//
+// Load takes a spec that says where the key is, which is what the command line and config files use:
+//
+//	env:SNACL_KEY                     the environment variable SNACL_KEY
+//	file:/etc/snacl/server.key        a file, or just /etc/snacl/server.key
+//	vault:secret/snacl/server         the private_key field of a Vault KV version 2 secret
+//	vault-transit:snacl:server.wrap   a file holding the key encrypted by the Vault transit key snacl
+//
+// Vault is reached at VAULT_ADDR with VAULT_TOKEN, like the vault command line.
+package keystore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// KeyStore loads key pairs by name, what a name is depends on the store
+type KeyStore interface {
+	Load(ctx context.Context, name string) (*snacl.Keys, error)
+}
+
+// ErrNotFound is returned when there's no key by that name
+var ErrNotFound = errors.New("keystore: key not found")
+
+// ParsePrivateKey returns the key pair for a base64 private key, surrounding whitespace is ignored
+func ParsePrivateKey(s string) (*snacl.Keys, error) {
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("keystore: private key isn't base64: %w", err)
+	}
+	defer clear(priv)
+	if len(priv) != 32 {
+		return nil, fmt.Errorf("keystore: a private key is 32 bytes, got %d", len(priv))
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	keys := &snacl.Keys{}
+	copy(keys.Private[:], priv)
+	copy(keys.Public[:], pub)
+	return keys, nil
+}
+
+// Env loads keys from environment variables, the name is the variable
+type Env struct{}
+
+// Load returns the key pair in the environment variable name
+func (Env) Load(ctx context.Context, name string) (*snacl.Keys, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s isn't set", ErrNotFound, name)
+	}
+	return ParsePrivateKey(value)
+}
+
+// Files loads keys from files, the name is the file's path relative to Dir
+type Files struct {
+	// Dir is where relative names are, the working directory if empty
+	Dir string
+}
+
+// Load returns the key pair in the file name
+func (f Files) Load(ctx context.Context, name string) (*snacl.Keys, error) {
+	data, err := f.read(name)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+	keys, err := ParsePrivateKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return keys, nil
+}
+
+func (f Files) read(name string) ([]byte, error) {
+	path := name
+	if f.Dir != "" && !strings.HasPrefix(name, "/") {
+		path = f.Dir + "/" + name
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return data, err
+}
+
+// Open returns the store and the name in it for a spec, see the package documentation
+func Open(spec string) (KeyStore, string, error) {
+	scheme, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Files{}, spec, nil
+	}
+	switch scheme {
+	case "env":
+		return Env{}, rest, nil
+	case "file":
+		return Files{}, rest, nil
+	case "vault":
+		mount, name, ok := strings.Cut(rest, "/")
+		if !ok {
+			return nil, "", fmt.Errorf("keystore: %q isn't vault:<mount>/<path>", spec)
+		}
+		return &VaultKV{Mount: mount}, name, nil
+	case "vault-transit":
+		key, path, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, "", fmt.Errorf("keystore: %q isn't vault-transit:<key>:<file>", spec)
+		}
+		return &VaultTransit{Key: key}, path, nil
+	default:
+		return nil, "", fmt.Errorf("keystore: unknown key store %q", scheme)
+	}
+}
+
+// Load loads the key pair spec points at, see the package documentation
+func Load(ctx context.Context, spec string) (*snacl.Keys, error) {
+	store, name, err := Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	return store.Load(ctx, name)
+}
@@ -0,0 +1,127 @@
+package keystore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func mustGenerateKeys(t *testing.T) (*snacl.Keys, string) {
+	keys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, base64.StdEncoding.EncodeToString(keys.Private[:])
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	keys, encoded := mustGenerateKeys(t)
+	parsed, err := ParsePrivateKey(" " + encoded + "\n")
+	if err != nil || *parsed != *keys {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParsePrivateKey(bad); err == nil {
+			t.Fatalf("Unexpected result. Parsed %q.", bad)
+		}
+	}
+}
+
+func TestEnvAndFiles(t *testing.T) {
+	keys, encoded := mustGenerateKeys(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "server.key"), []byte(encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SNACL_TEST_KEY", encoded)
+
+	ctx := context.Background()
+	for _, spec := range []string{"env:SNACL_TEST_KEY", "file:" + filepath.Join(dir, "server.key"), filepath.Join(dir, "server.key")} {
+		loaded, err := Load(ctx, spec)
+		if err != nil || *loaded != *keys {
+			t.Fatalf("Unexpected result for %s: %v", spec, err)
+		}
+	}
+	if loaded, err := (Files{Dir: dir}).Load(ctx, "server.key"); err != nil || *loaded != *keys {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	for _, spec := range []string{"env:SNACL_TEST_MISSING", filepath.Join(dir, "missing.key")} {
+		if _, err := Load(ctx, spec); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Unexpected result for %s: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"kms:key", "vault:nomount", "vault-transit:nofile"} {
+		if _, err := Load(ctx, spec); err == nil {
+			t.Fatalf("Unexpected result. Loaded %s.", spec)
+		}
+	}
+}
+
+// fakeVault answers KV version 2 reads of secret/snacl/server and transit decrypts with the key snacl,
+// whose ciphertexts are "vault:v1:" followed by the plaintext
+func fakeVault(t *testing.T, encoded string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/snacl/server":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": map[string]string{"private_key": encoded}}})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/decrypt/snacl":
+			var req struct {
+				Ciphertext string `json:"ciphertext"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Ciphertext) < 9 || req.Ciphertext[:9] != "vault:v1:" {
+				http.Error(w, "bad ciphertext", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": req.Ciphertext[9:]}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVault(t *testing.T) {
+	keys, encoded := mustGenerateKeys(t)
+	srv := fakeVault(t, encoded)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+	wrapped := filepath.Join(t.TempDir(), "server.wrapped")
+	if err := os.WriteFile(wrapped, []byte("vault:v1:"+encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, spec := range []string{"vault:secret/snacl/server", "vault-transit:snacl:" + wrapped} {
+		loaded, err := Load(ctx, spec)
+		if err != nil || *loaded != *keys {
+			t.Fatalf("Unexpected result for %s: %v", spec, err)
+		}
+	}
+	if _, err := Load(ctx, "vault:secret/snacl/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	kv := &VaultKV{Vault: Vault{Addr: srv.URL, Token: "wrong"}}
+	if _, err := kv.Load(ctx, "snacl/server"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := Load(ctx, "vault:secret/snacl/server"); err == nil {
+		t.Fatal("Unexpected result. Loaded from Vault without a token.")
+	}
+}
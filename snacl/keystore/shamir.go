@@ -0,0 +1,154 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// A share is one piece of a private key split with SplitKey:
+//
+//	[k uint8][x uint8][public key prefix 8][y 32]
+//
+// Each byte of the private key is the constant term of its own random polynomial of degree k-1 over
+// GF(2^8), and the share holds the 32 polynomials' values at x, like Shamir's original scheme. Any k
+// shares give the key back and fewer say nothing about it. The start of the public key is there so
+// CombineKey can tell shares of different keys apart, and check the key it puts together; it's public
+// anyway.
+const (
+	shareHeader = 1 + 1 + 8
+	shareLength = shareHeader + 32
+)
+
+// ErrBadShares is returned by CombineKey when the shares don't make a key
+var ErrBadShares = errors.New("keystore: bad key shares")
+
+// SplitKey splits priv into n shares, any k of which give it back with CombineKey. k is at least 2, a
+// single share would be a copy of the key, and n is at least k and at most 255.
+func SplitKey(priv *[32]byte, n, k int) ([][]byte, error) {
+	if k < 2 || n < k || n > 255 {
+		return nil, fmt.Errorf("keystore: can't split a key into %d shares needing %d", n, k)
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// coefficients[i] are the k-1 random coefficients of byte i's polynomial
+	coefficients := make([]byte, 32*(k-1))
+	defer clear(coefficients)
+	if _, err := rand.Read(coefficients); err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, n)
+	for s := range shares {
+		x := byte(s + 1)
+		share := make([]byte, shareLength)
+		share[0], share[1] = byte(k), x
+		copy(share[2:shareHeader], pub)
+		for i := range 32 {
+			// Horner's rule, from the highest coefficient down to the key's byte
+			var y byte
+			for j := k - 2; j >= 0; j-- {
+				y = gfMul(y, x) ^ coefficients[i*(k-1)+j]
+			}
+			share[shareHeader+i] = gfMul(y, x) ^ priv[i]
+		}
+		shares[s] = share
+	}
+	return shares, nil
+}
+
+// CombineKey puts a key pair back together from shares made by SplitKey. It needs at least as many
+// shares as the key was split to need, and uses the first that many.
+func CombineKey(shares [][]byte) (*snacl.Keys, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%w: no shares", ErrBadShares)
+	}
+	for _, share := range shares {
+		if len(share) != shareLength {
+			return nil, fmt.Errorf("%w: a share is %d bytes, got %d", ErrBadShares, shareLength, len(share))
+		}
+		if share[0] != shares[0][0] || subtle.ConstantTimeCompare(share[2:shareHeader], shares[0][2:shareHeader]) != 1 {
+			return nil, fmt.Errorf("%w: the shares are from different splits", ErrBadShares)
+		}
+	}
+	k := int(shares[0][0])
+	if k < 2 || len(shares) < k {
+		return nil, fmt.Errorf("%w: %d shares are needed, got %d", ErrBadShares, k, len(shares))
+	}
+	shares = shares[:k]
+	xs := make([]byte, k)
+	for i, share := range shares {
+		xs[i] = share[1]
+		if xs[i] == 0 {
+			// The value at 0 is the key itself, SplitKey never makes that share
+			return nil, fmt.Errorf("%w: a share has x 0", ErrBadShares)
+		}
+		for _, x := range xs[:i] {
+			if x == xs[i] {
+				return nil, fmt.Errorf("%w: the same share was given twice", ErrBadShares)
+			}
+		}
+	}
+
+	// Lagrange interpolation at 0: each share's y is weighted by the product of x_j / (x_j - x_i) over
+	// the other shares, and subtraction is xor in GF(2^8)
+	weights := make([]byte, k)
+	for i := range shares {
+		num, den := byte(1), byte(1)
+		for j := range shares {
+			if j != i {
+				num = gfMul(num, xs[j])
+				den = gfMul(den, xs[j]^xs[i])
+			}
+		}
+		weights[i] = gfMul(num, gfInverse(den))
+	}
+	keys := &snacl.Keys{}
+	for b := range 32 {
+		var secret byte
+		for i, share := range shares {
+			secret ^= gfMul(weights[i], share[shareHeader+b])
+		}
+		keys.Private[b] = secret
+	}
+
+	pub, err := curve25519.X25519(keys.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(keys.Public[:], pub)
+	if subtle.ConstantTimeCompare(keys.Public[:8], shares[0][2:shareHeader]) != 1 {
+		clear(keys.Private[:])
+		return nil, fmt.Errorf("%w: the shares don't make the key they were split from", ErrBadShares)
+	}
+	return keys, nil
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branching on secrets
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInverse returns the multiplicative inverse of a, a^254, 0 for 0
+func gfInverse(a byte) byte {
+	result := byte(1)
+	for range 7 {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
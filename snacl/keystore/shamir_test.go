@@ -0,0 +1,78 @@
+package keystore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitKey(t *testing.T) {
+	keys, _ := mustGenerateKeys(t)
+	shares, err := SplitKey(&keys.Private, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Unexpected result: %d shares", len(shares))
+	}
+
+	// Any 3 of the 5 give the key back
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				combined, err := CombineKey([][]byte{shares[c], shares[a], shares[b]})
+				if err != nil || *combined != *keys {
+					t.Fatalf("Unexpected result for shares %d, %d and %d: %v", a, b, c, err)
+				}
+			}
+		}
+	}
+	if combined, err := CombineKey(shares); err != nil || *combined != *keys {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
+
+func TestCombineKeyErrors(t *testing.T) {
+	keys, _ := mustGenerateKeys(t)
+	other, _ := mustGenerateKeys(t)
+	shares, err := SplitKey(&keys.Private, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherShares, err := SplitKey(&other.Private, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte(nil), shares[1]...)
+	corrupted[len(corrupted)-1] ^= 1
+
+	for _, bad := range [][][]byte{
+		nil,
+		{shares[0]},
+		{shares[0], shares[0]},
+		{shares[0], otherShares[1]},
+		{shares[0], corrupted},
+		{shares[0], shares[1][:10]},
+	} {
+		if _, err := CombineKey(bad); !errors.Is(err, ErrBadShares) {
+			t.Fatalf("Unexpected result: %v", err)
+		}
+	}
+
+	for _, split := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := SplitKey(&keys.Private, split[0], split[1]); err == nil {
+			t.Fatalf("Unexpected result. Split into %d shares needing %d.", split[0], split[1])
+		}
+	}
+}
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInverse(byte(a))) != 1 {
+			t.Fatalf("Unexpected result: %d has no inverse", a)
+		}
+	}
+	// The AES polynomial's example from FIPS 197
+	if gfMul(0x57, 0x83) != 0xc1 {
+		t.Fatalf("Unexpected result: %x", gfMul(0x57, 0x83))
+	}
+}
@@ -0,0 +1,157 @@
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Vault is how to reach a Vault server
+type Vault struct {
+	// Addr is the server's URL, VAULT_ADDR if empty
+	Addr string
+	// Token authenticates us, VAULT_TOKEN if empty
+	Token string
+	// Client makes the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// maxVaultResponse bounds the responses we read
+const maxVaultResponse = 1 << 20
+
+// do sends a request for path under /v1/ with body encoded as JSON if it isn't nil, and decodes the
+// response's data into data
+func (v *Vault) do(ctx context.Context, method, path string, body, data any) error {
+	addr := v.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	token := v.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if addr == "" || token == "" {
+		return fmt.Errorf("keystore: Vault needs an address and a token, set VAULT_ADDR and VAULT_TOKEN")
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(addr, "/")+"/v1/"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keystore: Vault %s %s: %s", method, path, resp.Status)
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxVaultResponse)).Decode(&envelope); err != nil {
+		return fmt.Errorf("keystore: Vault %s %s: %w", method, path, err)
+	}
+	return json.Unmarshal(envelope.Data, data)
+}
+
+// VaultKV loads keys from the private_key field of secrets in a KV version 2 secrets engine, the name
+// is the secret's path
+type VaultKV struct {
+	Vault
+	// Mount is where the secrets engine is mounted, "secret" if empty
+	Mount string
+}
+
+// Load returns the key pair in the secret at name
+func (v *VaultKV) Load(ctx context.Context, name string) (*snacl.Keys, error) {
+	mount := v.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	var secret struct {
+		Data struct {
+			PrivateKey string `json:"private_key"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, mount+"/data/"+escapePath(name), nil, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data.PrivateKey == "" {
+		return nil, fmt.Errorf("%w: the secret %s has no private_key", ErrNotFound, name)
+	}
+	return ParsePrivateKey(secret.Data.PrivateKey)
+}
+
+// VaultTransit loads keys from files holding them encrypted by a transit key, so the files are no use
+// without Vault. The name is the file, as for Files. A key is wrapped with
+//
+//	vault write -field=ciphertext transit/encrypt/<key> plaintext=$(cat server.key) > server.wrap
+//
+// since transit takes base64 plaintext, which a private key already is.
+type VaultTransit struct {
+	Vault
+	// Mount is where the transit secrets engine is mounted, "transit" if empty
+	Mount string
+	// Key is the transit key the files are encrypted with
+	Key string
+	// Files reads the encrypted keys
+	Files Files
+}
+
+// Load decrypts the key pair in the file name
+func (v *VaultTransit) Load(ctx context.Context, name string) (*snacl.Keys, error) {
+	ciphertext, err := v.Files.read(name)
+	if err != nil {
+		return nil, err
+	}
+	mount := v.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	var decrypted struct {
+		Plaintext string `json:"plaintext"`
+	}
+	body := map[string]string{"ciphertext": strings.TrimSpace(string(ciphertext))}
+	if err := v.do(ctx, http.MethodPost, mount+"/decrypt/"+url.PathEscape(v.Key), body, &decrypted); err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(decrypted.Plaintext)
+}
+
+// escapePath escapes each element of a secret's path
+func escapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
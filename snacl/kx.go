@@ -0,0 +1,58 @@
+package snacl
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// KeyExchanger is a key pair whose private half may be somewhere else, such as a PKCS#11 token, a
+// cloud KMS or the key agent (see the agent package), so the raw private key never has to be in this
+// process. The handshakes only need X25519 with the static key, everything else is derived from its
+// result. *Keys is a KeyExchanger for a key pair in memory.
+//
+// Implementations are called from every handshake, concurrently. An error fails the handshake.
+type KeyExchanger interface {
+	// PublicKey returns the public key
+	PublicKey() [32]byte
+	// X25519 returns the X25519 shared secret between the private key and peer. It's an error if it's
+	// all zeros, peer was a low-order point.
+	X25519(peer *[32]byte) ([32]byte, error)
+}
+
+// PublicKey returns k.Public
+func (k *Keys) PublicKey() [32]byte {
+	return k.Public
+}
+
+// X25519 returns the X25519 shared secret between k.Private and peer
+func (k *Keys) X25519(peer *[32]byte) ([32]byte, error) {
+	var shared [32]byte
+	out, err := curve25519.X25519(k.Private[:], peer[:])
+	if err != nil {
+		return shared, err
+	}
+	copy(shared[:], out)
+	return shared, nil
+}
+
+// boxSharedKey returns the key box.Precompute would for kx's private key and peer, the HSalsa20 of
+// their X25519 shared secret
+func boxSharedKey(kx KeyExchanger, peer *[32]byte) ([32]byte, error) {
+	var key [32]byte
+	// box.Precompute itself doesn't reject low-order points, and Version0 peers have always been
+	// allowed to send them
+	if keys, ok := kx.(*Keys); ok {
+		box.Precompute(&key, peer, &keys.Private)
+		return key, nil
+	}
+	shared, err := kx.X25519(peer)
+	if err != nil {
+		return key, fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+	var zeros [16]byte
+	salsa.HSalsa20(&key, &zeros, &shared, &salsa.Sigma)
+	return key, nil
+}
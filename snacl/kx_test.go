@@ -0,0 +1,59 @@
+package snacl
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// remoteKeys stands in for a key held somewhere else, it only does X25519 with it
+type remoteKeys struct {
+	keys  *Keys
+	calls int
+	err   error
+}
+
+func (r *remoteKeys) PublicKey() [32]byte {
+	return r.keys.Public
+}
+
+func (r *remoteKeys) X25519(peer *[32]byte) ([32]byte, error) {
+	r.calls++
+	if r.err != nil {
+		return [32]byte{}, r.err
+	}
+	return r.keys.X25519(peer)
+}
+
+func TestKeyExchanger(t *testing.T) {
+	for _, opts := range []Options{{}, {LegacyV0: true}, {Noise: true}} {
+		remote := &remoteKeys{keys: mustGenerateKeys(t)}
+		clientOpts := opts
+		clientOpts.KeyExchanger = remote
+		// KeyExchanger wins over Keys
+		clientOpts.Keys = mustGenerateKeys(t)
+
+		client, server := pipeOptions(t, &clientOpts, &opts)
+		if server.PeerPublicKey() != remote.keys.Public || client.LocalPublicKey() != remote.keys.Public || remote.calls == 0 {
+			t.Fatalf("Unexpected result: the key exchanger wasn't used (%d calls)", remote.calls)
+		}
+		go client.Write([]byte("hello"))
+		if msg, err := server.ReadMsg(); err != nil || string(msg.Data) != "hello" {
+			t.Fatalf("Unexpected result: %v", err)
+		}
+	}
+}
+
+func TestKeyExchangerError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go Server(c2, nil).Handshake()
+
+	unavailable := errors.New("token removed")
+	remote := &remoteKeys{keys: mustGenerateKeys(t), err: unavailable}
+	err := Client(c1, &Options{KeyExchanger: remote}).Handshake()
+	if !errors.Is(err, ErrHandshakeFailed) || !errors.Is(err, unavailable) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
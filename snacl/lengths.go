@@ -0,0 +1,50 @@
+package snacl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+// When both sides set Options.EncryptLengths, the length prefix of every frame is encrypted, so an
+// observer can't read the frame boundaries off the stream. Each direction has a length key derived
+// from its first traffic key, and the length of the nth frame is XORed with the ChaCha20 keystream for
+// that key with n as the nonce. Both sides count the frames, so no nonce is sent.
+//
+// The length isn't authenticated on its own: it decides exactly which bytes are opened as the box, so
+// a changed length makes the box fail to open the same as a changed box does.
+
+// lengthCipher encrypts or decrypts the length prefixes of one direction
+type lengthCipher struct {
+	key [32]byte
+	// seq is the number of the next frame
+	seq uint64
+}
+
+// newLengthCipher returns the lengthCipher for a direction whose first traffic key is trafficKey
+func newLengthCipher(trafficKey *[32]byte) (*lengthCipher, error) {
+	lc := &lengthCipher{}
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, trafficKey[:], []byte("snacl length key")), lc.key[:])
+	if err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+// apply encrypts or decrypts the length prefix of the next frame in place
+func (lc *lengthCipher) apply(header []byte) {
+	var nonce [chacha20.NonceSize]byte
+	binary.BigEndian.PutUint64(nonce[4:], lc.seq)
+	lc.seq++
+
+	stream, err := chacha20.NewUnauthenticatedCipher(lc.key[:], nonce[:])
+	if err != nil {
+		// The key and nonce sizes are fixed
+		panic(err)
+	}
+	stream.XORKeyStream(header[:wire.HeaderLength], header[:wire.HeaderLength])
+}
@@ -0,0 +1,71 @@
+package snacl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+func TestEncryptedLengths(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+	var buf bytes.Buffer
+	enc := newEncoder(&buf, key)
+	dec := newDecoder(&buf, key)
+	var err error
+	if enc.lengths, err = newLengthCipher(key); err != nil {
+		t.Fatal(err)
+	}
+	if dec.lengths, err = newLengthCipher(key); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(&Message{Data: []byte("hello")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frameLen := buf.Len() / 2
+	first, second := buf.Bytes()[:wire.HeaderLength], buf.Bytes()[frameLen:frameLen+wire.HeaderLength]
+	if binary.BigEndian.Uint32(first) == uint32(frameLen-wire.HeaderLength) {
+		t.Fatal("Unexpected result. The length is in the clear.")
+	}
+	// Every frame's length is encrypted differently
+	if bytes.Equal(first, second) {
+		t.Fatal("Unexpected result. Two frames of the same length have the same prefix.")
+	}
+
+	for i := 0; i < 2; i++ {
+		msg := &Message{}
+		if err := dec.Decode(msg); err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data) != "hello" {
+			t.Fatalf("Unexpected result: %q", msg.Data)
+		}
+	}
+}
+
+func TestConnEncryptedLengths(t *testing.T) {
+	client, server := pipe(t, &Options{EncryptLengths: true})
+	if !client.ConnectionState().EncryptedLengths {
+		t.Fatal("Unexpected result. Encrypted lengths weren't negotiated.")
+	}
+
+	expected := []byte("hello")
+	go func() {
+		client.Write(expected)
+		client.UpdateKey()
+		client.Write(expected)
+	}()
+	for i := 0; i < 2; i++ {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg.Data, expected) {
+			t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+		}
+	}
+}
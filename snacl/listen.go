@@ -0,0 +1,121 @@
+package snacl
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHandshakeTimeout is the default Options.HandshakeTimeout
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// Conn can be handed to code written for net.Conn
+var _ net.Conn = (*Conn)(nil)
+
+// Listen listens on the network address like net.Listen, and returns a net.Listener whose Accept
+// returns *Conn values that have already done the handshake, like tls.Listen. opts may be nil.
+func Listen(network, addr string, opts *Options) (net.Listener, error) {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewNetListener(l, opts), nil
+}
+
+// NewNetListener is Listen for a listener that's already open. Unlike Listener, which leaves the
+// handshake to the first Read or Write, it's a drop-in net.Listener for servers built on one.
+//
+// Every connection does its handshake in its own goroutine within Options.HandshakeTimeout, so a slow
+// client can't hold up Accept. Connections whose handshake fails are closed and never returned.
+func NewNetListener(l net.Listener, opts *Options) net.Listener {
+	nl := &netListener{
+		l:     NewListener(l, opts),
+		ready: make(chan *Conn),
+		errs:  make(chan error),
+		done:  make(chan struct{}),
+	}
+	nl.timeout = DefaultHandshakeTimeout
+	if opts != nil && opts.HandshakeTimeout > 0 {
+		nl.timeout = opts.HandshakeTimeout
+	}
+	go nl.acceptLoop()
+	return nl
+}
+
+// netListener is the net.Listener from NewNetListener
+type netListener struct {
+	l       *Listener
+	timeout time.Duration
+
+	// ready has connections that have done the handshake, errs has errors from the underlying Accept
+	ready chan *Conn
+	errs  chan error
+	// done is closed by Close
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// acceptLoop accepts connections and starts their handshakes until the listener is closed. Errors are
+// handed to Accept, so the server decides what to do about them like it would with any net.Listener.
+func (nl *netListener) acceptLoop() {
+	for {
+		conn, err := nl.l.Accept()
+		if err != nil {
+			select {
+			case nl.errs <- err:
+			case <-nl.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go nl.handshake(conn)
+	}
+}
+
+// handshake does conn's handshake and hands it to Accept
+func (nl *netListener) handshake(conn *Conn) {
+	conn.SetDeadline(time.Now().Add(nl.timeout))
+	err := conn.Handshake()
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case nl.ready <- conn:
+	case <-nl.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for the next connection that's done its handshake and returns it as a *Conn
+func (nl *netListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-nl.ready:
+		return conn, nil
+	case err := <-nl.errs:
+		return nil, err
+	case <-nl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the underlying listener, connections that haven't been accepted yet are closed too
+func (nl *netListener) Close() error {
+	nl.closeOnce.Do(func() {
+		close(nl.done)
+	})
+	return nl.l.Close()
+}
+
+// Addr returns the address of the underlying listener
+func (nl *netListener) Addr() net.Addr {
+	return nl.l.Addr()
+}
@@ -0,0 +1,65 @@
+package snacl
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", &Options{HandshakeTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A client that never does its handshake doesn't hold up the next one
+	stuck, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stuck.Close()
+
+	go func() {
+		conn, err := Dial("tcp", l.Addr().String(), nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello"))
+		conn.ReadMsg()
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sc, ok := conn.(*Conn)
+	if !ok || !sc.ConnectionState().HandshakeComplete {
+		t.Fatal("Unexpected result. Accept didn't return a Conn that's done its handshake.")
+	}
+	if sc.RemoteAddr() == nil || sc.LocalAddr().String() != l.Addr().String() {
+		t.Fatalf("Unexpected addresses: %v %v", sc.RemoteAddr(), sc.LocalAddr())
+	}
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("Unexpected result: %q", buf[:n])
+	}
+
+	// The stuck client is hung up on once its handshake times out
+	stuck.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(stuck); err != nil {
+		t.Fatalf("Unexpected result. The stuck client wasn't hung up on: %v", err)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err != net.ErrClosed {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
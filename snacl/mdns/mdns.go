@@ -0,0 +1,304 @@
+// Package mdns finds servers on the local network with multicast DNS (RFC 6762) and DNS-SD
+// (RFC 6763), so demos and pairing devices don't need addresses typed in. A server advertises the
+// service _snacl._tcp.local with its port and the fingerprint of its public key:
+//
+//	_snacl._tcp.local.           PTR  <instance>._snacl._tcp.local.
+//	<instance>._snacl._tcp.local. SRV  0 0 <port> <instance>.local.
+//	<instance>._snacl._tcp.local. TXT  "v=1" "fp=SHA256:..."
+//
+// Browse asks with a one-shot query from its own port, so answers come straight back to it rather
+// than to the multicast group, and it doesn't need port 5353.
+//
+// Anyone on the network can answer, so a discovered fingerprint only says what the server claims.
+// Clients compare it with the key the server uses in the handshake, which catches an answer and a
+// server that don't agree, but not a lying network; pin keys for that.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// service is the DNS-SD service type
+const service = "_snacl._tcp.local."
+
+// ttl is how long answers can be cached, in seconds
+const ttl = 120
+
+// cacheFlush is the mDNS cache-flush bit, set on the records only one host has. The same bit in a
+// question asks for a unicast answer.
+const cacheFlush = 1 << 15
+
+// group is the mDNS multicast group
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// queryInterval is how often Browse repeats its query, answers can be lost like any UDP
+const queryInterval = time.Second
+
+// Service is what a server advertises
+type Service struct {
+	// Instance names the server, usually the host name. It has to be a single DNS label.
+	Instance string
+	// Port is the TCP port the server listens on
+	Port int
+	// PublicKey is the key the server uses, its fingerprint is advertised
+	PublicKey [32]byte
+}
+
+// Peer is a server found by Browse
+type Peer struct {
+	Instance string
+	// Addr is the host:port to dial, the address the answer came from and the advertised port
+	Addr string
+	// Fingerprint is the advertised fingerprint of the server's public key, see snacl.Fingerprint
+	Fingerprint string
+}
+
+// Advertiser answers queries for a Service until it's closed
+type Advertiser struct {
+	conn     *net.UDPConn
+	response []byte
+	done     sync.WaitGroup
+}
+
+// Advertise starts answering queries for svc on every multicast interface
+func Advertise(svc *Service) (*Advertiser, error) {
+	if svc.Instance == "" || strings.ContainsAny(svc.Instance, ".") {
+		return nil, fmt.Errorf("mdns: instance %q isn't a single DNS label", svc.Instance)
+	}
+	response, err := svc.response(0, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, err
+	}
+	a := &Advertiser{conn: conn, response: response}
+	a.done.Add(1)
+	go a.serve(svc)
+
+	// Announce ourselves, so browsers that are already waiting see us
+	conn.WriteToUDP(response, group)
+	return a, nil
+}
+
+// serve answers queries until the Advertiser is closed
+func (a *Advertiser) serve(svc *Service) {
+	defer a.done.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if query.Unpack(buf[:n]) != nil || query.Response || !asksFor(&query) {
+			continue
+		}
+		// A query from a port other than 5353 is a one-shot query, answered to the sender directly
+		// with its ID and question (RFC 6762 section 6.7)
+		if from.Port != group.Port {
+			response, err := svc.response(query.ID, query.Questions)
+			if err == nil {
+				a.conn.WriteToUDP(response, from)
+			}
+			continue
+		}
+		a.conn.WriteToUDP(a.response, group)
+	}
+}
+
+// asksFor returns true if query asks for our service
+func asksFor(query *dnsmessage.Message) bool {
+	for _, q := range query.Questions {
+		if strings.EqualFold(q.Name.String(), service) && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+			return true
+		}
+	}
+	return false
+}
+
+// response builds the answer to a query with id and questions
+func (svc *Service) response(id uint16, questions []dnsmessage.Question) ([]byte, error) {
+	serviceName, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(svc.Instance + "." + service)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(svc.Instance + ".local.")
+	if err != nil {
+		return nil, err
+	}
+
+	unique := dnsmessage.Class(dnsmessage.ClassINET | cacheFlush)
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, Response: true, Authoritative: true},
+		Questions: questions,
+		Answers: []dnsmessage.Resource{
+			{
+				Header: dnsmessage.ResourceHeader{Name: serviceName, Class: dnsmessage.ClassINET, TTL: ttl},
+				Body:   &dnsmessage.PTRResource{PTR: instance},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Name: instance, Class: unique, TTL: ttl},
+				Body:   &dnsmessage.SRVResource{Port: uint16(svc.Port), Target: host},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Name: instance, Class: unique, TTL: ttl},
+				Body:   &dnsmessage.TXTResource{TXT: []string{"v=1", "fp=" + snacl.Fingerprint(svc.PublicKey)}},
+			},
+		},
+	}
+	return msg.Pack()
+}
+
+// Close stops answering queries
+func (a *Advertiser) Close() error {
+	err := a.conn.Close()
+	a.done.Wait()
+	return err
+}
+
+// Browse queries the local network until ctx is done and returns the servers that answered, each
+// once
+func Browse(ctx context.Context) ([]Peer, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	query, err := browseQuery()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for ctx.Err() == nil {
+			conn.WriteToUDP(query, group)
+			select {
+			case <-ctx.Done():
+			case <-time.After(queryInterval):
+			}
+		}
+	}()
+
+	var peers []Peer
+	seen := make(map[string]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return peers, nil
+			}
+			return peers, err
+		}
+		for _, peer := range parsePeers(buf[:n], from.IP) {
+			if key := peer.Instance + " " + peer.Addr; !seen[key] {
+				seen[key] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+}
+
+// browseQuery is the query Browse sends
+func browseQuery() ([]byte, error) {
+	serviceName, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: serviceName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// parsePeers returns the servers in a response that came from ip
+func parsePeers(response []byte, ip net.IP) []Peer {
+	var msg dnsmessage.Message
+	if msg.Unpack(response) != nil || !msg.Response {
+		return nil
+	}
+	records := append(msg.Answers, msg.Additionals...)
+
+	var peers []Peer
+	for _, ptr := range records {
+		body, ok := ptr.Body.(*dnsmessage.PTRResource)
+		if !ok || !strings.EqualFold(ptr.Header.Name.String(), service) {
+			continue
+		}
+		instance := body.PTR.String()
+		peer := Peer{Instance: strings.TrimSuffix(instance, "."+service)}
+		port := -1
+		for _, r := range records {
+			if !strings.EqualFold(r.Header.Name.String(), instance) {
+				continue
+			}
+			switch body := r.Body.(type) {
+			case *dnsmessage.SRVResource:
+				port = int(body.Port)
+			case *dnsmessage.TXTResource:
+				for _, txt := range body.TXT {
+					if fp, ok := strings.CutPrefix(txt, "fp="); ok {
+						peer.Fingerprint = fp
+					}
+				}
+			}
+		}
+		if port < 0 {
+			continue
+		}
+		peer.Addr = net.JoinHostPort(ip.String(), fmt.Sprint(port))
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// ErrNoPeers is returned by Find when no server answered
+var ErrNoPeers = errors.New("mdns: no servers found")
+
+// Find browses until ctx is done and returns the server called instance, or the only server found if
+// instance is empty. It's an error if instance is empty and there's more than one server.
+func Find(ctx context.Context, instance string) (*Peer, error) {
+	peers, err := Browse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []Peer
+	for _, peer := range peers {
+		if instance == "" || strings.EqualFold(peer.Instance, instance) {
+			found = append(found, peer)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, ErrNoPeers
+	case len(found) == 1 || instance != "":
+		// A named server that answered on several addresses can be reached on any of them
+		return &found[0], nil
+	default:
+		names := make([]string, len(found))
+		for i, peer := range found {
+			names[i] = peer.Instance + " (" + peer.Addr + ")"
+		}
+		return nil, fmt.Errorf("mdns: found %d servers, pick one of %s", len(found), strings.Join(names, ", "))
+	}
+}
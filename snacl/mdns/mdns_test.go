@@ -0,0 +1,68 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func TestResponse(t *testing.T) {
+	svc := &Service{Instance: "printer", Port: 9000, PublicKey: [32]byte{1, 2, 3}}
+	response, err := svc.response(7, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := parsePeers(response, net.IPv4(192, 168, 1, 20))
+	if len(peers) != 1 {
+		t.Fatalf("Unexpected result: %v", peers)
+	}
+	want := Peer{Instance: "printer", Addr: "192.168.1.20:9000", Fingerprint: snacl.Fingerprint(svc.PublicKey)}
+	if peers[0] != want {
+		t.Fatalf("Unexpected result: %+v", peers[0])
+	}
+}
+
+func TestAsksFor(t *testing.T) {
+	query, err := browseQuery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Fatal(err)
+	}
+	if !asksFor(&msg) {
+		t.Fatal("Unexpected result. The browse query isn't for the service.")
+	}
+	msg.Questions[0].Name = dnsmessage.MustNewName("_http._tcp.local.")
+	if asksFor(&msg) {
+		t.Fatal("Unexpected result. Answered a query for another service.")
+	}
+}
+
+func TestAdvertiseFind(t *testing.T) {
+	svc := &Service{Instance: "mdns-test", Port: 9000, PublicKey: [32]byte{4, 5, 6}}
+	a, err := Advertise(svc)
+	if err != nil {
+		t.Skipf("Multicast isn't available: %v", err)
+	}
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	peer, err := Find(ctx, "mdns-test")
+	if err == ErrNoPeers {
+		t.Skip("Multicast doesn't reach this host's own sockets")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Fingerprint != snacl.Fingerprint(svc.PublicKey) {
+		t.Fatalf("Unexpected result: %+v", peer)
+	}
+}
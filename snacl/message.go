@@ -0,0 +1,99 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// SealMessage encrypts msg into a single frame in the same format Writer sends, length prefix included.
+// The frame can be stored or queued and later opened with OpenMessage or read back through a Reader.
+// priv is your private key
+// pub is the public key of who the message is for
+func SealMessage(priv, pub *[32]byte, msg []byte) ([]byte, error) {
+	var nonce [wire.NonceLength]byte
+	err := wire.ReadNonce(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	return SealMessageWithNonce(priv, pub, &nonce, msg)
+}
+
+// SealMessageWithNonce is SealMessage with a nonce of your choosing, which makes the frame deterministic.
+// It's meant for generating test vectors: a nonce must never be used twice with the same keys,
+// use SealMessage for anything else.
+func SealMessageWithNonce(priv, pub *[32]byte, nonce *[24]byte, msg []byte) ([]byte, error) {
+	if len(msg) > MaxMessageLength {
+		return nil, fmt.Errorf("message is too large (len:%d max:%d)", len(msg), MaxMessageLength)
+	}
+
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, pub, priv)
+	return sealFrame(nil, msg, nonce[:], newSecretbox(&sharedKey)), nil
+}
+
+// OpenMessage decrypts a single frame produced by SealMessage or Writer and returns the message.
+// frame must hold exactly one frame.
+// priv is your private key
+// pub is the public key of who sent the message
+func OpenMessage(priv, pub *[32]byte, frame []byte) ([]byte, error) {
+	r := bytes.NewReader(frame)
+	msg, err := NewReader(r, priv, pub).ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing data after frame (len:%d)", r.Len())
+	}
+	return msg.Data, nil
+}
+
+// SealAnonymous encrypts msg into a single frame that only the owner of pub can open, without a sender
+// key: every frame is sealed with a fresh ephemeral key pair, like libsodium's crypto_box_seal. The
+// recipient learns nothing about who sent it, so it suits drop-box style submissions, but for the same
+// reason anyone who knows pub can send one. The frame is [length uint32be][ephemeral public key 32][box],
+// open it with OpenAnonymous.
+//
+// For a whole connection, a client with nil Options.Keys already uses a key pair of its own per Conn.
+func SealAnonymous(pub *[32]byte, msg []byte) ([]byte, error) {
+	if len(msg) > MaxMessageLength {
+		return nil, fmt.Errorf("message is too large (len:%d max:%d)", len(msg), MaxMessageLength)
+	}
+
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(msg)+box.AnonymousOverhead))
+	return box.SealAnonymous(out, msg, pub, rand.Reader)
+}
+
+// OpenAnonymous decrypts a single frame produced by SealAnonymous with the recipient's keys and returns
+// the message. frame must hold exactly one frame.
+func OpenAnonymous(keys *Keys, frame []byte) ([]byte, error) {
+	if len(frame) < wire.HeaderLength {
+		return nil, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(frame)
+	err := wire.CheckLength(length, MaxMessageLength+box.AnonymousOverhead)
+	if err != nil {
+		return nil, err
+	}
+	if length < box.AnonymousOverhead {
+		return nil, fmt.Errorf("%w (len:%d min:%d)", ErrFrameTooShort, length, box.AnonymousOverhead)
+	}
+	sealed := frame[wire.HeaderLength:]
+	if len(sealed) < int(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if len(sealed) != int(length) {
+		return nil, fmt.Errorf("trailing data after frame (len:%d)", len(sealed)-int(length))
+	}
+
+	msg, ok := box.OpenAnonymous(nil, sealed, &keys.Public, &keys.Private)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return msg, nil
+}
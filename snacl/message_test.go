@@ -0,0 +1,95 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSealOpenMessage(t *testing.T) {
+	alice, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("hello world\n")
+	frame, err := SealMessage(&alice.Private, &bob.Public, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := OpenMessage(&bob.Private, &alice.Public, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg, expected)
+	}
+
+	// A stored frame can be replayed through a Reader
+	buf := make([]byte, 1024)
+	n, err := NewReader(bytes.NewReader(frame), &bob.Private, &alice.Public).Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", buf[:n], expected)
+	}
+
+	if _, err := OpenMessage(&bob.Private, &alice.Public, append(frame, 0)); err == nil {
+		t.Fatal("Unexpected result. Trailing data was accepted.")
+	}
+	if _, err := SealMessage(&alice.Private, &bob.Public, make([]byte, MaxMessageLength+1)); err == nil {
+		t.Fatal("Unexpected result. An oversized message was sealed.")
+	}
+}
+
+func TestSealOpenAnonymous(t *testing.T) {
+	server, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("hello world\n")
+	frame, err := SealAnonymous(&server.Public, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := OpenAnonymous(server, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg, expected)
+	}
+
+	// Every frame uses a new ephemeral key
+	again, err := SealAnonymous(&server.Public, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(frame[4:36], again[4:36]) {
+		t.Fatal("Unexpected result. The ephemeral key was reused.")
+	}
+
+	other, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenAnonymous(other, frame); err != ErrDecrypt {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := OpenAnonymous(server, append(frame, 0)); err == nil {
+		t.Fatal("Unexpected result. Trailing data was accepted.")
+	}
+	if _, err := OpenAnonymous(server, frame[:len(frame)-1]); err == nil {
+		t.Fatal("Unexpected result. A truncated frame was accepted.")
+	}
+	if _, err := SealAnonymous(&server.Public, make([]byte, MaxMessageLength+1)); err == nil {
+		t.Fatal("Unexpected result. An oversized message was sealed.")
+	}
+}
@@ -0,0 +1,93 @@
+package snacl
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// DefaultReadQueueSize is the number of messages Conn.Messages holds if Options.ReadQueueSize is 0
+const DefaultReadQueueSize = 64
+
+// readPump is the goroutine behind Conn.Messages and Conn.Errors, it reads messages into a channel
+type readPump struct {
+	messages chan []byte
+	// errors gets the error that stopped the pump, if it wasn't io.EOF
+	errors chan error
+	// done is closed by Close, so a pump waiting for the application to take a message exits
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newReadPump(size int) *readPump {
+	return &readPump{
+		messages: make(chan []byte, size),
+		errors:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *readPump) stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// Messages returns a channel with every message read from the Conn, for applications that would
+// rather select on a channel than sit in a Read loop. The first call to Messages or Errors starts a
+// goroutine that reads the Conn until it fails or the other side closes it, from then on nothing else
+// should read it. Up to Options.ReadQueueSize messages are held while the application is busy, then the
+// goroutine stops reading until there's room, so flow control still works.
+//
+// The channel is closed when reading stops, Errors then says why.
+func (c *Conn) Messages() <-chan []byte {
+	c.pumpOnce.Do(c.startPump)
+	return c.pump.messages
+}
+
+// Errors returns a channel that gets the error that stopped Messages and is then closed. It's closed
+// without an error when the other side closed the stream. See Messages.
+func (c *Conn) Errors() <-chan error {
+	c.pumpOnce.Do(c.startPump)
+	return c.pump.errors
+}
+
+// startPump creates the read pump and starts its goroutine
+func (c *Conn) startPump() {
+	size := c.opts.ReadQueueSize
+	if size <= 0 {
+		size = DefaultReadQueueSize
+	}
+	p := newReadPump(size)
+	c.pump = p
+
+	go func() {
+		defer close(p.errors)
+		defer close(p.messages)
+		for {
+			msg, err := c.ReadMsg()
+			if err != nil {
+				if err != io.EOF {
+					p.errors <- err
+				}
+				return
+			}
+			select {
+			case p.messages <- msg.Data:
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// stopPump stops the read pump from waiting on the application, its read fails once the underlying
+// stream is closed. After Close Messages and Errors are already closed, with net.ErrClosed.
+func (c *Conn) stopPump() {
+	c.pumpOnce.Do(func() {
+		p := newReadPump(0)
+		p.errors <- net.ErrClosed
+		close(p.errors)
+		close(p.messages)
+		c.pump = p
+	})
+	c.pump.stop()
+}
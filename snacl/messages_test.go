@@ -0,0 +1,82 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnMessages(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer server.Close()
+
+	const count = 10
+	go func() {
+		for i := 0; i < count; i++ {
+			client.WriteMsg([]byte(fmt.Sprintf("message %d", i)))
+		}
+		client.Close()
+	}()
+
+	i := 0
+	for msg := range server.Messages() {
+		if expected := fmt.Sprintf("message %d", i); string(msg) != expected {
+			t.Fatalf("Unexpected result: %s != %s", msg, expected)
+		}
+		i++
+	}
+	if i != count {
+		t.Fatalf("Unexpected result: %d messages", i)
+	}
+	// The other side closed the stream, that's not an error
+	if err, ok := <-server.Errors(); ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnMessagesError(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+
+	server.Close()
+	select {
+	case err := <-server.Errors():
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. Errors didn't get the error.")
+	}
+	if _, ok := <-server.Messages(); ok {
+		t.Fatal("Unexpected result. Messages wasn't closed.")
+	}
+}
+
+func TestConnMessagesClose(t *testing.T) {
+	client, server := pipe(t, &Options{ReadQueueSize: 1})
+	defer client.Close()
+
+	// The application never takes the messages, so the pump is stuck waiting for room
+	server.Messages()
+	go func() {
+		for i := 0; i < 3; i++ {
+			client.WriteMsg([]byte("hello"))
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	server.Close()
+	done := make(chan struct{})
+	go func() {
+		for range server.Errors() {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. Close didn't stop the pump.")
+	}
+}
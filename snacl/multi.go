@@ -0,0 +1,138 @@
+package snacl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// MaxRecipients is the most recipients SealMulti seals a frame for
+const MaxRecipients = 256
+
+// wrappedKeyLength is the size of a content key and body hash sealed for one recipient, [nonce 24][box]
+const wrappedKeyLength = wire.NonceLength + 32 + sha256.Size + box.Overhead
+
+// ErrNotRecipient is returned by OpenMulti when none of the wrapped keys open with our key
+var ErrNotRecipient = errors.New("frame isn't sealed for this key")
+
+// SealMulti encrypts msg once and makes it readable by every one of recipients, so a single frame can
+// be broadcast to several clients. msg is sealed with secretbox under a random content key, and the
+// content key is sealed for each recipient with a box from priv, along with the SHA-256 hash of the
+// sealed body. The frame is
+//
+//	[length uint32be][count uint16be][count wrapped keys, [nonce 24][box]][nonce 24][secretbox]
+//
+// and the wrapped keys don't say who they're for, recipients try each one, see OpenMulti.
+//
+// Every recipient learns the content key, so the secretbox alone doesn't say who sealed the body, any
+// recipient could seal another one under the same key. It's the hash in each box from priv that ties
+// the body to the sender: a recipient can't make a box from priv for the others, so a body it
+// re-sealed doesn't match the hash they get.
+func SealMulti(priv *[32]byte, msg []byte, recipients []*[32]byte) ([]byte, error) {
+	if len(msg) > MaxMessageLength {
+		return nil, fmt.Errorf("message is too large (len:%d max:%d)", len(msg), MaxMessageLength)
+	}
+	if len(recipients) == 0 || len(recipients) > MaxRecipients {
+		return nil, fmt.Errorf("SealMulti needs 1 to %d recipients, got %d", MaxRecipients, len(recipients))
+	}
+
+	var contentKey [32]byte
+	_, err := io.ReadFull(rand.Reader, contentKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The body is sealed first, its hash goes in the wrapped keys
+	var nonce [wire.NonceLength]byte
+	err = wire.ReadNonce(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	sealed := secretbox.Seal(append([]byte(nil), nonce[:]...), msg, &nonce, &contentKey)
+	bodyHash := sha256.Sum256(sealed)
+	wrappedKey := append(append([]byte(nil), contentKey[:]...), bodyHash[:]...)
+
+	length := 2 + len(recipients)*wrappedKeyLength + len(sealed)
+	out := make([]byte, 0, wire.HeaderLength+length)
+	out = binary.BigEndian.AppendUint32(out, uint32(length))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(recipients)))
+	for _, pub := range recipients {
+		err = wire.ReadNonce(rand.Reader, nonce[:])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, nonce[:]...)
+		out = box.Seal(out, wrappedKey, &nonce, pub, priv)
+	}
+	return append(out, sealed...), nil
+}
+
+// OpenMulti decrypts a single frame produced by SealMulti and returns the message.
+// frame must hold exactly one frame.
+// priv is your private key
+// pub is the public key of who sent the message
+func OpenMulti(priv, pub *[32]byte, frame []byte) ([]byte, error) {
+	if len(frame) < wire.HeaderLength+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(frame)
+	count := int(binary.BigEndian.Uint16(frame[wire.HeaderLength:]))
+	if count == 0 || count > MaxRecipients {
+		return nil, fmt.Errorf("frame has %d recipients, it must have 1 to %d", count, MaxRecipients)
+	}
+
+	// The same checks as a Reader, with room for the wrapped keys
+	minLength := 2 + count*wrappedKeyLength + wire.NonceLength + secretbox.Overhead
+	err := wire.CheckLength(length, uint32(minLength+MaxMessageLength))
+	if err != nil {
+		return nil, err
+	}
+	if length < uint32(minLength) {
+		return nil, fmt.Errorf("%w (len:%d min:%d)", ErrFrameTooShort, length, minLength)
+	}
+	body := frame[wire.HeaderLength:]
+	if len(body) < int(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if len(body) != int(length) {
+		return nil, fmt.Errorf("trailing data after frame (len:%d)", len(body)-int(length))
+	}
+
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, pub, priv)
+
+	wrapped, sealed := body[2:2+count*wrappedKeyLength], body[2+count*wrappedKeyLength:]
+	var wrappedKey [32 + sha256.Size]byte
+	found := false
+	for ; len(wrapped) > 0; wrapped = wrapped[wrappedKeyLength:] {
+		key, ok := box.OpenAfterPrecomputation(wrappedKey[:0], wrapped[wire.NonceLength:wrappedKeyLength], (*[wire.NonceLength]byte)(wrapped), &sharedKey)
+		if ok && len(key) == len(wrappedKey) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrNotRecipient
+	}
+
+	// The content key is every recipient's, the hash is what says the body is the sender's
+	contentKey := (*[32]byte)(wrappedKey[:32])
+	bodyHash := sha256.Sum256(sealed)
+	if subtle.ConstantTimeCompare(bodyHash[:], wrappedKey[32:]) != 1 {
+		return nil, ErrDecrypt
+	}
+
+	msg, ok := secretbox.Open(nil, sealed[wire.NonceLength:], (*[wire.NonceLength]byte)(sealed), contentKey)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return msg, nil
+}
@@ -0,0 +1,89 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+func TestSealOpenMulti(t *testing.T) {
+	server, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clients []*Keys
+	var recipients []*[32]byte
+	for i := 0; i < 3; i++ {
+		keys, err := GenerateKeys(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, keys)
+		recipients = append(recipients, &keys.Public)
+	}
+
+	expected := []byte("hello everyone\n")
+	frame, err := SealMulti(&server.Private, expected, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range clients {
+		msg, err := OpenMulti(&client.Private, &server.Public, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, expected) {
+			t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg, expected)
+		}
+	}
+
+	// Someone who isn't a recipient can't open it, and neither can a recipient expecting another sender
+	other, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMulti(&other.Private, &server.Public, frame); err != ErrNotRecipient {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := OpenMulti(&clients[0].Private, &other.Public, frame); err != ErrNotRecipient {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tampered := append([]byte(nil), frame...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := OpenMulti(&clients[0].Private, &server.Public, tampered); err != ErrDecrypt {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := OpenMulti(&clients[0].Private, &server.Public, append(frame, 0)); err == nil {
+		t.Fatal("Unexpected result. Trailing data was accepted.")
+	}
+
+	// A recipient knows the content key, but a body it seals under it isn't taken as the sender's
+	wrapped := frame[wire.HeaderLength+2 : wire.HeaderLength+2+len(recipients)*wrappedKeyLength]
+	var wrappedKey []byte
+	for w := wrapped; len(w) > 0 && wrappedKey == nil; w = w[wrappedKeyLength:] {
+		wrappedKey, _ = box.Open(nil, w[wire.NonceLength:wrappedKeyLength], (*[wire.NonceLength]byte)(w), &server.Public, &clients[0].Private)
+	}
+	if wrappedKey == nil {
+		t.Fatal("Unexpected result. No wrapped key opened.")
+	}
+	var nonce [wire.NonceLength]byte
+	rand.Read(nonce[:])
+	forged := append([]byte(nil), frame[:wire.HeaderLength+2+len(wrapped)]...)
+	forged = append(forged, nonce[:]...)
+	forged = secretbox.Seal(forged, []byte("HELLO EVERYONE\n"), &nonce, (*[32]byte)(wrappedKey))
+	for _, client := range clients[1:] {
+		if _, err := OpenMulti(&client.Private, &server.Public, forged); err != ErrDecrypt {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if _, err := SealMulti(&server.Private, expected, nil); err == nil {
+		t.Fatal("Unexpected result. A frame was sealed for nobody.")
+	}
+}
@@ -0,0 +1,298 @@
+// Package mux carries many independent byte streams over one snacl.Conn, so each logical channel
+// doesn't need its own TCP connection and handshake. It works like yamux: every stream has an ID,
+// streams are opened and closed with frames of their own, and each one has a window so a stream whose
+// reader is slow can't hold up the others or make the other side buffer without limit.
+//
+// Every frame is a single snacl message:
+//
+//	[type uint8][stream ID uint32be][data]
+//
+// The side that dialed uses odd stream IDs and the side that accepted uses even ones.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Frame types
+const (
+	// frameOpen opens a stream, it has no data
+	frameOpen byte = 0
+	// frameData is data for a stream, at most the stream's send window
+	frameData byte = 1
+	// frameWindow gives the other side more send window, the data is a uint32be number of bytes
+	frameWindow byte = 2
+	// frameClose says the sender won't write to the stream again, like a TCP FIN
+	frameClose byte = 3
+	// frameReset abandons a stream in both directions
+	frameReset byte = 4
+)
+
+// headerLength is the size of a frame's type and stream ID
+const headerLength = 5
+
+// DefaultWindow is the default Config.Window
+const DefaultWindow = 256 * 1024
+
+// DefaultAcceptBacklog is the default Config.AcceptBacklog
+const DefaultAcceptBacklog = 64
+
+var (
+	// ErrSessionClosed is returned for anything done on a closed Session or its streams
+	ErrSessionClosed = errors.New("mux: session closed")
+	// ErrStreamReset is returned when the other side reset a stream, or it was reset because the
+	// session's accept backlog was full
+	ErrStreamReset = errors.New("mux: stream reset")
+	// ErrStreamClosed is returned for writes to a stream after Close
+	ErrStreamClosed = errors.New("mux: write to closed stream")
+	// ErrProtocol is returned when the other side breaks the protocol, the session is closed
+	ErrProtocol = errors.New("mux: protocol error")
+)
+
+// Config configures a Session, the zero value uses the defaults
+type Config struct {
+	// Window is how much data the other side can send on a stream before we've read it.
+	// 0 means DefaultWindow. Both sides must use the same window.
+	Window uint32
+	// AcceptBacklog is how many streams the other side can open before they're accepted, more are
+	// reset. 0 means DefaultAcceptBacklog.
+	AcceptBacklog int
+}
+
+// Session is one side of a multiplexed snacl.Conn
+type Session struct {
+	conn   *snacl.Conn
+	window uint32
+	// maxData is the most data that fits in one frame
+	maxData int
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error
+
+	accept chan *Stream
+	// done is closed when the session is closed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Client starts a session on the side that dialed conn. cfg may be nil.
+func Client(conn *snacl.Conn, cfg *Config) (*Session, error) {
+	return newSession(conn, cfg, 1)
+}
+
+// Server starts a session on the side that accepted conn. cfg may be nil.
+func Server(conn *snacl.Conn, cfg *Config) (*Session, error) {
+	return newSession(conn, cfg, 2)
+}
+
+func newSession(conn *snacl.Conn, cfg *Config, firstID uint32) (*Session, error) {
+	err := conn.Handshake()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		conn:    conn,
+		window:  DefaultWindow,
+		maxData: conn.ConnectionState().MaxMessageLength - headerLength,
+		streams: make(map[uint32]*Stream),
+		nextID:  firstID,
+		done:    make(chan struct{}),
+	}
+	backlog := DefaultAcceptBacklog
+	if cfg != nil {
+		if cfg.Window > 0 {
+			s.window = cfg.Window
+		}
+		if cfg.AcceptBacklog > 0 {
+			backlog = cfg.AcceptBacklog
+		}
+	}
+	if s.maxData <= 0 {
+		return nil, fmt.Errorf("mux: MaxMessageLength %d is too small", conn.ConnectionState().MaxMessageLength)
+	}
+	s.accept = make(chan *Stream, backlog)
+
+	go s.readLoop()
+	return s, nil
+}
+
+// Open opens a new stream
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := s.newStream(id)
+	s.mu.Unlock()
+
+	err := s.writeFrame(frameOpen, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for the other side to open a stream
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, s.closedErr()
+	}
+}
+
+// Close closes every stream and the underlying connection
+func (s *Session) Close() error {
+	return s.closeWithErr(ErrSessionClosed)
+}
+
+// newStream creates and registers a stream, s.mu must be held
+func (s *Session) newStream(id uint32) *Stream {
+	st := &Stream{id: id, sess: s, sendWindow: s.window, recvWindow: s.window}
+	st.cond.L = &st.mu
+	s.streams[id] = st
+	return st
+}
+
+// closeWithErr closes the session because of err, the first error is the one that's kept. It returns
+// the error from closing the connection, or nil if the session was already closed.
+func (s *Session) closeWithErr(err error) error {
+	var closeErr error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+
+		close(s.done)
+		closeErr = s.conn.Close()
+		for _, st := range streams {
+			st.wake()
+		}
+	})
+	return closeErr
+}
+
+func (s *Session) closedErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// writeFrame sends a frame as a single message
+func (s *Session) writeFrame(typ byte, id uint32, data []byte) error {
+	frame := make([]byte, headerLength, headerLength+len(data))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:], id)
+	frame = append(frame, data...)
+
+	err := s.conn.WriteMsg(frame)
+	if err != nil {
+		s.closeWithErr(err)
+		return err
+	}
+	return nil
+}
+
+// readLoop reads frames and hands them to their streams until the connection fails
+func (s *Session) readLoop() {
+	for {
+		msg, err := s.conn.ReadMsg()
+		if err == io.EOF {
+			err = ErrSessionClosed
+		}
+		if err == nil {
+			err = s.handleFrame(msg.Data)
+		}
+		if err != nil {
+			s.closeWithErr(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(frame []byte) error {
+	if len(frame) < headerLength {
+		return fmt.Errorf("%w: short frame", ErrProtocol)
+	}
+	typ, id, data := frame[0], binary.BigEndian.Uint32(frame[1:]), frame[headerLength:]
+
+	if typ == frameOpen {
+		return s.handleOpen(id)
+	}
+
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		// Frames can still be in flight for a stream we reset or finished with
+		return nil
+	}
+
+	switch typ {
+	case frameData:
+		return st.received(data)
+	case frameWindow:
+		if len(data) != 4 {
+			return fmt.Errorf("%w: bad window update", ErrProtocol)
+		}
+		st.mu.Lock()
+		st.sendWindow += binary.BigEndian.Uint32(data)
+		st.mu.Unlock()
+		st.cond.Broadcast()
+	case frameClose:
+		st.mu.Lock()
+		st.remoteClosed = true
+		st.mu.Unlock()
+		st.cond.Broadcast()
+		st.maybeRemove()
+	case frameReset:
+		st.mu.Lock()
+		st.reset = true
+		st.mu.Unlock()
+		st.cond.Broadcast()
+		s.remove(id)
+	default:
+		return fmt.Errorf("%w: unknown frame type %d", ErrProtocol, typ)
+	}
+	return nil
+}
+
+func (s *Session) handleOpen(id uint32) error {
+	s.mu.Lock()
+	// The other side's IDs have the other parity
+	if id%2 == s.nextID%2 || s.streams[id] != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: bad stream ID %d", ErrProtocol, id)
+	}
+	st := s.newStream(id)
+	s.mu.Unlock()
+
+	select {
+	case s.accept <- st:
+		return nil
+	default:
+		s.remove(id)
+		return s.writeFrame(frameReset, id, nil)
+	}
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
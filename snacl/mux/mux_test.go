@@ -0,0 +1,213 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/securetest"
+)
+
+// pipe returns a connected client and server Session
+func pipe(t *testing.T, cfg *Config) (client, server *Session) {
+	c, s := securetest.Pipe()
+	client, err := Client(c, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = Server(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// echo accepts streams and echoes them until the session is closed
+func echo(s *Session) {
+	for {
+		st, err := s.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(st, st)
+			st.Close()
+		}()
+	}
+}
+
+func TestStreams(t *testing.T) {
+	client, server := pipe(t, nil)
+	go echo(server)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := client.Open()
+			if err != nil {
+				errs <- err
+				return
+			}
+			// More than the window, so the echo has to hand window back as it goes
+			data := make([]byte, DefaultWindow+100000)
+			rand.Read(data)
+			go func() {
+				st.Write(data)
+				st.Close()
+			}()
+			got, err := io.ReadAll(st)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, data) {
+				errs <- errors.New("echoed data doesn't match")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestBufferedConn(t *testing.T) {
+	// Buffered writes would collect small frames into one message, every frame must still be its own
+	opts := &snacl.Options{WriteBufferSize: 1024, WriteBufferDelay: time.Hour}
+	c, s := securetest.PipeOptions(opts, opts)
+	client, err := Client(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := Server(s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go echo(server)
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		st.Write([]byte("hello"))
+		st.Close()
+	}()
+	got := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(st)
+		got <- b
+	}()
+	select {
+	case b := <-got:
+		if string(b) != "hello" {
+			t.Fatalf("Unexpected result: %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unexpected result. Nothing was echoed.")
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	client, server := pipe(t, &Config{Window: 1024})
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := st.Write(make([]byte, 4096))
+		written <- err
+	}()
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-written:
+		t.Fatal("Unexpected result. Write finished without the other side reading.")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	got, err := io.ReadFull(peer, make([]byte, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil || got != 4096 {
+		t.Fatalf("Unexpected result: %d %v", got, err)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	client, server := pipe(t, nil)
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestAcceptBacklog(t *testing.T) {
+	client, _ := pipe(t, &Config{AcceptBacklog: 1})
+
+	if _, err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := pipe(t, nil)
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	if _, err := st.Read(make([]byte, 1)); err != ErrSessionClosed {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Accept(); err != ErrSessionClosed {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Open(); err != ErrSessionClosed {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
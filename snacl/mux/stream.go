@@ -0,0 +1,177 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Stream is one of a Session's streams. It's an io.ReadWriteCloser, and Read and Write can be called
+// at the same time from different goroutines.
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	mu   sync.Mutex
+	cond sync.Cond
+	// buf is data received and not read yet, it's never more than the window
+	buf bytes.Buffer
+	// recvWindow is how much more the other side may send, and unacked is how much has been read
+	// since the last window update
+	recvWindow uint32
+	unacked    uint32
+	// sendWindow is how much more we may send
+	sendWindow uint32
+
+	// localClosed and remoteClosed are set when our side and the other side have closed the stream
+	localClosed, remoteClosed bool
+	reset                     bool
+}
+
+// ID returns the stream's ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data the other side wrote to the stream. It returns io.EOF once the other side has
+// closed the stream and everything has been read.
+func (st *Stream) Read(p []byte) (n int, err error) {
+	st.mu.Lock()
+	for st.buf.Len() == 0 {
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return 0, ErrStreamReset
+		case st.remoteClosed:
+			st.mu.Unlock()
+			return 0, io.EOF
+		case st.sessionClosed():
+			st.mu.Unlock()
+			return 0, st.sess.closedErr()
+		}
+		st.cond.Wait()
+	}
+
+	n, _ = st.buf.Read(p)
+	// The window is handed back in batches so there isn't an update for every read
+	st.unacked += uint32(n)
+	var update uint32
+	if st.unacked >= st.sess.window/2 {
+		update = st.unacked
+		st.recvWindow += update
+		st.unacked = 0
+	}
+	st.mu.Unlock()
+
+	if update > 0 {
+		st.sess.writeFrame(frameWindow, st.id, binary.BigEndian.AppendUint32(nil, update))
+	}
+	return n, nil
+}
+
+// Write writes p to the stream, waiting for the other side to read when the send window is used up
+func (st *Stream) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		st.mu.Lock()
+		for st.sendWindow == 0 && !st.reset && !st.localClosed && !st.sessionClosed() {
+			st.cond.Wait()
+		}
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return n, ErrStreamReset
+		case st.localClosed:
+			st.mu.Unlock()
+			return n, ErrStreamClosed
+		case st.sessionClosed():
+			st.mu.Unlock()
+			return n, st.sess.closedErr()
+		}
+		chunk := min(len(p)-n, int(st.sendWindow), st.sess.maxData)
+		st.sendWindow -= uint32(chunk)
+		st.mu.Unlock()
+
+		err = st.sess.writeFrame(frameData, st.id, p[n:n+chunk])
+		if err != nil {
+			return n, err
+		}
+		n += chunk
+	}
+	return n, nil
+}
+
+// Close closes our side of the stream, the other side reads io.EOF once it's read everything before it.
+// The stream can still be read until the other side closes it too.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localClosed || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.mu.Unlock()
+	st.cond.Broadcast()
+
+	err := st.sess.writeFrame(frameClose, st.id, nil)
+	st.maybeRemove()
+	return err
+}
+
+// Reset abandons the stream in both directions, anything not read yet is dropped
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	if st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.reset = true
+	st.mu.Unlock()
+	st.cond.Broadcast()
+
+	st.sess.remove(st.id)
+	return st.sess.writeFrame(frameReset, st.id, nil)
+}
+
+// received adds data from the other side to the buffer
+func (st *Stream) received(data []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if uint32(len(data)) > st.recvWindow {
+		return fmt.Errorf("%w: stream %d sent past its window", ErrProtocol, st.id)
+	}
+	if st.remoteClosed {
+		return fmt.Errorf("%w: stream %d sent data after closing", ErrProtocol, st.id)
+	}
+	st.recvWindow -= uint32(len(data))
+	st.buf.Write(data)
+	st.cond.Broadcast()
+	return nil
+}
+
+// maybeRemove forgets the stream once both sides have closed it
+func (st *Stream) maybeRemove() {
+	st.mu.Lock()
+	done := st.localClosed && st.remoteClosed
+	st.mu.Unlock()
+	if done {
+		st.sess.remove(st.id)
+	}
+}
+
+// wake wakes up anything waiting on the stream after the session is closed
+func (st *Stream) wake() {
+	st.mu.Lock()
+	st.mu.Unlock()
+	st.cond.Broadcast()
+}
+
+func (st *Stream) sessionClosed() bool {
+	select {
+	case <-st.sess.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,504 @@
+package snacl
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// The Noise handshake, see Options.Noise. It's Noise_XX_25519_ChaChaPoly_BLAKE2s from
+// https://noiseprotocol.org/noise.html:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+//
+// A client that already knows the server's key, with Options.NoiseIK, uses
+// Noise_IK_25519_ChaChaPoly_BLAKE2s instead:
+//
+//	<- s
+//	...
+//	-> e, es, s, ss
+//	<- e, ee, se
+//
+// The client's static key goes in the first message, encrypted to the server's static key, and the
+// server's is never sent. Servers answer either pattern, they tell them apart by the first message's
+// length.
+//
+// Every handshake message is sent as [length uint16be][message], and the first two carry the sender's
+// Options.MaxMessageLength as a uint32be payload. The keys from Split become the sending and receiving
+// keys of a Version1 connection using XChaCha20Poly1305, so the records and frames are the same as
+// after a hello.
+
+const (
+	noiseProtocolName   = "Noise_XX_25519_ChaChaPoly_BLAKE2s"
+	noiseIKProtocolName = "Noise_IK_25519_ChaChaPoly_BLAKE2s"
+	// noisePrologue is mixed into the transcript so the handshake can't be mistaken for another protocol's
+	noisePrologue = "snacl noise"
+
+	noiseKeyLength      = 32
+	noiseTagLength      = chacha20poly1305.Overhead
+	noisePayloadLength  = 4
+	noiseMessage1Length = noiseKeyLength + noisePayloadLength
+	noiseMessage2Length = noiseKeyLength + noiseKeyLength + noiseTagLength + noisePayloadLength + noiseTagLength
+	noiseMessage3Length = noiseKeyLength + noiseTagLength + noiseTagLength
+
+	noiseIKMessage1Length = noiseKeyLength + noiseKeyLength + noiseTagLength + noisePayloadLength + noiseTagLength
+	noiseIKMessage2Length = noiseKeyLength + noisePayloadLength + noiseTagLength
+)
+
+// handshakeNoise runs the Noise XX handshake. It authenticates both static keys, and neither is sent
+// in the clear: the client's is encrypted to the server's ephemeral key and the server's to the client's.
+func (c *Conn) handshakeNoise(kx KeyExchanger, state *ConnectionState) (sendKey, recvKey [32]byte, err error) {
+	if c.isClient && c.opts.NoiseIK {
+		return c.handshakeNoiseIK(kx, state, nil)
+	}
+	var first []byte
+	if !c.isClient {
+		first, err = c.readNoise(noiseMessage1Length, noiseIKMessage1Length)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		if len(first) == noiseIKMessage1Length {
+			return c.handshakeNoiseIK(kx, state, first)
+		}
+	}
+
+	ns := newNoiseState(noiseProtocolName)
+	defer ns.zero()
+	e, err := GenerateKeys(c.opts.rand())
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	defer e.Zero()
+	payload := binary.BigEndian.AppendUint32(nil, uint32(state.MaxMessageLength))
+	ourKey := kx.PublicKey()
+
+	var re, rs [32]byte
+	var msg, theirPayload []byte
+	if c.isClient {
+		// -> e
+		msg = ns.writeKey(nil, &e.Public)
+		msg, err = ns.encryptAndHash(msg, payload)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = c.writeNoise(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+
+		// <- e, ee, s, es
+		msg, err = c.readNoise(noiseMessage2Length)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg = ns.readKey(&re, msg)
+		err = ns.mixDH(&e.Private, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.readEncryptedKey(&rs, msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixDH(&e.Private, &rs)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		theirPayload, err = ns.decryptAndHash(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+
+		// -> s, se
+		msg, err = ns.encryptAndHash(nil, ourKey[:])
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixStaticDH(kx, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.encryptAndHash(msg, nil)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = c.writeNoise(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		sendKey, recvKey, err = ns.split()
+	} else {
+		// -> e
+		msg = ns.readKey(&re, first)
+		theirPayload, err = ns.decryptAndHash(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+
+		// <- e, ee, s, es
+		msg = ns.writeKey(nil, &e.Public)
+		err = ns.mixDH(&e.Private, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.encryptAndHash(msg, ourKey[:])
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixStaticDH(kx, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.encryptAndHash(msg, payload)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = c.writeNoise(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+
+		// -> s, se
+		msg, err = c.readNoise(noiseMessage3Length)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.readEncryptedKey(&rs, msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixDH(&e.Private, &rs)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		_, err = ns.decryptAndHash(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		recvKey, sendKey, err = ns.split()
+	}
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	noiseFinished(state, rs, theirPayload)
+	return sendKey, recvKey, nil
+}
+
+// handshakeNoiseIK runs the Noise IK handshake. The client's static key is encrypted to the server's,
+// which it has in Options.PeerKeys, so it's hidden from an eavesdropper in the first message. first is
+// the client's first message for the server, which has already read it.
+func (c *Conn) handshakeNoiseIK(kx KeyExchanger, state *ConnectionState, first []byte) (sendKey, recvKey [32]byte, err error) {
+	ns := newNoiseState(noiseIKProtocolName)
+	defer ns.zero()
+	e, err := GenerateKeys(c.opts.rand())
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	defer e.Zero()
+	payload := binary.BigEndian.AppendUint32(nil, uint32(state.MaxMessageLength))
+	ourKey := kx.PublicKey()
+
+	var re, rs [32]byte
+	var msg, theirPayload []byte
+	if c.isClient {
+		// <- s
+		rs = c.opts.PeerKeys[0]
+		ns.mixHash(rs[:])
+
+		// -> e, es, s, ss
+		msg = ns.writeKey(nil, &e.Public)
+		err = ns.mixDH(&e.Private, &rs)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.encryptAndHash(msg, ourKey[:])
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixStaticDH(kx, &rs)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.encryptAndHash(msg, payload)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = c.writeNoise(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+
+		// <- e, ee, se
+		msg, err = c.readNoise(noiseIKMessage2Length)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg = ns.readKey(&re, msg)
+		err = ns.mixDH(&e.Private, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixStaticDH(kx, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		theirPayload, err = ns.decryptAndHash(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		sendKey, recvKey, err = ns.split()
+	} else {
+		// <- s
+		ns.mixHash(ourKey[:])
+
+		// -> e, es, s, ss
+		msg = ns.readKey(&re, first)
+		err = ns.mixStaticDH(kx, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.readEncryptedKey(&rs, msg)
+		if err != nil {
+			return sendKey, recvKey, fmt.Errorf("%w, or the client has another key for us", err)
+		}
+		err = ns.mixStaticDH(kx, &rs)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		theirPayload, err = ns.decryptAndHash(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+
+		// <- e, ee, se
+		msg = ns.writeKey(nil, &e.Public)
+		err = ns.mixDH(&e.Private, &re)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = ns.mixDH(&e.Private, &rs)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		msg, err = ns.encryptAndHash(msg, payload)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		err = c.writeNoise(msg)
+		if err != nil {
+			return sendKey, recvKey, err
+		}
+		recvKey, sendKey, err = ns.split()
+	}
+	if err != nil {
+		return sendKey, recvKey, err
+	}
+	noiseFinished(state, rs, theirPayload)
+	return sendKey, recvKey, nil
+}
+
+// noiseFinished fills in state once a Noise handshake is done, theirPayload is the payload the other
+// side sent with its Options.MaxMessageLength
+func noiseFinished(state *ConnectionState, peerKey [32]byte, theirPayload []byte) {
+	state.Version = Version1
+	state.Noise = true
+	state.PeerPublicKey = peerKey
+	state.CipherSuite = XChaCha20Poly1305
+	if theirMax := binary.BigEndian.Uint32(theirPayload); theirMax != 0 {
+		state.MaxMessageLength = min(state.MaxMessageLength, int(theirMax))
+	}
+}
+
+// writeNoise sends a Noise handshake message
+func (c *Conn) writeNoise(msg []byte) error {
+	_, err := c.rwc.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+// readNoise reads a Noise handshake message, which has to be one of lengths bytes. Every message in the
+// patterns has a fixed length, so anything else is a peer that isn't speaking Noise and it isn't waited
+// for.
+func (c *Conn) readNoise(lengths ...int) ([]byte, error) {
+	var prefix [2]byte
+	_, err := io.ReadFull(c.rwc, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(prefix[:]))
+	if !slices.Contains(lengths, length) {
+		return nil, fmt.Errorf("%w: unexpected Noise message, the other side may not be using Options.Noise", ErrHandshakeFailed)
+	}
+	msg := make([]byte, length)
+	_, err = io.ReadFull(c.rwc, msg)
+	return msg, err
+}
+
+// noiseState is the Noise SymmetricState together with its CipherState
+type noiseState struct {
+	ck, h [32]byte
+	// k is the cipher key, and hasKey is set once there is one
+	k      [32]byte
+	hasKey bool
+	n      uint64
+}
+
+func newNoiseState(protocolName string) *noiseState {
+	ns := &noiseState{}
+	// The protocol name is longer than a hash, so it's hashed
+	ns.h = blake2s.Sum256([]byte(protocolName))
+	ns.ck = ns.h
+	ns.mixHash([]byte(noisePrologue))
+	return ns
+}
+
+// zero zeroes the keys once the handshake is done with them, see wipe.go
+func (ns *noiseState) zero() {
+	clear(ns.ck[:])
+	clear(ns.k[:])
+}
+
+func newBlake2s() hash.Hash {
+	h, _ := blake2s.New256(nil)
+	return h
+}
+
+// hkdf is the Noise HKDF, it returns two outputs
+func (ns *noiseState) hkdf(ikm []byte) (out1, out2 [32]byte) {
+	mac := hmac.New(newBlake2s, ns.ck[:])
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+	defer clear(temp)
+
+	mac = hmac.New(newBlake2s, temp)
+	mac.Write([]byte{1})
+	mac.Sum(out1[:0])
+
+	mac = hmac.New(newBlake2s, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{2})
+	mac.Sum(out2[:0])
+	return out1, out2
+}
+
+func (ns *noiseState) mixHash(data []byte) {
+	h := newBlake2s()
+	h.Write(ns.h[:])
+	h.Write(data)
+	h.Sum(ns.h[:0])
+}
+
+func (ns *noiseState) mixKey(ikm []byte) {
+	ns.ck, ns.k = ns.hkdf(ikm)
+	ns.hasKey = true
+	ns.n = 0
+}
+
+// mixDH mixes the Diffie-Hellman of priv and pub into the key
+func (ns *noiseState) mixDH(priv, pub *[32]byte) error {
+	shared, err := curve25519.X25519(priv[:], pub[:])
+	if err != nil {
+		return fmt.Errorf("%w: bad Noise key", ErrHandshakeFailed)
+	}
+	defer clear(shared)
+	ns.mixKey(shared)
+	return nil
+}
+
+// mixStaticDH is mixDH with our static key, which kx holds
+func (ns *noiseState) mixStaticDH(kx KeyExchanger, pub *[32]byte) error {
+	shared, err := kx.X25519(pub)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHandshakeFailed, err)
+	}
+	defer clear(shared[:])
+	ns.mixKey(shared[:])
+	return nil
+}
+
+func (ns *noiseState) aead() (cipher.AEAD, []byte, error) {
+	aead, err := chacha20poly1305.New(ns.k[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], ns.n)
+	ns.n++
+	return aead, nonce[:], nil
+}
+
+// encryptAndHash appends plaintext to out, encrypted once there's a key, and mixes it into the hash
+func (ns *noiseState) encryptAndHash(out, plaintext []byte) ([]byte, error) {
+	start := len(out)
+	if !ns.hasKey {
+		out = append(out, plaintext...)
+	} else {
+		aead, nonce, err := ns.aead()
+		if err != nil {
+			return nil, err
+		}
+		out = aead.Seal(out, nonce, plaintext, ns.h[:])
+	}
+	ns.mixHash(out[start:])
+	return out, nil
+}
+
+// decryptAndHash decrypts all of msg and mixes it into the hash
+func (ns *noiseState) decryptAndHash(msg []byte) ([]byte, error) {
+	if !ns.hasKey {
+		ns.mixHash(msg)
+		return msg, nil
+	}
+	aead, nonce, err := ns.aead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, msg, ns.h[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: Noise message doesn't decrypt, the handshake was corrupted or tampered with", ErrHandshakeFailed)
+	}
+	ns.mixHash(msg)
+	return plaintext, nil
+}
+
+// writeKey appends a public key sent in the clear to out
+func (ns *noiseState) writeKey(out []byte, key *[32]byte) []byte {
+	ns.mixHash(key[:])
+	return append(out, key[:]...)
+}
+
+// readKey reads a public key sent in the clear from the start of msg and returns the rest
+func (ns *noiseState) readKey(key *[32]byte, msg []byte) []byte {
+	copy(key[:], msg)
+	ns.mixHash(key[:])
+	return msg[noiseKeyLength:]
+}
+
+// readEncryptedKey reads an encrypted static key from the start of msg and returns the rest
+func (ns *noiseState) readEncryptedKey(key *[32]byte, msg []byte) ([]byte, error) {
+	plaintext, err := ns.decryptAndHash(msg[:noiseKeyLength+noiseTagLength])
+	if err != nil {
+		return nil, err
+	}
+	copy(key[:], plaintext)
+	return msg[noiseKeyLength+noiseTagLength:], nil
+}
+
+// split returns the initiator's and the responder's sending keys
+func (ns *noiseState) split() (initiator, responder [32]byte, err error) {
+	if !ns.hasKey {
+		return initiator, responder, errors.New("noise split before a key was mixed in")
+	}
+	initiator, responder = ns.hkdf(nil)
+	return initiator, responder, nil
+}
@@ -0,0 +1,146 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestHandshakeNoise(t *testing.T) {
+	clientKeys, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKeys, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := pipeOptions(t,
+		&Options{Noise: true, Keys: clientKeys, MaxMessageLength: 1000},
+		&Options{Noise: true, Keys: serverKeys})
+	defer client.Close()
+	defer server.Close()
+
+	state := client.ConnectionState()
+	if !state.Noise || state.CipherSuite != XChaCha20Poly1305 || state.MaxMessageLength != 1000 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+	if server.ConnectionState().MaxMessageLength != 1000 {
+		t.Fatalf("Unexpected max message length: %d", server.ConnectionState().MaxMessageLength)
+	}
+	if client.PeerPublicKey() != serverKeys.Public || server.PeerPublicKey() != clientKeys.Public {
+		t.Fatal("Unexpected result. The static keys weren't exchanged.")
+	}
+
+	expected := []byte("hello noise")
+	go client.Write(expected)
+	msg, err := server.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+	}
+}
+
+func TestHandshakeNoiseTampered(t *testing.T) {
+	// Flip a bit in the server's encrypted static key
+	c1, c2 := net.Pipe()
+	client := Client(&tamperConn{Conn: c1, offset: 2 + noiseKeyLength + 1}, &Options{Noise: true})
+	server := Server(c2, &Options{Noise: true})
+	defer client.Close()
+	defer server.Close()
+
+	go server.Handshake()
+	if err := client.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestHandshakeNoiseMismatch(t *testing.T) {
+	// A Version1 hello isn't a Noise message, so the server gives up straight away
+	c1, c2 := net.Pipe()
+	client := Client(c1, nil)
+	server := Server(c2, &Options{Noise: true})
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- client.Handshake()
+	}()
+	if err := server.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.Close()
+	if err := <-clientErr; err == nil {
+		t.Fatal("Expected an error")
+	}
+	client.Close()
+}
+
+// sniffConn keeps a copy of everything written, like an eavesdropper on the wire
+type sniffConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written []byte
+}
+
+func (c *sniffConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written = append(c.written, p...)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func TestHandshakeNoiseIK(t *testing.T) {
+	clientKeys, serverKeys := mustGenerateKeys(t), mustGenerateKeys(t)
+	c1, c2 := net.Pipe()
+	sniffed := &sniffConn{Conn: c1}
+	client := Client(sniffed, &Options{Noise: true, NoiseIK: true, Keys: clientKeys, PeerKeys: [][32]byte{serverKeys.Public}})
+	server := Server(c2, &Options{Noise: true, Keys: serverKeys, MaxMessageLength: 1000})
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write([]byte("hello IK"))
+	msg, err := server.ReadMsg()
+	if err != nil || string(msg.Data) != "hello IK" {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	if client.PeerPublicKey() != serverKeys.Public || server.PeerPublicKey() != clientKeys.Public {
+		t.Fatal("Unexpected result. The static keys weren't exchanged.")
+	}
+	if state := client.ConnectionState(); !state.Noise || state.MaxMessageLength != 1000 {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+
+	// The client sent one IK message, and its key isn't in it
+	sniffed.mu.Lock()
+	written := sniffed.written
+	sniffed.mu.Unlock()
+	if len(written) < 2+noiseIKMessage1Length || int(written[0])<<8|int(written[1]) != noiseIKMessage1Length {
+		t.Fatalf("Unexpected result: %x", written)
+	}
+	if bytes.Contains(written, clientKeys.Public[:]) {
+		t.Fatal("Unexpected result. The client's key was sent in the clear.")
+	}
+}
+
+func TestHandshakeNoiseIKWrongServerKey(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := Client(c1, &Options{Noise: true, NoiseIK: true, PeerKeys: [][32]byte{mustGenerateKeys(t).Public}})
+	server := Server(c2, &Options{Noise: true})
+	defer client.Close()
+
+	go client.Handshake()
+	if err := server.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.Close()
+
+	err := Client(nil, &Options{Noise: true, NoiseIK: true}).Handshake()
+	if err == nil || errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
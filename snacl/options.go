@@ -0,0 +1,195 @@
+package snacl
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"time"
+)
+
+// Options configures a Conn. A nil *Options is the same as the zero value.
+type Options struct {
+	// Keys is our key pair. If Keys is nil, a new key pair is generated for every connection.
+	Keys *Keys
+
+	// Rand is the source of randomness for generated keys and nonces. If nil, crypto/rand.Reader
+	// is used. Anything else must be cryptographically secure, apart from deterministic sources in tests.
+	Rand io.Reader
+
+	// NonceDRBG gives every connection its own ChaCha20 DRBG for nonces, seeded from Rand
+	// and reseeded every 1GB. This avoids contention on crypto/rand on servers with a lot of
+	// connections. Keys always come straight from Rand.
+	NonceDRBG bool
+
+	// WriteBufferSize turns on buffered writes, small writes are collected and sealed as one message
+	// of up to WriteBufferSize bytes. See Writer.Buffer and Conn.Flush.
+	WriteBufferSize int
+	// WriteBufferDelay is how long buffered data waits for more writes before it's sent anyway.
+	// 0 means data waits until the buffer is full or Flush is called.
+	WriteBufferDelay time.Duration
+
+	// MaxMessageLength is the biggest message we want to send or accept, 0 means MaxMessageLength.
+	// Both sides send theirs in the handshake and use the smaller one, see ConnectionState.
+	// It can be at most 1GB.
+	MaxMessageLength int
+
+	// LegacyV0 speaks Version0, the original challenge protocol: the raw public keys are exchanged and
+	// nothing is negotiated. Both sides have to agree on it, a Version0 side can't talk to a Version1 side.
+	LegacyV0 bool
+
+	// RekeyMessages and RekeyBytes are how many messages or bytes are sent before the sending key is
+	// updated, whichever comes first. 0 means DefaultRekeyMessages or DefaultRekeyBytes, and a negative
+	// value turns that threshold off. Key updates need Version1, see Conn.UpdateKey.
+	RekeyMessages int64
+	RekeyBytes    int64
+
+	// StatsSampleRate records the size of one in every StatsSampleRate messages in the histograms of
+	// Conn.Stats. 0 turns the histograms off, the counters are always on.
+	StatsSampleRate int
+
+	// WriteQueueSize is how many messages Conn.WriteMsgAsync can queue before it returns ErrQueueFull.
+	// 0 means DefaultWriteQueueSize.
+	WriteQueueSize int
+
+	// ReadQueueSize is how many messages Conn.Messages holds for the application before the read
+	// goroutine stops reading. 0 means DefaultReadQueueSize.
+	ReadQueueSize int
+
+	// CipherSuites are the cipher suites we accept in order of preference, the client's order wins.
+	// nil means NaClBox, XChaCha20Poly1305 and AES256GCM. Version0 always uses NaClBox.
+	CipherSuites []CipherSuite
+
+	// PostQuantum offers a hybrid key exchange, X25519 plus ML-KEM-768, so recorded traffic stays secret
+	// even if X25519 is broken later. It's used when both sides offer it, check ConnectionState.PostQuantum.
+	// It costs an extra round trip and about 2KB each way in the handshake. Version0 ignores it.
+	PostQuantum bool
+
+	// Noise replaces the hello with a Noise_XX_25519_ChaChaPoly_BLAKE2s handshake, see noise.go. Both
+	// static keys are authenticated and sent encrypted, so an eavesdropper doesn't learn who is talking.
+	// Both sides have to set it, the records are then sealed with XChaCha20Poly1305 and CipherSuites and
+	// PostQuantum are ignored. LegacyV0 takes precedence.
+	Noise bool
+	// NoiseIK makes a Noise client use Noise_IK_25519_ChaChaPoly_BLAKE2s with the server's key, which
+	// has to be the only one in PeerKeys. The client's key is in its first message, encrypted to the
+	// server's, so the handshake takes a round trip less and the server's key is never sent; but unlike
+	// XX, someone who later gets the server's private key can read which clients connected. Noise servers
+	// answer IK clients whether or not they set it.
+	NoiseIK bool
+
+	// AssociatedData lets messages carry associated data, sent in the clear but authenticated, see
+	// Conn.WriteMsgAAD. It's used when both sides set it and costs 2 bytes per frame. Version1 only.
+	AssociatedData bool
+
+	// Padding pads what we send so the frame lengths don't give away the message lengths, see
+	// PadToBlock and Padme. It's used when both sides set it, each with its own Padding. Version1 only.
+	Padding Padding
+
+	// EncryptLengths encrypts the length prefix of every frame, so the frame boundaries can't be read
+	// off the stream, see lengths.go. It's used when both sides set it. Version1 hellos only, Version0
+	// and Noise keep plaintext lengths.
+	EncryptLengths bool
+
+	// Compression compresses messages with DEFLATE before they're sealed, for big compressible payloads
+	// like logs or JSON over slow links. It's used when both sides set it. Version1 hellos only.
+	// It's off by default because the frame lengths then leak how compressible each message is, which
+	// gives away secrets mixed with attacker controlled data (CRIME, BREACH), see compress.go.
+	Compression bool
+
+	// FlowControlWindow turns on flow control: it's how many bytes of messages the other side can send
+	// before we've read them, and a Write waits once it's used up the other side's window. Reading
+	// gives the credit back, see flow.go. It's used when both sides set it, and messages are then at
+	// most the smaller window. Version1 hellos only.
+	FlowControlWindow int
+	// ManualWindowUpdates stops reads giving credit back, the application gives it with
+	// Conn.UpdateWindow instead, when it's ready for more.
+	ManualWindowUpdates bool
+
+	// KeepaliveInterval turns on keepalives: after this long without hearing from the other side we
+	// ping it, so half-open connections and NAT timeouts are noticed. It's used when both sides set it,
+	// see keepalive.go. Version1 hellos only.
+	KeepaliveInterval time.Duration
+	// KeepaliveTimeout is how long the other side can be silent before reads and writes fail with
+	// ErrPeerUnresponsive and the Conn is closed. 0 means 3 times KeepaliveInterval.
+	KeepaliveTimeout time.Duration
+
+	// OnUrgent is called with every urgent message the other side sends with Conn.WriteUrgent, see
+	// urgent.go. Setting it offers to take them. It's called from the goroutine reading the Conn, which
+	// waits for it, so it should be quick: cancel a context or send on a buffered channel, say. The
+	// message is the callback's to keep. Version1 hellos only.
+	OnUrgent func(msg []byte)
+
+	// IdleTimeout closes the Conn once no frame has been sent or received for this long, so abandoned
+	// peers can't hold on to a server's goroutines and file descriptors. The handshake has to finish
+	// within it too. Reads and writes then fail with ErrIdleTimeout. Keepalive pings count as frames,
+	// so IdleTimeout only sees a peer that's alive but idle when it's shorter than KeepaliveInterval.
+	IdleTimeout time.Duration
+
+	// HandshakeTimeout is how long a connection from Listen or NewNetListener has to finish its
+	// handshake. 0 means DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// Certificate is sent to the other side in the hello, see cert.go. It has to be for Keys.Public.
+	// Version1 only.
+	Certificate *Certificate
+	// TrustedCAs makes the handshake fail unless the other side presents a certificate for its key
+	// signed by one of them, see ConnectionState.PeerCertificate. Version1 only.
+	TrustedCAs []ed25519.PublicKey
+	// PeerName is the subject the other side's certificate must have, for a client usually the server's
+	// host name. It's only checked with TrustedCAs.
+	PeerName string
+	// RevocationChecker is asked whether the other side's key has been revoked, see revocation.go.
+	// A *RevocationList is one.
+	RevocationChecker RevocationChecker
+
+	// PeerKeys are the keys we accept from the other side, the handshake fails with any other. Version1
+	// sends them in the hello, so a server with GetKeys can pick one of them, see rotation.go.
+	PeerKeys [][32]byte
+	// GetKeys picks a server's key pair for each connection from the keys the client accepts, nil if
+	// the client didn't say, and replaces Keys. The server then reads the client's hello before sending
+	// its own. KeyRotator.GetKeys is one. Version1 servers only, and not with Certificate.
+	GetKeys func(peerKeys [][32]byte) (*Keys, error)
+
+	// ServerName is the name of the service a client wants, sent in its hello so a server with
+	// GetConfigForClient can tell services behind one listener apart, like TLS SNI. With TrustedCAs and
+	// no PeerName, the server's certificate must have it as its subject. At most 255 bytes, Version1 only.
+	ServerName string
+	// GetConfigForClient picks a server's Options for each connection from the client's ServerName, ""
+	// if it didn't send one, so one listener can present different keys and certificates for different
+	// services. It returns nil to keep these Options. The server then reads the client's hello before
+	// sending its own, as with GetKeys. IdleTimeout and HandshakeTimeout act before the hello is read,
+	// so they're always taken from these Options. Version1 servers only.
+	GetConfigForClient func(serverName string) (*Options, error)
+
+	// KeyExchanger holds our key pair in place of Keys, so the private key doesn't have to be in this
+	// process, see kx.go. It takes precedence over Keys.
+	KeyExchanger KeyExchanger
+
+	// LockMemory keeps the traffic keys in memory that's locked so it isn't swapped out, and left out of
+	// core dumps, see wipe.go. Locking can fail, usually because of RLIMIT_MEMLOCK, which doesn't fail
+	// the handshake; ConnectionState.MemoryLocked says whether it worked.
+	LockMemory bool
+
+	// Ratchet adds a double ratchet for long-lived connections, see ratchet.go. Every record is sealed
+	// with a key of its own that's dropped once it's used, and every rekey, from RekeyMessages, RekeyBytes
+	// or UpdateKey, mixes a fresh X25519 exchange into the keys, so a leaked key only exposes traffic up
+	// to the next rekey. It costs a key derivation per record and an X25519 per rekey. Both sides have to
+	// set it, check ConnectionState.Ratchet, and it's left off if ConnectionState.MaxMessageLength can't
+	// hold a ratchet step. Version1 hellos only, LegacyV0 and Noise ignore it.
+	Ratchet bool
+}
+
+// cipherSuites returns the cipher suites, see Options.CipherSuites
+func (o *Options) cipherSuites() []CipherSuite {
+	if o.CipherSuites == nil {
+		return defaultCipherSuites
+	}
+	return o.CipherSuites
+}
+
+// rand returns the source of randomness, see Options.Rand
+func (o *Options) rand() io.Reader {
+	if o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
+}
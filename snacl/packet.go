@@ -0,0 +1,249 @@
+package snacl
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// PacketConn is the datagram mode, for applications that can't wait for TCP's retransmissions. Every
+// datagram is sealed on its own with the box key of the two peers:
+//
+//	[nonce 24][box]
+//
+// The nonce is the first 16 bytes of the sender's public key and a uint64be counter. The prefix keeps
+// the two directions apart, they share a key. The counter starts at the time in nanoseconds and goes up
+// by one every datagram, so nonces aren't reused after a restart as long as the clock doesn't go back.
+// The receiver keeps a window of the last replayWindowSize counters for each peer and drops repeats and
+// anything older. There's no handshake, peers are set up with AddPeer, and datagrams that don't open
+// or come from unknown addresses are dropped.
+
+// packetNoncePrefixLength is the part of the nonce taken from the sender's public key
+const packetNoncePrefixLength = 16
+
+// packetOverhead is how much bigger a datagram is than its message
+const packetOverhead = 24 + box.Overhead
+
+// maxDatagramLength is the biggest UDP payload over IPv4
+const maxDatagramLength = 65507
+
+// replayWindowSize is how far out of order a datagram can arrive and still be accepted
+const replayWindowSize = 64
+
+// PacketConn can be handed to code written for net.PacketConn
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// ErrUnknownPeer is returned by PacketConn.WriteTo for an address that wasn't added with AddPeer
+var ErrUnknownPeer = errors.New("unknown peer")
+
+// PacketConn is a net.PacketConn that seals every datagram, see packet.go
+type PacketConn struct {
+	pc   net.PacketConn
+	keys *Keys
+
+	counter atomic.Uint64
+
+	mu    sync.Mutex
+	peers map[string]*packetPeer
+	// buf is where ReadFrom reads datagrams, readMu guards it
+	readMu sync.Mutex
+	buf    []byte
+}
+
+// packetPeer is what's kept for each peer
+type packetPeer struct {
+	publicKey [32]byte
+	sharedKey [32]byte
+	window    replayWindow
+}
+
+// NewPacketConn returns a PacketConn sending and receiving over pc with keys
+func NewPacketConn(pc net.PacketConn, keys *Keys) *PacketConn {
+	p := &PacketConn{pc: pc, keys: keys, peers: make(map[string]*packetPeer), buf: make([]byte, maxDatagramLength)}
+	p.counter.Store(uint64(time.Now().UnixNano()))
+	return p
+}
+
+// ListenPacket listens on the network address like net.ListenPacket and returns a PacketConn using keys
+func ListenPacket(network, addr string, keys *Keys) (*PacketConn, error) {
+	pc, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewPacketConn(pc, keys), nil
+}
+
+// AddPeer lets datagrams be sent to and received from addr, which has the public key pub. Adding an
+// address again replaces its key and forgets its replay window.
+func (p *PacketConn) AddPeer(addr net.Addr, pub *[32]byte) {
+	peer := &packetPeer{publicKey: *pub}
+	box.Precompute(&peer.sharedKey, pub, &p.keys.Private)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers[addr.String()] = peer
+}
+
+// RemovePeer stops sending to and receiving from addr
+func (p *PacketConn) RemovePeer(addr net.Addr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.peers, addr.String())
+}
+
+func (p *PacketConn) peer(addr net.Addr) *packetPeer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peers[addr.String()]
+}
+
+// ReadFrom reads the next datagram from a peer and opens it into b. Like UDP, a message longer than b
+// is cut short. Datagrams that don't open, are replayed or come from unknown addresses are dropped.
+func (p *PacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+
+	for {
+		length, addr, err := p.pc.ReadFrom(p.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		msg, ok := p.open(p.buf[:length], addr)
+		if !ok {
+			continue
+		}
+		return copy(b, msg), addr, nil
+	}
+}
+
+// open opens a datagram from addr, it returns false if it should be dropped
+func (p *PacketConn) open(datagram []byte, addr net.Addr) ([]byte, bool) {
+	if len(datagram) < packetOverhead {
+		return nil, false
+	}
+	peer := p.peer(addr)
+	if peer == nil {
+		return nil, false
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], datagram)
+	if [packetNoncePrefixLength]byte(nonce[:packetNoncePrefixLength]) != [packetNoncePrefixLength]byte(peer.publicKey[:packetNoncePrefixLength]) {
+		return nil, false
+	}
+	counter := binary.BigEndian.Uint64(nonce[packetNoncePrefixLength:])
+
+	p.mu.Lock()
+	fresh := peer.window.check(counter)
+	p.mu.Unlock()
+	if !fresh {
+		return nil, false
+	}
+
+	msg, ok := box.OpenAfterPrecomputation(nil, datagram[len(nonce):], &nonce, &peer.sharedKey)
+	if !ok {
+		return nil, false
+	}
+
+	// Only datagrams that opened move the window, so forgeries can't push it forward. Checking again
+	// catches the same datagram opened twice by two readers.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !peer.window.check(counter) {
+		return nil, false
+	}
+	peer.window.update(counter)
+	return msg, true
+}
+
+// WriteTo seals b and sends it to addr, which has to have been added with AddPeer. It returns len(b)
+// when the datagram was sent.
+func (p *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) > maxDatagramLength-packetOverhead {
+		return 0, errors.New("message is too large for a datagram")
+	}
+	peer := p.peer(addr)
+	if peer == nil {
+		return 0, ErrUnknownPeer
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], p.keys.Public[:packetNoncePrefixLength])
+	binary.BigEndian.PutUint64(nonce[packetNoncePrefixLength:], p.counter.Add(1))
+
+	datagram := make([]byte, len(nonce), packetOverhead+len(b))
+	copy(datagram, nonce[:])
+	datagram = box.SealAfterPrecomputation(datagram, b, &nonce, &peer.sharedKey)
+	_, err := p.pc.WriteTo(datagram, addr)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the underlying PacketConn
+func (p *PacketConn) Close() error {
+	return p.pc.Close()
+}
+
+// LocalAddr returns the address of the underlying PacketConn
+func (p *PacketConn) LocalAddr() net.Addr {
+	return p.pc.LocalAddr()
+}
+
+// SetDeadline sets the deadlines of the underlying PacketConn
+func (p *PacketConn) SetDeadline(t time.Time) error {
+	return p.pc.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying PacketConn
+func (p *PacketConn) SetReadDeadline(t time.Time) error {
+	return p.pc.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying PacketConn
+func (p *PacketConn) SetWriteDeadline(t time.Time) error {
+	return p.pc.SetWriteDeadline(t)
+}
+
+// replayWindow remembers which of the last replayWindowSize counters have been seen, like IPsec's
+// anti-replay window (RFC 4303)
+type replayWindow struct {
+	// highest is the highest counter seen, bit i of seen is set if highest-i has been seen
+	highest uint64
+	seen    uint64
+}
+
+// check returns true if counter hasn't been seen and isn't too old
+func (w *replayWindow) check(counter uint64) bool {
+	if w.seen == 0 || counter > w.highest {
+		return true
+	}
+	behind := w.highest - counter
+	return behind < replayWindowSize && w.seen&(1<<behind) == 0
+}
+
+// update marks counter as seen, check must have returned true for it
+func (w *replayWindow) update(counter uint64) {
+	if w.seen == 0 {
+		w.highest, w.seen = counter, 1
+		return
+	}
+	if counter > w.highest {
+		shift := counter - w.highest
+		if shift >= replayWindowSize {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.highest = counter
+		w.seen |= 1
+		return
+	}
+	w.seen |= 1 << (w.highest - counter)
+}
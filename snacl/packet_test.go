@@ -0,0 +1,110 @@
+package snacl
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func mustGenerateKeys(t *testing.T) *Keys {
+	keys, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func listenPacket(t *testing.T, keys *Keys) *PacketConn {
+	p, err := ListenPacket("udp", "127.0.0.1:0", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPacketConn(t *testing.T) {
+	aliceKeys, bobKeys := mustGenerateKeys(t), mustGenerateKeys(t)
+	alice, bob := listenPacket(t, aliceKeys), listenPacket(t, bobKeys)
+	alice.AddPeer(bob.LocalAddr(), &bobKeys.Public)
+	bob.AddPeer(alice.LocalAddr(), &aliceKeys.Public)
+
+	if _, err := alice.WriteTo([]byte("hello bob"), bob.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, addr, err := bob.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello bob" || addr.String() != alice.LocalAddr().String() {
+		t.Fatalf("Unexpected result: %q from %v", buf[:n], addr)
+	}
+
+	if _, err := alice.WriteTo([]byte("x"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}); err != ErrUnknownPeer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPacketConnReplay(t *testing.T) {
+	aliceKeys, bobKeys := mustGenerateKeys(t), mustGenerateKeys(t)
+	alice, bob := listenPacket(t, aliceKeys), listenPacket(t, bobKeys)
+
+	// An attacker in the middle records alice's datagram and sends it to bob twice
+	attacker, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attacker.Close()
+	alice.AddPeer(attacker.LocalAddr(), &bobKeys.Public)
+	bob.AddPeer(attacker.LocalAddr(), &aliceKeys.Public)
+
+	if _, err := alice.WriteTo([]byte("pay 10"), attacker.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	datagram := make([]byte, 1024)
+	n, _, err := attacker.ReadFrom(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := attacker.WriteTo(datagram[:n], bob.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 64)
+	if n, _, err := bob.ReadFrom(buf); err != nil || string(buf[:n]) != "pay 10" {
+		t.Fatalf("Unexpected result: %q %v", buf[:n], err)
+	}
+	bob.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := bob.ReadFrom(buf); err == nil {
+		t.Fatal("Unexpected result. The replayed datagram was accepted.")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	for _, tt := range []struct {
+		counter uint64
+		fresh   bool
+	}{
+		{100, true},
+		{100, false},
+		{102, true},
+		{101, true},
+		{101, false},
+		{102 - replayWindowSize, false},
+		{103 - replayWindowSize, true},
+		{1000, true},
+		{102, false},
+	} {
+		if got := w.check(tt.counter); got != tt.fresh {
+			t.Fatalf("Unexpected result for %d: %v", tt.counter, got)
+		}
+		if tt.fresh {
+			w.update(tt.counter)
+		}
+	}
+}
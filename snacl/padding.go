@@ -0,0 +1,79 @@
+package snacl
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// When both sides set Options.Padding, every record is padded before it's sealed so the frame lengths
+// don't give away the exact message lengths:
+//
+//	[type uint8][data][0x80][zeros]
+//
+// The 0x80 marks where the data ends, like ISO/IEC 7816-4 padding. Each side pads what it sends with
+// its own Padding, the other side only has to strip it. Padding never takes a record past the
+// negotiated MaxMessageLength, so the longest messages are padded less.
+
+// padMarker ends the data of a padded record
+const padMarker = 0x80
+
+// padMarkerLength is the size of padMarker
+const padMarkerLength = 1
+
+// Padding decides how long a padded record is
+type Padding interface {
+	// PaddedLength returns the length to pad n bytes to, at least n
+	PaddedLength(n int) int
+}
+
+// PadToBlock pads records to a multiple of size bytes
+func PadToBlock(size int) Padding {
+	if size <= 0 {
+		panic("snacl: PadToBlock size must be positive")
+	}
+	return blockPadding(size)
+}
+
+type blockPadding int
+
+func (b blockPadding) PaddedLength(n int) int {
+	size := int(b)
+	return (n + size - 1) / size * size
+}
+
+// Padme pads records with the Padmé scheme from "Reducing Metadata Leakage from Encrypted Files and
+// Communication with PURBs": the padding is at most 12% of the record and only O(log log n) bits of
+// the length are left.
+var Padme Padding = padme{}
+
+type padme struct{}
+
+func (padme) PaddedLength(n int) int {
+	if n < 2 {
+		return n
+	}
+	e := bits.Len(uint(n)) - 1
+	s := bits.Len(uint(e))
+	mask := 1<<(e-s) - 1
+	return (n + mask) &^ mask
+}
+
+// padRecord appends the padding for a record of n bytes, including the marker, to record
+func padRecord(record []byte, padding Padding, maxLength int) []byte {
+	n := len(record) + padMarkerLength
+	padded := min(max(padding.PaddedLength(n), n), maxLength)
+	record = append(record, padMarker)
+	return append(record, make([]byte, padded-n)...)
+}
+
+// unpadRecord strips the padding from an opened record
+func unpadRecord(record []byte) ([]byte, error) {
+	i := len(record) - 1
+	for i >= 0 && record[i] == 0 {
+		i--
+	}
+	if i < 0 || record[i] != padMarker {
+		return nil, fmt.Errorf("%w: bad padding", ErrBadRecord)
+	}
+	return record[:i], nil
+}
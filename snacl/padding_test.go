@@ -0,0 +1,96 @@
+package snacl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPaddedLength(t *testing.T) {
+	for _, tt := range []struct {
+		padding   Padding
+		n, padded int
+	}{
+		{PadToBlock(256), 1, 256},
+		{PadToBlock(256), 256, 256},
+		{PadToBlock(256), 257, 512},
+		{Padme, 1, 1},
+		{Padme, 9, 10},
+		{Padme, 100, 104},
+		{Padme, 1000, 1024},
+		{Padme, 31999, 32768},
+	} {
+		if got := tt.padding.PaddedLength(tt.n); got != tt.padded {
+			t.Fatalf("Unexpected padded length of %d: %d != %d", tt.n, got, tt.padded)
+		}
+	}
+}
+
+func TestUnpadRecord(t *testing.T) {
+	record := padRecord([]byte{recordData, 'h', 'i', 0}, PadToBlock(16), 100)
+	if len(record) != 16 {
+		t.Fatalf("Unexpected length: %d", len(record))
+	}
+	// Zeros at the end of the data aren't mistaken for padding
+	got, err := unpadRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{recordData, 'h', 'i', 0}) {
+		t.Fatalf("Unexpected record: %v", got)
+	}
+
+	for _, bad := range [][]byte{{}, {0, 0}, {recordData, 'x', 1, 0}} {
+		if _, err := unpadRecord(bad); !errors.Is(err, ErrBadRecord) {
+			t.Fatalf("Unexpected error for %v: %v", bad, err)
+		}
+	}
+}
+
+func TestEncoderPadding(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newEncoder(&buf, &[32]byte{'k', 'e', 'y'})
+	enc.typed = true
+	enc.padding = PadToBlock(1024)
+	if err := enc.Encode(&Message{Data: []byte("short")}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := frameLength(1024); buf.Len() != expected {
+		t.Fatalf("Unexpected frame length: %d != %d", buf.Len(), expected)
+	}
+
+	dec := newDecoder(&buf, &[32]byte{'k', 'e', 'y'})
+	dec.typed = true
+	dec.padded = true
+	msg := &Message{}
+	if err := dec.Decode(msg); err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "short" {
+		t.Fatalf("Unexpected result: %q", msg.Data)
+	}
+}
+
+func TestConnPadding(t *testing.T) {
+	client, server := pipeOptions(t, &Options{Padding: PadToBlock(1024)}, &Options{Padding: Padme})
+	if !client.ConnectionState().Padded || !server.ConnectionState().Padded {
+		t.Fatal("Unexpected result. Padding wasn't negotiated.")
+	}
+
+	expected := []byte("short")
+	go func() {
+		client.Write(expected)
+		// The longest message can't be padded past MaxMessageLength
+		client.Write(make([]byte, MaxMessageLength))
+	}()
+	msg, err := server.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+	}
+	if msg, err = server.ReadMsg(); err != nil || len(msg.Data) != MaxMessageLength {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package snacl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Errors for frames that can't be read. Length errors may be wrapped with more detail, use errors.Is.
+var (
+	// ErrFrameEmpty is returned for a frame with a length prefix of zero
+	ErrFrameEmpty = wire.ErrZeroLength
+	// ErrFrameTooLarge is returned for a frame longer than a MaxMessageLength message can be
+	ErrFrameTooLarge = wire.ErrTooLong
+	// ErrFrameTooShort is returned for a frame too short to hold a nonce and the box overhead
+	ErrFrameTooShort = errors.New("frame is too short for a nonce and a box")
+	// ErrDecrypt is returned when a box fails to open, usually because the encrypted data is malformed
+	// or was encrypted with different keys
+	ErrDecrypt = errors.New("failed to decrypt box, encrypted data is likely malformed")
+)
+
+// minFrameLength is the length prefix of a NaClBox frame holding an empty message
+const minFrameLength = wire.NonceLength + box.Overhead
+
+// checkFrameLength checks a frame's length prefix before anything is allocated or sliced for it.
+// maxLength is the biggest message the frame may hold, and minLength is the length prefix of an empty
+// message, the cipher's nonce and overhead.
+func checkFrameLength(length uint32, maxLength, minLength int) error {
+	// Restrict length to stop memory allocation attacks
+	err := wire.CheckLength(length, uint32(frameLength(maxLength)-wire.HeaderLength))
+	if err != nil {
+		return err
+	}
+	// Even an empty message has a nonce and the box overhead, anything shorter can't be valid
+	if length < uint32(minLength) {
+		return fmt.Errorf("%w (len:%d min:%d)", ErrFrameTooShort, length, minLength)
+	}
+	return nil
+}
+
+// Frame is a frame as it's sent on the wire, before the box is opened
+type Frame struct {
+	Nonce [wire.NonceLength]byte
+	// Box is the encrypted message, it points into the data the frame was parsed from
+	Box []byte
+}
+
+// ParseFrame parses the frame at the start of data without decrypting it, and returns it with the
+// number of bytes it took up. The length prefix is checked the same way a Reader checks it.
+// If data doesn't hold a whole frame, ParseFrame returns io.ErrUnexpectedEOF.
+func ParseFrame(data []byte) (*Frame, int, error) {
+	if len(data) < wire.HeaderLength {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	err := checkFrameLength(length, MaxMessageLength, minFrameLength)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n := wire.HeaderLength + int(length)
+	if len(data) < n {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	f := &Frame{Box: data[wire.HeaderLength+wire.NonceLength : n]}
+	copy(f.Nonce[:], data[wire.HeaderLength:])
+	return f, n, nil
+}
@@ -0,0 +1,136 @@
+package snacl
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+)
+
+// EventType is the kind of thing a Parser found in the stream
+type EventType int
+
+const (
+	// EventPublicKey is the peer's public key, the whole of the Version0 handshake
+	EventPublicKey EventType = iota + 1
+	// EventFrame is a whole frame
+	EventFrame
+)
+
+// Event is something a Parser found in the stream
+type Event struct {
+	Type EventType
+	// PublicKey is set for EventPublicKey
+	PublicKey [32]byte
+	// Frame is set for EventFrame. Its Box belongs to the event, Feed doesn't reuse it.
+	Frame *Frame
+}
+
+type parserState int
+
+const (
+	stateKey parserState = iota
+	stateLength
+	stateBody
+)
+
+// Parser parses the handshake and the frames after it from bytes that are pushed to it, instead of
+// reading them from an io.Reader. It never blocks, and the bytes can be split up any way at all, which
+// makes it easy to fuzz and to use on transports that hand over data in packets or callbacks.
+//
+// Parser checks frames the same way a Reader does but doesn't open them.
+type Parser struct {
+	state parserState
+	// field is the part of the stream being collected, n is how much of it has been fed so far
+	field []byte
+	n     int
+	// scratch holds the key and length fields so they don't allocate
+	scratch [32]byte
+	// err is sticky, once the stream is bad nothing after it can be trusted
+	err error
+}
+
+// NewParser returns a Parser for a stream that starts with the Version0 handshake if handshake is set,
+// or with the first frame if it's not.
+func NewParser(handshake bool) *Parser {
+	p := &Parser{}
+	if handshake {
+		p.expect(stateKey, p.scratch[:])
+	} else {
+		p.expect(stateLength, p.scratch[:wire.HeaderLength])
+	}
+	return p
+}
+
+// Feed parses data and returns the events it completed. Partial fields are kept for the next call.
+// On an error Feed returns the events before it, and every call after returns the same error.
+func (p *Parser) Feed(data []byte) ([]Event, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	var events []Event
+	for len(data) > 0 {
+		n := copy(p.field[p.n:], data)
+		p.n += n
+		data = data[n:]
+		if p.n < len(p.field) {
+			break
+		}
+
+		ev, err := p.complete()
+		if err != nil {
+			p.err = err
+			return events, err
+		}
+		if ev != nil {
+			events = append(events, *ev)
+		}
+	}
+	return events, nil
+}
+
+// EOF tells the parser the stream has ended, it returns io.ErrUnexpectedEOF if the stream ended
+// part way through the handshake or a frame.
+func (p *Parser) EOF() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.n > 0 || p.state == stateBody {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// expect starts collecting the next field into field
+func (p *Parser) expect(state parserState, field []byte) {
+	p.state = state
+	p.field = field
+	p.n = 0
+}
+
+// complete handles a field once all of it has been fed
+func (p *Parser) complete() (*Event, error) {
+	switch p.state {
+	case stateKey:
+		ev := &Event{Type: EventPublicKey}
+		copy(ev.PublicKey[:], p.field)
+		p.expect(stateLength, p.scratch[:wire.HeaderLength])
+		return ev, nil
+
+	case stateLength:
+		length := binary.BigEndian.Uint32(p.field)
+		err := checkFrameLength(length, MaxMessageLength, minFrameLength)
+		if err != nil {
+			return nil, err
+		}
+		p.expect(stateBody, make([]byte, length))
+		return nil, nil
+
+	default:
+		f := &Frame{Box: p.field[wire.NonceLength:]}
+		copy(f.Nonce[:], p.field)
+		p.expect(stateLength, p.scratch[:wire.HeaderLength])
+		return &Event{Type: EventFrame, Frame: f}, nil
+	}
+}
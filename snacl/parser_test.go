@@ -0,0 +1,63 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	keys, err := GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := SealMessage(&keys.Private, &keys.Public, []byte("hello world\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handshake followed by two frames, fed one byte at a time
+	stream := append(append(append([]byte{}, keys.Public[:]...), frame...), frame...)
+	p := NewParser(true)
+	var events []Event
+	for i := range stream {
+		evs, err := p.Feed(stream[i : i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evs...)
+	}
+	if err := p.EOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 || events[0].Type != EventPublicKey || events[1].Type != EventFrame || events[2].Type != EventFrame {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+	if events[0].PublicKey != keys.Public {
+		t.Fatal("Unexpected public key")
+	}
+	if !bytes.Equal(events[1].Frame.Box, frame[4+24:]) {
+		t.Fatal("Unexpected box")
+	}
+
+	// Half a frame is an unexpected EOF
+	p = NewParser(false)
+	if _, err := p.Feed(frame[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.EOF(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A bad length is sticky
+	p = NewParser(false)
+	if _, err := p.Feed([]byte{0xff, 0xff, 0xff, 0xff}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := p.Feed(frame); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Package policy enforces content rules on the messages a server receives, for operators exposing
+// a service publicly. A Policy is applied to a connection as an interceptor, see snacl.WrapConn.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Errors returned for messages that break a rule. They're wrapped by a *Violation.
+var (
+	ErrRateLimited = errors.New("policy: message rate exceeded")
+	ErrTooLarge    = errors.New("policy: message too large")
+	ErrDenied      = errors.New("policy: message matches a deny rule")
+	ErrNotUTF8     = errors.New("policy: message is not valid UTF-8")
+)
+
+// Action is what happens to a message that breaks a rule
+type Action int
+
+const (
+	// Reject discards the message and returns the violation from Read. The connection stays usable,
+	// the next Read gets the next message.
+	Reject Action = iota
+	// Close discards the message, closes the connection and returns the violation from Read
+	Close
+	// Log logs the violation and lets the message through
+	Log
+)
+
+// Violation describes a message that broke a rule
+type Violation struct {
+	// Err is the rule that was broken, one of the Err variables
+	Err error
+	// Detail says more about what was wrong with the message
+	Detail string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s (%s)", v.Err, v.Detail)
+}
+
+// Unwrap returns the rule that was broken, so errors.Is works with the Err variables
+func (v *Violation) Unwrap() error {
+	return v.Err
+}
+
+// Policy is a set of rules for incoming messages. Zero fields don't restrict anything.
+// A Policy can be shared by any number of connections, rate limits are kept per connection.
+type Policy struct {
+	// MaxRate is the number of messages per second a connection may send on average
+	MaxRate float64
+	// Burst is the number of messages a connection may send at once before MaxRate applies.
+	// If it's 0, a burst of one second's worth of messages is allowed, and at least one message.
+	Burst int
+	// MaxSize is the maximum size of a message in bytes
+	MaxSize int
+	// Deny rejects messages matching any of the expressions
+	Deny []*regexp.Regexp
+	// UTF8 rejects messages that aren't valid UTF-8, which rules out binary payloads
+	UTF8 bool
+
+	// Action is what happens to messages that break a rule
+	Action Action
+	// Logf logs violations for the Log action. If nil, log.Printf is used.
+	Logf func(format string, args ...interface{})
+}
+
+// Interceptor returns an interceptor enforcing the policy on the incoming messages of one connection.
+// conn is closed by the Close action. Outgoing messages aren't checked.
+func (p *Policy) Interceptor(conn io.Closer) snacl.Interceptor {
+	burst := float64(p.Burst)
+	if burst == 0 {
+		// A bucket that never holds a whole token would reject everything below one message a second
+		burst = max(1, p.MaxRate)
+	}
+	e := &enforcer{policy: p, conn: conn, burst: burst, tokens: burst, last: time.Now()}
+	return snacl.InterceptorFuncs{IncomingFunc: e.incoming}
+}
+
+// Wrap applies the policy to conn, it's short for snacl.WrapConn(conn, p.Interceptor(conn))
+func (p *Policy) Wrap(conn *snacl.Conn) *snacl.InterceptedConn {
+	return snacl.WrapConn(conn, p.Interceptor(conn))
+}
+
+// enforcer holds the per connection state of a Policy
+type enforcer struct {
+	policy *Policy
+	conn   io.Closer
+
+	// tokens is a token bucket for the rate limit, refilled at MaxRate tokens per second up to burst
+	mu     sync.Mutex
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (e *enforcer) incoming(msg []byte) ([]byte, error) {
+	v := e.check(msg)
+	if v == nil {
+		return msg, nil
+	}
+
+	switch e.policy.Action {
+	case Log:
+		logf := e.policy.Logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		logf("%v", v)
+		return msg, nil
+	case Close:
+		e.conn.Close()
+	}
+	return nil, v
+}
+
+// check returns the first rule msg breaks, or nil
+func (e *enforcer) check(msg []byte) *Violation {
+	p := e.policy
+
+	if p.MaxRate > 0 && !e.allow() {
+		return &Violation{Err: ErrRateLimited, Detail: fmt.Sprintf("max %g/s", p.MaxRate)}
+	}
+	if p.MaxSize > 0 && len(msg) > p.MaxSize {
+		return &Violation{Err: ErrTooLarge, Detail: fmt.Sprintf("len:%d max:%d", len(msg), p.MaxSize)}
+	}
+	if p.UTF8 && !utf8.Valid(msg) {
+		return &Violation{Err: ErrNotUTF8, Detail: fmt.Sprintf("len:%d", len(msg))}
+	}
+	for _, re := range p.Deny {
+		if re.Match(msg) {
+			return &Violation{Err: ErrDenied, Detail: re.String()}
+		}
+	}
+	return nil
+}
+
+// allow takes a token from the bucket if there is one
+func (e *enforcer) allow() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	e.tokens += now.Sub(e.last).Seconds() * e.policy.MaxRate
+	if e.tokens > e.burst {
+		e.tokens = e.burst
+	}
+	e.last = now
+
+	if e.tokens < 1 {
+		return false
+	}
+	e.tokens--
+	return true
+}
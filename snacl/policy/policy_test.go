@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+// closer records whether it was closed
+type closer struct {
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPolicyRules(t *testing.T) {
+	p := &Policy{
+		MaxSize: 16,
+		Deny:    []*regexp.Regexp{regexp.MustCompile(`DROP TABLE`)},
+		UTF8:    true,
+	}
+	i := p.Interceptor(&closer{})
+
+	tests := []struct {
+		msg string
+		err error
+	}{
+		{"hello world\n", nil},
+		{"hello world hello world\n", ErrTooLarge},
+		{"DROP TABLE keys", ErrDenied},
+		{"\xff\xfe", ErrNotUTF8},
+	}
+	for _, test := range tests {
+		_, err := i.Incoming([]byte(test.msg))
+		if !errors.Is(err, test.err) {
+			t.Fatalf("Unexpected error for %q: %v != %v", test.msg, err, test.err)
+		}
+	}
+}
+
+func TestPolicyRate(t *testing.T) {
+	p := &Policy{MaxRate: 1, Burst: 2}
+	i := p.Interceptor(&closer{})
+
+	for n := 0; n < 2; n++ {
+		if _, err := i.Incoming([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := i.Incoming([]byte("hello")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Unexpected error: %v != %v", err, ErrRateLimited)
+	}
+
+	// Every connection gets its own bucket
+	if _, err := p.Interceptor(&closer{}).Incoming([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicyFractionalRate(t *testing.T) {
+	// One message every two seconds, the default burst still lets the first one through
+	i := (&Policy{MaxRate: 0.5}).Interceptor(&closer{})
+	if _, err := i.Incoming([]byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := i.Incoming([]byte("hello")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Unexpected error: %v != %v", err, ErrRateLimited)
+	}
+}
+
+func TestPolicyActions(t *testing.T) {
+	c := &closer{}
+	p := &Policy{MaxSize: 1, Action: Close}
+	if _, err := p.Interceptor(c).Incoming([]byte("hello")); err == nil || !c.closed {
+		t.Fatalf("Unexpected result: err:%v closed:%v", err, c.closed)
+	}
+
+	var logged string
+	p = &Policy{MaxSize: 1, Action: Log, Logf: func(format string, args ...interface{}) {
+		logged = fmt.Sprintf(format, args...)
+	}}
+	msg, err := p.Interceptor(&closer{}).Incoming([]byte("hello"))
+	if err != nil || string(msg) != "hello" || logged == "" {
+		t.Fatalf("Unexpected result: msg:%s err:%v logged:%q", msg, err, logged)
+	}
+}
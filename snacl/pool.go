@@ -0,0 +1,48 @@
+package snacl
+
+import (
+	"sync"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// maxFrameLength is the size of the biggest frame with the default MaxMessageLength, including the length prefix
+const maxFrameLength = wire.HeaderLength + wire.NonceLength + MaxMessageLength + box.Overhead
+
+// frameLength returns the size of the frame for a message of msgLength bytes, including the length prefix
+func frameLength(msgLength int) int {
+	return wire.HeaderLength + wire.NonceLength + msgLength + box.Overhead
+}
+
+// poolBufferLength is the size of pooled buffers, big enough for a whole Version1 frame of the default size
+// with padding and associated data
+const poolBufferLength = maxFrameLength + recordHeaderLength + padMarkerLength + aadHeaderLength + MaxAssociatedDataLength
+
+// bufferPool holds scratch buffers of poolBufferLength bytes so reading and writing messages doesn't
+// allocate once a connection is up and running. It holds pointers so Put doesn't allocate either.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, poolBufferLength)
+		return &buf
+	},
+}
+
+// getBuffer returns a scratch buffer of at least size bytes. Buffers up to poolBufferLength come from
+// the pool, bigger ones are only needed when a connection negotiated a bigger MaxMessageLength.
+func getBuffer(size int) *[]byte {
+	if size > poolBufferLength {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return bufferPool.Get().(*[]byte)
+}
+
+// putBuffer zeroes a buffer from getBuffer, since it may have held plaintext or keys, and returns it to
+// the pool. It must not be used afterwards.
+func putBuffer(buf *[]byte) {
+	clear(*buf)
+	if len(*buf) == poolBufferLength {
+		bufferPool.Put(buf)
+	}
+}
@@ -0,0 +1,156 @@
+package snacl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// A Dialer with Proxy set connects through a proxy, for clients behind a corporate proxy or on Tor.
+// Supported proxies are socks5:// and socks5h://, which are the same here since the proxy always
+// resolves names, and http:// for HTTP CONNECT. Credentials go in the URL's user info. Only tcp
+// networks are proxied.
+//
+// .onion addresses can only be reached through a SOCKS proxy like Tor's, they're handed to it as they
+// are, never resolved locally.
+
+// ErrOnionWithoutProxy is returned when dialing a .onion address without a proxy
+var ErrOnionWithoutProxy = errors.New(".onion addresses need a SOCKS proxy such as Tor")
+
+// ProxyFromEnvironment is a Dialer.Proxy that uses the ALL_PROXY (or all_proxy) environment variable,
+// except for hosts in NO_PROXY (or no_proxy) and localhost, in the same format net/http uses for
+// HTTP_PROXY and NO_PROXY. A value without a scheme is taken to be an HTTP proxy.
+func ProxyFromEnvironment(addr string) (*url.URL, error) {
+	cfg := &httpproxy.Config{
+		HTTPProxy: getEnvAny("ALL_PROXY", "all_proxy"),
+		NoProxy:   getEnvAny("NO_PROXY", "no_proxy"),
+	}
+	return cfg.ProxyFunc()(&url.URL{Scheme: "http", Host: addr})
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// dialProxy opens the underlying connection to addr for d, through a proxy if d.Proxy names one
+func (d *Dialer) dialProxy(ctx context.Context, netDialer *net.Dialer, network, addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if d.Proxy != nil && strings.HasPrefix(network, "tcp") {
+		var err error
+		proxyURL, err = d.Proxy(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if proxyURL == nil {
+		if host, _, err := net.SplitHostPort(addr); err == nil && isOnion(host) {
+			return nil, ErrOnionWithoutProxy
+		}
+		return netDialer.DialContext(ctx, network, addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, netDialer)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	case "http":
+		return dialHTTPConnect(ctx, netDialer, proxyURL, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// isOnion returns true if host is a Tor onion service
+func isOnion(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".onion")
+}
+
+// dialHTTPConnect connects to addr through the HTTP proxy at proxyURL with a CONNECT request
+func dialHTTPConnect(ctx context.Context, netDialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := netDialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like the handshake, ctx being done interrupts the request by expiring the deadlines
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	br := bufio.NewReader(conn)
+	err = req.Write(conn)
+	if err == nil {
+		var resp *http.Response
+		resp, err = http.ReadResponse(br, req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("proxy refused to connect to %s: %s", addr, resp.Status)
+			}
+		}
+	}
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	// The server may have spoken already, whatever was read past the response belongs to the connection
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads start with what was already buffered
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.r.Read(p)
+}
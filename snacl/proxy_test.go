@@ -0,0 +1,186 @@
+package snacl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// echoServer accepts secure connections and echoes a message on each, it returns the address
+func echoServer(t *testing.T) string {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				msg, err := conn.(*Conn).ReadMsg()
+				if err == nil {
+					conn.Write(msg.Data)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// checkEcho dials addr with d and checks the echo server answers
+func checkEcho(t *testing.T, d *Dialer, addr string) {
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Unexpected result: %q %v", buf[:n], err)
+	}
+}
+
+// socks5Proxy is a SOCKS5 proxy without authentication that connects to the hosts in routes, it returns
+// the proxy's address and a channel with the addresses it was asked for
+func socks5Proxy(t *testing.T, routes map[string]string) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	requested := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				// Greeting: version, methods
+				greeting := make([]byte, 2)
+				io.ReadFull(br, greeting)
+				io.ReadFull(br, make([]byte, greeting[1]))
+				conn.Write([]byte{5, 0})
+				// Request: version, connect, reserved, domain name, length, name, port
+				req := make([]byte, 5)
+				if _, err := io.ReadFull(br, req); err != nil || req[3] != 3 {
+					return
+				}
+				host := make([]byte, req[4]+2)
+				io.ReadFull(br, host)
+				addr := net.JoinHostPort(string(host[:req[4]]), strconv.Itoa(int(binary.BigEndian.Uint16(host[req[4]:]))))
+				requested <- addr
+				target, err := net.Dial("tcp", routes[addr])
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l.Addr().String(), requested
+}
+
+func TestDialSOCKS5Onion(t *testing.T) {
+	server := echoServer(t)
+	proxyAddr, requested := socks5Proxy(t, map[string]string{"example.onion:80": server})
+
+	proxyURL := &url.URL{Scheme: "socks5h", Host: proxyAddr}
+	d := &Dialer{Proxy: func(string) (*url.URL, error) { return proxyURL, nil }}
+	checkEcho(t, d, "example.onion:80")
+	// The name reaches the proxy as it is, it's never resolved locally
+	if addr := <-requested; addr != "example.onion:80" {
+		t.Fatalf("Unexpected result: %s", addr)
+	}
+
+	if _, err := Dial("tcp", "example.onion:80", nil); !errors.Is(err, ErrOnionWithoutProxy) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestDialHTTPConnect(t *testing.T) {
+	server := echoServer(t)
+	proxy := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			http.Error(w, "no", http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(target, brw)
+		io.Copy(conn, target)
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(l)
+	defer proxy.Close()
+
+	d := &Dialer{Proxy: func(string) (*url.URL, error) {
+		return url.Parse("http://user:pass@" + l.Addr().String())
+	}}
+	checkEcho(t, d, server)
+
+	d.Proxy = func(string) (*url.URL, error) { return url.Parse("http://" + l.Addr().String()) }
+	if _, err := d.DialContext(context.Background(), "tcp", server); err == nil {
+		t.Fatal("Unexpected result. Connected without the proxy's credentials.")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("ALL_PROXY", "socks5://127.0.0.1:9050")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	for _, tt := range []struct {
+		addr  string
+		proxy string
+	}{
+		{"example.com:80", "socks5://127.0.0.1:9050"},
+		{"example.onion:80", "socks5://127.0.0.1:9050"},
+		{"internal.example.com:80", ""},
+		{"localhost:80", ""},
+	} {
+		proxyURL, err := ProxyFromEnvironment(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if proxyURL != nil {
+			got = proxyURL.String()
+		}
+		if got != tt.proxy {
+			t.Fatalf("Unexpected result for %s: %v", tt.addr, proxyURL)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package pubsub is a small message bus over snacl connections. Clients subscribe to named topics and
+// publish messages to them, and the server sends every message on to each of the topic's subscribers
+// over their own Conn. The server decides who may do what from the connection's authenticated state,
+// see Server.Authorize.
+//
+// Every frame is a single snacl message:
+//
+//	[type uint8][topic length uint8][topic][data]
+//
+// A published message reaches the subscribers as the same frame with frameMessage as its type. Each
+// connection negotiates its own MaxMessageLength, a subscriber whose frames can't be that big doesn't
+// get the message and the server logs it.
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Frame types
+const (
+	// frameSubscribe asks for the topic's messages, it has no data
+	frameSubscribe byte = 0
+	// frameUnsubscribe stops the topic's messages, it has no data
+	frameUnsubscribe byte = 1
+	// framePublish sends the data to the topic's subscribers
+	framePublish byte = 2
+	// frameMessage is a message published to a topic the client subscribed to
+	frameMessage byte = 3
+	// frameDenied says Server.Authorize refused a frame, the data is the refused frame's type
+	frameDenied byte = 4
+)
+
+// MaxTopicLength is the longest topic name
+const MaxTopicLength = 255
+
+var (
+	// ErrProtocol is returned when the other side breaks the protocol, the connection is closed
+	ErrProtocol = errors.New("pubsub: protocol error")
+)
+
+// DeniedError is returned by Client.Receive when the server refused to let the client subscribe or
+// publish to a topic. The connection is still usable.
+type DeniedError struct {
+	Topic string
+	// Publish is set if a publish was refused, and not a subscribe
+	Publish bool
+}
+
+func (e *DeniedError) Error() string {
+	if e.Publish {
+		return fmt.Sprintf("pubsub: not allowed to publish to %q", e.Topic)
+	}
+	return fmt.Sprintf("pubsub: not allowed to subscribe to %q", e.Topic)
+}
+
+// Message is a message published to a topic
+type Message struct {
+	Topic string
+	Data  []byte
+}
+
+// Server passes published messages on to subscribers, the zero value is ready to use and lets everyone
+// do everything
+type Server struct {
+	// Authorize is called before a client subscribes or publishes to a topic, with the client's
+	// connection state, so it can check ConnectionState.PeerPublicKey or PeerCertificate. A refused
+	// frame is dropped and the client is told. nil allows everything.
+	Authorize func(state snacl.ConnectionState, topic string, publish bool) bool
+
+	mu sync.Mutex
+	// topics has the connections subscribed to every topic
+	topics map[string]map[*snacl.Conn]struct{}
+}
+
+// ServeConn handles a client's frames until it goes away, which returns nil, and then closes conn.
+// Messages are sent to subscribers with Conn.WriteMsgAsync, so a subscriber that can't keep up with
+// Options.WriteQueueSize messages is disconnected rather than holding up the publisher.
+func (s *Server) ServeConn(conn *snacl.Conn) error {
+	defer conn.Close()
+	err := conn.Handshake()
+	if err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+
+	subscribed := make(map[string]struct{})
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for topic := range subscribed {
+			s.remove(topic, conn)
+		}
+	}()
+
+	for {
+		msg, err := conn.ReadMsg()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		typ, topic, _, err := parseFrame(msg.Data)
+		if err != nil {
+			return err
+		}
+		if typ != frameSubscribe && typ != frameUnsubscribe && typ != framePublish {
+			return fmt.Errorf("%w: frame type %d sent to a server", ErrProtocol, typ)
+		}
+
+		if typ != frameUnsubscribe && s.Authorize != nil && !s.Authorize(state, topic, typ == framePublish) {
+			err = writeFrame(conn, frameDenied, topic, []byte{typ})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch typ {
+		case frameSubscribe:
+			subscribed[topic] = struct{}{}
+			s.mu.Lock()
+			if s.topics == nil {
+				s.topics = make(map[string]map[*snacl.Conn]struct{})
+			}
+			if s.topics[topic] == nil {
+				s.topics[topic] = make(map[*snacl.Conn]struct{})
+			}
+			s.topics[topic][conn] = struct{}{}
+			s.mu.Unlock()
+		case frameUnsubscribe:
+			delete(subscribed, topic)
+			s.mu.Lock()
+			s.remove(topic, conn)
+			s.mu.Unlock()
+		case framePublish:
+			// The frame is reused for the subscribers, only the type changes
+			msg.Data[0] = frameMessage
+			s.publish(topic, msg.Data)
+		}
+	}
+}
+
+// remove unsubscribes conn from topic, s.mu must be held
+func (s *Server) remove(topic string, conn *snacl.Conn) {
+	delete(s.topics[topic], conn)
+	if len(s.topics[topic]) == 0 {
+		delete(s.topics, topic)
+	}
+}
+
+// publish queues frame for every subscriber to topic
+func (s *Server) publish(topic string, frame []byte) {
+	s.mu.Lock()
+	subscribers := make([]*snacl.Conn, 0, len(s.topics[topic]))
+	for conn := range s.topics[topic] {
+		subscribers = append(subscribers, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range subscribers {
+		if max := conn.ConnectionState().MaxMessageLength; len(frame) > max {
+			log.Printf("pubsub: message on %q is too large for %v (len:%d max:%d), dropping it", topic, conn.RemoteAddr(), len(frame), max)
+			continue
+		}
+		err := conn.WriteMsgAsync(frame, nil)
+		if errors.Is(err, snacl.ErrQueueFull) {
+			log.Printf("pubsub: %v can't keep up, disconnecting it", conn.RemoteAddr())
+			// Close waits for the queue, the deadline fails the write it's stuck on. Its ServeConn then
+			// fails and unsubscribes it.
+			conn.SetWriteDeadline(time.Now())
+			go conn.Close()
+		}
+	}
+}
+
+// Client subscribes and publishes to topics over a snacl.Conn
+type Client struct {
+	conn *snacl.Conn
+}
+
+// NewClient does conn's handshake and returns a client using it
+func NewClient(conn *snacl.Conn) (*Client, error) {
+	err := conn.Handshake()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Subscribe asks for the messages published to topic, they're read with Receive. It doesn't wait for
+// the server, a refusal comes from Receive as a DeniedError.
+func (c *Client) Subscribe(topic string) error {
+	return writeFrame(c.conn, frameSubscribe, topic, nil)
+}
+
+// Unsubscribe stops the messages published to topic, some may already be on their way
+func (c *Client) Unsubscribe(topic string) error {
+	return writeFrame(c.conn, frameUnsubscribe, topic, nil)
+}
+
+// Publish sends msg to everyone subscribed to topic, including this client if it is. It doesn't wait
+// for the server, a refusal comes from Receive as a DeniedError.
+func (c *Client) Publish(topic string, msg []byte) error {
+	return writeFrame(c.conn, framePublish, topic, msg)
+}
+
+// Receive reads the next message published to a topic the client subscribed to. It returns io.EOF
+// when the server closes the connection.
+func (c *Client) Receive() (*Message, error) {
+	msg, err := c.conn.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	typ, topic, data, err := parseFrame(msg.Data)
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	switch typ {
+	case frameMessage:
+		return &Message{Topic: topic, Data: data}, nil
+	case frameDenied:
+		return nil, &DeniedError{Topic: topic, Publish: len(data) == 1 && data[0] == framePublish}
+	default:
+		c.conn.Close()
+		return nil, fmt.Errorf("%w: frame type %d sent to a client", ErrProtocol, typ)
+	}
+}
+
+// Close closes the underlying connection, which unsubscribes from everything
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// writeFrame sends a frame as a single message
+func writeFrame(conn *snacl.Conn, typ byte, topic string, data []byte) error {
+	if topic == "" || len(topic) > MaxTopicLength {
+		return fmt.Errorf("pubsub: topic must be 1 to %d bytes long", MaxTopicLength)
+	}
+	frame := make([]byte, 0, 2+len(topic)+len(data))
+	frame = append(frame, typ, byte(len(topic)))
+	frame = append(frame, topic...)
+	frame = append(frame, data...)
+	return conn.WriteMsg(frame)
+}
+
+// parseFrame splits a frame into its type, topic and data
+func parseFrame(frame []byte) (typ byte, topic string, data []byte, err error) {
+	if len(frame) < 2 || frame[1] == 0 || len(frame) < 2+int(frame[1]) {
+		return 0, "", nil, fmt.Errorf("%w: short frame", ErrProtocol)
+	}
+	end := 2 + int(frame[1])
+	return frame[0], string(frame[2:end]), frame[end:], nil
+}
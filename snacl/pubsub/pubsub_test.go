@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/securetest"
+)
+
+// connect returns a client served by s, opts configures the client and may be nil
+func connect(t *testing.T, s *Server, opts *snacl.Options) *Client {
+	c, conn := securetest.PipeOptions(opts, nil)
+	go s.ServeConn(conn)
+	client, err := NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// receive reads a message and checks it's msg on topic, a message that doesn't come fails the test
+func receive(t *testing.T, c *Client, topic, msg string) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+	m, err := c.Receive()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.Topic != topic || string(m.Data) != msg {
+		t.Fatalf("Unexpected result: %s %q", m.Topic, m.Data)
+	}
+}
+
+// subscribe subscribes c to topic and waits until the server has it. Nothing answers a subscription, so
+// c publishes to a topic of its own, which the server handles after the subscription.
+func subscribe(t *testing.T, c *Client, topic string) {
+	t.Helper()
+	ready := fmt.Sprintf("ready %p", c)
+	c.Subscribe(topic)
+	c.Subscribe(ready)
+	c.Publish(ready, nil)
+	receive(t, c, ready, "")
+	c.Unsubscribe(ready)
+}
+
+func TestPublish(t *testing.T) {
+	s := &Server{}
+	news, sport, publisher := connect(t, s, nil), connect(t, s, nil), connect(t, s, nil)
+
+	subscribe(t, news, "news")
+	subscribe(t, sport, "sport")
+	subscribe(t, news, "sport")
+
+	publisher.Publish("news", []byte("headline"))
+	publisher.Publish("sport", []byte("score"))
+	receive(t, news, "news", "headline")
+	receive(t, news, "sport", "score")
+	receive(t, sport, "sport", "score")
+
+	// Unsubscribing and disconnecting both stop the messages
+	news.Unsubscribe("sport")
+	news.Publish("news", []byte("unsubscribed"))
+	receive(t, news, "news", "unsubscribed")
+	sport.Close()
+	publisher.Publish("sport", []byte("final score"))
+	publisher.Publish("news", []byte("late edition"))
+	receive(t, news, "news", "late edition")
+}
+
+func TestPublishTooLarge(t *testing.T) {
+	s := &Server{}
+	small, large := connect(t, s, &snacl.Options{MaxMessageLength: 100}), connect(t, s, nil)
+	subscribe(t, small, "news")
+	subscribe(t, large, "news")
+
+	// Only the subscriber that can take it gets the long message, the other one isn't disconnected
+	long := strings.Repeat("x", 200)
+	large.Publish("news", []byte(long))
+	large.Publish("news", []byte("short"))
+	receive(t, large, "news", long)
+	receive(t, large, "news", "short")
+	receive(t, small, "news", "short")
+}
+
+func TestAuthorize(t *testing.T) {
+	s := &Server{Authorize: func(state snacl.ConnectionState, topic string, publish bool) bool {
+		if state.PeerPublicKey != securetest.ClientKeys.PublicKey() {
+			t.Errorf("Unexpected result: %x", state.PeerPublicKey)
+		}
+		return topic == "public" || !publish
+	}}
+	c := connect(t, s, nil)
+
+	c.Subscribe("secret")
+	c.Subscribe("public")
+	c.Publish("secret", []byte("leak"))
+	_, err := c.Receive()
+	var denied *DeniedError
+	if !errors.As(err, &denied) || denied.Topic != "secret" || !denied.Publish {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.Publish("public", []byte("hello"))
+	receive(t, c, "public", "hello")
+}
+
+func TestTopicLength(t *testing.T) {
+	c := connect(t, &Server{}, nil)
+	for _, topic := range []string{"", string(make([]byte, MaxTopicLength+1))} {
+		if err := c.Subscribe(topic); err == nil {
+			t.Fatalf("Unexpected result. A %d byte topic was sent.", len(topic))
+		}
+	}
+}
+
+func TestServerProtocolError(t *testing.T) {
+	c, conn := securetest.Pipe()
+	defer c.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- (&Server{}).ServeConn(conn)
+	}()
+
+	c.WriteMsg([]byte{frameMessage, 1, 'x'})
+	if err := <-served; !errors.Is(err, ErrProtocol) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ErrQueueFull is returned by WriteMsgAsync when the write queue has no room, see Options.WriteQueueSize
+var ErrQueueFull = errors.New("write queue is full")
+
+// DefaultWriteQueueSize is the number of messages WriteMsgAsync can queue if Options.WriteQueueSize is 0
+const DefaultWriteQueueSize = 64
+
+// writeQueue holds messages waiting to be sent by the Conn's write goroutine
+type writeQueue struct {
+	// mu guards closed and sending on ch, so nothing is sent on a closed channel
+	mu     sync.Mutex
+	closed bool
+	ch     chan queuedWrite
+	// done is closed once the write goroutine has sent everything and exited
+	done chan struct{}
+}
+
+type queuedWrite struct {
+	msg  []byte
+	done func(error)
+}
+
+// WriteMsgAsync queues msg to be sent and returns without waiting for it. Like WriteMsg it's sent as
+// a single message, so msg can be at most ConnectionState.MaxMessageLength bytes. done is called from
+// the Conn's write goroutine with the result of the write once msg has been sent, and may be nil.
+// msg must not be modified until then.
+//
+// Queued messages are sent in the order WriteMsgAsync was called. If the queue is full, WriteMsgAsync
+// returns ErrQueueFull and msg is not sent, done is not called when WriteMsgAsync returns an error.
+// done must not call Close, Close waits for the queue to be sent.
+func (c *Conn) WriteMsgAsync(msg []byte, done func(error)) error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	if len(msg) > c.state.MaxMessageLength {
+		return fmt.Errorf("message is too large (len:%d max:%d)", len(msg), c.state.MaxMessageLength)
+	}
+
+	c.queueOnce.Do(c.startQueue)
+	q := c.queue
+	if q == nil {
+		// Close got there first
+		return net.ErrClosed
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return net.ErrClosed
+	}
+	select {
+	case q.ch <- queuedWrite{msg: msg, done: done}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// startQueue creates the write queue and starts the goroutine that sends it
+func (c *Conn) startQueue() {
+	size := c.opts.WriteQueueSize
+	if size <= 0 {
+		size = DefaultWriteQueueSize
+	}
+	q := &writeQueue{
+		ch:   make(chan queuedWrite, size),
+		done: make(chan struct{}),
+	}
+	c.queue = q
+
+	go func() {
+		defer close(q.done)
+		for w := range q.ch {
+			c.writeMu.Lock()
+			// Anything buffered was written first, see WriteMsg
+			err := c.sw.Flush()
+			if err == nil {
+				err = c.closedErr(c.sw.enc.Encode(&Message{Data: w.msg}))
+			}
+			c.writeMu.Unlock()
+			if w.done != nil {
+				w.done(err)
+			}
+		}
+	}()
+}
+
+// closeQueue stops the queue taking messages and waits for the ones already queued to be sent
+func (c *Conn) closeQueue() {
+	// Make sure there's never a queue started after this
+	c.queueOnce.Do(func() {})
+	q := c.queue
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
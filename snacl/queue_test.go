@@ -0,0 +1,101 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestConnWriteMsgAsync(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer server.Close()
+
+	const count = 20
+	results := make(chan error, count)
+	for i := 0; i < count; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		err := client.WriteMsgAsync(msg, func(err error) { results <- err })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Messages arrive in the order they were queued
+	for i := 0; i < count; i++ {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("message %d", i); string(msg.Data) != expected {
+			t.Fatalf("Unexpected result: %s != %s", msg.Data, expected)
+		}
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client.Close()
+	if err := client.WriteMsgAsync([]byte("late"), nil); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnWriteMsgAsyncQueueFull(t *testing.T) {
+	client, server := pipe(t, &Options{WriteQueueSize: 1})
+	defer server.Close()
+
+	// Nothing is reading, so the write goroutine blocks on the first message and the queue fills up
+	var err error
+	queued := 0
+	for i := 0; i < 3 && err == nil; i++ {
+		err = client.WriteMsgAsync([]byte("hello"), nil)
+		if err == nil {
+			queued++
+		}
+	}
+	if err != ErrQueueFull {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Close sends everything that was queued
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Close()
+	}()
+	for i := 0; i < queued; i++ {
+		if _, err := server.ReadMsg(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnWriteMsgAsyncSingleMessages(t *testing.T) {
+	client, server := pipe(t, &Options{WriteBufferSize: 1024, MaxMessageLength: 100})
+	defer client.Close()
+	defer server.Close()
+
+	if err := client.WriteMsgAsync(make([]byte, 101), nil); err == nil {
+		t.Fatal("Unexpected result. A message over MaxMessageLength was queued.")
+	}
+
+	// Queued messages are never merged with each other or with buffered writes
+	client.Write([]byte("buffered"))
+	for _, msg := range []string{"one", "two"} {
+		if err := client.WriteMsgAsync([]byte(msg), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"buffered", "one", "two"} {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data) != expected {
+			t.Fatalf("Unexpected result: %s != %s", msg.Data, expected)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package snacl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The double ratchet, see Options.Ratchet. It's the Signal double ratchet cut down for an ordered stream,
+// where records can't be skipped or arrive out of order.
+//
+// The symmetric ratchet: every record is sealed with a key of its own. Once a record has been sealed,
+// or opened, the key is replaced with the next in its chain, see nextKey, so a stolen key opens one
+// record and nothing before it.
+//
+// The DH ratchet: each side has a ratchet key pair, the first is sent in the hello. When a rekey is due
+// the sender makes a new ratchet key pair, mixes the X25519 of it and the other side's newest ratchet
+// key into the root key of its direction, and starts a new chain from the result. It tells the other
+// side with a record sealed with the old chain:
+//
+//	[new ratchet public key 32][index of the other side's ratchet key it used uint32be]
+//
+// and the other side does the same X25519 with its private key. Ratchet keys are numbered from 0, the
+// hello's. The new public key is also the one the other side's next step uses. Stolen traffic and root
+// keys only keep opening records until the next step that wasn't seen by the thief, so a compromise
+// only exposes a window of traffic.
+//
+// Each direction has its own root key, derived from its first traffic key, so both sides can step
+// at once.
+
+// ratchetStepLength is the size of a ratchet step record
+const ratchetStepLength = 32 + 4
+
+// maxRatchetKeys is how many of our ratchet key pairs are kept for the other side to use. It only uses
+// the newest it's read, so it would have to be this many steps behind to need one we've dropped.
+const maxRatchetKeys = 16
+
+// ratchet is the DH ratchet state of a connection, shared by its encoder and decoder
+type ratchet struct {
+	mu   sync.Mutex
+	rand io.Reader
+	// ours are our ratchet key pairs by index, from oldest to next-1
+	ours   map[uint32]*Keys
+	oldest uint32
+	next   uint32
+	// theirs is the other side's newest ratchet public key, and theirIndex is its index
+	theirs     [32]byte
+	theirIndex uint32
+	// sendRoot and recvRoot are the root keys of each direction
+	sendRoot, recvRoot [32]byte
+}
+
+// newRatchet returns the ratchet for our first ratchet key pair and the other side's first public key
+func newRatchet(rand io.Reader, ours *Keys, theirs [32]byte) *ratchet {
+	return &ratchet{rand: rand, ours: map[uint32]*Keys{0: ours}, next: 1, theirs: theirs}
+}
+
+// init derives the root keys from the first traffic keys
+func (r *ratchet) init(sendKey, recvKey *[32]byte) error {
+	for _, k := range []struct{ root, traffic *[32]byte }{{&r.sendRoot, sendKey}, {&r.recvRoot, recvKey}} {
+		_, err := io.ReadFull(hkdf.Expand(sha256.New, k.traffic[:], []byte("snacl ratchet root")), k.root[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step takes a DH ratchet step for the sending direction, and returns the record that tells the other
+// side about it and the new chain key
+func (r *ratchet) step() (record []byte, chain [32]byte, err error) {
+	keys, err := GenerateKeys(r.rand)
+	if err != nil {
+		return nil, chain, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chain, err = ratchetKDF(&r.sendRoot, &keys.Private, &r.theirs)
+	if err != nil {
+		return nil, chain, err
+	}
+	r.ours[r.next] = keys
+	r.next++
+	for r.next-r.oldest > maxRatchetKeys {
+		r.ours[r.oldest].Zero()
+		delete(r.ours, r.oldest)
+		r.oldest++
+	}
+	record = binary.BigEndian.AppendUint32(append([]byte(nil), keys.Public[:]...), r.theirIndex)
+	return record, chain, nil
+}
+
+// receive follows a DH ratchet step the other side took for the receiving direction, and returns the
+// new chain key
+func (r *ratchet) receive(record []byte) (chain [32]byte, err error) {
+	if len(record) != ratchetStepLength {
+		return chain, fmt.Errorf("%w: ratchet step of %d bytes", ErrBadRecord, len(record))
+	}
+	var theirs [32]byte
+	copy(theirs[:], record)
+	index := binary.BigEndian.Uint32(record[32:])
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ours, ok := r.ours[index]
+	if !ok {
+		return chain, fmt.Errorf("%w: ratchet step with our key %d, which we don't have", ErrBadRecord, index)
+	}
+	chain, err = ratchetKDF(&r.recvRoot, &ours.Private, &theirs)
+	if err != nil {
+		return chain, err
+	}
+	r.theirs = theirs
+	r.theirIndex++
+	// The other side has seen key index, so it won't use the ones before it
+	for ; r.oldest < index; r.oldest++ {
+		r.ours[r.oldest].Zero()
+		delete(r.ours, r.oldest)
+	}
+	return chain, nil
+}
+
+// ratchetKDF mixes the X25519 of priv and pub into root, and returns the new chain key
+func ratchetKDF(root, priv, pub *[32]byte) (chain [32]byte, err error) {
+	dh, err := curve25519.X25519(priv[:], pub[:])
+	if err != nil {
+		return chain, fmt.Errorf("%w: bad ratchet key", ErrBadRecord)
+	}
+	defer clear(dh)
+	out := hkdf.New(sha256.New, dh, root[:], []byte("snacl ratchet"))
+	_, err = io.ReadFull(out, root[:])
+	if err != nil {
+		return chain, err
+	}
+	_, err = io.ReadFull(out, chain[:])
+	return chain, err
+}
+
+// zero zeroes the ratchet's keys, see wipe.go
+func (r *ratchet) zero() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, keys := range r.ours {
+		keys.Zero()
+	}
+	clear(r.ours)
+	clear(r.sendRoot[:])
+	clear(r.recvRoot[:])
+}
+
+// ratchetStep takes a DH ratchet step in place of a key update. enc.mu must be held.
+func (enc *encoder) ratchetStep() error {
+	record, chain, err := enc.ratchet.step()
+	defer clear(chain[:])
+	if err != nil {
+		return err
+	}
+	err = enc.writeRecord(recordRatchet, record, nil)
+	if err != nil {
+		return err
+	}
+	enc.rekey.reset()
+	*enc.sharedKey = chain
+	return enc.setSuite(enc.suite)
+}
+
+// ratchetStep follows the other side's DH ratchet step in record
+func (dec *decoder) ratchetStep(record []byte) error {
+	if dec.ratchet == nil {
+		return fmt.Errorf("%w: ratchet step without the ratchet", ErrBadRecord)
+	}
+	chain, err := dec.ratchet.receive(record)
+	defer clear(chain[:])
+	if err != nil {
+		return err
+	}
+	*dec.sharedKey = chain
+	return dec.setSuite(dec.suite)
+}
@@ -0,0 +1,165 @@
+package snacl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRatchet(t *testing.T) {
+	client, server := pipe(t, &Options{Ratchet: true, RekeyMessages: 3})
+	defer client.Close()
+	defer server.Close()
+	if !client.ConnectionState().Ratchet || !server.ConnectionState().Ratchet {
+		t.Fatal("Unexpected result. The ratchet wasn't negotiated.")
+	}
+
+	// Both sides take steps at the same time
+	const count = 20
+	for _, c := range []*Conn{client, server} {
+		go func() {
+			for i := 0; i < count; i++ {
+				c.Write([]byte(fmt.Sprintf("message %d", i)))
+			}
+		}()
+	}
+	for _, c := range []*Conn{server, client} {
+		for i := 0; i < count; i++ {
+			msg, err := c.ReadMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fmt.Sprintf("message %d", i); string(msg.Data) != expected {
+				t.Fatalf("Unexpected result: %s != %s", msg.Data, expected)
+			}
+		}
+	}
+
+	// A step after messages 3, 6, ... 18 in each direction
+	for _, c := range []*Conn{client, server} {
+		if c.ratchet.next != count/3+1 || c.ratchet.theirIndex != count/3 {
+			t.Fatalf("Unexpected result: %d steps taken and %d followed", c.ratchet.next-1, c.ratchet.theirIndex)
+		}
+		if c.ratchet.next-c.ratchet.oldest > maxRatchetKeys {
+			t.Fatalf("Unexpected result: %d ratchet keys kept", c.ratchet.next-c.ratchet.oldest)
+		}
+	}
+	if client.ratchet.sendRoot != server.ratchet.recvRoot || client.ratchet.recvRoot != server.ratchet.sendRoot {
+		t.Fatal("Unexpected result. The root keys don't match.")
+	}
+	if *client.sw.enc.sharedKey != *server.sr.dec.sharedKey || *server.sw.enc.sharedKey != *client.sr.dec.sharedKey {
+		t.Fatal("Unexpected result. The receiving keys don't match the sending keys.")
+	}
+}
+
+func TestRatchetRecordKeys(t *testing.T) {
+	client, server := pipe(t, &Options{Ratchet: true})
+	defer client.Close()
+	defer server.Close()
+
+	// Every record moves the chain on, so no key seals two of them
+	seen := map[[32]byte]bool{*client.sw.enc.sharedKey: true}
+	for i := 0; i < 3; i++ {
+		go client.Write([]byte("hello"))
+		if _, err := server.ReadMsg(); err != nil {
+			t.Fatal(err)
+		}
+		key := *client.sw.enc.sharedKey
+		if seen[key] {
+			t.Fatal("Unexpected result. A key was used twice.")
+		}
+		seen[key] = true
+		if key != *server.sr.dec.sharedKey {
+			t.Fatal("Unexpected result. The receiving key doesn't match the sending key.")
+		}
+	}
+
+	// UpdateKey takes a DH ratchet step
+	root := client.ratchet.sendRoot
+	go func() {
+		client.UpdateKey()
+		client.Write([]byte("after"))
+	}()
+	if msg, err := server.ReadMsg(); err != nil || string(msg.Data) != "after" {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	if client.ratchet.sendRoot == root || client.ratchet.sendRoot != server.ratchet.recvRoot {
+		t.Fatal("Unexpected result. UpdateKey didn't take a ratchet step.")
+	}
+}
+
+func TestRatchetNegotiation(t *testing.T) {
+	client, server := pipeOptions(t, &Options{Ratchet: true}, nil)
+	defer client.Close()
+	defer server.Close()
+	if client.ConnectionState().Ratchet || client.ratchet != nil {
+		t.Fatal("Unexpected result. The ratchet was used with one side offering it.")
+	}
+	go client.Write([]byte("hello"))
+	if msg, err := server.ReadMsg(); err != nil || string(msg.Data) != "hello" {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// Messages too small for a ratchet step leave it off on both sides, and rekeys still work
+	opts := &Options{MaxMessageLength: ratchetStepLength - 1, Ratchet: true, RekeyMessages: 1, Padding: PadToBlock(8)}
+	client, server = pipe(t, opts)
+	defer client.Close()
+	defer server.Close()
+	if client.ConnectionState().Ratchet || server.ConnectionState().Ratchet {
+		t.Fatal("Unexpected result. The ratchet was used without room for its steps.")
+	}
+	go func() {
+		for i := 0; i < 3; i++ {
+			client.WriteMsg([]byte("hello"))
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if msg, err := server.ReadMsg(); err != nil || string(msg.Data) != "hello" {
+			t.Fatalf("Unexpected result: %v", err)
+		}
+	}
+}
+
+func TestRatchetBadRecord(t *testing.T) {
+	// writeRatchet sends a ratchet step and reads the alert it gets back
+	writeRatchet := func(c *Conn, index uint32) {
+		keys := mustGenerateKeys(t)
+		enc := c.sw.enc
+		enc.mu.Lock()
+		enc.writeRecord(recordRatchet, binary.BigEndian.AppendUint32(keys.Public[:], index), nil)
+		enc.mu.Unlock()
+		c.ReadMsg()
+	}
+
+	// A step on a connection without the ratchet
+	client, server := pipe(t, nil)
+	go writeRatchet(client, 0)
+	if _, err := server.ReadMsg(); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Close()
+	server.Close()
+
+	// A step with a ratchet key we never made
+	client, server = pipe(t, &Options{Ratchet: true})
+	defer client.Close()
+	defer server.Close()
+	go writeRatchet(client, 7)
+	if _, err := server.ReadMsg(); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCloseWipesRatchet(t *testing.T) {
+	client, server := pipe(t, &Options{Ratchet: true})
+	defer server.Close()
+	ours := client.ratchet.ours[0]
+	client.Close()
+	if client.ratchet.sendRoot != [32]byte{} || client.ratchet.recvRoot != [32]byte{} || len(client.ratchet.ours) != 0 {
+		t.Fatal("Unexpected result. Close didn't zero the ratchet.")
+	}
+	if ours.Private != [32]byte{} {
+		t.Fatal("Unexpected result. Close didn't zero the ratchet keys.")
+	}
+}
@@ -0,0 +1,193 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// In Version1 every sealed payload is a record: [type uint8][data]. The type is inside the box, so it's
+// authenticated along with the data. Data records are handed to the application, the others are control
+// records handled by the connection itself. Version0 payloads are just the data.
+
+// recordHeaderLength is the size of the record type
+const recordHeaderLength = 1
+
+// Record types
+const (
+	// recordData is application data
+	recordData byte = 0
+	// recordKeyUpdate says every record after it is sealed with the next key, see nextKey. It has no data.
+	recordKeyUpdate byte = 1
+	// recordCompressed is application data compressed with DEFLATE, see compress.go
+	recordCompressed byte = 2
+	// recordWindowUpdate gives the other side more flow control credit, see flow.go
+	recordWindowUpdate byte = 3
+	// recordPing asks the other side for a recordPong, see keepalive.go. Neither has any data.
+	recordPing byte = 4
+	recordPong byte = 5
+	// recordRatchet is a DH ratchet step, every record after it is sealed with a new chain, see ratchet.go
+	recordRatchet byte = 6
+	// recordAlert says why the other side is hanging up, see alert.go
+	recordAlert byte = 7
+	// recordTyped is application data with a message type, see typed.go
+	recordTyped byte = 8
+	// recordUrgent is an urgent message, see urgent.go
+	recordUrgent byte = 9
+)
+
+// ErrBadRecord is returned for a record with an unknown type or bad contents
+var ErrBadRecord = errors.New("malformed or unknown record")
+
+// Default rekeying thresholds, see Options.RekeyMessages and Options.RekeyBytes
+const (
+	DefaultRekeyMessages = 1 << 20
+	DefaultRekeyBytes    = 1 << 30
+)
+
+// handleRecord handles a record opened by the decoder. It returns the data and true for a data record,
+// and handles control records itself.
+func (dec *decoder) handleRecord(record []byte) ([]byte, bool, error) {
+	if len(record) < recordHeaderLength {
+		return nil, false, fmt.Errorf("%w: missing record type", ErrBadRecord)
+	}
+
+	typ, data := record[0], record[recordHeaderLength:]
+	switch typ {
+	case recordData:
+		return data, true, nil
+	case recordKeyUpdate:
+		if len(data) != 0 {
+			return nil, false, fmt.Errorf("%w: key update with data", ErrBadRecord)
+		}
+		return nil, false, dec.nextKey()
+	case recordCompressed:
+		if !dec.compressed {
+			return nil, false, fmt.Errorf("%w: compressed record without compression", ErrBadRecord)
+		}
+		data, err := decompressRecord(data, dec.maxLength)
+		if err != nil {
+			return nil, false, err
+		}
+		return data, true, nil
+	case recordWindowUpdate:
+		if dec.flow == nil {
+			return nil, false, fmt.Errorf("%w: window update without flow control", ErrBadRecord)
+		}
+		return nil, false, dec.flow.credited(data)
+	case recordPing, recordPong:
+		if dec.keepalive == nil {
+			return nil, false, fmt.Errorf("%w: ping without keepalives", ErrBadRecord)
+		}
+		if len(data) != 0 {
+			return nil, false, fmt.Errorf("%w: ping with data", ErrBadRecord)
+		}
+		if typ == recordPing {
+			dec.keepalive.pinged()
+		}
+		return nil, false, nil
+	case recordRatchet:
+		return nil, false, dec.ratchetStep(data)
+	case recordAlert:
+		return nil, false, readAlert(data)
+	case recordTyped:
+		return dec.typedRecord(data)
+	case recordUrgent:
+		return nil, false, dec.readUrgent(data)
+	default:
+		return nil, false, fmt.Errorf("%w: unknown record type %d", ErrBadRecord, typ)
+	}
+}
+
+// updateKey sends a key update and switches to the next sending key, the other side switches its
+// receiving key when it reads it. With the double ratchet it takes a DH ratchet step instead. enc.mu
+// must be held.
+func (enc *encoder) updateKey() error {
+	if enc.ratchet != nil {
+		return enc.ratchetStep()
+	}
+	err := enc.writeRecord(recordKeyUpdate, nil, nil)
+	if err != nil {
+		return err
+	}
+	enc.rekey.reset()
+	return enc.nextKey()
+}
+
+// nextKey moves the encoder on to the next key in its chain, see nextKey
+func (enc *encoder) nextKey() error {
+	err := nextKey(enc.sharedKey)
+	if err != nil {
+		return err
+	}
+	return enc.setSuite(enc.suite)
+}
+
+// nextKey moves the decoder on to the next key in its chain, see nextKey
+func (dec *decoder) nextKey() error {
+	err := nextKey(dec.sharedKey)
+	if err != nil {
+		return err
+	}
+	return dec.setSuite(dec.suite)
+}
+
+// rekeyPolicy counts what's been sent with the current key and decides when it's time for the next one
+type rekeyPolicy struct {
+	// maxMessages and maxBytes are the thresholds, 0 means there isn't one
+	maxMessages uint64
+	maxBytes    uint64
+
+	messages uint64
+	bytes    uint64
+}
+
+// newRekeyPolicy returns a policy for Options.RekeyMessages and Options.RekeyBytes
+func newRekeyPolicy(messages, bytes int64) rekeyPolicy {
+	var p rekeyPolicy
+	switch {
+	case messages == 0:
+		p.maxMessages = DefaultRekeyMessages
+	case messages > 0:
+		p.maxMessages = uint64(messages)
+	}
+	switch {
+	case bytes == 0:
+		p.maxBytes = DefaultRekeyBytes
+	case bytes > 0:
+		p.maxBytes = uint64(bytes)
+	}
+	return p
+}
+
+// due returns true once either threshold has been reached
+func (p *rekeyPolicy) due() bool {
+	return (p.maxMessages > 0 && p.messages >= p.maxMessages) || (p.maxBytes > 0 && p.bytes >= p.maxBytes)
+}
+
+// sent counts a message of n bytes
+func (p *rekeyPolicy) sent(n int) {
+	p.messages++
+	p.bytes += uint64(n)
+}
+
+// reset starts counting again for a new key
+func (p *rekeyPolicy) reset() {
+	p.messages, p.bytes = 0, 0
+}
+
+// UpdateKey sends a key update straight away instead of waiting for Options.RekeyMessages or
+// Options.RekeyBytes. It needs Version1, Version0 has no key updates.
+func (c *Conn) UpdateKey() error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	if c.state.Version < Version1 {
+		return errors.New("key updates need Version1")
+	}
+
+	enc := c.sw.enc
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.updateKey()
+}
@@ -0,0 +1,92 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConnRekey(t *testing.T) {
+	client, server := pipe(t, &Options{RekeyMessages: 3})
+	defer client.Close()
+	defer server.Close()
+
+	initial := *client.sw.enc.sharedKey
+	serverInitial := *server.sw.enc.sharedKey
+
+	const count = 10
+	go func() {
+		for i := 0; i < count; i++ {
+			client.Write([]byte(fmt.Sprintf("message %d", i)))
+		}
+	}()
+	for i := 0; i < count; i++ {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("message %d", i); string(msg.Data) != expected {
+			t.Fatalf("Unexpected result: %s != %s", msg.Data, expected)
+		}
+	}
+
+	// The key was updated after messages 3, 6 and 9, and only in the client to server direction
+	if *client.sw.enc.sharedKey == initial {
+		t.Fatal("Unexpected result. The sending key was never updated.")
+	}
+	if *server.sr.dec.sharedKey != *client.sw.enc.sharedKey {
+		t.Fatal("Unexpected result. The receiving key doesn't match the sending key.")
+	}
+	if *server.sw.enc.sharedKey != serverInitial {
+		t.Fatal("Unexpected result. The server's sending key was updated.")
+	}
+}
+
+func TestConnUpdateKey(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.UpdateKey()
+		client.Write([]byte("hello"))
+	}()
+	msg, err := server.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "hello" {
+		t.Fatalf("Unexpected result: %s != %s", msg.Data, "hello")
+	}
+
+	legacy, legacyServer := pipe(t, &Options{LegacyV0: true})
+	defer legacy.Close()
+	defer legacyServer.Close()
+	if err := legacy.UpdateKey(); err == nil {
+		t.Fatal("Unexpected result. Version0 updated its key.")
+	}
+}
+
+func TestUnknownRecord(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// The server tells the client why it's hanging up
+	clientErr := make(chan error, 1)
+	go func() {
+		enc := client.sw.enc
+		enc.mu.Lock()
+		enc.writeRecord(0xff, nil, nil)
+		enc.mu.Unlock()
+		_, err := client.ReadMsg()
+		clientErr <- err
+	}()
+	if _, err := server.ReadMsg(); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var alert *AlertError
+	if err := <-clientErr; !errors.As(err, &alert) || alert.Alert != AlertBadRecord {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
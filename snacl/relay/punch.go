@@ -0,0 +1,345 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Hole punching gets two peers behind NATs a direct connection, with the relay as the rendezvous.
+// Each peer registers the token with versionPunch and the address of its UDP socket as the relay saw
+// it, and once both have, the relay sends each one the other's addresses and hangs up:
+//
+//	[version uint8][token length uint8][token][UDP address length uint8][UDP address]
+//	[statusPaired][TCP address length uint8][TCP address][UDP address length uint8][UDP address]
+//
+// For TCP both peers then connect to each other's address from the port they registered from, so
+// each NAT sees an outgoing connection and lets the other's SYN in, a simultaneous open. The side
+// that dials keeps dialing, the side that accepts listens on the port as well, since without NATs in
+// the way the dials simply arrive. It needs SO_REUSEPORT, see reuse.go, and doesn't get through
+// every NAT, so DialDirect and AcceptDirect fall back to relaying.
+//
+// For UDP both peers send probes to each other's address until one arrives. A relay finds out a UDP
+// socket's address for it by answering a reflectRequest with the address it came from, see
+// Server.ServePacket.
+
+// versionPunch is the registration version for hole punching
+const versionPunch = 2
+
+// reflectRequest asks a relay's UDP socket for the address it came from
+var reflectRequest = []byte("snacl-relay-reflect")
+
+// punchProbe is what peers send each other while punching UDP
+var punchProbe = []byte("snacl-relay-punch")
+
+// DefaultPunchTimeout is how long DialDirect, AcceptDirect and PunchUDP try before giving up
+const DefaultPunchTimeout = 5 * time.Second
+
+// punchInterval is how often a dial or probe is retried while punching
+const punchInterval = 100 * time.Millisecond
+
+// ErrPunchFailed is returned when no direct connection could be made in time
+var ErrPunchFailed = errors.New("relay: hole punching failed")
+
+// ServePacket answers reflect requests on pc, which is normally at the same address as the
+// listener given to Serve, until pc fails
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if string(buf[:n]) == string(reflectRequest) {
+			pc.WriteTo([]byte(addr.String()), addr)
+		}
+	}
+}
+
+// rendezvous is what a punch registration returns
+type rendezvous struct {
+	// local is the address we registered from, peerTCP and peerUDP are the other peer's as the relay
+	// saw them
+	local            *net.TCPAddr
+	peerTCP, peerUDP string
+}
+
+// meet registers token for punching with the relay at addr and waits for the other peer. udpAddr is
+// our UDP socket's public address, or empty.
+func meet(ctx context.Context, addr, token, udpAddr string) (*rendezvous, error) {
+	if len(token) == 0 || len(token) > 255 {
+		return nil, errors.New("relay: token must be 1 to 255 bytes")
+	}
+	d := &net.Dialer{Control: reusePort}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	reg := appendString(appendString([]byte{versionPunch}, token), udpAddr)
+	if _, err := conn.Write(reg); err != nil {
+		return nil, err
+	}
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return nil, err
+	}
+	switch status[0] {
+	case statusPaired:
+	case statusTimeout:
+		return nil, ErrTimeout
+	default:
+		return nil, fmt.Errorf("relay: unknown status %d", status[0])
+	}
+	peerTCP, err := readString(conn)
+	if err != nil {
+		return nil, err
+	}
+	peerUDP, err := readString(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &rendezvous{local: conn.LocalAddr().(*net.TCPAddr), peerTCP: peerTCP, peerUDP: peerUDP}, nil
+}
+
+// punchTCP makes a direct TCP connection to the other peer of r. The dialing side only dials, the
+// other listens too and takes whichever connection comes first.
+func punchTCP(ctx context.Context, r *rendezvous, dialing bool) (net.Conn, error) {
+	conns := make(chan net.Conn, 1)
+	offer := func(conn net.Conn) {
+		select {
+		case conns <- conn:
+		default:
+			conn.Close()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if !dialing {
+		lc := &net.ListenConfig{Control: reusePort}
+		l, err := lc.Listen(ctx, "tcp", r.local.String())
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				offer(conn)
+			}
+		}()
+	}
+	go func() {
+		d := &net.Dialer{LocalAddr: r.local, Control: reusePort, Timeout: punchInterval}
+		for ctx.Err() == nil {
+			conn, err := d.DialContext(ctx, "tcp", r.peerTCP)
+			if err == nil {
+				offer(conn)
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(punchInterval):
+			}
+		}
+	}()
+
+	select {
+	case conn := <-conns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ErrPunchFailed
+	}
+}
+
+// DialDirect is Dial with hole punching: it tries to connect straight to the other peer, who calls
+// AcceptDirect with the same token, and relays through the relay at addr if that doesn't work within
+// DefaultPunchTimeout. Only tcp relays are supported.
+func DialDirect(addr, token string, peer *[32]byte, opts *snacl.Options) (*snacl.Conn, error) {
+	return connectDirect(addr, token, peer, opts, true)
+}
+
+// AcceptDirect is DialDirect for the other peer, it does the handshake as the server
+func AcceptDirect(addr, token string, peer *[32]byte, opts *snacl.Options) (*snacl.Conn, error) {
+	return connectDirect(addr, token, peer, opts, false)
+}
+
+func connectDirect(addr, token string, peer *[32]byte, opts *snacl.Options, dialing bool) (*snacl.Conn, error) {
+	side := snacl.Server
+	if dialing {
+		side = snacl.Client
+	}
+	conn, err := punchAndHandshake(addr, token, peer, opts, dialing, side)
+	if err == nil {
+		return conn, nil
+	}
+	return connect("tcp", addr, token, peer, opts, side)
+}
+
+// punchAndHandshake punches a TCP connection and does the handshake over it, all within
+// DefaultPunchTimeout so both peers give up and fall back at about the same time
+func punchAndHandshake(addr, token string, peer *[32]byte, opts *snacl.Options, dialing bool, side func(io.ReadWriteCloser, *snacl.Options) *snacl.Conn) (*snacl.Conn, error) {
+	deadline := time.Now().Add(DefaultPunchTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	r, err := meet(ctx, addr, token, "")
+	if err != nil {
+		return nil, err
+	}
+	rawConn, err := punchTCP(ctx, r, dialing)
+	if err != nil {
+		return nil, err
+	}
+	rawConn.SetDeadline(deadline)
+	conn := side(rawConn, opts)
+	err = conn.Handshake()
+	if err == nil {
+		err = rawConn.SetDeadline(time.Time{})
+	}
+	if err == nil && peer != nil && conn.PeerPublicKey() != *peer {
+		err = ErrWrongPeer
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// PunchUDP opens a UDP socket and punches a path through to the other peer, who calls PunchUDP with
+// the same token. It returns the socket and the other peer's address, ready for
+// snacl.NewPacketConn and PacketConn.AddPeer. There's nothing to fall back to, it fails with
+// ErrPunchFailed if no probe arrives within timeout.
+func PunchUDP(addr, token string, timeout time.Duration) (net.PacketConn, net.Addr, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+	peerAddr, err := punchUDP(ctx, pc, addr, token)
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+	return pc, peerAddr, nil
+}
+
+func punchUDP(ctx context.Context, pc net.PacketConn, addr, token string) (net.Addr, error) {
+	relayAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	pc.SetReadDeadline(deadline)
+	defer pc.SetReadDeadline(time.Time{})
+
+	public, err := reflect(ctx, pc, relayAddr)
+	if err != nil {
+		return nil, err
+	}
+	r, err := meet(ctx, addr, token, public)
+	if err != nil {
+		return nil, err
+	}
+	peerAddr, err := net.ResolveUDPAddr("udp", r.peerUDP)
+	if err != nil {
+		return nil, fmt.Errorf("relay: the other peer has no UDP address: %w", err)
+	}
+
+	// Keep probing until a probe arrives, and a while after so ours gets through too. The probes outlive
+	// ctx, which PunchUDP cancels as soon as it returns.
+	probing, stopProbing := context.WithCancel(context.Background())
+	go func() {
+		for probing.Err() == nil {
+			pc.WriteTo(punchProbe, peerAddr)
+			select {
+			case <-probing.Done():
+			case <-time.After(punchInterval):
+			}
+		}
+	}()
+	buf := make([]byte, 64)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			stopProbing()
+			if ctx.Err() != nil {
+				return nil, ErrPunchFailed
+			}
+			return nil, err
+		}
+		// The NAT in front of the other peer may have given it another port for us than for the relay
+		fromUDP, ok := from.(*net.UDPAddr)
+		if ok && string(buf[:n]) == string(punchProbe) && fromUDP.IP.Equal(peerAddr.IP) {
+			time.AfterFunc(3*punchInterval, stopProbing)
+			return from, nil
+		}
+	}
+}
+
+// reflect asks the relay for pc's public address
+func reflect(ctx context.Context, pc net.PacketConn, relayAddr net.Addr) (string, error) {
+	replies := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				close(replies)
+				return
+			}
+			if from.String() == relayAddr.String() {
+				replies <- string(buf[:n])
+				return
+			}
+		}
+	}()
+	for {
+		pc.WriteTo(reflectRequest, relayAddr)
+		select {
+		case public, ok := <-replies:
+			if !ok {
+				return "", ErrPunchFailed
+			}
+			return public, nil
+		case <-ctx.Done():
+			return "", ErrPunchFailed
+		case <-time.After(punchInterval):
+		}
+	}
+}
+
+// readString reads a string with a uint8 length
+func readString(r io.Reader) (string, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", err
+	}
+	s := make([]byte, length[0])
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// appendString appends s with a uint8 length
+func appendString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s))), s...)
+}
@@ -0,0 +1,266 @@
+// Package relay lets two peers that can't reach each other, both behind NATs say, talk through a
+// server they can both reach. The relay pairs the two connections that register the same token and
+// copies bytes between them; the peers then do an ordinary handshake over the pair. The relay never
+// has either private key, so what it copies is sealed end to end.
+//
+// A peer registers by sending
+//
+//	[version uint8][token length uint8][token]
+//
+// and the relay answers with one status byte once the other peer has registered the same token, or
+// it's given up waiting. After statusPaired everything is the two peers' own.
+//
+// Peers can try to connect directly first, with the relay only introducing them, see punch.go.
+//
+// A relay sits in the middle of the handshake, so it could do a handshake with each peer itself. Dial
+// and Accept check the other peer's public key to rule that out.
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// version is the registration format's version
+const version = 1
+
+// Statuses the relay answers a registration with
+const (
+	// statusPaired means the other peer has registered, the connection is now theirs
+	statusPaired byte = 0
+	// statusTimeout means the other peer didn't register within Server.WaitTimeout
+	statusTimeout byte = 1
+)
+
+// DefaultWaitTimeout is the default Server.WaitTimeout
+const DefaultWaitTimeout = time.Minute
+
+// registerTimeout is how long a connection has to send its registration
+const registerTimeout = 10 * time.Second
+
+// Errors returned by Dial and Accept
+var (
+	ErrTimeout   = errors.New("relay: the other peer didn't arrive in time")
+	ErrWrongPeer = errors.New("relay: the other peer's public key isn't the expected one")
+)
+
+// Server pairs connections by token and relays between them, the zero value is ready to use. Once a
+// pair is relaying its token is free again, a third peer waits for a fourth.
+type Server struct {
+	// WaitTimeout is how long the first peer waits for the second, DefaultWaitTimeout if 0
+	WaitTimeout time.Duration
+
+	mu sync.Mutex
+	// waiting has the first peer for every token whose second peer hasn't arrived yet
+	waiting map[string]*waiter
+}
+
+// waiter is a peer waiting for the other one
+type waiter struct {
+	conn net.Conn
+	// udpAddr is the UDP address a punching peer registered
+	udpAddr string
+	// paired is closed once the other peer has taken the connection
+	paired chan struct{}
+}
+
+// Serve relays the connections accepted from l until l fails
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle reads conn's registration and either waits for the other peer or relays to it
+func (s *Server) handle(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(registerTimeout))
+	reg, err := readRegistration(conn)
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		log.Printf("relay: registration from %v: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	// Relaying and punching peers only meet their own kind
+	key := string(reg.version) + reg.token
+	s.mu.Lock()
+	first, ok := s.waiting[key]
+	if ok {
+		delete(s.waiting, key)
+		close(first.paired)
+		s.mu.Unlock()
+		if reg.version == versionPunch {
+			introduce(first, &waiter{conn: conn, udpAddr: reg.udpAddr})
+			return
+		}
+		s.relay(first.conn, conn)
+		return
+	}
+	w := &waiter{conn: conn, udpAddr: reg.udpAddr, paired: make(chan struct{})}
+	if s.waiting == nil {
+		s.waiting = make(map[string]*waiter)
+	}
+	s.waiting[key] = w
+	s.mu.Unlock()
+
+	timeout := s.WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.paired:
+		// The second peer's goroutine relays
+	case <-timer.C:
+		s.mu.Lock()
+		timedOut := s.waiting[key] == w
+		if timedOut {
+			delete(s.waiting, key)
+		}
+		s.mu.Unlock()
+		if timedOut {
+			conn.Write([]byte{statusTimeout})
+			conn.Close()
+		}
+	}
+}
+
+// relay tells both peers they're paired and copies between them until both directions are done
+func (s *Server) relay(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	if _, err := a.Write([]byte{statusPaired}); err != nil {
+		b.Write([]byte{statusTimeout})
+		return
+	}
+	if _, err := b.Write([]byte{statusPaired}); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	splice := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Pass the end of one direction on without cutting the other short
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go splice(a, b)
+	go splice(b, a)
+	<-done
+	<-done
+}
+
+// introduce sends two punching peers each other's addresses, see punch.go
+func introduce(a, b *waiter) {
+	defer a.conn.Close()
+	defer b.conn.Close()
+	a.conn.Write(appendString(appendString([]byte{statusPaired}, b.conn.RemoteAddr().String()), b.udpAddr))
+	b.conn.Write(appendString(appendString([]byte{statusPaired}, a.conn.RemoteAddr().String()), a.udpAddr))
+}
+
+// registration is what a peer registers with
+type registration struct {
+	version byte
+	token   string
+	// udpAddr is only sent by punching peers
+	udpAddr string
+}
+
+// readRegistration reads a registration
+func readRegistration(r io.Reader) (*registration, error) {
+	var v [1]byte
+	if _, err := io.ReadFull(r, v[:]); err != nil {
+		return nil, err
+	}
+	reg := &registration{version: v[0]}
+	if reg.version != version && reg.version != versionPunch {
+		return nil, fmt.Errorf("unsupported version %d", reg.version)
+	}
+	var err error
+	if reg.token, err = readString(r); err != nil {
+		return nil, err
+	}
+	if reg.version == versionPunch {
+		if reg.udpAddr, err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
+
+// register registers token with the relay on conn and waits for the other peer
+func register(conn net.Conn, token string) error {
+	if len(token) == 0 || len(token) > 255 {
+		return errors.New("relay: token must be 1 to 255 bytes")
+	}
+	if _, err := conn.Write(appendString([]byte{version}, token)); err != nil {
+		return err
+	}
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return err
+	}
+	switch status[0] {
+	case statusPaired:
+		return nil
+	case statusTimeout:
+		return ErrTimeout
+	default:
+		return fmt.Errorf("relay: unknown status %d", status[0])
+	}
+}
+
+// Dial connects to the other peer through the relay at addr, both registering token, and does the
+// handshake as the client. peer is the public key the other peer has to have; if it's nil anyone
+// who knows the token, the relay included, can be on the other end. opts may be nil.
+func Dial(network, addr, token string, peer *[32]byte, opts *snacl.Options) (*snacl.Conn, error) {
+	return connect(network, addr, token, peer, opts, snacl.Client)
+}
+
+// Accept is Dial for the other peer, it does the handshake as the server
+func Accept(network, addr, token string, peer *[32]byte, opts *snacl.Options) (*snacl.Conn, error) {
+	return connect(network, addr, token, peer, opts, snacl.Server)
+}
+
+func connect(network, addr, token string, peer *[32]byte, opts *snacl.Options, side func(io.ReadWriteCloser, *snacl.Options) *snacl.Conn) (*snacl.Conn, error) {
+	rawConn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := register(rawConn, token); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	conn := side(rawConn, opts)
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if peer != nil {
+		if conn.PeerPublicKey() != *peer {
+			conn.Close()
+			return nil, ErrWrongPeer
+		}
+	}
+	return conn, nil
+}
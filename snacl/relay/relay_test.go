@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func startRelay(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func TestRelay(t *testing.T) {
+	addr := startRelay(t, &Server{})
+	aliceKeys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobKeys, err := snacl.GenerateKeys(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan *snacl.Conn, 1)
+	go func() {
+		conn, err := Accept("tcp", addr, "meet", &aliceKeys.Public, &snacl.Options{Keys: bobKeys})
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	alice, err := Dial("tcp", addr, "meet", &bobKeys.Public, &snacl.Options{Keys: aliceKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob := <-accepted
+	if bob == nil {
+		t.FailNow()
+	}
+	defer bob.Close()
+
+	if _, err := alice.Write([]byte("hello bob")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := bob.ReadMsg()
+	if err != nil || string(msg.Data) != "hello bob" {
+		t.Fatalf("Unexpected result: %v %v", msg, err)
+	}
+}
+
+func TestRelayWrongPeer(t *testing.T) {
+	addr := startRelay(t, &Server{})
+	var expected [32]byte
+
+	go func() {
+		conn, err := Accept("tcp", addr, "meet", nil, nil)
+		if err == nil {
+			conn.ReadMsg()
+			conn.Close()
+		}
+	}()
+	if _, err := Dial("tcp", addr, "meet", &expected, nil); !errors.Is(err, ErrWrongPeer) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestRelayTimeout(t *testing.T) {
+	addr := startRelay(t, &Server{WaitTimeout: 50 * time.Millisecond})
+	if _, err := Dial("tcp", addr, "alone", nil, nil); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestDirect(t *testing.T) {
+	addr := startRelay(t, &Server{})
+
+	accepted := make(chan *snacl.Conn, 1)
+	go func() {
+		conn, err := AcceptDirect(addr, "meet", nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	alice, err := DialDirect(addr, "meet", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob := <-accepted
+	if bob == nil {
+		t.FailNow()
+	}
+	defer bob.Close()
+
+	// Nothing is in the way on loopback, so the connection doesn't go through the relay
+	if alice.RemoteAddr().String() == addr || alice.RemoteAddr().String() != bob.LocalAddr().String() {
+		t.Fatalf("Unexpected result. %v is connected to %v, %v to %v.", alice.LocalAddr(), alice.RemoteAddr(), bob.LocalAddr(), bob.RemoteAddr())
+	}
+	if _, err := alice.Write([]byte("hello bob")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := bob.ReadMsg(); err != nil || string(msg.Data) != "hello bob" {
+		t.Fatalf("Unexpected result: %v %v", msg, err)
+	}
+}
+
+func TestPunchUDP(t *testing.T) {
+	s := &Server{}
+	addr := startRelay(t, s)
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go s.ServePacket(pc)
+
+	type result struct {
+		pc   net.PacketConn
+		peer net.Addr
+		err  error
+	}
+	results := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			pc, peer, err := PunchUDP(addr, "meet", time.Second)
+			results <- result{pc, peer, err}
+		}()
+	}
+	a, b := <-results, <-results
+	if a.err != nil || b.err != nil {
+		t.Fatal(a.err, b.err)
+	}
+	defer a.pc.Close()
+	defer b.pc.Close()
+	if a.peer.(*net.UDPAddr).Port != b.pc.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatalf("Unexpected result. %v punched through to %v, not %v.", a.pc.LocalAddr(), a.peer, b.pc.LocalAddr())
+	}
+
+	aliceKeys, _ := snacl.GenerateKeys(rand.Reader)
+	bobKeys, _ := snacl.GenerateKeys(rand.Reader)
+	alice, bob := snacl.NewPacketConn(a.pc, aliceKeys), snacl.NewPacketConn(b.pc, bobKeys)
+	alice.AddPeer(a.peer, &bobKeys.Public)
+	bob.AddPeer(b.peer, &aliceKeys.Public)
+	if _, err := alice.WriteTo([]byte("hello bob"), a.peer); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	bob.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := bob.ReadFrom(buf); err != nil || string(buf[:n]) != "hello bob" {
+		t.Fatalf("Unexpected result: %q %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package relay
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePort lets a socket share its port with others, so a peer can dial and listen on the port it
+// registered from
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	controlErr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if err == nil {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
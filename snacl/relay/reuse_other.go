@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package relay
+
+import "syscall"
+
+// reusePort does nothing here, so TCP hole punching fails and DialDirect and AcceptDirect relay
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
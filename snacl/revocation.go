@@ -0,0 +1,183 @@
+package snacl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// A revocation list invalidates compromised keys across a fleet. It's signed by a CA key, like
+// certificates, and lists the revoked public keys:
+//
+//	[magic "SNRL"][version uint8][issued int64be][key count uint32be][public keys 32 each][signature 64]
+//
+// Revoking a key also revokes every certificate for it. Options.RevocationChecker is asked about the
+// other side's key in every handshake, whether or not it had a certificate, and a *RevocationList is
+// one. Lists are published as files or at URLs, see ReadRevocationList and FetchRevocationList, and
+// it's up to the application to reload them.
+
+const (
+	revocationMagic   = "SNRL"
+	revocationVersion = 1
+)
+
+// maxRevocationListSize bounds the lists FetchRevocationList downloads
+const maxRevocationListSize = 16 << 20
+
+// ErrBadRevocationList is returned for revocation lists that can't be parsed or don't verify. It's
+// wrapped with the reason, use errors.Is.
+var ErrBadRevocationList = errors.New("bad revocation list")
+
+// ErrKeyRevoked is wrapped by the handshake error when the other side's key is revoked
+var ErrKeyRevoked = errors.New("key revoked")
+
+// RevocationChecker decides whether a public key has been revoked. An error fails the handshake, as
+// a key that can't be checked isn't trusted. It's called from every handshake, concurrently.
+type RevocationChecker interface {
+	Revoked(pub [32]byte) (bool, error)
+}
+
+// RevocationList is a CA-signed list of revoked public keys, see revocation.go
+type RevocationList struct {
+	// Issued is when the list was signed, it's kept to the second. A newer list replaces an older one.
+	Issued    time.Time
+	Keys      [][32]byte
+	Signature []byte
+}
+
+// SignRevocationList returns a list of the revoked keys issued at issued, signed by ca
+func SignRevocationList(ca ed25519.PrivateKey, keys [][32]byte, issued time.Time) *RevocationList {
+	list := &RevocationList{Issued: time.Unix(issued.Unix(), 0), Keys: keys}
+	list.Signature = ed25519.Sign(ca, list.signed())
+	return list
+}
+
+// signed returns the part of the list the signature covers
+func (list *RevocationList) signed() []byte {
+	b := make([]byte, 0, len(revocationMagic)+1+8+4+32*len(list.Keys))
+	b = append(b, revocationMagic...)
+	b = append(b, revocationVersion)
+	b = binary.BigEndian.AppendUint64(b, uint64(list.Issued.Unix()))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(list.Keys)))
+	for _, key := range list.Keys {
+		b = append(b, key[:]...)
+	}
+	return b
+}
+
+// Marshal returns the list in its wire format
+func (list *RevocationList) Marshal() []byte {
+	return append(list.signed(), list.Signature...)
+}
+
+// ParseRevocationList parses a revocation list in its wire format, it doesn't verify it
+func ParseRevocationList(b []byte) (*RevocationList, error) {
+	header := len(revocationMagic) + 1 + 8 + 4
+	if len(b) < header || string(b[:len(revocationMagic)]) != revocationMagic {
+		return nil, fmt.Errorf("%w: not a revocation list", ErrBadRevocationList)
+	}
+	if b[len(revocationMagic)] != revocationVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadRevocationList, b[len(revocationMagic)])
+	}
+	b = b[len(revocationMagic)+1:]
+	list := &RevocationList{Issued: time.Unix(int64(binary.BigEndian.Uint64(b)), 0)}
+	count := uint64(binary.BigEndian.Uint32(b[8:]))
+	b = b[12:]
+	if uint64(len(b)) != 32*count+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: wrong length", ErrBadRevocationList)
+	}
+
+	list.Keys = make([][32]byte, count)
+	for i := range list.Keys {
+		copy(list.Keys[i][:], b[32*i:])
+	}
+	list.Signature = append([]byte(nil), b[32*count:]...)
+	return list, nil
+}
+
+// Verify checks the list was signed by one of cas
+func (list *RevocationList) Verify(cas []ed25519.PublicKey) error {
+	signed := list.signed()
+	for _, ca := range cas {
+		if ed25519.Verify(ca, signed, list.Signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: not signed by a trusted CA", ErrBadRevocationList)
+}
+
+// Revoked returns true if pub is on the list
+func (list *RevocationList) Revoked(pub [32]byte) (bool, error) {
+	for _, key := range list.Keys {
+		if key == pub {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReadRevocationList reads the list in the file at path and verifies it was signed by one of cas
+func ReadRevocationList(path string, cas []ed25519.PublicKey) (*RevocationList, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return verifiedRevocationList(b, cas)
+}
+
+// FetchRevocationList downloads the list at url and verifies it was signed by one of cas. The list is
+// signed, so it doesn't matter who serves it.
+func FetchRevocationList(ctx context.Context, url string, cas []ed25519.PublicKey) (*RevocationList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching revocation list %s: %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxRevocationListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxRevocationListSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrBadRevocationList, maxRevocationListSize)
+	}
+	return verifiedRevocationList(b, cas)
+}
+
+func verifiedRevocationList(b []byte, cas []ed25519.PublicKey) (*RevocationList, error) {
+	list, err := ParseRevocationList(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := list.Verify(cas); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// checkRevocation fails the handshake if opts.RevocationChecker says the other side's key is revoked
+func checkRevocation(opts *Options, peerKey [32]byte) error {
+	if opts.RevocationChecker == nil {
+		return nil
+	}
+	revoked, err := opts.RevocationChecker.Revoked(peerKey)
+	if err != nil {
+		return fmt.Errorf("%w: checking revocation: %w", ErrHandshakeFailed, err)
+	}
+	if revoked {
+		return fmt.Errorf("%w: %w: %s", ErrHandshakeFailed, ErrKeyRevoked, Fingerprint(peerKey))
+	}
+	return nil
+}
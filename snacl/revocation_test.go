@@ -0,0 +1,139 @@
+package snacl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRevocationListRoundTrip(t *testing.T) {
+	caPub, caPriv := newCA(t)
+	revoked := [][32]byte{mustGenerateKeys(t).Public, mustGenerateKeys(t).Public}
+	list := SignRevocationList(caPriv, revoked, time.Now())
+
+	parsed, err := ParseRevocationList(list.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := parsed.Verify([]ed25519.PublicKey{caPub}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !parsed.Issued.Equal(list.Issued) || len(parsed.Keys) != 2 {
+		t.Fatalf("Unexpected result: %+v", parsed)
+	}
+	for _, key := range revoked {
+		if ok, err := parsed.Revoked(key); !ok || err != nil {
+			t.Fatalf("Unexpected result: %v %v", ok, err)
+		}
+	}
+	if ok, _ := parsed.Revoked(mustGenerateKeys(t).Public); ok {
+		t.Fatal("Unexpected result: a key that isn't on the list is revoked")
+	}
+
+	otherPub, _ := newCA(t)
+	if err := parsed.Verify([]ed25519.PublicKey{otherPub}); !errors.Is(err, ErrBadRevocationList) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	// Dropping a key from the list breaks the signature
+	parsed.Keys = parsed.Keys[1:]
+	if err := parsed.Verify([]ed25519.PublicKey{caPub}); !errors.Is(err, ErrBadRevocationList) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	raw := list.Marshal()
+	for _, bad := range [][]byte{nil, raw[:12], raw[:len(raw)-1], append(raw, 0)} {
+		if _, err := ParseRevocationList(bad); !errors.Is(err, ErrBadRevocationList) {
+			t.Fatalf("Unexpected result for %d bytes: %v", len(bad), err)
+		}
+	}
+}
+
+func TestRevocationListSources(t *testing.T) {
+	caPub, caPriv := newCA(t)
+	cas := []ed25519.PublicKey{caPub}
+	raw := SignRevocationList(caPriv, [][32]byte{mustGenerateKeys(t).Public}, time.Now()).Marshal()
+
+	path := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := ReadRevocationList(path, cas); err != nil || len(list.Keys) != 1 {
+		t.Fatalf("Unexpected result: %v %v", list, err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/revoked" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(raw)
+	}))
+	defer srv.Close()
+	if list, err := FetchRevocationList(context.Background(), srv.URL+"/revoked", cas); err != nil || len(list.Keys) != 1 {
+		t.Fatalf("Unexpected result: %v %v", list, err)
+	}
+	if _, err := FetchRevocationList(context.Background(), srv.URL+"/missing", cas); err == nil {
+		t.Fatal("Unexpected result: no error for a missing list")
+	}
+
+	otherPub, _ := newCA(t)
+	if _, err := ReadRevocationList(path, []ed25519.PublicKey{otherPub}); !errors.Is(err, ErrBadRevocationList) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+}
+
+// failingChecker can't check anything
+type failingChecker struct{}
+
+func (failingChecker) Revoked([32]byte) (bool, error) {
+	return false, errors.New("revocation list unavailable")
+}
+
+func TestRevokedKeyHandshake(t *testing.T) {
+	_, caPriv := newCA(t)
+	serverKeys := mustGenerateKeys(t)
+
+	// Not revoked yet
+	list := SignRevocationList(caPriv, [][32]byte{mustGenerateKeys(t).Public}, time.Now())
+	pipeOptions(t, &Options{RevocationChecker: list}, &Options{Keys: serverKeys})
+
+	tests := map[string]*Options{
+		"revoked":            {RevocationChecker: SignRevocationList(caPriv, [][32]byte{serverKeys.Public}, time.Now())},
+		"revoked with noise": {RevocationChecker: SignRevocationList(caPriv, [][32]byte{serverKeys.Public}, time.Now()), Noise: true},
+		"checker fails":      {RevocationChecker: failingChecker{}},
+	}
+	for name, clientOpts := range tests {
+		t.Run(name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+			client := Client(c1, clientOpts)
+			server := Server(c2, &Options{Keys: serverKeys, Noise: clientOpts.Noise})
+
+			serverErr := make(chan error, 1)
+			go func() {
+				_, err := server.ReadMsg()
+				serverErr <- err
+			}()
+			err := client.Handshake()
+			if !errors.Is(err, ErrHandshakeFailed) {
+				t.Fatalf("Expected ErrHandshakeFailed, got %v", err)
+			}
+			if clientOpts.RevocationChecker != (failingChecker{}) && !errors.Is(err, ErrKeyRevoked) {
+				t.Fatalf("Expected ErrKeyRevoked, got %v", err)
+			}
+			// The server's handshake succeeded, it's told why the client hung up
+			var alert *AlertError
+			if err := <-serverErr; !errors.As(err, &alert) || alert.Alert != AlertAuthFailed {
+				t.Fatalf("Unexpected server error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package snacl
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Key rotation replaces a server's key pair before it expires without breaking clients that pinned
+// it. A KeyRotator generates the replacement RenewBefore ahead of the current key's expiry, so it can
+// be published (see KeyRotator.OnRotate) while the current key is still in use, and keeps the old key
+// for Grace after it expires for clients that haven't caught up yet.
+//
+// The server picks the key per connection: clients send the keys they accept, Options.PeerKeys, in
+// their hello, and a server with Options.GetKeys reads the hello before sending its own, so it can
+// answer with one of them. That costs the client nothing, it waits for the server's hello anyway.
+
+// KeyInfo is a key pair with when it was made and when it expires
+type KeyInfo struct {
+	Keys    *Keys
+	Created time.Time
+	Expires time.Time
+}
+
+// Default KeyRotator settings
+const (
+	DefaultKeyLifetime = 90 * 24 * time.Hour
+	DefaultRenewBefore = 14 * 24 * time.Hour
+	DefaultKeyGrace    = 7 * 24 * time.Hour
+)
+
+// ErrNoKeys is returned by KeyRotator.GetKeys when there's no usable key and none could be generated
+var ErrNoKeys = errors.New("no usable key pair")
+
+// KeyRotator keeps a server's key pairs and rotates them, see rotation.go. Its GetKeys method is meant
+// for Options.GetKeys. Keys are rotated as they're asked for, there's no background goroutine.
+type KeyRotator struct {
+	// Lifetime is how long a key is used for, DefaultKeyLifetime if 0
+	Lifetime time.Duration
+	// RenewBefore is how long before the current key expires its replacement is generated,
+	// DefaultRenewBefore if 0
+	RenewBefore time.Duration
+	// Grace is how long an expired key is still used for clients that only accept it, DefaultKeyGrace
+	// if 0
+	Grace time.Duration
+	// Rand is the source of randomness for new keys, crypto/rand.Reader if nil
+	Rand io.Reader
+	// OnRotate is called with every key the rotator generates, to publish and store it. It's called
+	// without locks held, but a slow OnRotate holds up the handshake that caused the rotation.
+	OnRotate func(KeyInfo)
+
+	mu sync.Mutex
+	// keys are oldest first
+	keys []KeyInfo
+}
+
+// Add gives the rotator a key it already had, from storage, keys added out of order are sorted
+func (r *KeyRotator) Add(info KeyInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := len(r.keys)
+	for i > 0 && r.keys[i-1].Expires.After(info.Expires) {
+		i--
+	}
+	r.keys = append(r.keys[:i], append([]KeyInfo{info}, r.keys[i:]...)...)
+}
+
+// Keys returns the keys the rotator still uses, oldest first
+func (r *KeyRotator) Keys() []KeyInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]KeyInfo(nil), r.keys...)
+}
+
+// GetKeys returns the key pair to use with a client that accepts peerKeys, rotating first if it's
+// time. It's the newest key the client accepts, or the current key if it accepts none of ours or
+// didn't say.
+func (r *KeyRotator) GetKeys(peerKeys [][32]byte) (*Keys, error) {
+	return r.getKeys(peerKeys, time.Now())
+}
+
+func (r *KeyRotator) getKeys(peerKeys [][32]byte, now time.Time) (*Keys, error) {
+	generated, err := r.rotate(now)
+	if err != nil {
+		return nil, err
+	}
+	if r.OnRotate != nil && generated != nil {
+		r.OnRotate(*generated)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		for _, key := range peerKeys {
+			if r.keys[i].Keys.Public == key {
+				return r.keys[i].Keys, nil
+			}
+		}
+	}
+	// The current key is the oldest that hasn't expired, the ones after it haven't been published for
+	// long
+	for _, info := range r.keys {
+		if now.Before(info.Expires) {
+			return info.Keys, nil
+		}
+	}
+	return nil, ErrNoKeys
+}
+
+// rotate drops keys past their grace period and generates a replacement if the newest key expires
+// within RenewBefore, it returns the new key if there is one
+func (r *KeyRotator) rotate(now time.Time) (*KeyInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	grace := r.Grace
+	if grace == 0 {
+		grace = DefaultKeyGrace
+	}
+	for len(r.keys) > 0 && !now.Before(r.keys[0].Expires.Add(grace)) {
+		r.keys = r.keys[1:]
+	}
+
+	renewBefore := r.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = DefaultRenewBefore
+	}
+	lifetime := r.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultKeyLifetime
+	}
+	if lifetime <= renewBefore {
+		return nil, errors.New("KeyRotator.Lifetime must be longer than RenewBefore")
+	}
+	if len(r.keys) > 0 && now.Before(r.keys[len(r.keys)-1].Expires.Add(-renewBefore)) {
+		return nil, nil
+	}
+
+	keys, err := GenerateKeys((&Options{Rand: r.Rand}).rand())
+	if err != nil {
+		return nil, err
+	}
+	// A replacement takes over when the key before it expires, and lasts a lifetime from then
+	start := now
+	if len(r.keys) > 0 && r.keys[len(r.keys)-1].Expires.After(now) {
+		start = r.keys[len(r.keys)-1].Expires
+	}
+	info := KeyInfo{Keys: keys, Created: now, Expires: start.Add(lifetime)}
+	r.keys = append(r.keys, info)
+	return &info, nil
+}
@@ -0,0 +1,139 @@
+package snacl
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeyRotator(t *testing.T) {
+	var rotated []KeyInfo
+	r := &KeyRotator{
+		Lifetime:    10 * time.Hour,
+		RenewBefore: 2 * time.Hour,
+		Grace:       time.Hour,
+		OnRotate:    func(info KeyInfo) { rotated = append(rotated, info) },
+	}
+	start := time.Now()
+	at := func(d time.Duration, peerKeys ...[32]byte) *Keys {
+		keys, err := r.getKeys(peerKeys, start.Add(d))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return keys
+	}
+
+	first := at(0)
+	if len(rotated) != 1 || rotated[0].Keys != first || !rotated[0].Expires.Equal(start.Add(10*time.Hour)) {
+		t.Fatalf("Unexpected result: %+v", rotated)
+	}
+	if at(7*time.Hour) != first || len(rotated) != 1 {
+		t.Fatal("Unexpected result: rotated too early")
+	}
+
+	// The replacement is generated ahead of time, but only used by clients that ask for it until the
+	// first key expires
+	if at(8*time.Hour) != first || len(rotated) != 2 {
+		t.Fatal("Unexpected result: no replacement")
+	}
+	second := rotated[1].Keys
+	if !rotated[1].Expires.Equal(start.Add(20 * time.Hour)) {
+		t.Fatalf("Unexpected result: %v", rotated[1].Expires)
+	}
+	if at(8*time.Hour, second.Public) != second {
+		t.Fatal("Unexpected result: the replacement wasn't picked")
+	}
+	if at(8*time.Hour, first.Public, second.Public) != second {
+		t.Fatal("Unexpected result: the newest key wasn't picked")
+	}
+
+	// After the first key expires it's only used for clients that accept nothing else, until the grace
+	// period is over
+	if at(10*time.Hour) != second {
+		t.Fatal("Unexpected result: the replacement didn't take over")
+	}
+	if at(10*time.Hour+30*time.Minute, first.Public) != first {
+		t.Fatal("Unexpected result: the old key wasn't kept for the grace period")
+	}
+	if at(11*time.Hour, first.Public) != second || len(r.Keys()) != 1 {
+		t.Fatalf("Unexpected result: %+v", r.Keys())
+	}
+}
+
+func TestKeyRotatorSettings(t *testing.T) {
+	r := &KeyRotator{Lifetime: time.Hour, RenewBefore: time.Hour}
+	if _, err := r.GetKeys(nil); err == nil {
+		t.Fatal("Unexpected result: no error for RenewBefore as long as Lifetime")
+	}
+
+	// Keys from storage are kept in order
+	r = &KeyRotator{}
+	now := time.Now()
+	older := KeyInfo{Keys: mustGenerateKeys(t), Created: now.Add(-time.Hour), Expires: now.Add(DefaultKeyLifetime)}
+	newer := KeyInfo{Keys: mustGenerateKeys(t), Created: now, Expires: now.Add(2 * DefaultKeyLifetime)}
+	r.Add(newer)
+	r.Add(older)
+	if keys := r.Keys(); len(keys) != 2 || keys[0].Keys != older.Keys {
+		t.Fatalf("Unexpected result: %+v", keys)
+	}
+	if keys, err := r.GetKeys(nil); err != nil || keys != older.Keys {
+		t.Fatalf("Unexpected result: %v %v", keys, err)
+	}
+}
+
+func TestKeyRotationHandshake(t *testing.T) {
+	now := time.Now()
+	old := KeyInfo{Keys: mustGenerateKeys(t), Created: now.Add(-DefaultKeyLifetime), Expires: now.Add(time.Hour)}
+	replacement := KeyInfo{Keys: mustGenerateKeys(t), Created: now, Expires: now.Add(DefaultKeyLifetime)}
+	r := &KeyRotator{}
+	r.Add(old)
+	r.Add(replacement)
+	serverOpts := &Options{GetKeys: r.GetKeys}
+
+	// Clients get the key they pinned, and the current key if they didn't pin one
+	for _, tt := range []struct {
+		peerKeys [][32]byte
+		expected [32]byte
+	}{
+		{nil, old.Keys.Public},
+		{[][32]byte{old.Keys.Public}, old.Keys.Public},
+		{[][32]byte{replacement.Keys.Public}, replacement.Keys.Public},
+		{[][32]byte{old.Keys.Public, replacement.Keys.Public}, replacement.Keys.Public},
+	} {
+		client, server := pipeOptions(t, &Options{PeerKeys: tt.peerKeys}, serverOpts)
+		if client.PeerPublicKey() != tt.expected || server.LocalPublicKey() != tt.expected {
+			t.Fatalf("Unexpected result for %d pinned keys: %x", len(tt.peerKeys), client.PeerPublicKey())
+		}
+		go client.Write([]byte("hello"))
+		if msg, err := server.ReadMsg(); err != nil || string(msg.Data) != "hello" {
+			t.Fatalf("Unexpected result: %v", err)
+		}
+	}
+
+	// A client that pinned a key the server doesn't have gives up
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := Server(c2, serverOpts).ReadMsg()
+		serverErr <- err
+	}()
+	client := Client(c1, &Options{PeerKeys: [][32]byte{mustGenerateKeys(t).Public}})
+	if err := client.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Expected ErrHandshakeFailed, got %v", err)
+	}
+	var alert *AlertError
+	if err := <-serverErr; !errors.As(err, &alert) || alert.Alert != AlertAuthFailed {
+		t.Fatalf("Unexpected server error: %v", err)
+	}
+
+	// GetKeys is only for Version1 servers
+	c3, c4 := net.Pipe()
+	defer c3.Close()
+	defer c4.Close()
+	if err := Client(c3, serverOpts).Handshake(); err == nil {
+		t.Fatal("Unexpected result: no error for a client with GetKeys")
+	}
+}
@@ -0,0 +1,68 @@
+package snacl
+
+import (
+	"net/rpc"
+	"sync"
+)
+
+// net/rpc over a Conn: every request and response is two messages, the header and then the body, each
+// encoded with the codec. Serve with rpc.ServeCodec(snacl.NewServerCodec(conn, snacl.GobCodec)) and call
+// with rpc.NewClientWithCodec(snacl.NewClientCodec(conn, snacl.GobCodec)).
+
+// NewServerCodec returns a net/rpc ServerCodec for the server side of c
+func NewServerCodec(c *Conn, codec Codec) rpc.ServerCodec {
+	return &rpcCodec{c: c, enc: NewEncoder(c, codec), dec: NewDecoder(c, codec)}
+}
+
+// NewClientCodec returns a net/rpc ClientCodec for the client side of c
+func NewClientCodec(c *Conn, codec Codec) rpc.ClientCodec {
+	return &rpcCodec{c: c, enc: NewEncoder(c, codec), dec: NewDecoder(c, codec)}
+}
+
+// rpcCodec is both codecs, the server reads requests and writes responses and the client does the
+// opposite
+type rpcCodec struct {
+	c   *Conn
+	enc *Encoder
+	dec *Decoder
+	// mu keeps a header and its body together, net/rpc already serializes writes but doesn't promise to
+	mu sync.Mutex
+}
+
+func (rc *rpcCodec) ReadRequestHeader(r *rpc.Request) error {
+	return rc.dec.Decode(r)
+}
+
+func (rc *rpcCodec) ReadRequestBody(body any) error {
+	return rc.dec.Decode(body)
+}
+
+func (rc *rpcCodec) WriteResponse(r *rpc.Response, body any) error {
+	return rc.write(r, body)
+}
+
+func (rc *rpcCodec) WriteRequest(r *rpc.Request, body any) error {
+	return rc.write(r, body)
+}
+
+func (rc *rpcCodec) ReadResponseHeader(r *rpc.Response) error {
+	return rc.dec.Decode(r)
+}
+
+func (rc *rpcCodec) ReadResponseBody(body any) error {
+	return rc.dec.Decode(body)
+}
+
+func (rc *rpcCodec) write(header, body any) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	err := rc.enc.Encode(header)
+	if err != nil {
+		return err
+	}
+	return rc.enc.Encode(body)
+}
+
+func (rc *rpcCodec) Close() error {
+	return rc.c.Close()
+}
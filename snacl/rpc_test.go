@@ -0,0 +1,76 @@
+package snacl
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+)
+
+type Arith struct{}
+
+type Args struct {
+	A, B int
+}
+
+func (Arith) Divide(args *Args, quotient *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*quotient = args.A / args.B
+	return nil
+}
+
+func TestRPC(t *testing.T) {
+	for name, codec := range map[string]Codec{"gob": GobCodec, "json": JSONCodec} {
+		t.Run(name, func(t *testing.T) {
+			client, server := pipe(t, nil)
+			srv := rpc.NewServer()
+			if err := srv.Register(Arith{}); err != nil {
+				t.Fatal(err)
+			}
+			go srv.ServeCodec(NewServerCodec(server, codec))
+
+			c := rpc.NewClientWithCodec(NewClientCodec(client, codec))
+			defer c.Close()
+			var quotient int
+			if err := c.Call("Arith.Divide", &Args{7, 2}, &quotient); err != nil {
+				t.Fatal(err)
+			}
+			if quotient != 3 {
+				t.Fatalf("Unexpected result: %d", quotient)
+			}
+			if err := c.Call("Arith.Divide", &Args{1, 0}, &quotient); err == nil || err.Error() != "divide by zero" {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := c.Call("Arith.Missing", &Args{}, &quotient); err == nil {
+				t.Fatal("Unexpected result. A missing method was called.")
+			}
+			// The connection is still in step after the errors
+			if err := c.Call("Arith.Divide", &Args{9, 3}, &quotient); err != nil || quotient != 3 {
+				t.Fatalf("Unexpected result: %d %v", quotient, err)
+			}
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	client, server := pipe(t, nil)
+	type point struct{ X, Y int }
+	go func() {
+		enc := NewEncoder(client, JSONCodec)
+		enc.Encode(point{1, 2})
+		enc.Encode(point{3, 4})
+	}()
+
+	dec := NewDecoder(server, JSONCodec)
+	if err := dec.Decode(nil); err != nil {
+		t.Fatal(err)
+	}
+	var p point
+	if err := dec.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{3, 4}) {
+		t.Fatalf("Unexpected result: %+v", p)
+	}
+}
@@ -0,0 +1,227 @@
+// Package secretstream encrypts files and streams in the format of libsodium's
+// crypto_secretstream_xchacha20poly1305, so they can be decrypted by sodium based tools in other
+// languages and the other way around.
+//
+// A stream is a HeaderSize byte header followed by chunks, each one ABytes longer than its message.
+// Every chunk carries a tag, and the last one is tagged TagFinal so a truncated stream is detected.
+// Encryptor and Decryptor are crypto_secretstream_xchacha20poly1305_push and _pull, and Writer and
+// Reader split a stream into chunks of a fixed size the way libsodium's documentation does.
+package secretstream
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
+)
+
+const (
+	// KeySize is the size of a key
+	KeySize = 32
+	// HeaderSize is the size of the header at the start of a stream
+	HeaderSize = 24
+	// ABytes is how much longer a chunk is than its message, the encrypted tag and the MAC
+	ABytes = 1 + poly1305.TagSize
+)
+
+// Tags, the same values as libsodium's crypto_secretstream_xchacha20poly1305_TAG_*
+const (
+	// TagMessage is an ordinary chunk
+	TagMessage byte = 0
+	// TagPush marks the end of a set of chunks, it doesn't change the keys
+	TagPush byte = 1
+	// TagRekey switches to new keys after the chunk
+	TagRekey byte = 2
+	// TagFinal is the last chunk of the stream
+	TagFinal = TagPush | TagRekey
+)
+
+var (
+	// ErrDecrypt is returned for a chunk that doesn't authenticate, it was corrupted, tampered with,
+	// reordered or encrypted with a different key
+	ErrDecrypt = errors.New("secretstream: failed to decrypt chunk")
+	// ErrTruncated is returned when a stream ends without a TagFinal chunk
+	ErrTruncated = errors.New("secretstream: stream ended before the final chunk")
+	// ErrTrailingData is returned for data after the TagFinal chunk
+	ErrTrailingData = errors.New("secretstream: data after the final chunk")
+)
+
+// state is crypto_secretstream_xchacha20poly1305_state
+type state struct {
+	k [KeySize]byte
+	// nonce is a 32 bit little-endian counter followed by the 8 byte inonce
+	nonce [chacha20.NonceSize]byte
+}
+
+func (s *state) init(key *[KeySize]byte, header []byte) error {
+	k, err := chacha20.HChaCha20(key[:], header[:16])
+	if err != nil {
+		return err
+	}
+	copy(s.k[:], k)
+	s.resetCounter()
+	copy(s.nonce[4:], header[16:HeaderSize])
+	return nil
+}
+
+func (s *state) resetCounter() {
+	binary.LittleEndian.PutUint32(s.nonce[:4], 1)
+}
+
+// stream returns the ChaCha20 keystream for the current key and nonce, starting at block counter
+func (s *state) stream(counter uint32) *chacha20.Cipher {
+	c, err := chacha20.NewUnauthenticatedCipher(s.k[:], s.nonce[:])
+	if err != nil {
+		// The key and nonce sizes are fixed
+		panic(err)
+	}
+	c.SetCounter(counter)
+	return c
+}
+
+// mac returns the Poly1305 MAC of the chunk the way libsodium computes it: the additional data padded
+// to 16 bytes, the encrypted tag block and message, padding, then their lengths. libsodium pads the
+// message with (0x10 - 64 + mlen) & 0xf zeros, which is mlen%16 rather than up to a multiple of 16.
+func (s *state) mac(ad, block, c []byte) [poly1305.TagSize]byte {
+	var macKey [32]byte
+	s.stream(0).XORKeyStream(macKey[:], macKey[:])
+	mac := poly1305.New(&macKey)
+
+	var pad [16]byte
+	mac.Write(ad)
+	mac.Write(pad[:(16-len(ad)%16)%16])
+	mac.Write(block)
+	mac.Write(c)
+	mac.Write(pad[:len(c)%16])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(block)+len(c)))
+	mac.Write(lengths[:])
+
+	var sum [poly1305.TagSize]byte
+	mac.Sum(sum[:0])
+	return sum
+}
+
+// next moves on to the next chunk's nonce, and rekeys after a TagRekey chunk or when the counter wraps
+func (s *state) next(mac []byte, tag byte) {
+	subtle.XORBytes(s.nonce[4:], s.nonce[4:], mac[:8])
+	counter := binary.LittleEndian.Uint32(s.nonce[:4]) + 1
+	binary.LittleEndian.PutUint32(s.nonce[:4], counter)
+	if tag&TagRekey != 0 || counter == 0 {
+		s.rekey()
+	}
+}
+
+// rekey is crypto_secretstream_xchacha20poly1305_rekey
+func (s *state) rekey() {
+	var buf [KeySize + 8]byte
+	copy(buf[:], s.k[:])
+	copy(buf[KeySize:], s.nonce[4:])
+	s.stream(0).XORKeyStream(buf[:], buf[:])
+	copy(s.k[:], buf[:KeySize])
+	copy(s.nonce[4:], buf[KeySize:])
+	s.resetCounter()
+}
+
+// Encryptor encrypts the chunks of a stream
+type Encryptor struct {
+	s state
+}
+
+// NewEncryptor starts a stream with key and returns its Encryptor and header, which has to be sent
+// before the chunks. rand is the source of the header, crypto/rand.Reader if nil.
+func NewEncryptor(key *[KeySize]byte, rand io.Reader) (*Encryptor, []byte, error) {
+	if rand == nil {
+		rand = cryptorand.Reader
+	}
+	header := make([]byte, HeaderSize)
+	_, err := io.ReadFull(rand, header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := &Encryptor{}
+	err = e.s.init(key, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, header, nil
+}
+
+// Push encrypts msg with its additional data ad and tag, appends the chunk to out and returns it.
+// ad may be nil.
+func (e *Encryptor) Push(out, msg, ad []byte, tag byte) []byte {
+	var block [64]byte
+	block[0] = tag
+	e.s.stream(1).XORKeyStream(block[:], block[:])
+
+	start := len(out)
+	out = append(out, block[0])
+	out = append(out, msg...)
+	c := out[start+1:]
+	e.s.stream(2).XORKeyStream(c, c)
+
+	mac := e.s.mac(ad, block[:], c)
+	out = append(out, mac[:]...)
+	e.s.next(mac[:], tag)
+	return out
+}
+
+// Rekey switches to new keys without telling the other side, which has to call Rekey at the same point
+func (e *Encryptor) Rekey() {
+	e.s.rekey()
+}
+
+// Decryptor decrypts the chunks of a stream
+type Decryptor struct {
+	s state
+}
+
+// NewDecryptor returns the Decryptor for a stream with key and the header it started with
+func NewDecryptor(key *[KeySize]byte, header []byte) (*Decryptor, error) {
+	if len(header) != HeaderSize {
+		return nil, errors.New("secretstream: bad header length")
+	}
+	d := &Decryptor{}
+	err := d.s.init(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Pull decrypts a chunk with its additional data ad, appends the message to out and returns it with
+// the chunk's tag. ad may be nil. A chunk that fails leaves the Decryptor unchanged.
+func (d *Decryptor) Pull(out, chunk, ad []byte) ([]byte, byte, error) {
+	if len(chunk) < ABytes {
+		return nil, 0, ErrDecrypt
+	}
+	c, expected := chunk[1:len(chunk)-poly1305.TagSize], chunk[len(chunk)-poly1305.TagSize:]
+
+	var block [64]byte
+	block[0] = chunk[0]
+	d.s.stream(1).XORKeyStream(block[:], block[:])
+	tag := block[0]
+	block[0] = chunk[0]
+
+	mac := d.s.mac(ad, block[:], c)
+	if subtle.ConstantTimeCompare(mac[:], expected) != 1 {
+		return nil, 0, ErrDecrypt
+	}
+
+	start := len(out)
+	out = append(out, c...)
+	d.s.stream(2).XORKeyStream(out[start:], out[start:])
+	d.s.next(mac[:], tag)
+	return out, tag, nil
+}
+
+// Rekey switches to new keys, see Encryptor.Rekey
+func (d *Decryptor) Rekey() {
+	d.s.rekey()
+}
@@ -0,0 +1,135 @@
+package secretstream
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+// libsodiumStream was made by libsodium 1.0.18 with the key 00 01 02 .. 1f, pushing the chunks in
+// libsodiumChunks with crypto_secretstream_xchacha20poly1305_push
+const libsodiumStream = "4f4275419cd69603c9d9d18bb91d31db9d85b70d6da8a0aafc35c0ecb5aa05f83b08f89be8d679b16d0296eaba89237dae3245a7995184ed5e45820d661238361bafb57f8cd1f60caac25c0f7ae1ab533ba768092ab1a6e9fa78275faf50d5b66990b690797d82c6d934ba98ee4265a6ff6b5e5a7b5e315086750c80329f4736589d72deeb49e6e025116e17dfc586397d219e180a3a68e13cb1b1fc3b9ca9ae8752862abb194e70f56d1ec9bdafd05599d477e6a8a1ff7d45202adb79805c7250067e02f6a985a093493b9474a702c3522ffa56d6a99270691b075347f33d4c"
+
+var libsodiumChunks = []struct {
+	msg, ad []byte
+	tag     byte
+}{
+	{[]byte("hello "), nil, TagMessage},
+	{[]byte("secret"), []byte("ad"), TagRekey},
+	{bytes.Repeat([]byte("stream"), 20), nil, TagMessage},
+	{nil, nil, TagFinal},
+}
+
+func testKey() *[KeySize]byte {
+	var key [KeySize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return &key
+}
+
+func TestLibsodiumCompatible(t *testing.T) {
+	stream, err := hex.DecodeString(libsodiumStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := stream[:HeaderSize]
+
+	// Pushing the same chunks with the same header gives the same bytes
+	enc, gotHeader, err := NewEncryptor(testKey(), bytes.NewReader(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gotHeader
+	for _, c := range libsodiumChunks {
+		got = enc.Push(got, c.msg, c.ad, c.tag)
+	}
+	if !bytes.Equal(got, stream) {
+		t.Fatalf("Unexpected stream:\nGot:%x\nExpected:%x\n", got, stream)
+	}
+
+	// And libsodium's stream pulls
+	dec, err := NewDecryptor(testKey(), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := stream[HeaderSize:]
+	for _, c := range libsodiumChunks {
+		n := len(c.msg) + ABytes
+		msg, tag, err := dec.Pull(nil, rest[:n], c.ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, c.msg) || tag != c.tag {
+			t.Fatalf("Unexpected chunk: %q tag %d", msg, tag)
+		}
+		rest = rest[n:]
+	}
+}
+
+func TestPullTampered(t *testing.T) {
+	enc, header, err := NewEncryptor(testKey(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := enc.Push(nil, []byte("first"), nil, TagMessage)
+	second := enc.Push(nil, []byte("second"), nil, TagFinal)
+
+	dec, err := NewDecryptor(testKey(), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chunks can't be reordered
+	if _, _, err := dec.Pull(nil, second, nil); err != ErrDecrypt {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first[len(first)-1] ^= 1
+	if _, _, err := dec.Pull(nil, first, nil); err != ErrDecrypt {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A failed chunk doesn't change the state
+	first[len(first)-1] ^= 1
+	if _, _, err := dec.Pull(nil, first, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	for _, size := range []int{0, 1, 99, 100, 101, 1000} {
+		var stream bytes.Buffer
+		w, err := NewWriterSize(&stream, testKey(), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := bytes.Repeat([]byte{'x'}, size)
+		if _, err := w.Write(expected); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted := stream.Bytes()
+		r, err := NewReaderSize(bytes.NewReader(encrypted), testKey(), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("Unexpected result for %d bytes: got %d bytes", size, len(got))
+		}
+
+		// Cutting off the final chunk is noticed
+		r, err = NewReaderSize(bytes.NewReader(encrypted[:len(encrypted)-ABytes]), testKey(), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err != ErrTruncated && err != ErrDecrypt {
+			t.Fatalf("Unexpected error for %d bytes: %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package secretstream
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultChunkSize is the message size of each chunk Writer writes, the size libsodium's documentation
+// uses for files. Both sides have to use the same chunk size.
+const DefaultChunkSize = 4096
+
+// Writer encrypts everything written to it as a stream. The last chunk is only written by Close, so
+// Close must be called or the stream reads as truncated.
+type Writer struct {
+	w         io.Writer
+	enc       *Encryptor
+	chunkSize int
+	// buf holds up to chunkSize bytes that haven't been pushed yet, out is scratch for the chunk
+	buf, out []byte
+	err      error
+}
+
+// NewWriter writes the header of a new stream with key to w and returns a Writer for the rest of it,
+// with DefaultChunkSize chunks
+func NewWriter(w io.Writer, key *[KeySize]byte) (*Writer, error) {
+	return NewWriterSize(w, key, DefaultChunkSize)
+}
+
+// NewWriterSize is NewWriter with chunks of chunkSize bytes
+func NewWriterSize(w io.Writer, key *[KeySize]byte, chunkSize int) (*Writer, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("secretstream: chunk size must be positive")
+	}
+	enc, header, err := NewEncryptor(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, enc: enc, chunkSize: chunkSize, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// Write encrypts p. Chunks are written once they're full and there's more after them, so the final
+// chunk can be tagged by Close.
+func (sw *Writer) Write(p []byte) (n int, err error) {
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	for len(p) > 0 {
+		if len(sw.buf) == sw.chunkSize {
+			err = sw.push(TagMessage)
+			if err != nil {
+				return n, err
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):sw.chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close writes the final chunk. It doesn't close the underlying writer.
+func (sw *Writer) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	err := sw.push(TagFinal)
+	if err == nil {
+		sw.err = errors.New("secretstream: write after Close")
+	}
+	return err
+}
+
+func (sw *Writer) push(tag byte) error {
+	sw.out = sw.enc.Push(sw.out[:0], sw.buf, nil, tag)
+	sw.buf = sw.buf[:0]
+	_, err := sw.w.Write(sw.out)
+	if err != nil {
+		sw.err = err
+	}
+	return err
+}
+
+// Reader decrypts a stream written by Writer, or by libsodium with the same chunk size
+type Reader struct {
+	r         io.Reader
+	dec       *Decryptor
+	chunkSize int
+	// chunk is scratch for the next chunk, msg is the decrypted message not read yet
+	chunk, msg []byte
+	done       bool
+	err        error
+}
+
+// NewReader reads the header of a stream with key from r and returns a Reader for the rest of it,
+// with DefaultChunkSize chunks
+func NewReader(r io.Reader, key *[KeySize]byte) (*Reader, error) {
+	return NewReaderSize(r, key, DefaultChunkSize)
+}
+
+// NewReaderSize is NewReader with chunks of chunkSize bytes
+func NewReaderSize(r io.Reader, key *[KeySize]byte, chunkSize int) (*Reader, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("secretstream: chunk size must be positive")
+	}
+	header := make([]byte, HeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := NewDecryptor(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, dec: dec, chunkSize: chunkSize, chunk: make([]byte, chunkSize+ABytes)}, nil
+}
+
+// Read decrypts into p. It returns io.EOF after the final chunk, and ErrTruncated if the stream
+// ends before it.
+func (sr *Reader) Read(p []byte) (n int, err error) {
+	for len(sr.msg) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		sr.err = sr.next()
+	}
+	n = copy(p, sr.msg)
+	sr.msg = sr.msg[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk into msg
+func (sr *Reader) next() error {
+	if sr.done {
+		return io.EOF
+	}
+	n, err := io.ReadFull(sr.r, sr.chunk)
+	if err == io.EOF {
+		return ErrTruncated
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	var tag byte
+	sr.msg, tag, err = sr.dec.Pull(sr.msg[:0], sr.chunk[:n], nil)
+	if err != nil {
+		return err
+	}
+	if tag == TagFinal {
+		sr.done = true
+		var extra [1]byte
+		if m, _ := io.ReadFull(sr.r, extra[:]); m != 0 {
+			return ErrTrailingData
+		}
+		return nil
+	}
+	// Only the final chunk may be short
+	if n < len(sr.chunk) {
+		return ErrTruncated
+	}
+	return nil
+}
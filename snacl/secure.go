@@ -0,0 +1,689 @@
+// Package snacl secures a stream using NaCl boxes.
+//
+// Every message is sealed with box.SealAfterPrecomputation and sent as a frame, see WireFormat.
+// Reader and Writer work on any stream once the keys are known, Conn does the key exchange for you
+// and can negotiate other ciphers, see CipherSuite.
+package snacl
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// WireFormat describes the canonical frame format. Every frame is [length][nonce][box]:
+// length is a uint32 in network byte order (big-endian) covering the nonce and the box,
+// nonce is 24 bytes, and box is the message sealed with box.SealAfterPrecomputation.
+// Other cipher suites keep the layout with their own nonce and overhead, see CipherSuite.
+const WireFormat = "snacl/1 [length uint32be][nonce 24][box]"
+
+// MaxMessageLength is the default maximum size of a message. This is to prevent memory allocation attacks.
+// In this case, we use 32kb - 1 since that's the challeges max length.
+// Connections can negotiate a different maximum, see Options.MaxMessageLength.
+const MaxMessageLength = 31999
+
+// Message is a representation of an indivudal message that can be encoded and decoded
+type Message struct {
+	// Data is the underlying data
+	Data []byte
+	// AssociatedData is sent in the clear alongside Data and authenticated with it, like a routing header.
+	// It's only sent on connections that negotiated it, see Options.AssociatedData.
+	AssociatedData []byte
+	// Type is the message type, sealed with Data, see Conn.WriteTyped. 0 is an ordinary message.
+	Type byte
+}
+
+// encoder encrypts a Message and sends it over a Writer
+type encoder struct {
+	w         io.Writer
+	sharedKey *[32]byte
+	// lockedKey is set when sharedKey came from memlock.NewKey, see wipe.go
+	lockedKey bool
+	// suite is the cipher suite, aead is its cipher keyed with sharedKey
+	suite CipherSuite
+	aead  cipher.AEAD
+	// rand is where nonces come from, crypto/rand unless the connection has its own DRBG
+	rand io.Reader
+	// maxLength is the biggest message that's sent, MaxMessageLength unless it was negotiated
+	maxLength int
+	// stats counts sent messages for a Conn, it's nil otherwise
+	stats *counters
+
+	// mu serializes messages, so a key update never happens half way through one
+	mu sync.Mutex
+	// typed is set for Version1 connections, every message starts with a record type, see record.go
+	typed bool
+	// rekey decides when the sending key is updated, it's only used when typed is set
+	rekey rekeyPolicy
+	// withAAD is set when every frame carries associated data, see sealFrameAAD
+	withAAD bool
+	// padding pads every record when it's set, see padding.go. It's only used when typed is set.
+	padding Padding
+	// lengths encrypts the length prefixes when it's set, see lengths.go
+	lengths *lengthCipher
+	// compress is set when messages are compressed, see compress.go. It's only used when typed is set.
+	compress bool
+	// flow is the flow control that decides when a message can be sent, see flow.go. It's nil unless
+	// flow control was negotiated.
+	flow *flowControl
+	// idle is told about every frame sent when Options.IdleTimeout is set, see idle.go
+	idle *idleTimer
+	// ratchet is set for the double ratchet, every record then has a key of its own, see ratchet.go
+	ratchet *ratchet
+}
+
+// newEncoder allocates an encoder and initializes it for you, it uses NaClBox until setSuite is called.
+func newEncoder(w io.Writer, sharedKey *[32]byte) *encoder {
+	enc := &encoder{}
+	enc.w = w
+	enc.sharedKey = sharedKey
+	enc.suite = NaClBox
+	enc.aead = newSecretbox(sharedKey)
+	enc.rand = rand.Reader
+	enc.maxLength = MaxMessageLength
+
+	return enc
+}
+
+// setSuite switches the encoder to suite, keyed with its current key
+func (enc *encoder) setSuite(suite CipherSuite) error {
+	aead, err := newAEAD(suite, enc.sharedKey)
+	if err != nil {
+		return err
+	}
+	enc.suite, enc.aead = suite, aead
+	return nil
+}
+
+// Encode encrypts a Message and sends it over a Writer.
+// The whole frame is assembled first and sent with a single write loop, so a failure can't leave
+// a length prefix on the stream without the box that goes with it.
+func (enc *encoder) Encode(msg *Message) error {
+	if len(msg.AssociatedData) > 0 && !enc.withAAD {
+		return ErrNoAssociatedData
+	}
+	if len(msg.AssociatedData) > MaxAssociatedDataLength {
+		return fmt.Errorf("associated data is too large (len:%d max:%d)", len(msg.AssociatedData), MaxAssociatedDataLength)
+	}
+	if msg.Type != 0 && !enc.typed {
+		return ErrNoMessageTypes
+	}
+
+	// Credit is taken before enc.mu, window updates have to be able to go out while we wait for it
+	if enc.flow != nil {
+		err := enc.flow.reserve(len(msg.Data))
+		if err != nil {
+			return err
+		}
+	}
+
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	// The key is updated before the message that's due, so an error means msg wasn't sent
+	if enc.typed && enc.rekey.due() {
+		err := enc.updateKey()
+		if err != nil {
+			return err
+		}
+	}
+
+	typ, data := recordData, msg.Data
+	var flags byte
+	if enc.typed && enc.compress {
+		if compressed, ok := compressRecord(msg.Data); ok {
+			typ, data = recordCompressed, compressed
+			flags = typedCompressed
+		}
+	}
+	if msg.Type != 0 {
+		typed := getBuffer(typedHeaderLength + len(data))
+		defer putBuffer(typed)
+		typ, data = recordTyped, append(append((*typed)[:0], msg.Type, flags), data...)
+	}
+	err := enc.writeRecord(typ, data, msg.AssociatedData)
+	if err != nil {
+		return err
+	}
+
+	enc.rekey.sent(len(msg.Data))
+	if enc.stats != nil {
+		enc.stats.record(len(msg.Data))
+	}
+	return nil
+}
+
+// writeRecord seals data and sends it with aad. typ is only sent when typed is set, and aad when
+// withAAD is set. enc.mu must be held.
+func (enc *encoder) writeRecord(typ byte, data, aad []byte) error {
+	if enc.aead == nil {
+		// The keys were wiped by Close
+		return net.ErrClosed
+	}
+	var nonce [wire.NonceLength]byte
+	err := wire.ReadNonce(enc.rand, nonce[:enc.aead.NonceSize()])
+	if err != nil {
+		return err
+	}
+
+	payload := data
+	if enc.typed {
+		size := len(data) + recordHeaderLength
+		if enc.padding != nil {
+			size = enc.maxLength + recordHeaderLength + padMarkerLength
+		}
+		plain := getBuffer(size)
+		defer putBuffer(plain)
+		payload = append(append((*plain)[:0], typ), data...)
+		if enc.padding != nil {
+			payload = padRecord(payload, enc.padding, size)
+		}
+	}
+
+	scratch := getBuffer(wire.HeaderLength + aadHeaderLength + len(aad) + enc.aead.NonceSize() + len(payload) + enc.aead.Overhead())
+	defer putBuffer(scratch)
+
+	var frame []byte
+	if enc.withAAD {
+		frame = sealFrameAAD((*scratch)[:0], payload, aad, nonce[:enc.aead.NonceSize()], enc.aead)
+	} else {
+		frame = sealFrame((*scratch)[:0], payload, nonce[:enc.aead.NonceSize()], enc.aead)
+	}
+	if enc.lengths != nil {
+		enc.lengths.apply(frame)
+	}
+	if enc.ratchet != nil {
+		err = enc.nextKey()
+		if err != nil {
+			return err
+		}
+	}
+	if enc.idle != nil {
+		enc.idle.active()
+	}
+	return wire.WriteFull(enc.w, frame)
+}
+
+// sealFrame appends the frame for msg to out and returns it, see WireFormat
+func sealFrame(out, msg, nonce []byte, aead cipher.AEAD) []byte {
+	start := len(out)
+	out = append(out, make([]byte, wire.HeaderLength)...)
+	out = append(out, nonce...)
+
+	// Seal appends the encrypted data to out and returns it
+	// We pass the header and nonce as out so we get returned data in the form [length][nonce][encryptedData]
+	out = aead.Seal(out, nonce, msg, nil)
+
+	// The length lets the reader know how much room to make when reading
+	binary.BigEndian.PutUint32(out[start:], uint32(len(out)-start-wire.HeaderLength))
+	return out
+}
+
+// decoder decrypts data from a Reader. The data is expected to be encoded by encoder
+type decoder struct {
+	r         io.Reader
+	sharedKey *[32]byte
+	// lockedKey is set when sharedKey came from memlock.NewKey, see wipe.go
+	lockedKey bool
+	// suite is the cipher suite, aead is its cipher keyed with sharedKey
+	suite CipherSuite
+	aead  cipher.AEAD
+	// maxLength is the biggest message that's accepted, MaxMessageLength unless it was negotiated
+	maxLength int
+	// stats counts opened messages for a Conn, it's nil otherwise
+	stats *counters
+	// typed is set for Version1 connections, every message starts with a record type, see record.go
+	typed bool
+	// withAAD is set when every frame carries associated data, see sealFrameAAD. aad is the associated
+	// data of the last message from next, it points into next's scratch buffer.
+	withAAD bool
+	aad     []byte
+	// padded is set when every record is padded, see padding.go. It's only used when typed is set.
+	padded bool
+	// lengths decrypts the length prefixes when it's set, see lengths.go
+	lengths *lengthCipher
+	// compressed is set when recordCompressed records are accepted, see compress.go
+	compressed bool
+	// flow takes window updates when flow control was negotiated, see flow.go
+	flow *flowControl
+	// keepalive is told about every frame and ping when keepalives were negotiated, see keepalive.go
+	keepalive *keepalive
+	// idle is told about every frame read when Options.IdleTimeout is set, see idle.go
+	idle *idleTimer
+	// ratchet is set for the double ratchet, every record then has a key of its own, see ratchet.go
+	ratchet *ratchet
+	// msgType is the type of the message next returned, see typed.go
+	msgType byte
+	// urgent is Options.OnUrgent when we offered to take urgent messages, see urgent.go
+	urgent func(msg []byte)
+
+	// wipeMu guards readers, the number of reads in progress, and closing, which is set once the keys
+	// are to be zeroed, see wipe.go
+	wipeMu  sync.Mutex
+	readers int
+	closing bool
+}
+
+// newDecoder allocates a decoder and initializes it for you, it uses NaClBox until setSuite is called.
+func newDecoder(r io.Reader, sharedKey *[32]byte) *decoder {
+	dec := &decoder{}
+	dec.r = r
+	dec.sharedKey = sharedKey
+	dec.suite = NaClBox
+	dec.aead = newSecretbox(sharedKey)
+	dec.maxLength = MaxMessageLength
+
+	return dec
+}
+
+// setSuite switches the decoder to suite, keyed with its current key
+func (dec *decoder) setSuite(suite CipherSuite) error {
+	aead, err := newAEAD(suite, dec.sharedKey)
+	if err != nil {
+		return err
+	}
+	dec.suite, dec.aead = suite, aead
+	return nil
+}
+
+// Decode decrypts a Message from the underlying Reader and stores it in m
+// The decrypted data is written over m.Data, so m.Data's memory is reused if it's big enough.
+func (dec *decoder) Decode(m *Message) error {
+	scratch := dec.getBuffer()
+	defer putBuffer(scratch)
+
+	data, err := dec.next(*scratch, m.Data[:cap(m.Data)], nil)
+	if err != nil {
+		return err
+	}
+	m.Data = data
+	m.AssociatedData = append(m.AssociatedData[:0], dec.aad...)
+	m.Type = dec.msgType
+	dec.aad = nil
+	return nil
+}
+
+// next reads frames until it gets a message, handling any control records on the way, and opens the
+// message into out. If spare is set and the message doesn't fit in out, it's opened into spare instead,
+// otherwise out is grown. scratch must come from dec.getBuffer, and nothing can overlap it.
+func (dec *decoder) next(scratch, out, spare []byte) ([]byte, error) {
+	err := dec.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer dec.leave()
+	for {
+		frame, err := dec.readFrame(scratch)
+		if err != nil {
+			return nil, err
+		}
+
+		var aad []byte
+		if dec.withAAD {
+			aad, frame, err = splitAAD(frame)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		dst := out
+		if spare != nil && dec.openedLength(frame) > len(out) {
+			dst = spare
+		}
+		data, err := dec.open(dst[:0], frame, aad)
+		if err != nil {
+			return nil, err
+		}
+		if dec.ratchet != nil {
+			err = dec.nextKey()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if dec.typed {
+			if dec.padded {
+				data, err = unpadRecord(data)
+				if err != nil {
+					return nil, err
+				}
+			}
+			var ok bool
+			dec.msgType = 0
+			data, ok, err = dec.handleRecord(data)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if dec.stats != nil {
+			dec.stats.record(len(data))
+		}
+		dec.aad = aad
+		return data, nil
+	}
+}
+
+// readFrame reads the next frame from the underlying Reader into buf and returns the [nonce][box] part of it.
+// buf must come from dec.getBuffer.
+func (dec *decoder) readFrame(buf []byte) ([]byte, error) {
+	// Length is the length of the encrypted data (including the nonce and the cipher's overhead)
+	var header [wire.HeaderLength]byte
+	_, err := io.ReadFull(dec.r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	if dec.lengths != nil {
+		dec.lengths.apply(header[:])
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	minLength := dec.aead.NonceSize() + dec.aead.Overhead()
+	if dec.withAAD {
+		minLength += aadHeaderLength
+	}
+	err = checkFrameLength(length, dec.maxFrameContents(), minLength)
+	if err != nil {
+		return nil, err
+	}
+
+	// To be able to decrypt properly, we must receive all the data that we encrypted with
+	frame := buf[:length]
+	_, err = io.ReadFull(dec.r, frame)
+	if err != nil {
+		return nil, err
+	}
+	if dec.keepalive != nil {
+		dec.keepalive.read()
+	}
+	if dec.idle != nil {
+		dec.idle.active()
+	}
+	return frame, nil
+}
+
+// getBuffer returns a scratch buffer big enough for any frame the decoder accepts
+func (dec *decoder) getBuffer() *[]byte {
+	return getBuffer(frameLength(dec.maxFrameContents()))
+}
+
+// maxFrameContents returns the most a frame can hold besides the nonce and the overhead, the sealed
+// payload and any associated data
+func (dec *decoder) maxFrameContents() int {
+	if dec.withAAD {
+		return dec.maxPayload() + aadHeaderLength + MaxAssociatedDataLength
+	}
+	return dec.maxPayload()
+}
+
+// maxPayload returns the biggest sealed payload the decoder accepts, the message and its record type
+func (dec *decoder) maxPayload() int {
+	if dec.typed && dec.padded {
+		return dec.maxLength + recordHeaderLength + padMarkerLength
+	}
+	if dec.typed {
+		return dec.maxLength + recordHeaderLength
+	}
+	return dec.maxLength
+}
+
+// openedLength returns the length of the message in a frame from readFrame
+func (dec *decoder) openedLength(frame []byte) int {
+	return len(frame) - dec.aead.NonceSize() - dec.aead.Overhead()
+}
+
+// open decrypts a frame from readFrame, appends the message to out and returns it. aad is the frame's
+// associated data, see splitAAD. out must not overlap frame.
+func (dec *decoder) open(out, frame, aad []byte) ([]byte, error) {
+	nonceSize := dec.aead.NonceSize()
+
+	// Open appends to out and returns the appended data
+	data, err := dec.aead.Open(out, frame[:nonceSize], frame[nonceSize:], aad)
+
+	// If it fails, we have failed to decrypt properly
+	// Usually this is because the encrypted data is malformed
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return data, nil
+}
+
+// Reader decrypts from a stream securely using public-key cryptography
+type Reader struct {
+	dec *decoder
+}
+
+// NewReader is a convenient helper method that allocates and initializes a secure reader for you
+// r is the underlying stream to read securely from
+// priv is your private key
+// pub is the public key of who you're communicating with
+func NewReader(r io.Reader, priv, pub *[32]byte) *Reader {
+	sr := &Reader{}
+	sr.Init(r, priv, pub)
+	return sr
+}
+
+// Init initializes our Reader
+// r is the underlying stream to read securely from
+// priv is your private key
+// pub is the public key of who you're communicating with
+func (sr *Reader) Init(r io.Reader, priv, pub *[32]byte) {
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, pub, priv)
+	sr.initKey(r, sharedKey)
+}
+
+// NewReaderPSK allocates and initializes a Reader that opens messages with secretbox and a pre-shared key,
+// for deployments where keys are exchanged out of band. The frames are the same as NewReader's, a box
+// with precomputed keys is a secretbox.
+// key is the pre-shared key, it should be 32 random bytes. See NewWriterPSK.
+func NewReaderPSK(r io.Reader, key *[32]byte) *Reader {
+	sr := &Reader{}
+	sr.initKey(r, *key)
+	return sr
+}
+
+// initKey initializes the Reader with a key that's already been worked out, like the keys Conn derives
+func (sr *Reader) initKey(r io.Reader, sharedKey [32]byte) {
+	sr.dec = newDecoder(r, &sharedKey)
+}
+
+// SetMaxMessageLength sets the biggest message the Reader accepts, by default that's MaxMessageLength.
+// SetMaxMessageLength must be called before the Reader is used.
+func (sr *Reader) SetMaxMessageLength(n int) {
+	sr.dec.maxLength = n
+}
+
+// ReadMsg decrypts an entire message from the underlying stream and returns it
+// ReadMsg is more effecient than calling .Read() because you don't need to preallocate
+// the max message size beforehand.
+func (sr *Reader) ReadMsg() (msg *Message, err error) {
+	msg = new(Message)
+
+	err = sr.dec.Decode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Read decrypts a box from the underlying stream and writes it to p []byte
+// p is expected to be big enough to hold the entire decrypted message, if it's not,
+// Read writes as much as it can to p []byte and discards the rest of the message.
+func (sr *Reader) Read(p []byte) (n int, err error) {
+	scratch := sr.dec.getBuffer()
+	defer putBuffer(scratch)
+	plain := sr.dec.getBuffer()
+	defer putBuffer(plain)
+
+	// The message is decrypted straight into p when it's big enough, which saves copying the whole
+	// message, and the copy below is then at most a shift past the record type.
+	// Otherwise it's decrypted into more scratch space and as much as fits is copied.
+	data, err := sr.dec.next(*scratch, p, *plain)
+	if err != nil {
+		return 0, err
+	}
+
+	n = copy(p, data)
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, so io.Copy from a Reader hands every message straight to w.
+// It decrypts messages and writes them to w until the underlying stream returns io.EOF.
+func (sr *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	scratch := sr.dec.getBuffer()
+	defer putBuffer(scratch)
+	plain := sr.dec.getBuffer()
+	defer putBuffer(plain)
+
+	for {
+		data, err := sr.dec.next(*scratch, *plain, nil)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		err = wire.WriteFull(w, data)
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(data))
+	}
+}
+
+// Writer encrypts data securely to a stream
+type Writer struct {
+	enc *encoder
+
+	// buffered is set by Buffer, see buffer.go
+	buffered *writeBuffer
+}
+
+// NewWriter is a convenient helper method that allocates and initializes a secure writer for you
+// w is the underlying stream to write securely to
+// priv is your private key
+// pub is the public key of who you're communicating with
+func NewWriter(w io.Writer, priv, pub *[32]byte) *Writer {
+	sw := &Writer{}
+	sw.Init(w, priv, pub)
+	return sw
+}
+
+// Init initializes our Writer.
+// w is the underlying stream to write securely to
+// priv is your private key
+// pub is the public key of who you're communicating with
+func (sw *Writer) Init(w io.Writer, priv, pub *[32]byte) {
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, pub, priv)
+	sw.initKey(w, sharedKey)
+}
+
+// NewWriterPSK allocates and initializes a Writer that seals messages with secretbox and a pre-shared key.
+// A message sealed with a key opens with that key whichever way it's going, so use a different key
+// for each direction or an attacker can reflect a side's messages back at it.
+// key is the pre-shared key, it should be 32 random bytes. See NewReaderPSK.
+func NewWriterPSK(w io.Writer, key *[32]byte) *Writer {
+	sw := &Writer{}
+	sw.initKey(w, *key)
+	return sw
+}
+
+// initKey initializes the Writer with a key that's already been worked out, like the keys Conn derives
+func (sw *Writer) initKey(w io.Writer, sharedKey [32]byte) {
+	sw.enc = newEncoder(w, &sharedKey)
+}
+
+// SetRand sets where nonces come from, by default that's crypto/rand.Reader.
+// rand must be cryptographically secure unless it's a deterministic source for tests.
+// SetRand must be called before the Writer is used.
+func (sw *Writer) SetRand(rand io.Reader) {
+	sw.enc.rand = rand
+}
+
+// SetMaxMessageLength sets the biggest message the Writer sends, by default that's MaxMessageLength.
+// Longer writes are split up. SetMaxMessageLength must be called before the Writer is used,
+// and before Buffer.
+func (sw *Writer) SetMaxMessageLength(n int) {
+	sw.enc.maxLength = n
+}
+
+// Write encrypts p []byte to the underlying stream.
+// p is sent as messages of at most MaxMessageLength bytes so the other side is able to read them,
+// see SetMaxMessageLength.
+// n is the number of bytes of p that were sent, so on failure it only counts the messages that
+// were written completely.
+// In buffered mode p is only copied into the buffer, see Buffer.
+func (sw *Writer) Write(p []byte) (n int, err error) {
+	if sw.buffered != nil {
+		return sw.buffered.Write(p)
+	}
+	return sw.write(p)
+}
+
+// write sends p straight away, bypassing the buffer
+func (sw *Writer) write(p []byte) (n int, err error) {
+	for {
+		chunk := p[n:]
+		if len(chunk) > sw.enc.maxLength {
+			chunk = chunk[:sw.enc.maxLength]
+		}
+
+		err = sw.enc.Encode(&Message{Data: chunk})
+		if err != nil {
+			return n, err
+		}
+
+		// If encoding is successful, we're guaranteed that all of chunk was written
+		n += len(chunk)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, so io.Copy to a Writer reads up to a whole message at a time
+// instead of whatever size io.Copy's own buffer happens to be. Every read from r is sent as a message
+// straight away, so interactive streams aren't held up waiting for a full message.
+// It reads from r until io.EOF and returns the number of bytes sent.
+func (sw *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	// Anything still buffered has to go out first to keep the order
+	err = sw.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	scratch := getBuffer(sw.enc.maxLength)
+	defer putBuffer(scratch)
+	buf := (*scratch)[:sw.enc.maxLength]
+
+	for {
+		nr, err := r.Read(buf)
+		if nr > 0 {
+			encErr := sw.enc.Encode(&Message{Data: buf[:nr]})
+			if encErr != nil {
+				return n, encErr
+			}
+			n += int64(nr)
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
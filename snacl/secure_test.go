@@ -0,0 +1,372 @@
+package snacl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestReaderRejectsTampering(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var buf bytes.Buffer
+	if _, err := NewWriter(&buf, priv, pub).Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a bit in the box, the header and the nonce are untouched
+	data := buf.Bytes()
+	data[len(data)-1] ^= 1
+
+	if _, err := NewReader(&buf, priv, pub).ReadMsg(); err == nil {
+		t.Fatal("Unexpected result. A tampered box was decrypted.")
+	}
+}
+
+// shortWriter writes at most one byte per call to the underlying writer
+type shortWriter struct {
+	w *bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return w.w.Write(p)
+}
+
+func TestWriterShortWrites(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var buf bytes.Buffer
+	expected := []byte("hello world\n")
+	if _, err := NewWriter(&shortWriter{&buf}, priv, pub).Write(expected); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewReader(&buf, priv, pub).ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+	}
+}
+
+// countingWriter counts the calls to Write
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func TestWriterSingleWritePerMessage(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	// A separate write for the length would go out as a tiny packet with TCP_NODELAY
+	w := &countingWriter{}
+	if _, err := NewWriter(w, priv, pub).Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Fatalf("Unexpected number of writes: %d != %d", w.writes, 1)
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	w := NewWriter(io.Discard, priv, pub)
+	msg := make([]byte, 1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	// Seal one message and read it back over and over
+	var frame bytes.Buffer
+	msg := make([]byte, 1024)
+	if _, err := NewWriter(&frame, priv, pub).Write(msg); err != nil {
+		b.Fatal(err)
+	}
+	r := bytes.NewReader(frame.Bytes())
+	sr := NewReader(r, priv, pub)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	for i := 0; i < b.N; i++ {
+		r.Seek(0, io.SeekStart)
+		if _, err := sr.Read(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReaderShortBuffer(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var frames bytes.Buffer
+	w := NewWriter(&frames, priv, pub)
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write([]byte("hello world\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewReader(&frames, priv, pub)
+
+	// A buffer that's too small gets what fits and the rest of the message is discarded
+	buf := make([]byte, 5)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "hello" {
+		t.Fatalf("Unexpected result: %s != %s", got, "hello")
+	}
+
+	// A buffer that's big enough gets the whole next message
+	buf = make([]byte, 1024)
+	n, err = r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "hello world\n" {
+		t.Fatalf("Unexpected result: %s != %s", got, "hello world\n")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	expected := make([]byte, 3*MaxMessageLength+123)
+	for i := range expected {
+		expected[i] = byte(i)
+	}
+
+	// io.Copy uses ReadFrom on the Writer, and sends full messages
+	var frames bytes.Buffer
+	n, err := io.Copy(NewWriter(&frames, priv, pub), bytes.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(expected)) {
+		t.Fatalf("Unexpected count: %d != %d", n, len(expected))
+	}
+	if frameCount := frames.Len() / maxFrameLength; frameCount != 3 {
+		t.Fatalf("Unexpected number of full frames: %d != %d", frameCount, 3)
+	}
+
+	// io.Copy uses WriteTo on the Reader, and stops at the end of the stream
+	var got bytes.Buffer
+	n, err = io.Copy(&got, NewReader(&frames, priv, pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(expected)) || !bytes.Equal(got.Bytes(), expected) {
+		t.Fatalf("Unexpected result after copying %d bytes", n)
+	}
+}
+
+func TestWriterBuffer(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	w := &countingWriter{}
+	sw := NewWriter(w, priv, pub)
+	sw.Buffer(16, 0)
+
+	// Small writes wait in the buffer until it's full
+	for i := 0; i < 5; i++ {
+		if _, err := sw.Write([]byte("abc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.writes != 0 {
+		t.Fatalf("Unexpected number of writes: %d != %d", w.writes, 0)
+	}
+	if _, err := sw.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Fatalf("Unexpected number of writes: %d != %d", w.writes, 1)
+	}
+
+	// Flush sends what's left
+	if err := sw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 2 {
+		t.Fatalf("Unexpected number of writes: %d != %d", w.writes, 2)
+	}
+
+	// The delay sends the buffer without a Flush
+	var frames bytes.Buffer
+	sw = NewWriter(&frames, priv, pub)
+	sw.Buffer(0, 10*time.Millisecond)
+	sw.Write([]byte("hello "))
+	sw.Write([]byte("world\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	// Flush waits for the timer's flush to be done with frames
+	sw.Flush()
+	msg, err := NewReader(&frames, priv, pub).ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(msg.Data); got != "hello world\n" {
+		t.Fatalf("Unexpected result: %s != %s", got, "hello world\n")
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestWriterBufferFailedFlush(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	sw := NewWriter(failingWriter{}, priv, pub)
+	sw.Buffer(10, 0)
+	if n, err := sw.Write([]byte("abcd")); n != 4 || err != nil {
+		t.Fatalf("Unexpected result: %d %v", n, err)
+	}
+	// The flush fails with the earlier write's bytes in the buffer, none of this one's got out
+	n, err := sw.Write([]byte("efghij"))
+	if n != 0 || !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Unexpected result: %d %v", n, err)
+	}
+
+	// Bytes past a flush that went out count, the ones in the failed flush don't
+	w := &failAfterWriter{ok: 1}
+	sw = NewWriter(w, priv, pub)
+	sw.Buffer(10, 0)
+	sw.Write([]byte("abcd"))
+	n, err = sw.Write([]byte("0123456789abcdefghij"))
+	if n != 6 || !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Unexpected result: %d %v", n, err)
+	}
+}
+
+// failAfterWriter fails every write after the first ok ones
+type failAfterWriter struct {
+	ok int
+}
+
+func (w *failAfterWriter) Write(p []byte) (int, error) {
+	if w.ok == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	w.ok--
+	return len(p), nil
+}
+
+// zeroReader is a deterministic source of randomness for tests, it only returns zeroes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestWriterSetRand(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	// With the same source of randomness the same message seals to the same frame
+	var frames [2]bytes.Buffer
+	for i := range frames {
+		sw := NewWriter(&frames[i], priv, pub)
+		sw.SetRand(zeroReader{})
+		if _, err := sw.Write([]byte("hello world\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(frames[0].Bytes(), frames[1].Bytes()) {
+		t.Fatal("Unexpected result. The frames are different with a deterministic source.")
+	}
+}
+
+func TestFrameLengthValidation(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	tests := []struct {
+		length uint32
+		err    error
+	}{
+		{0, ErrFrameEmpty},
+		{1, ErrFrameTooShort},
+		{23, ErrFrameTooShort},
+		{24, ErrFrameTooShort},
+		{24 + 15, ErrFrameTooShort},
+		// Long enough, but the box doesn't open
+		{24 + 16, ErrDecrypt},
+		{maxFrameLength - 4 + 1, ErrFrameTooLarge},
+		{0xffffffff, ErrFrameTooLarge},
+	}
+	for _, test := range tests {
+		// The Reader and ParseFrame must agree on every frame
+		data := make([]byte, 4+int(min(test.length, 1024)))
+		binary.BigEndian.PutUint32(data, test.length)
+
+		_, err := NewReader(bytes.NewReader(data), priv, pub).ReadMsg()
+		if !errors.Is(err, test.err) {
+			t.Fatalf("Unexpected Reader error for length %d: %v != %v", test.length, err, test.err)
+		}
+
+		_, _, err = ParseFrame(data)
+		if test.err == ErrDecrypt {
+			if err != nil {
+				t.Fatalf("Unexpected ParseFrame error for length %d: %v", test.length, err)
+			}
+		} else if !errors.Is(err, test.err) {
+			t.Fatalf("Unexpected ParseFrame error for length %d: %v != %v", test.length, err, test.err)
+		}
+	}
+}
+
+func TestReaderWriterPSK(t *testing.T) {
+	key := &[32]byte{'p', 's', 'k'}
+
+	var buf bytes.Buffer
+	expected := []byte("hello world\n")
+	if _, err := NewWriterPSK(&buf, key).Write(expected); err != nil {
+		t.Fatal(err)
+	}
+	frame := append([]byte(nil), buf.Bytes()...)
+
+	msg, err := NewReaderPSK(&buf, key).ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Data, expected) {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+	}
+
+	// It's a plain secretbox
+	opened, ok := secretbox.Open(nil, frame[4+24:], (*[24]byte)(frame[4:]), key)
+	if !ok || !bytes.Equal(opened, expected) {
+		t.Fatal("Unexpected result. The frame isn't a secretbox.")
+	}
+
+	if _, err := NewReaderPSK(bytes.NewReader(frame), &[32]byte{'o', 't', 'h', 'e', 'r'}).ReadMsg(); err != ErrDecrypt {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package securetest
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Faults describes what a FaultyConn does to the data going through it.
+// The zero value doesn't inject any faults.
+type Faults struct {
+	// Latency is added before every Read and Write
+	Latency time.Duration
+	// MaxRead limits every Read to at most MaxRead bytes, so readers see short reads. 0 means no limit.
+	MaxRead int
+	// DropEvery drops every DropEvery'th byte written, counting from the start of the stream.
+	// 0 means no bytes are dropped.
+	DropEvery int64
+	// DisconnectAfter closes the underlying stream once that many bytes have been written, even in the
+	// middle of a write. Later writes fail with io.ErrClosedPipe. 0 means never.
+	DisconnectAfter int64
+}
+
+// FaultyConn wraps a ReadWriteCloser and injects faults into it.
+// It's meant to sit under a Reader, Writer or Conn to check how code above them deals with bad networks.
+type FaultyConn struct {
+	rwc    io.ReadWriteCloser
+	faults Faults
+
+	mu      sync.Mutex
+	written int64
+	closed  bool
+}
+
+// NewFaultyConn returns a FaultyConn injecting faults into rwc
+func NewFaultyConn(rwc io.ReadWriteCloser, faults Faults) *FaultyConn {
+	return &FaultyConn{rwc: rwc, faults: faults}
+}
+
+// Read reads from the underlying stream, after the latency and at most MaxRead bytes
+func (f *FaultyConn) Read(p []byte) (int, error) {
+	time.Sleep(f.faults.Latency)
+
+	if f.faults.MaxRead > 0 && len(p) > f.faults.MaxRead {
+		p = p[:f.faults.MaxRead]
+	}
+	return f.rwc.Read(p)
+}
+
+// Write writes to the underlying stream after the latency, dropping bytes and disconnecting as configured.
+// The returned count includes dropped bytes, the writer isn't supposed to notice.
+func (f *FaultyConn) Write(p []byte) (int, error) {
+	time.Sleep(f.faults.Latency)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	// Only the part of p before the disconnect makes it through
+	cut := len(p)
+	if f.faults.DisconnectAfter > 0 && f.written+int64(len(p)) >= f.faults.DisconnectAfter {
+		cut = int(f.faults.DisconnectAfter - f.written)
+	}
+
+	out := p[:cut]
+	if f.faults.DropEvery > 0 {
+		out = make([]byte, 0, cut)
+		for i, b := range p[:cut] {
+			if (f.written+int64(i)+1)%f.faults.DropEvery != 0 {
+				out = append(out, b)
+			}
+		}
+	}
+
+	_, err := f.rwc.Write(out)
+	if err != nil {
+		return 0, err
+	}
+	f.written += int64(cut)
+
+	if cut < len(p) || f.written == f.faults.DisconnectAfter {
+		f.closed = true
+		f.rwc.Close()
+		if cut < len(p) {
+			return cut, io.ErrClosedPipe
+		}
+	}
+	return cut, nil
+}
+
+// Close closes the underlying stream
+func (f *FaultyConn) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	return f.rwc.Close()
+}
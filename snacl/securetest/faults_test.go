@@ -0,0 +1,66 @@
+package securetest
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// faultyPipe returns a Writer and Reader connected through FaultyConns, and the writer's FaultyConn
+func faultyPipe(faults Faults) (*snacl.Writer, *snacl.Reader, io.Closer) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	c1, c2 := net.Pipe()
+	faulty := NewFaultyConn(c1, faults)
+	return snacl.NewWriter(faulty, priv, pub), snacl.NewReader(NewFaultyConn(c2, faults), priv, pub), faulty
+}
+
+func TestFaultyConnShortReads(t *testing.T) {
+	w, r, c := faultyPipe(Faults{MaxRead: 3})
+	defer c.Close()
+
+	go w.Write([]byte("hello world\n"))
+
+	msg, err := r.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(msg.Data); got != "hello world\n" {
+		t.Fatalf("Unexpected result: %s != %s", got, "hello world\n")
+	}
+}
+
+func TestFaultyConnDroppedBytes(t *testing.T) {
+	w, r, c := faultyPipe(Faults{DropEvery: 10})
+
+	// The frame comes up short, so the reader only finds out once the stream is closed
+	go func() {
+		w.Write([]byte("hello world\n"))
+		c.Close()
+	}()
+
+	if _, err := r.ReadMsg(); err == nil {
+		t.Fatal("Unexpected result. A frame with dropped bytes was read.")
+	}
+}
+
+func TestFaultyConnDisconnect(t *testing.T) {
+	// Disconnect in the middle of the first frame
+	w, r, c := faultyPipe(Faults{DisconnectAfter: 20})
+	defer c.Close()
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("hello world\n"))
+		writeErr <- err
+	}()
+
+	if _, err := r.ReadMsg(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Unexpected error: %v != %v", err, io.ErrUnexpectedEOF)
+	}
+	if err := <-writeErr; err == nil {
+		t.Fatal("Unexpected result. Writing through a disconnect succeeded.")
+	}
+}
@@ -0,0 +1,31 @@
+package securetest
+
+import (
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	client, server := Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		msg, err := server.ReadMsg()
+		if err != nil {
+			return
+		}
+		server.Write(msg.Data)
+	}()
+
+	expected := "hello world\n"
+	if _, err := client.Write([]byte(expected)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(msg.Data); got != expected {
+		t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", got, expected)
+	}
+}
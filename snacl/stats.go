@@ -0,0 +1,107 @@
+package snacl
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// Stats are the counters of a Conn, see Conn.Stats. Bytes are counted before sealing and after opening,
+// so they're the application's data without the framing.
+//
+// Counting is always on and costs two atomic adds per message on the goroutine doing the read or
+// write, there are no locks and nothing is shared between connections. Histograms are off unless
+// Options.StatsSampleRate is set, a sampled message costs one more atomic add.
+type Stats struct {
+	MessagesRead    uint64
+	MessagesWritten uint64
+	BytesRead       uint64
+	BytesWritten    uint64
+
+	// ReadSizes and WriteSizes are histograms of the sizes of sampled messages,
+	// they're nil unless Options.StatsSampleRate is set
+	ReadSizes  *Histogram
+	WriteSizes *Histogram
+}
+
+// histogramBuckets is enough power of two buckets for any message up to maxNegotiableLength
+const histogramBuckets = 32
+
+// Histogram counts message sizes in power of two buckets: bucket 0 counts empty messages and
+// bucket i counts sizes from 1<<(i-1) up to (1<<i)-1.
+type Histogram struct {
+	Buckets [histogramBuckets]uint64
+}
+
+// Count returns the number of sizes in the histogram
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for _, b := range h.Buckets {
+		n += b
+	}
+	return n
+}
+
+// Quantile returns an upper bound for the q quantile of the sizes, q is between 0 and 1.
+// It's the top of the bucket the quantile falls in, so it's at most twice the real value.
+func (h *Histogram) Quantile(q float64) int {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(q * float64(total))
+	var n uint64
+	for i, b := range h.Buckets {
+		n += b
+		if n > target || n == total {
+			return (1 << i) - 1
+		}
+	}
+	return 0
+}
+
+// counters count messages in one direction of a Conn
+type counters struct {
+	messages atomic.Uint64
+	bytes    atomic.Uint64
+	// sampleRate is Options.StatsSampleRate, sizes are only recorded when it's set
+	sampleRate uint64
+	sizes      [histogramBuckets]atomic.Uint64
+}
+
+// record counts a message of n bytes
+func (c *counters) record(n int) {
+	m := c.messages.Add(1)
+	c.bytes.Add(uint64(n))
+	if c.sampleRate > 0 && m%c.sampleRate == 0 {
+		c.sizes[bits.Len(uint(n))].Add(1)
+	}
+}
+
+// histogram returns a copy of the sampled sizes, or nil if sampling is off
+func (c *counters) histogram() *Histogram {
+	if c.sampleRate == 0 {
+		return nil
+	}
+	h := &Histogram{}
+	for i := range c.sizes {
+		h.Buckets[i] = c.sizes[i].Load()
+	}
+	return h
+}
+
+// Stats returns a snapshot of the connection's counters. It's safe to call at any time, including
+// while other goroutines are reading and writing. Everything is zero until Handshake has succeeded.
+func (c *Conn) Stats() Stats {
+	if !c.ready.Load() {
+		return Stats{}
+	}
+	return Stats{
+		MessagesRead:    c.readStats.messages.Load(),
+		MessagesWritten: c.writeStats.messages.Load(),
+		BytesRead:       c.readStats.bytes.Load(),
+		BytesWritten:    c.writeStats.bytes.Load(),
+		ReadSizes:       c.readStats.histogram(),
+		WriteSizes:      c.writeStats.histogram(),
+	}
+}
@@ -0,0 +1,59 @@
+package snacl
+
+import (
+	"testing"
+)
+
+func TestConnStats(t *testing.T) {
+	client, server := pipe(t, &Options{StatsSampleRate: 1})
+	defer client.Close()
+	defer server.Close()
+
+	sizes := []int{0, 1, 100, 1000}
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for _, n := range sizes {
+			client.Write(make([]byte, n))
+		}
+	}()
+	for range sizes {
+		if _, err := server.ReadMsg(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := server.Stats()
+	if stats.MessagesRead != 4 || stats.BytesRead != 1101 || stats.MessagesWritten != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if stats.ReadSizes == nil || stats.ReadSizes.Count() != 4 {
+		t.Fatalf("Unexpected histogram: %+v", stats.ReadSizes)
+	}
+	expected := [histogramBuckets]uint64{0: 1, 1: 1, 7: 1, 10: 1}
+	if stats.ReadSizes.Buckets != expected {
+		t.Fatalf("Unexpected buckets: %v", stats.ReadSizes.Buckets)
+	}
+	if q := stats.ReadSizes.Quantile(1); q != 1023 {
+		t.Fatalf("Unexpected quantile: %d != %d", q, 1023)
+	}
+
+	<-written
+	if stats := client.Stats(); stats.MessagesWritten != 4 || stats.BytesWritten != 1101 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestConnStatsNoSampling(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write([]byte("hello"))
+	if _, err := server.ReadMsg(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := server.Stats(); stats.MessagesRead != 1 || stats.ReadSizes != nil {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
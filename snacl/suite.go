@@ -0,0 +1,157 @@
+package snacl
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/arianitu/go-challenge-2/internal/wire"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// CipherSuite seals and opens records. Version1 connections negotiate one in the handshake, see
+// Options.CipherSuites, and Version0 connections and the standalone Reader and Writer always use NaClBox.
+//
+// The frame layout is the same for every suite, [length][nonce][sealed record], but the nonce and the
+// overhead are the suite's own sizes. Nonces are random, so a suite's nonce has to be big enough for
+// random nonces to be safe for the number of messages sent between key updates.
+type CipherSuite interface {
+	// ID identifies the suite in the handshake. IDs below 0x100 are reserved for this package.
+	ID() uint16
+	// Name is for people, like ConnectionState and logs
+	Name() string
+	// AEAD returns the suite's cipher keyed with key. Its nonce can be at most 24 bytes and its overhead at most 16.
+	AEAD(key *[32]byte) (cipher.AEAD, error)
+}
+
+// The cipher suites in this package
+var (
+	// NaClBox is XSalsa20-Poly1305 with a 24 byte nonce, box.SealAfterPrecomputation with the derived key.
+	// It's what Version0 uses.
+	NaClBox CipherSuite = naclBox{}
+	// XChaCha20Poly1305 is XChaCha20-Poly1305 with a 24 byte nonce
+	XChaCha20Poly1305 CipherSuite = xchacha20Poly1305{}
+	// AES256GCM is AES-256-GCM with a 12 byte nonce, for deployments that need FIPS approved algorithms.
+	// Random 12 byte nonces are safe for 2^32 messages per key, far more than DefaultRekeyMessages.
+	AES256GCM CipherSuite = aes256GCM{}
+)
+
+// defaultCipherSuites is used when Options.CipherSuites is nil, in order of preference
+var defaultCipherSuites = []CipherSuite{NaClBox, XChaCha20Poly1305, AES256GCM}
+
+type naclBox struct{}
+
+func (naclBox) ID() uint16   { return 1 }
+func (naclBox) Name() string { return "NaClBox" }
+
+func (naclBox) AEAD(key *[32]byte) (cipher.AEAD, error) {
+	return newSecretbox(key), nil
+}
+
+type xchacha20Poly1305 struct{}
+
+func (xchacha20Poly1305) ID() uint16   { return 2 }
+func (xchacha20Poly1305) Name() string { return "XChaCha20Poly1305" }
+
+func (xchacha20Poly1305) AEAD(key *[32]byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(key[:])
+}
+
+type aes256GCM struct{}
+
+func (aes256GCM) ID() uint16   { return 3 }
+func (aes256GCM) Name() string { return "AES256GCM" }
+
+func (aes256GCM) AEAD(key *[32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretboxAEAD is nacl/secretbox as a cipher.AEAD
+type secretboxAEAD struct {
+	key *[32]byte
+}
+
+// newSecretbox returns the NaClBox cipher for key. It uses key where it is rather than a copy, so
+// zeroing key zeroes the cipher's, see wipe.go.
+func newSecretbox(key *[32]byte) cipher.AEAD {
+	return &secretboxAEAD{key: key}
+}
+
+func (s *secretboxAEAD) NonceSize() int { return wire.NonceLength }
+func (s *secretboxAEAD) Overhead() int  { return secretbox.Overhead }
+
+func (s *secretboxAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return secretbox.Seal(dst, plaintext, (*[wire.NonceLength]byte)(nonce), s.keyFor(additionalData))
+}
+
+func (s *secretboxAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	out, ok := secretbox.Open(dst, ciphertext, (*[wire.NonceLength]byte)(nonce), s.keyFor(additionalData))
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// keyFor returns the key for a box with additional data. secretbox can't authenticate anything but the
+// box, so with additional data the box is sealed with HMAC-SHA256(key, additionalData) instead, and
+// it only opens with the same additional data. Without it the key is used as it is, so the boxes are
+// the same as box.SealAfterPrecomputation's.
+func (s *secretboxAEAD) keyFor(additionalData []byte) *[32]byte {
+	if len(additionalData) == 0 {
+		return s.key
+	}
+	var key [32]byte
+	mac := hmac.New(sha256.New, s.key[:])
+	mac.Write(additionalData)
+	mac.Sum(key[:0])
+	return &key
+}
+
+// newAEAD returns suite's cipher for key and checks it fits in a frame
+func newAEAD(suite CipherSuite, key *[32]byte) (cipher.AEAD, error) {
+	aead, err := suite.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead.NonceSize() > wire.NonceLength {
+		return nil, fmt.Errorf("cipher suite %s has a %d byte nonce, the most is %d", suite.Name(), aead.NonceSize(), wire.NonceLength)
+	}
+	// Frames are sized for NaClBox, see frameLength
+	if aead.Overhead() > box.Overhead {
+		return nil, fmt.Errorf("cipher suite %s has %d bytes of overhead, the most is %d", suite.Name(), aead.Overhead(), box.Overhead)
+	}
+	return aead, nil
+}
+
+// chooseCipherSuite returns the first of the client's suites that the server supports as well.
+// ours is our own list, which is one of the two.
+func chooseCipherSuite(clientIDs, serverIDs []uint16, ours []CipherSuite) (CipherSuite, error) {
+	for _, id := range clientIDs {
+		if !containsID(serverIDs, id) {
+			continue
+		}
+		for _, suite := range ours {
+			if suite.ID() == id {
+				return suite, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no cipher suite in common", ErrHandshakeFailed)
+}
+
+func containsID(ids []uint16, id uint16) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package snacl
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCipherSuites(t *testing.T) {
+	for _, suite := range defaultCipherSuites {
+		t.Run(suite.Name(), func(t *testing.T) {
+			client, server := pipe(t, &Options{CipherSuites: []CipherSuite{suite}})
+			if got := client.ConnectionState().CipherSuite; got != suite {
+				t.Fatalf("Unexpected cipher suite: %v != %v", got, suite)
+			}
+
+			expected := bytes.Repeat([]byte("suite"), 100)
+			go func() {
+				client.Write(expected)
+				// A key update has to rebuild the cipher with the next key
+				client.UpdateKey()
+				client.Write(expected)
+			}()
+			for i := 0; i < 2; i++ {
+				msg, err := server.ReadMsg()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(msg.Data, expected) {
+					t.Fatalf("Unexpected result:\nGot:%s\nExpected:%s\n", msg.Data, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestCipherSuiteNegotiation(t *testing.T) {
+	// The client's preference wins
+	client, server := pipeOptions(t,
+		&Options{CipherSuites: []CipherSuite{AES256GCM, XChaCha20Poly1305}},
+		&Options{CipherSuites: []CipherSuite{XChaCha20Poly1305, AES256GCM}})
+	if client.ConnectionState().CipherSuite != AES256GCM || server.ConnectionState().CipherSuite != AES256GCM {
+		t.Fatalf("Unexpected cipher suites: %v and %v", client.ConnectionState().CipherSuite, server.ConnectionState().CipherSuite)
+	}
+
+	// Version0 can't negotiate
+	client, _ = pipe(t, &Options{LegacyV0: true})
+	if client.ConnectionState().CipherSuite != NaClBox {
+		t.Fatalf("Unexpected cipher suite: %v", client.ConnectionState().CipherSuite)
+	}
+}
+
+func TestCipherSuiteMismatch(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	client := Client(c1, &Options{CipherSuites: []CipherSuite{AES256GCM}})
+	server := Server(c2, &Options{CipherSuites: []CipherSuite{NaClBox}})
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Handshake()
+	}()
+	if err := client.Handshake(); !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Expected ErrHandshakeFailed, got %v", err)
+	}
+	if err := <-serverErr; !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("Expected ErrHandshakeFailed, got %v", err)
+	}
+}
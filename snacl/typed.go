@@ -0,0 +1,81 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Messages can carry a type, so an application can tell control messages from data without an
+// envelope of its own, see Conn.WriteTyped. A typed message is a recordTyped record:
+//
+//	[message type uint8][flags uint8][data]
+//
+// The type and flags are sealed with the data, so neither is visible on the wire. Type 0 is an ordinary
+// message and is sent as one. The only flag is typedCompressed, the rest are reserved and must be 0.
+
+// typedHeaderLength is the size of the message type and flags, they count towards MaxMessageLength
+const typedHeaderLength = 2
+
+// typedCompressed says a typed record's data is compressed, see compress.go
+const typedCompressed byte = 1 << 0
+
+// ErrNoMessageTypes is returned for a typed message on a Version0 connection, which has no records
+var ErrNoMessageTypes = errors.New("message types need Version1")
+
+// typedRecord returns the data of a recordTyped record, and sets dec.msgType
+func (dec *decoder) typedRecord(record []byte) ([]byte, bool, error) {
+	if len(record) < typedHeaderLength {
+		return nil, false, fmt.Errorf("%w: missing message type", ErrBadRecord)
+	}
+	typ, flags, data := record[0], record[1], record[typedHeaderLength:]
+	if flags&^typedCompressed != 0 {
+		return nil, false, fmt.Errorf("%w: unknown message flags %#x", ErrBadRecord, flags)
+	}
+	if flags&typedCompressed != 0 {
+		if !dec.compressed {
+			return nil, false, fmt.Errorf("%w: compressed record without compression", ErrBadRecord)
+		}
+		var err error
+		data, err = decompressRecord(data, dec.maxLength-typedHeaderLength)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	dec.msgType = typ
+	return data, true, nil
+}
+
+// WriteTyped sends msg as a single message of type t, which the other side gets from ReadTyped. Type 0
+// is an ordinary message, as sent by Write and WriteMsg. msg can be at most
+// ConnectionState.MaxMessageLength-2 bytes, the type takes the rest. Version0 can only send type 0.
+func (c *Conn) WriteTyped(t byte, msg []byte) error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	if t != 0 && c.state.Version < Version1 {
+		return ErrNoMessageTypes
+	}
+	if len(msg) > c.state.MaxMessageLength-typedHeaderLength {
+		return fmt.Errorf("message is too large (len:%d max:%d)", len(msg), c.state.MaxMessageLength-typedHeaderLength)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	// Anything buffered was written first
+	err = c.sw.Flush()
+	if err != nil {
+		return err
+	}
+	return c.closedErr(c.sw.enc.Encode(&Message{Data: msg, Type: t}))
+}
+
+// ReadTyped reads the next message and returns it with its type, see WriteTyped. Messages sent by
+// Write and WriteMsg are type 0. Read and WriteTo drop the type.
+func (c *Conn) ReadTyped() (t byte, msg []byte, err error) {
+	m, err := c.ReadMsg()
+	if err != nil {
+		return 0, nil, err
+	}
+	return m.Type, m.Data, nil
+}
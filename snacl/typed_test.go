@@ -0,0 +1,73 @@
+package snacl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTypedMessages(t *testing.T) {
+	for _, opts := range []*Options{nil, {Compression: true}, {FlowControlWindow: 1 << 16}, {Padding: PadToBlock(64)}} {
+		client, server := pipe(t, opts)
+
+		compressible := bytes.Repeat([]byte("control "), 100)
+		go func() {
+			client.WriteTyped(1, []byte("ping"))
+			client.WriteMsg([]byte("data"))
+			client.WriteTyped(2, compressible)
+			client.WriteTyped(0, []byte("untyped"))
+		}()
+		for _, expected := range []struct {
+			typ  byte
+			data []byte
+		}{{1, []byte("ping")}, {0, []byte("data")}, {2, compressible}, {0, []byte("untyped")}} {
+			typ, msg, err := server.ReadTyped()
+			if err != nil {
+				t.Fatalf("Unexpected error for %+v: %v", opts, err)
+			}
+			if typ != expected.typ || !bytes.Equal(msg, expected.data) {
+				t.Fatalf("Unexpected result for %+v: %d %q", opts, typ, msg)
+			}
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestTypedMessageLength(t *testing.T) {
+	client, server := pipe(t, &Options{MaxMessageLength: 100})
+	defer client.Close()
+	defer server.Close()
+
+	if err := client.WriteTyped(1, make([]byte, 99)); err == nil {
+		t.Fatal("Unexpected result. The type didn't count towards the message length.")
+	}
+	go client.WriteTyped(1, make([]byte, 98))
+	if typ, msg, err := server.ReadTyped(); err != nil || typ != 1 || len(msg) != 98 {
+		t.Fatalf("Unexpected result: %d %d %v", typ, len(msg), err)
+	}
+
+	legacy, legacyServer := pipe(t, &Options{LegacyV0: true})
+	defer legacy.Close()
+	defer legacyServer.Close()
+	if err := legacy.WriteTyped(1, []byte("hello")); !errors.Is(err, ErrNoMessageTypes) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestTypedRecordFlags(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		enc := client.sw.enc
+		enc.mu.Lock()
+		enc.writeRecord(recordTyped, []byte{1, 0x80, 'h', 'i'}, nil)
+		enc.mu.Unlock()
+		client.ReadMsg()
+	}()
+	if _, _, err := server.ReadTyped(); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
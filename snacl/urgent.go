@@ -0,0 +1,71 @@
+package snacl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Urgent messages are a priority lane for signals like cancel or abort. They're recordUrgent records
+// holding the message, and they skip what holds ordinary messages up: flow control, the write buffer and
+// a Write waiting on either. A side that sets Options.OnUrgent offers to take them with an empty
+// extUrgent in its hello, and its OnUrgent is called with each one as it's read, ahead of any ordinary
+// messages still queued for the application.
+//
+// Urgent messages aren't flow controlled, so they're kept small, see MaxUrgentLength, and like every
+// message they fit in ConnectionState.MaxMessageLength, which is inside the flow control windows. They're only read
+// when something is reading the Conn, like every other record, which flow control always is.
+
+// MaxUrgentLength is the biggest urgent message
+const MaxUrgentLength = 1024
+
+// ErrNoUrgent is returned by Conn.WriteUrgent when the other side didn't set Options.OnUrgent
+var ErrNoUrgent = errors.New("the other side doesn't take urgent messages")
+
+// WriteUrgent sends msg as an urgent message, which the other side's Options.OnUrgent gets even if
+// ordinary messages are waiting for flow control credit or in the write buffer. msg can be at most
+// MaxUrgentLength bytes, or ConnectionState.MaxMessageLength if that's smaller. It returns ErrNoUrgent
+// unless the other side set Options.OnUrgent.
+func (c *Conn) WriteUrgent(msg []byte) error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	if !c.state.Urgent {
+		return ErrNoUrgent
+	}
+	if max := min(MaxUrgentLength, c.state.MaxMessageLength); len(msg) > max {
+		return fmt.Errorf("urgent message is too large (len:%d max:%d)", len(msg), max)
+	}
+	// Not c.writeMu, a Write waiting for credit holds it
+	return c.closedErr(c.sw.enc.writeUrgent(msg))
+}
+
+// writeUrgent sends an urgent message
+func (enc *encoder) writeUrgent(msg []byte) error {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if enc.rekey.due() {
+		err := enc.updateKey()
+		if err != nil {
+			return err
+		}
+	}
+	err := enc.writeRecord(recordUrgent, msg, nil)
+	if err != nil {
+		return err
+	}
+	enc.rekey.sent(len(msg))
+	return nil
+}
+
+// readUrgent hands an urgent message to Options.OnUrgent
+func (dec *decoder) readUrgent(data []byte) error {
+	if dec.urgent == nil {
+		return fmt.Errorf("%w: urgent message without Options.OnUrgent", ErrBadRecord)
+	}
+	if len(data) > MaxUrgentLength {
+		return fmt.Errorf("%w: urgent message of %d bytes", ErrBadRecord, len(data))
+	}
+	dec.urgent(append([]byte(nil), data...))
+	return nil
+}
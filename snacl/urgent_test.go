@@ -0,0 +1,106 @@
+package snacl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteUrgent(t *testing.T) {
+	urgent := make(chan []byte, 1)
+	client, server := pipeOptions(t, &Options{FlowControlWindow: 100}, &Options{FlowControlWindow: 100, OnUrgent: func(msg []byte) {
+		urgent <- msg
+	}})
+	defer client.Close()
+	defer server.Close()
+	if !client.ConnectionState().Urgent || server.ConnectionState().Urgent {
+		t.Fatal("Unexpected result. Only the server takes urgent messages.")
+	}
+
+	// The server isn't reading, so the second write waits for credit
+	wrote := make(chan error, 1)
+	go func() {
+		client.Write(make([]byte, 100))
+		_, err := client.Write(make([]byte, 100))
+		wrote <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-wrote:
+		t.Fatal("Unexpected result. The write didn't wait for credit.")
+	default:
+	}
+
+	if err := client.WriteUrgent([]byte("cancel")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case msg := <-urgent:
+		if string(msg) != "cancel" {
+			t.Fatalf("Unexpected result: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. The urgent message didn't get past the blocked write.")
+	}
+
+	// The ordinary messages are still there, in order
+	for i := 0; i < 2; i++ {
+		if msg, err := server.ReadMsg(); err != nil || len(msg.Data) != 100 {
+			t.Fatalf("Unexpected result: %v", err)
+		}
+	}
+	if err := <-wrote; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestWriteUrgentErrors(t *testing.T) {
+	client, server := pipeOptions(t, nil, &Options{OnUrgent: func([]byte) {}})
+	defer client.Close()
+	defer server.Close()
+
+	if err := server.WriteUrgent([]byte("cancel")); !errors.Is(err, ErrNoUrgent) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := client.WriteUrgent(make([]byte, MaxUrgentLength+1)); err == nil {
+		t.Fatal("Unexpected result. An urgent message over MaxUrgentLength was sent.")
+	}
+
+	// An urgent message the client never offered to take
+	go func() {
+		enc := server.sw.enc
+		enc.mu.Lock()
+		enc.writeRecord(recordUrgent, []byte("cancel"), nil)
+		enc.mu.Unlock()
+		server.ReadMsg()
+	}()
+	if _, err := client.ReadMsg(); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestWriteUrgentMaxMessageLength(t *testing.T) {
+	urgent := make(chan []byte, 1)
+	client, server := pipeOptions(t, &Options{MaxMessageLength: 100, Padding: PadToBlock(16)}, &Options{MaxMessageLength: 100, Padding: PadToBlock(16), OnUrgent: func(msg []byte) {
+		urgent <- msg
+	}})
+	defer client.Close()
+	defer server.Close()
+
+	// An urgent message has to fit in a message like any other
+	if err := client.WriteUrgent(make([]byte, 101)); err == nil {
+		t.Fatal("Unexpected result. An urgent message over MaxMessageLength was sent.")
+	}
+	go server.ReadMsg()
+	if err := client.WriteUrgent(make([]byte, 100)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case msg := <-urgent:
+		if len(msg) != 100 {
+			t.Fatalf("Unexpected result: %d bytes", len(msg))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unexpected result. The urgent message didn't arrive.")
+	}
+}
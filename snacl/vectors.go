@@ -0,0 +1,87 @@
+package snacl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Vector is a test vector for the wire format, so other implementations can check they produce and
+// accept exactly the same frames. All byte fields are hex encoded.
+type Vector struct {
+	Name string `json:"name"`
+	// The sender seals Plaintext with its private key and the recipient's public key
+	SenderPrivateKey string `json:"sender_private_key"`
+	SenderPublicKey  string `json:"sender_public_key"`
+	// The recipient opens Frame with its private key and the sender's public key
+	RecipientPrivateKey string `json:"recipient_private_key"`
+	RecipientPublicKey  string `json:"recipient_public_key"`
+	// SharedKey is the result of box.Precompute, the same on both sides
+	SharedKey string `json:"shared_key"`
+	Nonce     string `json:"nonce"`
+	Plaintext string `json:"plaintext"`
+	// Frame is the whole frame as it's sent on the wire, see WireFormat
+	Frame string `json:"frame"`
+}
+
+// GenerateVectors returns test vectors for the wire format. The keys, nonces and messages are derived
+// from fixed seeds, so the vectors are the same every time.
+func GenerateVectors() ([]Vector, error) {
+	sender, err := vectorKeys("sender")
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := vectorKeys("recipient")
+	if err != nil {
+		return nil, err
+	}
+
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, &recipient.Public, &sender.Private)
+
+	long := make([]byte, MaxMessageLength)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	messages := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", []byte{}},
+		{"hello", []byte("hello world\n")},
+		{"max-length", long},
+	}
+
+	vectors := make([]Vector, 0, len(messages))
+	for _, m := range messages {
+		var nonce [24]byte
+		seed := sha256.Sum256([]byte("snacl vector nonce " + m.name))
+		copy(nonce[:], seed[:])
+
+		frame, err := SealMessageWithNonce(&sender.Private, &recipient.Public, &nonce, m.msg)
+		if err != nil {
+			return nil, err
+		}
+
+		vectors = append(vectors, Vector{
+			Name:                m.name,
+			SenderPrivateKey:    hex.EncodeToString(sender.Private[:]),
+			SenderPublicKey:     hex.EncodeToString(sender.Public[:]),
+			RecipientPrivateKey: hex.EncodeToString(recipient.Private[:]),
+			RecipientPublicKey:  hex.EncodeToString(recipient.Public[:]),
+			SharedKey:           hex.EncodeToString(sharedKey[:]),
+			Nonce:               hex.EncodeToString(nonce[:]),
+			Plaintext:           hex.EncodeToString(m.msg),
+			Frame:               hex.EncodeToString(frame),
+		})
+	}
+	return vectors, nil
+}
+
+// vectorKeys derives a key pair from a fixed seed
+func vectorKeys(name string) (*Keys, error) {
+	seed := sha256.Sum256([]byte("snacl vector key " + name))
+	return GenerateKeys(bytes.NewReader(seed[:]))
+}
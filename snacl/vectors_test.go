@@ -0,0 +1,46 @@
+package snacl
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateVectors(t *testing.T) {
+	vectors, err := GenerateVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := GenerateVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vectors, again) {
+		t.Fatal("Unexpected result. The vectors are not deterministic.")
+	}
+
+	// Every frame opens to its plaintext using only the data in the vector
+	for _, v := range vectors {
+		var priv, pub [32]byte
+		mustDecodeHex(t, priv[:], v.RecipientPrivateKey)
+		mustDecodeHex(t, pub[:], v.SenderPublicKey)
+		frame, _ := hex.DecodeString(v.Frame)
+
+		msg, err := OpenMessage(&priv, &pub, frame)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		if got := hex.EncodeToString(msg); got != v.Plaintext {
+			t.Fatalf("%s: unexpected plaintext", v.Name)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, dst []byte, s string) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(dst) {
+		t.Fatalf("Bad hex %q: %v", s, err)
+	}
+	copy(dst, b)
+}
@@ -0,0 +1,47 @@
+// Package websocket runs secure connections over WebSocket, so they can get through proxies that only
+// pass HTTP and be terminated by standard ingress. The handshake and frames are the same as over TCP,
+// each snacl frame is sent as one binary WebSocket message.
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"golang.org/x/net/websocket"
+)
+
+// Dial opens a WebSocket connection to url, a ws:// or wss:// URL, and performs the handshake over it.
+// origin is sent as the Origin header. opts may be nil.
+func Dial(url, origin string, opts *snacl.Options) (*snacl.Conn, error) {
+	ws, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	conn := snacl.Client(ws, opts)
+	err = conn.Handshake()
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Handler returns an http.Handler that accepts WebSocket connections, performs the handshake and calls
+// serve with each Conn. The Conn is closed when serve returns. opts may be nil.
+//
+// The Origin header isn't checked, any page can connect. The keys are what authenticate the other
+// side, see snacl.Conn.PeerPublicKey.
+func Handler(opts *snacl.Options, serve func(*snacl.Conn)) http.Handler {
+	return websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		conn := snacl.Server(ws, opts)
+		defer conn.Close()
+
+		if conn.Handshake() != nil {
+			return
+		}
+		serve(conn)
+	}}
+}
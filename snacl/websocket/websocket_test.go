@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func TestWebSocket(t *testing.T) {
+	srv := httptest.NewServer(Handler(nil, func(conn *snacl.Conn) {
+		for {
+			msg, err := conn.ReadMsg()
+			if err != nil {
+				return
+			}
+			conn.Write(msg.Data)
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !conn.ConnectionState().HandshakeComplete {
+		t.Fatal("Unexpected result. The handshake isn't done.")
+	}
+
+	for _, size := range []int{1, 1000, 30000} {
+		data := bytes.Repeat([]byte("w"), size)
+		if _, err := conn.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := conn.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg.Data, data) {
+			t.Fatalf("Unexpected result for %d bytes", size)
+		}
+	}
+}
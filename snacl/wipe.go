@@ -0,0 +1,113 @@
+package snacl
+
+import (
+	"net"
+
+	"github.com/arianitu/go-challenge-2/internal/memlock"
+)
+
+// Secrets are zeroed as soon as they're done with, so they don't linger in the heap for a core dump
+// or a memory disclosure bug to find: the handshake's shared secrets once the traffic keys are derived,
+// a key pair generated for the connection once the handshake is over, and the traffic keys when the
+// Conn is closed. Pooled buffers are zeroed before they go back to the pool, since they held plaintext,
+// see putBuffer.
+//
+// Options.LockMemory also keeps the traffic keys in locked memory that's left out of core dumps, see
+// internal/memlock. NaClBox uses the key where it is, the other cipher suites expand it into memory of
+// their own that we can't zero or lock, it's dropped on Close for the garbage collector.
+//
+// Keys, and the private key in Options.Keys, belong to the application, which can zero them with
+// Keys.Zero once it's done with them.
+
+// Zero zeroes the private key. The key pair can't be used afterwards.
+func (k *Keys) Zero() {
+	clear(k.Private[:])
+}
+
+// trafficKey returns where a traffic key is kept, copying key there and zeroing it. With lock it's in
+// locked memory, and locked says whether locking worked.
+func trafficKey(key *[32]byte, lock bool) (kept *[32]byte, locked bool, err error) {
+	defer clear(key[:])
+	if !lock {
+		kept = new([32]byte)
+	} else {
+		kept, locked, err = memlock.NewKey()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	*kept = *key
+	return kept, locked, nil
+}
+
+// wipeKey zeroes a traffic key from trafficKey
+func wipeKey(key *[32]byte, inLockedMemory bool) {
+	if inLockedMemory {
+		memlock.FreeKey(key)
+	} else {
+		clear(key[:])
+	}
+}
+
+// wipe zeroes the encoder's keys, nothing can be sent afterwards
+func (enc *encoder) wipe() {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if enc.aead == nil {
+		return
+	}
+	enc.aead = nil
+	wipeKey(enc.sharedKey, enc.lockedKey)
+	if enc.lengths != nil {
+		clear(enc.lengths.key[:])
+	}
+}
+
+// wipe zeroes the decoder's keys, nothing can be read afterwards. A message being read has its key
+// zeroed when it's done, see enter.
+func (dec *decoder) wipe() {
+	dec.wipeMu.Lock()
+	defer dec.wipeMu.Unlock()
+	dec.closing = true
+	if dec.readers == 0 {
+		dec.wipeNow()
+	}
+}
+
+// enter starts a read, it returns net.ErrClosed once the decoder is being wiped. Reads are one at a
+// time, but they can overlap wipe, which mustn't zero the key out from under them.
+func (dec *decoder) enter() error {
+	dec.wipeMu.Lock()
+	defer dec.wipeMu.Unlock()
+	if dec.closing {
+		return net.ErrClosed
+	}
+	dec.readers++
+	return nil
+}
+
+// leave ends a read from enter, and wipes the decoder if wipe was called in the meantime
+func (dec *decoder) leave() {
+	dec.wipeMu.Lock()
+	defer dec.wipeMu.Unlock()
+	dec.readers--
+	if dec.closing && dec.readers == 0 {
+		dec.wipeNow()
+	}
+}
+
+// wipeNow zeroes the decoder's keys, dec.wipeMu must be held
+func (dec *decoder) wipeNow() {
+	if dec.aead == nil {
+		return
+	}
+	dec.aead = nil
+	wipeKey(dec.sharedKey, dec.lockedKey)
+	if dec.ratchet != nil {
+		// The encoder has been wiped already, so this is the last user
+		dec.ratchet.zero()
+	}
+	if dec.lengths != nil {
+		clear(dec.lengths.key[:])
+	}
+}
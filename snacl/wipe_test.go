@@ -0,0 +1,85 @@
+package snacl
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseWipesKeys(t *testing.T) {
+	for _, opts := range []*Options{{}, {EncryptLengths: true, CipherSuites: []CipherSuite{NaClBox}}, {LockMemory: true}, {Noise: true}} {
+		client, server := pipe(t, opts)
+		go client.Write([]byte("hello"))
+		if _, err := server.ReadMsg(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		sendKey, recvKey := client.sw.enc.sharedKey, client.sr.dec.sharedKey
+		if *sendKey == [32]byte{} || *recvKey == [32]byte{} {
+			t.Fatal("Unexpected result. The traffic keys are zero before Close.")
+		}
+
+		client.Close()
+		if *sendKey != [32]byte{} || *recvKey != [32]byte{} {
+			t.Fatalf("Unexpected result. Close didn't zero the traffic keys for %+v.", opts)
+		}
+		if opts.EncryptLengths && (client.sw.enc.lengths.key != [32]byte{} || client.sr.dec.lengths.key != [32]byte{}) {
+			t.Fatal("Unexpected result. Close didn't zero the length keys.")
+		}
+		if _, err := client.Write([]byte("after")); err == nil {
+			t.Fatal("Unexpected result. Wrote with wiped keys.")
+		}
+		if _, err := client.ReadMsg(); err == nil {
+			t.Fatal("Unexpected result. Read with wiped keys.")
+		}
+		server.Close()
+	}
+}
+
+func TestCloseWipesKeysAfterRead(t *testing.T) {
+	client, server := pipe(t, nil)
+	defer server.Close()
+	recvKey := client.sr.dec.sharedKey
+
+	// A read in progress keeps its key until it returns
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := client.ReadMsg()
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	if err := <-readErr; !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	if *recvKey != [32]byte{} {
+		t.Fatal("Unexpected result. The key wasn't zeroed once the read returned.")
+	}
+}
+
+func TestLockMemory(t *testing.T) {
+	client, server := pipe(t, &Options{LockMemory: true})
+	defer client.Close()
+	defer server.Close()
+	go client.Write([]byte("hello"))
+	if msg, err := server.ReadMsg(); err != nil || string(msg.Data) != "hello" {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	// Locking depends on RLIMIT_MEMLOCK, so it isn't required, but the keys come from memlock either way
+	if !client.sw.enc.lockedKey || !server.sr.dec.lockedKey {
+		t.Fatal("Unexpected result. The keys aren't from memlock.")
+	}
+	t.Logf("memory locked: %v", client.ConnectionState().MemoryLocked)
+}
+
+func TestPutBufferZeroes(t *testing.T) {
+	buf := getBuffer(100)
+	copy(*buf, "secret")
+	putBuffer(buf)
+	for _, b := range *buf {
+		if b != 0 {
+			t.Fatal("Unexpected result. A pooled buffer wasn't zeroed.")
+		}
+	}
+}
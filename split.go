@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/arianitu/go-challenge-2/snacl/keystore"
+)
+
+// splitKey prints the key pair at key, see loadKey, split into n base64 shares any k of which give it
+// back, one per line. Each share goes somewhere different, a safe, a colleague, another site:
+//
+//	go-challenge-2 split /etc/snacl/server.key 5 3
+//	go-challenge-2 combine < three-shares > server.key
+func splitKey(w io.Writer, key string, n, k int) error {
+	keys, err := loadKey(key)
+	if err != nil {
+		return err
+	}
+	defer clear(keys.Private[:])
+	shares, err := keystore.SplitKey(&keys.Private, n, k)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		if _, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(share)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// combineKey reads base64 shares from splitKey from r, one per line, and prints the private key they
+// make, in the format key files use
+func combineKey(r io.Reader, w io.Writer) error {
+	var shares [][]byte
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		share, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return fmt.Errorf("share %d isn't base64: %w", len(shares)+1, err)
+		}
+		shares = append(shares, share)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	keys, err := keystore.CombineKey(shares)
+	if err != nil {
+		return err
+	}
+	defer clear(keys.Private[:])
+	_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(keys.Private[:]))
+	return err
+}
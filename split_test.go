@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+func TestSplitCombineKey(t *testing.T) {
+	keys, _ := snacl.GenerateKeys(rand.Reader)
+	encoded := base64.StdEncoding.EncodeToString(keys.Private[:])
+	keyFile := filepath.Join(t.TempDir(), "server.key")
+	if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var shares bytes.Buffer
+	if err := splitKey(&shares, keyFile, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(shares.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Unexpected result: %d shares", len(lines))
+	}
+
+	var combined bytes.Buffer
+	if err := combineKey(strings.NewReader(lines[3]+"\n\n"+lines[1]+"\n"), &combined); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(combined.String()) != encoded {
+		t.Fatalf("Unexpected result: %q", combined.String())
+	}
+	if err := combineKey(strings.NewReader(lines[0]), &combined); err == nil {
+		t.Fatal("Unexpected result. One share made a key.")
+	}
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore is a Store that keeps every value in its own file in a directory.
+// Files are replaced with a rename, so several processes can share the same directory.
+//
+// Each file holds the expiry as 8 bytes of big-endian unix nanoseconds (0 means never) followed by the value.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore keeping its files in dir, dir is created if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file for key. Keys are hex encoded so any key makes a valid file name.
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(key)))
+}
+
+// Get returns the value stored under key
+func (s *FileStore) Get(key string) ([]byte, bool, error) {
+	value, expires, err := s.read(key)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if expired(time.Now(), expires) {
+		err = os.Remove(s.path(key))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	return value, true, nil
+}
+
+// Set stores value under key until ttl has passed
+func (s *FileStore) Set(key string, value []byte, ttl time.Duration) error {
+	return s.write(key, value, expiry(time.Now(), ttl))
+}
+
+// Expire changes when the value under key expires
+func (s *FileStore) Expire(key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		err := os.Remove(s.path(key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	value, _, err := s.read(key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.write(key, value, time.Now().Add(ttl))
+}
+
+// read returns the value and expiry stored in key's file
+func (s *FileStore) read(key string) ([]byte, time.Time, error) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(data) < 8 {
+		return nil, time.Time{}, fmt.Errorf("store file for %q is corrupt (len:%d)", key, len(data))
+	}
+
+	var expires time.Time
+	if nanos := int64(binary.BigEndian.Uint64(data)); nanos != 0 {
+		expires = time.Unix(0, nanos)
+	}
+	return data[8:], expires, nil
+}
+
+// write replaces key's file with value and expires. The data goes to a temporary file first so
+// readers never see a half written value.
+func (s *FileStore) write(key string, value []byte, expires time.Time) error {
+	data := make([]byte, 8+len(value))
+	if !expires.IsZero() {
+		binary.BigEndian.PutUint64(data, uint64(expires.UnixNano()))
+	}
+	copy(data[8:], value)
+
+	f, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), s.path(key))
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
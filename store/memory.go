@@ -0,0 +1,82 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how many Sets MemoryStore waits between removing expired values
+const sweepInterval = 1024
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryStore is a Store that keeps everything in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	sets    int
+}
+
+// NewMemoryStore allocates a MemoryStore and initializes it for you
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+// Get returns the value stored under key
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if expired(time.Now(), e.expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+// Set stores value under key until ttl has passed
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: expiry(now, ttl)}
+
+	// Expired values are normally removed by Get, so sweep now and then to get rid of the ones
+	// nobody asks for anymore
+	s.sets++
+	if s.sets >= sweepInterval {
+		s.sets = 0
+		for k, e := range s.entries {
+			if expired(now, e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Expire changes when the value under key expires
+func (s *MemoryStore) Expire(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(s.entries, key)
+		return nil
+	}
+	e.expires = time.Now().Add(ttl)
+	s.entries[key] = e
+	return nil
+}
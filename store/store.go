@@ -0,0 +1,31 @@
+// Package store keeps small pieces of security state, such as replay windows and resumption
+// tickets, outside of a single connection. MemoryStore keeps them in the process, FileStore keeps
+// them on disk so they survive restarts and can be shared by several processes on a machine.
+package store
+
+import "time"
+
+// Store is a key/value store where every value can have an expiry.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored under key. ok is false if there is no value or it has expired.
+	Get(key string) (value []byte, ok bool, err error)
+	// Set stores value under key. The value expires after ttl, or never if ttl is 0.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Expire changes when the value under key expires to ttl from now, a ttl of 0 or less removes
+	// the value straight away. Expiring a key that doesn't exist is not an error.
+	Expire(key string, ttl time.Duration) error
+}
+
+// expiry returns the time a value set now with ttl expires, the zero time means never
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// expired reports whether a value with the given expiry has expired at now
+func expired(now, expires time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	if _, ok, err := s.Get("missing"); err != nil || ok {
+		t.Fatalf("Unexpected result for a missing key: ok:%v err:%v", ok, err)
+	}
+
+	if err := s.Set("ticket", []byte("hello world"), 0); err != nil {
+		t.Fatal(err)
+	}
+	value, ok, err := s.Get("ticket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(value) != "hello world" {
+		t.Fatalf("Unexpected result: %s != %s", value, "hello world")
+	}
+
+	if err := s.Set("window", []byte("1"), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, err := s.Get("window"); err != nil || ok {
+		t.Fatalf("Unexpected result for an expired key: ok:%v err:%v", ok, err)
+	}
+
+	if err := s.Expire("ticket", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Get("ticket"); err != nil || !ok {
+		t.Fatalf("Unexpected result after extending a key: ok:%v err:%v", ok, err)
+	}
+	if err := s.Expire("ticket", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Get("ticket"); err != nil || ok {
+		t.Fatalf("Unexpected result after expiring a key: ok:%v err:%v", ok, err)
+	}
+	if err := s.Expire("missing", 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	// A second store on the same directory, like another process, sees the same values
+	if err := s.Set("shared", []byte("hello world"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewFileStore(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := other.Get("shared"); err != nil || !ok || string(value) != "hello world" {
+		t.Fatalf("Unexpected result from a second store: %s ok:%v err:%v", value, ok, err)
+	}
+}
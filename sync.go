@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// Directory sync, an rsync-lite on top of file transfer. The sync subcommand pushes a directory tree to
+// a receive server, which only asks for the files it doesn't already have:
+//
+//	go-challenge-2 receive 9000 backups
+//	go-challenge-2 sync photos localhost:9000
+//
+// The sender starts with a syncMessageType message holding the number of entries, [count uint64be],
+// and then sends the manifest, a message per directory and regular file:
+//
+//	[mode uint32be][size uint64be][modification time int64be, Unix nanoseconds][SHA-256 hash][path]
+//
+// Paths are slash separated and relative to the tree, and directories come before what's in them. The
+// receiver answers with a bitmap of the entries it wants, bit i%8 of byte i/8 for entry i, split into
+// messages of up to MaxMessageLength. A file is wanted unless the receiver has one of the same size and
+// either the same modification time or the same hash. The wanted files are then sent one after another
+// like a file transfer's body, see sendBody, and get the sender's permissions and modification time.
+// Files that are only on the receiver are left alone, and symlinks and other special files aren't
+// synced. Message types need Version1, so sync doesn't work with -legacy.
+
+// syncMessageType is the type of the message that starts a sync, a file transfer's header is an
+// ordinary message
+const syncMessageType byte = 1
+
+// syncEntryLength is the size of a manifest entry without the path
+const syncEntryLength = 4 + 8 + 8 + sha256.Size
+
+// syncEntry is a directory or regular file in a synced tree
+type syncEntry struct {
+	path    string
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+}
+
+func (e *syncEntry) marshal() []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(e.mode))
+	b = binary.BigEndian.AppendUint64(b, uint64(e.size))
+	b = binary.BigEndian.AppendUint64(b, uint64(e.modTime.UnixNano()))
+	b = append(b, e.hash[:]...)
+	return append(b, e.path...)
+}
+
+func parseSyncEntry(b []byte) (*syncEntry, error) {
+	if len(b) < syncEntryLength {
+		return nil, errors.New("short manifest entry")
+	}
+	e := &syncEntry{
+		mode:    fs.FileMode(binary.BigEndian.Uint32(b)),
+		size:    int64(binary.BigEndian.Uint64(b[4:])),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(b[12:]))),
+		path:    string(b[syncEntryLength:]),
+	}
+	copy(e.hash[:], b[20:])
+	// The path must not take the entry out of the receiver's directory
+	if e.size < 0 || e.mode&^(fs.ModeDir|fs.ModePerm) != 0 || e.path == "." || !filepath.IsLocal(filepath.FromSlash(e.path)) {
+		return nil, fmt.Errorf("bad manifest entry for %q", e.path)
+	}
+	return e, nil
+}
+
+// sendTree syncs the tree at root to a receiver on conn. progress, which may be nil, returns the
+// progress function for each file that's sent, see sendFile.
+func sendTree(conn *snacl.Conn, root string, progress func(name string) func(sent, size int64)) error {
+	var entries []*syncEntry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		e := &syncEntry{
+			path:    filepath.ToSlash(rel),
+			mode:    info.Mode() & (fs.ModeDir | fs.ModePerm),
+			modTime: info.ModTime(),
+		}
+		if !info.IsDir() {
+			e.size, e.hash, err = hashFile(p)
+		}
+		entries = append(entries, e)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = conn.WriteTyped(syncMessageType, binary.BigEndian.AppendUint64(nil, uint64(len(entries))))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := conn.WriteMsg(e.marshal()); err != nil {
+			return err
+		}
+	}
+
+	want, err := readBitmap(conn, len(entries))
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if want[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if e.mode.IsDir() {
+			return fmt.Errorf("the receiver asked for directory %s", e.path)
+		}
+		var fileProgress func(sent, size int64)
+		if progress != nil {
+			fileProgress = progress(e.path)
+		}
+		if err := sendSyncFile(conn, filepath.Join(root, filepath.FromSlash(e.path)), e.size, fileProgress); err != nil {
+			return fmt.Errorf("%s: %w", e.path, err)
+		}
+	}
+	return nil
+}
+
+// sendSyncFile sends the body of the file at p, see sendBody
+func sendSyncFile(conn *snacl.Conn, p string, size int64, progress func(sent, size int64)) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sendBody(conn, f, size, progress)
+}
+
+// hashFile returns the size and SHA-256 hash of the file at p
+func hashFile(p string) (size int64, sum [sha256.Size]byte, err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, sum, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err = io.Copy(hash, f)
+	hash.Sum(sum[:0])
+	return size, sum, err
+}
+
+// receiveTree receives a tree into dir, count is the first message of the sync
+func receiveTree(conn *snacl.Conn, dir string, count []byte) (name string, err error) {
+	if len(count) != 8 {
+		return "", errors.New("bad sync start")
+	}
+	n := binary.BigEndian.Uint64(count)
+
+	// The manifest is read before anything is allocated for it, n is only the sender's word
+	var entries []*syncEntry
+	var want []byte
+	// dirs has the directories of the tree, which every entry must be in
+	dirs := map[string]bool{".": true}
+	for i := uint64(0); i < n; i++ {
+		msg, err := conn.ReadMsg()
+		if err != nil {
+			return "", err
+		}
+		e, err := parseSyncEntry(msg.Data)
+		if err != nil {
+			return "", err
+		}
+		if !dirs[path.Dir(e.path)] {
+			return "", fmt.Errorf("%s comes before its directory", e.path)
+		}
+		wanted, err := prepareSyncEntry(filepath.Join(dir, filepath.FromSlash(e.path)), e)
+		if err != nil {
+			return "", err
+		}
+		if e.mode.IsDir() {
+			dirs[e.path] = true
+		}
+		if len(entries)%8 == 0 {
+			want = append(want, 0)
+		}
+		if wanted {
+			want[len(entries)/8] |= 1 << (len(entries) % 8)
+		}
+		entries = append(entries, e)
+	}
+
+	err = writeBitmap(conn, want)
+	if err != nil {
+		return "", err
+	}
+	changed := 0
+	for i, e := range entries {
+		if want[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(e.path))
+		if err := receiveBody(conn, target, e.size, e.hash); err != nil {
+			return "", fmt.Errorf("%s: %w", e.path, err)
+		}
+		if err := os.Chmod(target, e.mode.Perm()); err != nil {
+			return "", err
+		}
+		if err := os.Chtimes(target, e.modTime, e.modTime); err != nil {
+			return "", err
+		}
+		changed++
+	}
+
+	// Directories get their permissions last, in case they don't let us write into them. Deeper ones
+	// come later in the manifest, so going backwards does them before their parents.
+	for i := len(entries) - 1; i >= 0; i-- {
+		if e := entries[i]; e.mode.IsDir() {
+			if err := os.Chmod(filepath.Join(dir, filepath.FromSlash(e.path)), e.mode.Perm()); err != nil {
+				return "", err
+			}
+		}
+	}
+	return fmt.Sprintf("a tree of %d entries, %d files changed,", len(entries), changed), nil
+}
+
+// prepareSyncEntry creates a directory entry's directory and returns whether a file entry needs to be
+// sent. A file that doesn't need to be gets the entry's permissions.
+func prepareSyncEntry(target string, e *syncEntry) (bool, error) {
+	info, err := os.Lstat(target)
+	if e.mode.IsDir() {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Writable until the tree is done, see receiveTree
+			return false, os.Mkdir(target, 0o755)
+		}
+		if err != nil {
+			return false, err
+		}
+		if !info.IsDir() {
+			return false, fmt.Errorf("%s isn't a directory", e.path)
+		}
+		return false, os.Chmod(target, 0o755|e.mode.Perm())
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		// Not something to write through, like a symlink
+		return false, fmt.Errorf("%s isn't a regular file", e.path)
+	}
+	if info.Size() != e.size {
+		return true, nil
+	}
+	if !info.ModTime().Equal(e.modTime) {
+		_, sum, err := hashFile(target)
+		if err != nil {
+			return false, err
+		}
+		if sum != e.hash {
+			return true, nil
+		}
+		// The same file, the next sync doesn't have to hash it
+		if err := os.Chtimes(target, e.modTime, e.modTime); err != nil {
+			return false, err
+		}
+	}
+	return false, os.Chmod(target, e.mode.Perm())
+}
+
+// writeBitmap sends the bitmap of wanted entries in messages of up to MaxMessageLength
+func writeBitmap(conn *snacl.Conn, want []byte) error {
+	max := conn.ConnectionState().MaxMessageLength
+	for len(want) > 0 {
+		n := min(len(want), max)
+		if err := conn.WriteMsg(want[:n]); err != nil {
+			return err
+		}
+		want = want[n:]
+	}
+	return nil
+}
+
+// readBitmap reads the bitmap of wanted entries for a manifest of count entries
+func readBitmap(conn *snacl.Conn, count int) ([]byte, error) {
+	want := make([]byte, 0, (count+7)/8)
+	for len(want) < cap(want) {
+		msg, err := conn.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Data) > cap(want)-len(want) {
+			return nil, errors.New("the receiver sent too long a bitmap")
+		}
+		want = append(want, msg.Data...)
+	}
+	return want, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl/securetest"
+)
+
+// syncTree syncs src into dst and returns the names of the files that were sent
+func syncTree(t *testing.T, src, dst string) []string {
+	client, server := securetest.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	received := make(chan error, 1)
+	go func() {
+		_, err := receive(server, dst)
+		received <- err
+	}()
+	var sent []string
+	err := sendTree(client, src, func(name string) func(sent, size int64) {
+		sent = append(sent, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := <-received; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sort.Strings(sent)
+	return sent
+}
+
+func TestSync(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(src, "docs", "old"), 0o755)
+	os.WriteFile(filepath.Join(src, "readme.txt"), []byte("hello"), 0o644)
+	os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\n"), 0o755)
+	os.WriteFile(filepath.Join(src, "docs", "a.txt"), []byte("a"), 0o600)
+	os.WriteFile(filepath.Join(src, "docs", "old", "b.txt"), []byte("b"), 0o644)
+	os.Symlink("readme.txt", filepath.Join(src, "link"))
+
+	sent := syncTree(t, src, dst)
+	if len(sent) != 4 {
+		t.Fatalf("Unexpected result: %v", sent)
+	}
+	for name, expected := range map[string]string{"readme.txt": "hello", "run.sh": "#!/bin/sh\n", "docs/a.txt": "a", "docs/old/b.txt": "b"} {
+		if got, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(got) != expected {
+			t.Fatalf("Unexpected result for %s: %q %v", name, got, err)
+		}
+	}
+	for name, expected := range map[string]fs.FileMode{"run.sh": 0o755, "docs/a.txt": 0o600} {
+		if info, err := os.Stat(filepath.Join(dst, name)); err != nil || info.Mode().Perm() != expected {
+			t.Fatalf("Unexpected result for %s: %v %v", name, info.Mode(), err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "link")); !os.IsNotExist(err) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Nothing has changed
+	if sent := syncTree(t, src, dst); len(sent) != 0 {
+		t.Fatalf("Unexpected result: %v", sent)
+	}
+
+	// Only the changed file is sent, a touched one with the same contents and a new mode isn't
+	os.WriteFile(filepath.Join(src, "docs", "a.txt"), []byte("a, changed"), 0o600)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(src, "readme.txt"), later, later)
+	os.Chmod(filepath.Join(src, "run.sh"), 0o700)
+	if sent := syncTree(t, src, dst); len(sent) != 1 || sent[0] != "docs/a.txt" {
+		t.Fatalf("Unexpected result: %v", sent)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "run.sh")); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("Unexpected result: %v %v", info.Mode(), err)
+	}
+}
+
+func TestParseSyncEntry(t *testing.T) {
+	for _, p := range []string{"", ".", "..", "../escape", "a/../../escape", "/etc/passwd"} {
+		e := &syncEntry{path: p, mode: 0o644}
+		if _, err := parseSyncEntry(e.marshal()); err == nil {
+			t.Fatalf("Unexpected result. %q was accepted.", p)
+		}
+	}
+	e := &syncEntry{path: "a/b.txt", mode: 0o644 | fs.ModeSymlink}
+	if _, err := parseSyncEntry(e.marshal()); err == nil {
+		t.Fatal("Unexpected result. A symlink was accepted.")
+	}
+	e = &syncEntry{path: "a/b.txt", mode: 0o640, size: 3, modTime: time.Unix(0, 42)}
+	if got, err := parseSyncEntry(e.marshal()); err != nil || *got != *e {
+		t.Fatalf("Unexpected result: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// File transfer. The receive subcommand listens and saves the files it's sent into a directory, and
+// the send subcommand sends it one. It takes trees from the sync subcommand too, see sync.go.
+//
+//	go-challenge-2 receive 9000 downloads
+//	go-challenge-2 send backup.tar localhost:9000
+//
+// The sender starts with a header, [size uint64be][SHA-256 hash][name], and the receiver answers with
+// the offset to start from, [offset uint64be]. The file is then sent from there in messages of up to
+// MaxMessageLength, and once it's all there the receiver checks the hash and answers with one status
+// byte. Until then the file is kept as name.part, so a transfer that's cut off carries on from the
+// end of it the next time the same file is sent. The hash covers the whole file, a partial file that
+// turns out not to match is removed and the next transfer starts over.
+
+// Statuses the receiver ends a transfer with
+const (
+	transferOK      byte = 0
+	transferBadHash byte = 1
+)
+
+// partSuffix is added to the names of files that are still being received
+const partSuffix = ".part"
+
+// fileHeaderLength is the size of a file header without the name
+const fileHeaderLength = 8 + sha256.Size
+
+var errTransferHash = errors.New("the received file doesn't match its hash, it was removed")
+
+// fileHeader describes the file being sent
+type fileHeader struct {
+	name string
+	size int64
+	hash [sha256.Size]byte
+}
+
+func (h *fileHeader) marshal() []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(h.size))
+	b = append(b, h.hash[:]...)
+	return append(b, h.name...)
+}
+
+func parseFileHeader(b []byte) (*fileHeader, error) {
+	if len(b) < fileHeaderLength {
+		return nil, errors.New("short file header")
+	}
+	h := &fileHeader{
+		size: int64(binary.BigEndian.Uint64(b)),
+		name: string(b[fileHeaderLength:]),
+	}
+	copy(h.hash[:], b[8:])
+	// The name must not take the file out of the receiver's directory
+	if h.size < 0 || h.name == "" || h.name == "." || h.name == ".." || filepath.Base(h.name) != h.name {
+		return nil, fmt.Errorf("bad file header for %q", h.name)
+	}
+	return h, nil
+}
+
+// sendFile sends the file at path to a receiver on conn. progress, which may be nil, is called with
+// how much of the file the receiver has after every message, starting from what it already had.
+func sendFile(conn *snacl.Conn, path string, progress func(sent, size int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := &fileHeader{name: filepath.Base(path)}
+	hash := sha256.New()
+	if h.size, err = io.Copy(hash, f); err != nil {
+		return err
+	}
+	hash.Sum(h.hash[:0])
+	if err := conn.WriteMsg(h.marshal()); err != nil {
+		return err
+	}
+
+	return sendBody(conn, f, h.size, progress)
+}
+
+// sendBody sends the size bytes of f from the offset the receiver asks for and waits for it to check
+// the hash, see receiveBody
+func sendBody(conn *snacl.Conn, f io.ReadSeeker, size int64, progress func(sent, size int64)) error {
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if len(msg.Data) != 8 || int64(binary.BigEndian.Uint64(msg.Data)) > size {
+		return errors.New("the receiver sent a bad offset")
+	}
+	sent := int64(binary.BigEndian.Uint64(msg.Data))
+	if _, err := f.Seek(sent, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, conn.ConnectionState().MaxMessageLength)
+	for sent < size {
+		n, err := io.ReadFull(f, buf[:min(int64(len(buf)), size-sent)])
+		if err != nil {
+			// The file got shorter since it was hashed
+			return err
+		}
+		if err := conn.WriteMsg(buf[:n]); err != nil {
+			return err
+		}
+		sent += int64(n)
+		if progress != nil {
+			progress(sent, size)
+		}
+	}
+
+	msg, err = conn.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if len(msg.Data) != 1 {
+		return errors.New("the receiver sent a bad status")
+	}
+	if msg.Data[0] == transferBadHash {
+		return errTransferHash
+	}
+	if msg.Data[0] != transferOK {
+		return fmt.Errorf("the receiver sent unknown status %d", msg.Data[0])
+	}
+	return nil
+}
+
+// receive handles a transfer on conn into dir, a file sent by sendFile or a tree sent by sendTree. It
+// returns the name of the file or directory.
+func receive(conn *snacl.Conn, dir string) (name string, err error) {
+	typ, msg, err := conn.ReadTyped()
+	if err != nil {
+		return "", err
+	}
+	if typ == syncMessageType {
+		return receiveTree(conn, dir, msg)
+	}
+	return receiveFile(conn, dir, msg)
+}
+
+// receiveFile receives the file whose header is header into dir and returns its name
+func receiveFile(conn *snacl.Conn, dir string, header []byte) (name string, err error) {
+	h, err := parseFileHeader(header)
+	if err != nil {
+		return "", err
+	}
+	return h.name, receiveBody(conn, filepath.Join(dir, h.name), h.size, h.hash)
+}
+
+// receiveBody receives a file of size bytes into path and checks it against sum. A transfer that's cut
+// off leaves path.part behind for the next one to carry on from.
+func receiveBody(conn *snacl.Conn, path string, size int64, sum [sha256.Size]byte) error {
+	part := path + partSuffix
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Whatever is already there is carried on from, the writes below go after it
+	hash := sha256.New()
+	received, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if received > size {
+		// It's left from some other file of the same name
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hash.Reset()
+		received = 0
+	}
+	if err := conn.WriteMsg(binary.BigEndian.AppendUint64(nil, uint64(received))); err != nil {
+		return err
+	}
+
+	for received < size {
+		msg, err := conn.ReadMsg()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if int64(len(msg.Data)) > size-received {
+			return errors.New("the sender sent more than the file's size")
+		}
+		if _, err := f.Write(msg.Data); err != nil {
+			return err
+		}
+		hash.Write(msg.Data)
+		received += int64(len(msg.Data))
+	}
+
+	if !bytes.Equal(hash.Sum(nil), sum[:]) {
+		f.Close()
+		os.Remove(part)
+		conn.WriteMsg([]byte{transferBadHash})
+		return errTransferHash
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(part, path); err != nil {
+		return err
+	}
+	return conn.WriteMsg([]byte{transferOK})
+}
+
+// receiveInto returns a handler for serve that receives a file or tree into dir on every connection
+func receiveInto(dir string) func(*snacl.Conn) {
+	return func(conn *snacl.Conn) {
+		name, err := receive(conn, dir)
+		if err != nil {
+			log.Printf("receiving from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+		log.Printf("received %s from %v", name, conn.RemoteAddr())
+	}
+}
+
+// printProgress returns a progress function for sendFile and sendTree that keeps a line on w up to date
+func printProgress(w io.Writer, name string) func(sent, size int64) {
+	return func(sent, size int64) {
+		fmt.Fprintf(w, "\r%s: %d/%d bytes (%d%%)", name, sent, size, sent*100/size)
+		if sent == size {
+			fmt.Fprintln(w)
+		}
+	}
+}
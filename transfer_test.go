@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+	"github.com/arianitu/go-challenge-2/snacl/securetest"
+)
+
+// transfer sends the file at path to a receiver saving into dir and returns both sides' errors, and the
+// first progress the sender reported
+func transfer(t *testing.T, path, dir string) (sendErr, receiveErr error, firstProgress int64) {
+	client, server := securetest.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	received := make(chan error, 1)
+	go func() {
+		_, err := receive(server, dir)
+		received <- err
+	}()
+	firstProgress = -1
+	sendErr = sendFile(client, path, func(sent, size int64) {
+		if firstProgress < 0 {
+			firstProgress = sent
+		}
+	})
+	return sendErr, <-received, firstProgress
+}
+
+func TestTransfer(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	data := make([]byte, 3*snacl.MaxMessageLength+100)
+	rand.Read(data)
+	path := filepath.Join(src, "data.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sendErr, receiveErr, first := transfer(t, path, dst)
+	if sendErr != nil || receiveErr != nil {
+		t.Fatalf("Unexpected error: %v %v", sendErr, receiveErr)
+	}
+	if first != snacl.MaxMessageLength {
+		t.Fatalf("Unexpected result: %d", first)
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "data.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "data.bin"+partSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestTransferResume(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	data := make([]byte, 2*snacl.MaxMessageLength)
+	rand.Read(data)
+	path := filepath.Join(src, "data.bin")
+	os.WriteFile(path, data, 0o644)
+
+	// An earlier transfer got half way
+	const done = 1000
+	os.WriteFile(filepath.Join(dst, "data.bin"+partSuffix), data[:done], 0o644)
+	sendErr, receiveErr, first := transfer(t, path, dst)
+	if sendErr != nil || receiveErr != nil {
+		t.Fatalf("Unexpected error: %v %v", sendErr, receiveErr)
+	}
+	if first != done+snacl.MaxMessageLength {
+		t.Fatalf("Unexpected result. The transfer didn't carry on from %d: %d", done, first)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "data.bin")); !bytes.Equal(got, data) {
+		t.Fatal("Unexpected result. The resumed file is wrong.")
+	}
+
+	// A partial file that isn't the start of this one is found out by the hash and removed
+	os.WriteFile(filepath.Join(dst, "data.bin"+partSuffix), []byte("something else"), 0o644)
+	sendErr, receiveErr, _ = transfer(t, path, dst)
+	if !errors.Is(sendErr, errTransferHash) || !errors.Is(receiveErr, errTransferHash) {
+		t.Fatalf("Unexpected error: %v %v", sendErr, receiveErr)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "data.bin"+partSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseFileHeader(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../escape", "dir/file", "/etc/passwd"} {
+		h := &fileHeader{name: name, size: 1}
+		if _, err := parseFileHeader(h.marshal()); err == nil {
+			t.Fatalf("Unexpected result. %q was accepted.", name)
+		}
+	}
+	h := &fileHeader{name: "file.txt", size: 1}
+	if got, err := parseFileHeader(h.marshal()); err != nil || *got != *h {
+		t.Fatalf("Unexpected result: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/arianitu/go-challenge-2/snacl"
+)
+
+// tuneMessageSizes are the MaxMessageLength values the tune subcommand tries
+var tuneMessageSizes = []int{1024, 4096, 16384, snacl.MaxMessageLength, 65536, 262144}
+
+// tuneCipherSuites are the cipher suites the tune subcommand compares
+var tuneCipherSuites = []snacl.CipherSuite{snacl.NaClBox, snacl.XChaCha20Poly1305, snacl.AES256GCM}
+
+// tuneSmallWrite is the write size used to see whether buffering small writes pays off
+const tuneSmallWrite = 128
+
+// tune measures throughput over loopback TCP with different settings, spending about d on each,
+// and writes the results and the options it recommends to w
+func tune(w io.Writer, d time.Duration) error {
+	fmt.Fprintf(w, "Measuring throughput over loopback TCP, %v per setting\n\n", d)
+
+	// Bigger messages spread the per message overhead, but cost memory and latency
+	bestSize, bestRate := 0, 0.0
+	for _, size := range tuneMessageSizes {
+		rate, err := measureThroughput(&snacl.Options{MaxMessageLength: size}, size, d)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "message size %-8d %10.1f MB/s\n", size, rate)
+		if rate > bestRate {
+			bestSize, bestRate = size, rate
+		}
+	}
+
+	// crypto/rand can be contended on a busy machine, the DRBG isn't
+	drbgRate, err := measureThroughput(&snacl.Options{MaxMessageLength: bestSize, NonceDRBG: true}, bestSize, d)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "nonce DRBG            %10.1f MB/s\n", drbgRate)
+
+	// Buffering only matters when the application writes a little at a time
+	unbuffered, err := measureThroughput(&snacl.Options{MaxMessageLength: bestSize}, tuneSmallWrite, d)
+	if err != nil {
+		return err
+	}
+	buffered, err := measureThroughput(&snacl.Options{MaxMessageLength: bestSize, WriteBufferSize: bestSize, WriteBufferDelay: time.Millisecond}, tuneSmallWrite, d)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d byte writes        %10.1f MB/s unbuffered, %.1f MB/s buffered\n", tuneSmallWrite, unbuffered, buffered)
+
+	// AES-GCM wins on CPUs with AES instructions, the others win everywhere else
+	var bestSuite snacl.CipherSuite
+	bestSuiteRate := 0.0
+	for _, suite := range tuneCipherSuites {
+		rate, err := measureThroughput(&snacl.Options{MaxMessageLength: bestSize, CipherSuites: []snacl.CipherSuite{suite}}, bestSize, d)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "cipher %-14s %10.1f MB/s\n", suite.Name(), rate)
+		if rate > bestSuiteRate {
+			bestSuite, bestSuiteRate = suite, rate
+		}
+	}
+
+	fmt.Fprintf(w, "\nRecommended options for this machine, both sides need the same MaxMessageLength to use it:\n\n")
+	fmt.Fprintf(w, "\t&snacl.Options{\n")
+	fmt.Fprintf(w, "\t\tMaxMessageLength: %d,\n", bestSize)
+	if drbgRate > bestRate {
+		fmt.Fprintf(w, "\t\tNonceDRBG:        true,\n")
+	}
+	if bestSuite != snacl.NaClBox {
+		fmt.Fprintf(w, "\t\tCipherSuites:     []snacl.CipherSuite{snacl.%s},\n", bestSuite.Name())
+	}
+	if buffered > unbuffered {
+		fmt.Fprintf(w, "\t\t// For protocols that make a lot of small writes:\n")
+		fmt.Fprintf(w, "\t\t// WriteBufferSize:  %d,\n", bestSize)
+		fmt.Fprintf(w, "\t\t// WriteBufferDelay: time.Millisecond,\n")
+	}
+	fmt.Fprintf(w, "\t}\n")
+	return nil
+}
+
+// measureThroughput sends writes of writeSize bytes for about d and returns the throughput in MB/s
+func measureThroughput(opts *snacl.Options, writeSize int, d time.Duration) (float64, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	sl := snacl.NewListener(l, opts)
+	defer sl.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := sl.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		_, err = io.Copy(io.Discard, conn)
+		done <- err
+	}()
+
+	conn, err := snacl.Dial("tcp", sl.Addr().String(), opts)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, writeSize)
+	var sent int64
+	start := time.Now()
+	for time.Since(start) < d {
+		n, err := conn.Write(buf)
+		sent += int64(n)
+		if err != nil {
+			conn.Close()
+			return 0, err
+		}
+	}
+
+	// Everything has arrived once the server sees the close
+	err = conn.Close()
+	if err != nil {
+		return 0, err
+	}
+	err = <-done
+	if err != nil {
+		return 0, err
+	}
+	return float64(sent) / time.Since(start).Seconds() / 1e6, nil
+}
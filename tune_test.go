@@ -0,0 +1,18 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTune(t *testing.T) {
+	var out bytes.Buffer
+	if err := tune(&out, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "MaxMessageLength:") {
+		t.Fatalf("Unexpected output, there are no recommended options:\n%s", out.String())
+	}
+}